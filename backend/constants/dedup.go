@@ -0,0 +1,7 @@
+package constants
+
+const (
+	DedupModeSkip   = "skip"
+	DedupModeUpsert = "upsert"
+	DedupModeKeep   = "keep" // 不去重，去重字段只作为结果的键（用于比较任务结果）
+)
@@ -0,0 +1,13 @@
+package constants
+
+const (
+	DependencyTypePython = "python"
+	DependencyTypeNode   = "node"
+	DependencyTypeGo     = "go"
+)
+
+const (
+	DependencyStatusInstalling = "installing"
+	DependencyStatusInstalled  = "installed"
+	DependencyStatusError      = "error"
+)
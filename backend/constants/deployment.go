@@ -0,0 +1,7 @@
+package constants
+
+const (
+	DeployStatusSyncing = "syncing"
+	DeployStatusSuccess = "success"
+	DeployStatusError   = "error"
+)
@@ -4,4 +4,5 @@ const (
 	MsgTypeGetLog        = "get-log"
 	MsgTypeGetSystemInfo = "get-sys-info"
 	MsgTypeCancelTask    = "cancel-task"
+	MsgTypeCleanLogs     = "clean-logs"
 )
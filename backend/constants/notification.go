@@ -0,0 +1,19 @@
+package constants
+
+// 通知渠道类型
+const (
+	ChannelTypeEmail    = "email"    // SMTP邮件
+	ChannelTypeWebhook  = "webhook"  // 通用Webhook
+	ChannelTypeDingTalk = "dingtalk" // 钉钉机器人
+	ChannelTypeWeCom    = "wecom"    // 企业微信机器人
+	ChannelTypeSlack    = "slack"    // Slack Incoming Webhook
+)
+
+// 任务通知事件
+const (
+	NotifyEventStarted     = "started"      // 开始执行
+	NotifyEventFinished    = "finished"     // 执行完成
+	NotifyEventError       = "error"        // 执行出错
+	NotifyEventCancelled   = "cancelled"    // 已取消
+	NotifyEventZeroResults = "zero_results" // 执行完成但没有结果
+)
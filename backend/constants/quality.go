@@ -0,0 +1,15 @@
+package constants
+
+const (
+	QualityRuleRequired = "required" // 必填
+	QualityRuleRegex    = "regex"    // 正则匹配
+	QualityRuleFormat   = "format"   // 格式
+	QualityRuleRange    = "range"    // 数值范围
+)
+
+const (
+	QualityFormatNumber = "number"
+	QualityFormatUrl    = "url"
+	QualityFormatEmail  = "email"
+	QualityFormatDate   = "date"
+)
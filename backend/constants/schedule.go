@@ -0,0 +1,7 @@
+package constants
+
+const (
+	MisfireIgnore  = "ignore"
+	MisfireRunOnce = "run_once"
+	MisfireRunAll  = "run_all"
+)
@@ -0,0 +1,8 @@
+package constants
+
+const (
+	SinkTypeMongo   = "mongo"
+	SinkTypeSql     = "sql"
+	SinkTypeKafka   = "kafka"
+	SinkTypeWebhook = "webhook"
+)
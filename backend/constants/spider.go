@@ -4,3 +4,15 @@ const (
 	Customized   = "customized"
 	Configurable = "configurable"
 )
+
+const (
+	UploaderSystem = "system"
+)
+
+// 爬虫项目类型（自动检测）
+const (
+	FrameworkScrapy = "scrapy"
+	FrameworkNode   = "node"
+	FrameworkPython = "python"
+	FrameworkGo     = "go"
+)
@@ -6,6 +6,9 @@ const (
 	StatusFinished  string = "finished"
 	StatusError     string = "error"
 	StatusCancelled string = "cancelled"
+
+	// 已完成，但结果未通过数据质量规则校验
+	StatusFinishedWithWarnings string = "finished_with_warnings"
 )
 
 const (
@@ -0,0 +1,20 @@
+package constants
+
+// 工作流步骤触发条件
+const (
+	ConditionOnSuccess = "on_success"
+	ConditionOnFailure = "on_failure"
+	ConditionAlways    = "always"
+)
+
+// 工作流步骤状态（除任务状态外）
+const (
+	StatusWaiting string = "waiting"
+	StatusSkipped string = "skipped"
+)
+
+// 工作流触发方式
+const (
+	TriggerManual   = "manual"
+	TriggerSchedule = "schedule"
+)
@@ -102,6 +102,72 @@ func (r *Redis) HKeys(collection string) ([]string, error) {
 	return value, nil
 }
 
+// 仅当key不存在时设置，并指定过期时间（毫秒）
+func (r *Redis) SetNxPx(key string, value string, ms int) (bool, error) {
+	c, err := GetRedisConn()
+	if err != nil {
+		debug.PrintStack()
+		return false, err
+	}
+	defer c.Close()
+
+	if _, err := redis.String(c.Do("SET", key, value, "NX", "PX", ms)); err != nil {
+		if err == redis.ErrNil {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// 当key的值与value相等时，刷新过期时间（毫秒）
+var pexpireIfEqualScript = redis.NewScript(1, `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+else
+	return 0
+end
+`)
+
+func (r *Redis) PExpireIfEqual(key string, value string, ms int) (bool, error) {
+	c, err := GetRedisConn()
+	if err != nil {
+		debug.PrintStack()
+		return false, err
+	}
+	defer c.Close()
+
+	res, err := redis.Int(pexpireIfEqualScript.Do(c, key, value, ms))
+	if err != nil {
+		return false, err
+	}
+	return res == 1, nil
+}
+
+// 当key的值与value相等时，删除该key
+var delIfEqualScript = redis.NewScript(1, `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+else
+	return 0
+end
+`)
+
+func (r *Redis) DelIfEqual(key string, value string) (bool, error) {
+	c, err := GetRedisConn()
+	if err != nil {
+		debug.PrintStack()
+		return false, err
+	}
+	defer c.Close()
+
+	res, err := redis.Int(delIfEqualScript.Do(c, key, value))
+	if err != nil {
+		return false, err
+	}
+	return res == 1, nil
+}
+
 func GetRedisConn() (redis.Conn, error) {
 	var address = viper.GetString("redis.address")
 	var port = viper.GetString("redis.port")
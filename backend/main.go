@@ -53,6 +53,14 @@ func main() {
 			panic(err)
 		}
 		log.Info("初始化定时任务成功")
+
+		// 初始化主节点选举（Leader负责执行定时任务）
+		if err := services.InitLeaderService(); err != nil {
+			log.Error("init leader service error:" + err.Error())
+			debug.PrintStack()
+			panic(err)
+		}
+		log.Info("初始化主节点选举成功")
 	}
 
 	// 初始化任务执行器
@@ -86,6 +94,24 @@ func main() {
 	}
 	log.Info("初始化用户服务成功")
 
+	if services.IsMaster() {
+		// 初始化工作流服务
+		if err := services.InitWorkflowService(); err != nil {
+			log.Error("init workflow service error:" + err.Error())
+			debug.PrintStack()
+			panic(err)
+		}
+		log.Info("初始化工作流服务成功")
+
+		// 初始化数据保留服务
+		if err := services.InitRetentionService(); err != nil {
+			log.Error("init retention service error:" + err.Error())
+			debug.PrintStack()
+			panic(err)
+		}
+		log.Info("初始化数据保留服务成功")
+	}
+
 	// 以下为主节点服务
 	if services.IsMaster() {
 		// 中间件
@@ -101,34 +127,80 @@ func main() {
 		app.GET("/nodes/:id/system", routes.GetSystemInfo)  // 节点任务列表
 		app.DELETE("/nodes/:id", routes.DeleteNode)         // 删除节点
 		// 爬虫
-		app.GET("/spiders", routes.GetSpiderList)              // 爬虫列表
-		app.GET("/spiders/:id", routes.GetSpider)              // 爬虫详情
-		app.POST("/spiders", routes.PutSpider)                 // 上传爬虫
-		app.POST("/spiders/:id", routes.PostSpider)            // 修改爬虫
-		app.POST("/spiders/:id/publish", routes.PublishSpider) // 发布爬虫
-		app.DELETE("/spiders/:id", routes.DeleteSpider)        // 删除爬虫
-		app.GET("/spiders/:id/tasks", routes.GetSpiderTasks)   // 爬虫任务列表
-		app.GET("/spiders/:id/file", routes.GetSpiderFile)     // 爬虫文件读取
-		app.POST("/spiders/:id/file", routes.PostSpiderFile)   // 爬虫目录写入
-		app.GET("/spiders/:id/dir", routes.GetSpiderDir)       // 爬虫目录
-		app.GET("/spiders/:id/stats", routes.GetSpiderStats)   // 爬虫统计数据
+		app.GET("/spiders", routes.GetSpiderList)                              // 爬虫列表
+		app.GET("/spiders/:id", routes.GetSpider)                              // 爬虫详情
+		app.POST("/spiders", routes.PutSpider)                                 // 上传爬虫
+		app.PUT("/spiders", routes.PutGitSpider)                               // 从Git仓库创建爬虫
+		app.POST("/spiders/:id", routes.PostSpider)                            // 修改爬虫
+		app.POST("/spiders/:id/publish", routes.PublishSpider)                 // 发布爬虫
+		app.POST("/spiders/:id/pull", routes.PullSpider)                       // 拉取Git爬虫
+		app.DELETE("/spiders/:id", routes.DeleteSpider)                        // 删除爬虫
+		app.GET("/spiders/:id/tasks", routes.GetSpiderTasks)                   // 爬虫任务列表
+		app.GET("/spiders/:id/file", routes.GetSpiderFile)                     // 爬虫文件读取
+		app.POST("/spiders/:id/file", routes.PostSpiderFile)                   // 爬虫目录写入
+		app.PUT("/spiders/:id/files", routes.CreateSpiderFile)                 // 爬虫文件新建
+		app.PUT("/spiders/:id/files/dir", routes.CreateSpiderDir)              // 爬虫目录新建
+		app.POST("/spiders/:id/files/rename", routes.RenameSpiderFile)         // 爬虫文件重命名或移动
+		app.DELETE("/spiders/:id/files", routes.DeleteSpiderFile)              // 爬虫文件删除
+		app.POST("/spiders/:id/files/upload", routes.UploadSpiderFile)         // 爬虫文件上传
+		app.GET("/spiders/:id/files/download", routes.DownloadSpiderFile)      // 爬虫文件或目录下载
+		app.GET("/spiders/:id/dir", routes.GetSpiderDir)                       // 爬虫目录
+		app.GET("/spiders/:id/stats", routes.GetSpiderStats)                   // 爬虫统计数据
+		app.GET("/spiders/:id/versions", routes.GetSpiderVersions)             // 爬虫版本列表
+		app.POST("/spiders/:id/versions/:vid/rollback", routes.RollbackSpider) // 回滚爬虫版本
+		app.GET("/spiders/:id/deployments", routes.GetSpiderDeployments)       // 爬虫部署状态
+		app.GET("/spiders/:id/results", routes.GetSpiderResults)               // 爬虫结果查询（多个任务）
+		app.GET("/spiders/:id/results/download", routes.DownloadSpiderResults) // 下载爬虫结果（多个任务）
+		app.GET("/spiders/:id/results/schema", routes.GetSpiderResultSchema)   // 爬虫结果结构及字段统计
+		app.GET("/spiders/:id/scrapy", routes.GetScrapyProject)                // Scrapy项目信息
+		app.POST("/spiders/:id/scrapy/settings", routes.PostScrapySettings)    // 修改Scrapy项目设置
 		// 任务
-		app.GET("/tasks", routes.GetTaskList)                                 // 任务列表
-		app.GET("/tasks/:id", routes.GetTask)                                 // 任务详情
-		app.PUT("/tasks", routes.PutTask)                                     // 派发任务
-		app.DELETE("/tasks/:id", routes.DeleteTask)                           // 删除任务
-		app.POST("/tasks/:id/cancel", routes.CancelTask)                      // 取消任务
-		app.GET("/tasks/:id/log", routes.GetTaskLog)                          // 任务日志
-		app.GET("/tasks/:id/results", routes.GetTaskResults)                  // 任务结果
-		app.GET("/tasks/:id/results/download", routes.DownloadTaskResultsCsv) // 下载任务结果
+		app.GET("/tasks", routes.GetTaskList)                              // 任务列表
+		app.GET("/tasks/:id", routes.GetTask)                              // 任务详情
+		app.PUT("/tasks", routes.PutTask)                                  // 派发任务
+		app.DELETE("/tasks/:id", routes.DeleteTask)                        // 删除任务
+		app.POST("/tasks/:id/cancel", routes.CancelTask)                   // 取消任务
+		app.GET("/tasks/:id/log", routes.GetTaskLog)                       // 任务日志
+		app.GET("/tasks/:id/results", routes.GetTaskResults)               // 任务结果
+		app.GET("/tasks/:id/results/download", routes.DownloadTaskResults) // 下载任务结果
+		app.GET("/tasks/:id/results/diff", routes.GetTaskResultsDiff)      // 比较两个任务的结果
 		// 定时任务
 		app.GET("/schedules", routes.GetScheduleList)       // 定时任务列表
 		app.GET("/schedules/:id", routes.GetSchedule)       // 定时任务详情
 		app.PUT("/schedules", routes.PutSchedule)           // 创建定时任务
 		app.POST("/schedules/:id", routes.PostSchedule)     // 修改定时任务
 		app.DELETE("/schedules/:id", routes.DeleteSchedule) // 删除定时任务
+		// 工作流
+		app.GET("/workflows", routes.GetWorkflowList)                 // 工作流列表
+		app.GET("/workflows/:id", routes.GetWorkflow)                 // 工作流详情
+		app.PUT("/workflows", routes.PutWorkflow)                     // 创建工作流
+		app.POST("/workflows/:id", routes.PostWorkflow)               // 修改工作流
+		app.DELETE("/workflows/:id", routes.DeleteWorkflow)           // 删除工作流
+		app.POST("/workflows/:id/run", routes.RunWorkflow)            // 运行工作流
+		app.GET("/workflows/:id/runs", routes.GetWorkflowRunList)     // 工作流运行记录
+		app.GET("/workflows/:id/runs/:run_id", routes.GetWorkflowRun) // 工作流运行详情
+		// 触发器
+		app.GET("/triggers", routes.GetTriggerList)       // 触发器列表
+		app.PUT("/triggers", routes.PutTrigger)           // 创建触发器
+		app.DELETE("/triggers/:id", routes.DeleteTrigger) // 吊销触发器
+		app.POST("/triggers/:token", routes.FireTrigger)  // 触发任务
 		// 统计数据
 		app.GET("/stats/home", routes.GetHomeStats) // 首页统计数据
+		// 通知
+		app.GET("/notifications/channels", routes.GetNotificationChannelList)        // 通知渠道列表
+		app.PUT("/notifications/channels", routes.PutNotificationChannel)            // 创建通知渠道
+		app.POST("/notifications/channels/:id", routes.PostNotificationChannel)      // 修改通知渠道
+		app.DELETE("/notifications/channels/:id", routes.DeleteNotificationChannel)  // 删除通知渠道
+		app.POST("/notifications/channels/:id/test", routes.TestNotificationChannel) // 发送测试通知
+		app.GET("/notifications/rules", routes.GetNotificationRuleList)              // 通知规则列表
+		app.PUT("/notifications/rules", routes.PutNotificationRule)                  // 创建通知规则
+		app.POST("/notifications/rules/:id", routes.PostNotificationRule)            // 修改通知规则
+		app.DELETE("/notifications/rules/:id", routes.DeleteNotificationRule)        // 删除通知规则
+		// 数据保留
+		app.GET("/retention/reports", routes.GetRetentionReportList) // 数据保留清理报告列表
+		app.GET("/retention/reports/:id", routes.GetRetentionReport) // 数据保留清理报告详情
+		app.POST("/retention/run", routes.RunRetention)              // 执行数据保留策略（dry_run=1时只统计）
+
 		// 用户
 		app.GET("/users", routes.GetUserList)       // 用户列表
 		app.GET("/users/:id", routes.GetUser)       // 用户详情
@@ -137,8 +209,14 @@ func main() {
 		app.DELETE("/users/:id", routes.DeleteUser) // 删除用户
 		app.POST("/login", routes.Login)            // 用户登录
 		app.GET("/me", routes.GetMe)                // 获取自己账户
+
+		// 下载令牌
+		app.PUT("/download-tokens", routes.PutDownloadToken) // 生成结果下载令牌
 	}
 
+	// 结果写入（主节点及工作节点均提供，通过任务令牌校验）
+	app.POST("/tasks/:id/results", routes.PostTaskResults)
+
 	// 路由ping
 	app.GET("/ping", routes.Ping)
 
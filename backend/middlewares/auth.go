@@ -2,19 +2,44 @@ package middlewares
 
 import (
 	"crawlab/constants"
+	"crawlab/model"
 	"crawlab/routes"
 	"crawlab/services"
 	"github.com/gin-gonic/gin"
 	"net/http"
+	"regexp"
 	"strings"
 )
 
+// 不校验用户令牌的路由（按请求方法及完整路径匹配）
+type publicRoute struct {
+	Method  string
+	Pattern *regexp.Regexp
+}
+
+var publicRoutes = []publicRoute{
+	// 登录、注册
+	{http.MethodPost, regexp.MustCompile(`^/login$`)},
+	{http.MethodPut, regexp.MustCompile(`^/users$`)},
+	// 触发任务，通过触发令牌校验
+	{http.MethodPost, regexp.MustCompile(`^/triggers/[^/]+$`)},
+	// 写入结果，通过任务令牌校验
+	{http.MethodPost, regexp.MustCompile(`^/tasks/[^/]+/results$`)},
+}
+
+func isPublicRoute(method string, path string) bool {
+	for _, route := range publicRoutes {
+		if route.Method == method && route.Pattern.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
+
 func AuthorizationMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// 如果为登录或注册，不用校验
-		if c.Request.URL.Path == "/login" ||
-			(c.Request.URL.Path == "/users" && c.Request.Method == "PUT") ||
-			strings.HasSuffix(c.Request.URL.Path, "download") {
+		// 如果为公开路由，不用校验
+		if isPublicRoute(c.Request.Method, c.Request.URL.Path) {
 			c.Next()
 			return
 		}
@@ -22,8 +47,14 @@ func AuthorizationMiddleware() gin.HandlerFunc {
 		// 获取token string
 		tokenStr := c.GetHeader("Authorization")
 
-		// 校验token
-		user, err := services.CheckToken(tokenStr)
+		// 校验token，下载地址未携带Authorization请求头时校验查询参数中的下载令牌
+		var user model.User
+		var err error
+		if tokenStr == "" && c.Request.Method == http.MethodGet && services.IsDownloadPath(c.Request.URL.Path) {
+			user, err = services.CheckDownloadToken(c.Query("token"), c.Request.URL.Path)
+		} else {
+			user, err = services.CheckToken(tokenStr)
+		}
 
 		// 校验失败，返回错误响应
 		if err != nil {
@@ -47,7 +78,8 @@ func AuthorizationMiddleware() gin.HandlerFunc {
 			}
 		}
 
-		// 校验成功
+		// 校验成功，保存当前用户
+		c.Set("user", user)
 		c.Next()
 	}
 }
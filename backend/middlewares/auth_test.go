@@ -0,0 +1,31 @@
+package middlewares
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestIsPublicRoute(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+		want   bool
+	}{
+		{http.MethodPost, "/login", true},
+		{http.MethodPut, "/users", true},
+		{http.MethodPost, "/triggers/abc", true},
+		{http.MethodPost, "/tasks/abc/results", true},
+		{http.MethodGet, "/tasks/abc/results/download", false},
+		{http.MethodGet, "/spiders/abc/results/download", false},
+		{http.MethodGet, "/spiders/abc/files/download", false},
+		{http.MethodGet, "/spiders/abc/results/download/x", false},
+		{http.MethodGet, "/tasks/abc/results", false},
+		{http.MethodPost, "/users", false},
+		{http.MethodGet, "/download", false},
+	}
+	for _, tt := range tests {
+		if got := isPublicRoute(tt.method, tt.path); got != tt.want {
+			t.Errorf("isPublicRoute(%s, %s) = %v, want %v", tt.method, tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package model
+
+import (
+	"crawlab/database"
+	"github.com/globalsign/mgo/bson"
+	"runtime/debug"
+	"time"
+)
+
+// 爬虫在节点上的部署状态
+type Deployment struct {
+	Id        bson.ObjectId `json:"_id" bson:"_id"`
+	SpiderId  bson.ObjectId `json:"spider_id" bson:"spider_id"`                       // 爬虫ID
+	NodeId    bson.ObjectId `json:"node_id" bson:"node_id"`                           // 节点ID
+	VersionId bson.ObjectId `json:"version_id,omitempty" bson:"version_id,omitempty"` // 已安装版本ID
+	Hash      string        `json:"hash" bson:"hash"`                                 // 已安装版本内容哈希
+	Status    string        `json:"status" bson:"status"`                             // 部署状态
+	Error     string        `json:"error" bson:"error"`                               // 错误信息
+	InstallTs time.Time     `json:"install_ts" bson:"install_ts"`                     // 安装时间
+
+	// 前端展示
+	NodeName string `json:"node_name" bson:"-"`
+	IsLatest bool   `json:"is_latest" bson:"-"`
+
+	UpdateTs time.Time `json:"update_ts" bson:"update_ts"`
+}
+
+// 更新部署状态，不存在则新建
+func UpsertDeployment(spiderId bson.ObjectId, nodeId bson.ObjectId, update bson.M) error {
+	s, c := database.GetCol("deployments")
+	defer s.Close()
+
+	update["update_ts"] = time.Now()
+	selector := bson.M{
+		"spider_id": spiderId,
+		"node_id":   nodeId,
+	}
+	if _, err := c.Upsert(selector, bson.M{
+		"$set":         update,
+		"$setOnInsert": bson.M{"_id": bson.NewObjectId()},
+	}); err != nil {
+		debug.PrintStack()
+		return err
+	}
+	return nil
+}
+
+func GetDeploymentList(filter interface{}) ([]Deployment, error) {
+	s, c := database.GetCol("deployments")
+	defer s.Close()
+
+	deployments := []Deployment{}
+	if err := c.Find(filter).All(&deployments); err != nil {
+		debug.PrintStack()
+		return deployments, err
+	}
+	return deployments, nil
+}
+
+// 获取爬虫在各个节点上的部署状态（尚未上报的节点状态为空）
+func GetSpiderDeploymentList(spider Spider) ([]Deployment, error) {
+	// 已上报的部署状态
+	deployments, err := GetDeploymentList(bson.M{"spider_id": spider.Id})
+	if err != nil {
+		return nil, err
+	}
+	deploymentMap := map[bson.ObjectId]Deployment{}
+	for _, d := range deployments {
+		deploymentMap[d.NodeId] = d
+	}
+
+	// 节点列表
+	nodes, err := GetNodeList(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []Deployment{}
+	for _, node := range nodes {
+		d, ok := deploymentMap[node.Id]
+		if !ok {
+			d = Deployment{
+				SpiderId: spider.Id,
+				NodeId:   node.Id,
+			}
+		}
+		d.NodeName = node.Name
+		d.IsLatest = d.Hash != "" && d.Hash == spider.Hash
+		results = append(results, d)
+	}
+	return results, nil
+}
+
+func RemoveDeploymentsBySpiderId(spiderId bson.ObjectId) error {
+	s, c := database.GetCol("deployments")
+	defer s.Close()
+
+	if _, err := c.RemoveAll(bson.M{"spider_id": spiderId}); err != nil {
+		debug.PrintStack()
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,275 @@
+package model
+
+import (
+	"crawlab/database"
+	"github.com/apex/log"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"runtime/debug"
+	"time"
+)
+
+// 通知渠道
+type NotificationChannel struct {
+	Id   bson.ObjectId `json:"_id" bson:"_id"`
+	Name string        `json:"name" bson:"name"`
+	Type string        `json:"type" bson:"type"` // 类型：email, webhook, dingtalk, wecom, slack
+
+	// SMTP邮件
+	SmtpHost     string   `json:"smtp_host" bson:"smtp_host"`
+	SmtpPort     int      `json:"smtp_port" bson:"smtp_port"`
+	SmtpUsername string   `json:"smtp_username" bson:"smtp_username"`
+	SmtpPassword string   `json:"smtp_password" bson:"smtp_password"`
+	SmtpFrom     string   `json:"smtp_from" bson:"smtp_from"` // 发件人，为空时使用用户名
+	To           []string `json:"to" bson:"to"`               // 收件人
+
+	// Webhook、机器人
+	Url     string            `json:"url" bson:"url"`         // Webhook地址
+	Secret  string            `json:"secret" bson:"secret"`   // 钉钉机器人加签密钥
+	Headers map[string]string `json:"headers" bson:"headers"` // 通用Webhook附加请求头
+
+	CreateTs time.Time `json:"create_ts" bson:"create_ts"`
+	UpdateTs time.Time `json:"update_ts" bson:"update_ts"`
+}
+
+// 通知规则，爬虫或定时任务的任务发生指定事件时，按模板向渠道发送通知
+type NotificationRule struct {
+	Id         bson.ObjectId   `json:"_id" bson:"_id"`
+	Name       string          `json:"name" bson:"name"`
+	SpiderId   bson.ObjectId   `json:"spider_id,omitempty" bson:"spider_id,omitempty"`     // 爬虫ID
+	ScheduleId bson.ObjectId   `json:"schedule_id,omitempty" bson:"schedule_id,omitempty"` // 定时任务ID
+	Events     []string        `json:"events" bson:"events"`                               // 事件：started, finished, error, cancelled, zero_results
+	ChannelIds []bson.ObjectId `json:"channel_ids" bson:"channel_ids"`                     // 通知渠道
+	Title      string          `json:"title" bson:"title"`                                 // 标题模板，为空时使用默认模板
+	Template   string          `json:"template" bson:"template"`                           // 内容模板，为空时使用默认模板
+	Disabled   bool            `json:"disabled" bson:"disabled"`                           // 是否停用
+
+	// 前端展示
+	SpiderName   string `json:"spider_name" bson:"spider_name"`
+	ScheduleName string `json:"schedule_name" bson:"schedule_name"`
+
+	CreateTs time.Time `json:"create_ts" bson:"create_ts"`
+	UpdateTs time.Time `json:"update_ts" bson:"update_ts"`
+}
+
+func (ch *NotificationChannel) Add() error {
+	s, c := database.GetCol("notification_channels")
+	defer s.Close()
+
+	ch.Id = bson.NewObjectId()
+	ch.CreateTs = time.Now()
+	ch.UpdateTs = time.Now()
+
+	if err := c.Insert(&ch); err != nil {
+		debug.PrintStack()
+		return err
+	}
+	return nil
+}
+
+func (ch *NotificationChannel) Save() error {
+	s, c := database.GetCol("notification_channels")
+	defer s.Close()
+
+	ch.UpdateTs = time.Now()
+
+	if err := c.UpdateId(ch.Id, ch); err != nil {
+		debug.PrintStack()
+		return err
+	}
+	return nil
+}
+
+func GetNotificationChannelList(filter interface{}) ([]NotificationChannel, error) {
+	s, c := database.GetCol("notification_channels")
+	defer s.Close()
+
+	channels := []NotificationChannel{}
+	if err := c.Find(filter).Sort("-create_ts").All(&channels); err != nil {
+		debug.PrintStack()
+		return channels, err
+	}
+	return channels, nil
+}
+
+func GetNotificationChannel(id bson.ObjectId) (NotificationChannel, error) {
+	s, c := database.GetCol("notification_channels")
+	defer s.Close()
+
+	var result NotificationChannel
+	if err := c.FindId(id).One(&result); err != nil {
+		if err != mgo.ErrNotFound {
+			debug.PrintStack()
+		}
+		return result, err
+	}
+	return result, nil
+}
+
+func UpdateNotificationChannel(id bson.ObjectId, item NotificationChannel) error {
+	result, err := GetNotificationChannel(id)
+	if err != nil {
+		return err
+	}
+
+	item.Id = id
+	item.CreateTs = result.CreateTs
+	return item.Save()
+}
+
+// 删除通知渠道，并从通知规则中移除
+func RemoveNotificationChannel(id bson.ObjectId) error {
+	s, c := database.GetCol("notification_channels")
+	defer s.Close()
+
+	if err := c.RemoveId(id); err != nil {
+		return err
+	}
+
+	s2, c2 := database.GetCol("notification_rules")
+	defer s2.Close()
+	if _, err := c2.UpdateAll(bson.M{"channel_ids": id}, bson.M{"$pull": bson.M{"channel_ids": id}}); err != nil {
+		debug.PrintStack()
+		return err
+	}
+	return nil
+}
+
+func (r *NotificationRule) Add() error {
+	s, c := database.GetCol("notification_rules")
+	defer s.Close()
+
+	r.Id = bson.NewObjectId()
+	r.CreateTs = time.Now()
+	r.UpdateTs = time.Now()
+
+	if err := c.Insert(&r); err != nil {
+		debug.PrintStack()
+		return err
+	}
+	return nil
+}
+
+func (r *NotificationRule) Save() error {
+	s, c := database.GetCol("notification_rules")
+	defer s.Close()
+
+	r.UpdateTs = time.Now()
+
+	if err := c.UpdateId(r.Id, r); err != nil {
+		debug.PrintStack()
+		return err
+	}
+	return nil
+}
+
+func GetNotificationRuleList(filter interface{}) ([]NotificationRule, error) {
+	s, c := database.GetCol("notification_rules")
+	defer s.Close()
+
+	rules := []NotificationRule{}
+	if err := c.Find(filter).Sort("-create_ts").All(&rules); err != nil {
+		debug.PrintStack()
+		return rules, err
+	}
+
+	for i, rule := range rules {
+		// 获取爬虫名称
+		if rule.SpiderId != "" {
+			spider, err := GetSpider(rule.SpiderId)
+			if err != nil {
+				log.Errorf(err.Error())
+			} else {
+				rules[i].SpiderName = spider.DisplayName
+			}
+		}
+
+		// 获取定时任务名称
+		if rule.ScheduleId != "" {
+			schedule, err := GetSchedule(rule.ScheduleId)
+			if err != nil {
+				log.Errorf(err.Error())
+			} else {
+				rules[i].ScheduleName = schedule.Name
+			}
+		}
+	}
+
+	return rules, nil
+}
+
+func GetNotificationRule(id bson.ObjectId) (NotificationRule, error) {
+	s, c := database.GetCol("notification_rules")
+	defer s.Close()
+
+	var result NotificationRule
+	if err := c.FindId(id).One(&result); err != nil {
+		if err != mgo.ErrNotFound {
+			debug.PrintStack()
+		}
+		return result, err
+	}
+	return result, nil
+}
+
+func UpdateNotificationRule(id bson.ObjectId, item NotificationRule) error {
+	result, err := GetNotificationRule(id)
+	if err != nil {
+		return err
+	}
+
+	item.Id = id
+	item.CreateTs = result.CreateTs
+	return item.Save()
+}
+
+// 获取任务在指定事件上生效的通知规则（任务所属爬虫或定时任务的规则）
+func GetTaskNotificationRules(t Task, event string) ([]NotificationRule, error) {
+	s, c := database.GetCol("notification_rules")
+	defer s.Close()
+
+	targets := []bson.M{{"spider_id": t.SpiderId}}
+	if t.ScheduleId != "" {
+		targets = append(targets, bson.M{"schedule_id": t.ScheduleId})
+	}
+
+	var rules []NotificationRule
+	if err := c.Find(bson.M{
+		"disabled": bson.M{"$ne": true},
+		"events":   event,
+		"$or":      targets,
+	}).All(&rules); err != nil {
+		debug.PrintStack()
+		return rules, err
+	}
+	return rules, nil
+}
+
+func RemoveNotificationRule(id bson.ObjectId) error {
+	s, c := database.GetCol("notification_rules")
+	defer s.Close()
+
+	return c.RemoveId(id)
+}
+
+func RemoveNotificationRulesBySpiderId(spiderId bson.ObjectId) error {
+	s, c := database.GetCol("notification_rules")
+	defer s.Close()
+
+	if _, err := c.RemoveAll(bson.M{"spider_id": spiderId}); err != nil {
+		debug.PrintStack()
+		return err
+	}
+	return nil
+}
+
+func RemoveNotificationRulesByScheduleId(scheduleId bson.ObjectId) error {
+	s, c := database.GetCol("notification_rules")
+	defer s.Close()
+
+	if _, err := c.RemoveAll(bson.M{"schedule_id": scheduleId}); err != nil {
+		debug.PrintStack()
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package model
+
+// 数据质量规则
+type QualityRule struct {
+	Field   string   `json:"field" bson:"field"`     // 字段名，嵌套字段以点号连接
+	Type    string   `json:"type" bson:"type"`       // 规则类型：required, regex, format, range
+	Pattern string   `json:"pattern" bson:"pattern"` // 正则表达式（regex）
+	Format  string   `json:"format" bson:"format"`   // 格式（format）：number, url, email, date
+	Min     *float64 `json:"min" bson:"min"`         // 最小值（range）
+	Max     *float64 `json:"max" bson:"max"`         // 最大值（range）
+}
+
+// 数据质量配置，任务结束时校验
+type QualityConfig struct {
+	Rules            []QualityRule `json:"rules" bson:"rules"`
+	MinResults       int           `json:"min_results" bson:"min_results"`               // 每个任务最少的结果数量，为0时不校验
+	MaxViolationRate float64       `json:"max_violation_rate" bson:"max_violation_rate"` // 允许违反规则的结果比例，超出时任务状态为finished_with_warnings；默认为0，即不允许任何结果违反规则
+	SampleSize       int           `json:"sample_size" bson:"sample_size"`               // 最多校验的结果数量，为0时使用默认值
+}
+
+// 数据质量规则的违反情况
+type QualityViolation struct {
+	Field    string   `json:"field" bson:"field"`
+	Rule     string   `json:"rule" bson:"rule"`         // 规则类型，结果数量不足时为min_results
+	Message  string   `json:"message" bson:"message"`   // 规则说明
+	Count    int      `json:"count" bson:"count"`       // 违反规则的结果数量
+	Examples []string `json:"examples" bson:"examples"` // 违反规则的结果ID
+}
+
+// 是否配置了数据质量规则
+func (q QualityConfig) IsEnabled() bool {
+	return len(q.Rules) > 0 || q.MinResults > 0
+}
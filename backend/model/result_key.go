@@ -0,0 +1,98 @@
+package model
+
+import (
+	"crawlab/database"
+	"github.com/globalsign/mgo/bson"
+	"runtime/debug"
+	"time"
+)
+
+// 结果去重键，记录相同结果首次及最近一次出现的任务
+type ResultKey struct {
+	Id          string        `json:"_id" bson:"_id"` // 爬虫ID:去重键
+	SpiderId    bson.ObjectId `json:"spider_id" bson:"spider_id"`
+	Key         string        `json:"key" bson:"key"`                     // 去重字段的哈希
+	FirstTaskId string        `json:"first_task_id" bson:"first_task_id"` // 首次出现的任务ID
+	LastTaskId  string        `json:"last_task_id" bson:"last_task_id"`   // 最近一次出现的任务ID
+	FirstSeenTs time.Time     `json:"first_seen_ts" bson:"first_seen_ts"`
+	LastSeenTs  time.Time     `json:"last_seen_ts" bson:"last_seen_ts"`
+}
+
+func getResultKeyId(spiderId bson.ObjectId, key string) string {
+	return spiderId.Hex() + ":" + key
+}
+
+// 获取已存在的去重键，返回以去重键为索引的字典
+func GetResultKeys(spiderId bson.ObjectId, keys []string) (map[string]ResultKey, error) {
+	s, c := database.GetCol("result_keys")
+	defer s.Close()
+
+	var ids []string
+	for _, key := range keys {
+		ids = append(ids, getResultKeyId(spiderId, key))
+	}
+
+	var list []ResultKey
+	if err := c.Find(bson.M{"_id": bson.M{"$in": ids}}).All(&list); err != nil {
+		debug.PrintStack()
+		return nil, err
+	}
+
+	results := map[string]ResultKey{}
+	for _, rk := range list {
+		results[rk.Key] = rk
+	}
+	return results, nil
+}
+
+// 记录去重键在该任务中出现，不存在则新建
+func UpsertResultKeys(spiderId bson.ObjectId, keys []string, taskId string) error {
+	s, c := database.GetCol("result_keys")
+	defer s.Close()
+
+	now := time.Now()
+	bulk := c.Bulk()
+	bulk.Unordered()
+	for _, key := range keys {
+		bulk.Upsert(bson.M{"_id": getResultKeyId(spiderId, key)}, bson.M{
+			"$set": bson.M{
+				"last_task_id": taskId,
+				"last_seen_ts": now,
+			},
+			"$setOnInsert": bson.M{
+				"spider_id":     spiderId,
+				"key":           key,
+				"first_task_id": taskId,
+				"first_seen_ts": now,
+			},
+		})
+	}
+	if _, err := bulk.Run(); err != nil {
+		debug.PrintStack()
+		return err
+	}
+	return nil
+}
+
+func RemoveResultKeysBySpiderId(spiderId bson.ObjectId) error {
+	s, c := database.GetCol("result_keys")
+	defer s.Close()
+
+	if _, err := c.RemoveAll(bson.M{"spider_id": spiderId}); err != nil {
+		debug.PrintStack()
+		return err
+	}
+	return nil
+}
+
+// 删除去重键，query为查询条件
+func RemoveResultKeys(query bson.M) error {
+	s, c := database.GetCol("result_keys")
+	defer s.Close()
+
+	if _, err := c.RemoveAll(query); err != nil {
+		debug.PrintStack()
+		return err
+	}
+	return nil
+}
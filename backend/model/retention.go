@@ -0,0 +1,143 @@
+package model
+
+import (
+	"crawlab/database"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"runtime/debug"
+	"time"
+)
+
+// 爬虫结果保留策略，两者均为0时永久保留
+// 任务超出任一限制时删除其结果（运行中的任务除外），任务记录保留
+type RetentionPolicy struct {
+	Days  int `json:"days" bson:"days"`   // 保留最近N天的任务结果
+	Tasks int `json:"tasks" bson:"tasks"` // 保留最近N个任务的结果
+}
+
+// 是否配置了保留策略
+func (p RetentionPolicy) IsEnabled() bool {
+	return p.Days > 0 || p.Tasks > 0
+}
+
+// 爬虫结果清理情况
+type RetentionSpiderReport struct {
+	SpiderId   bson.ObjectId `json:"spider_id" bson:"spider_id"`
+	SpiderName string        `json:"spider_name" bson:"spider_name"`
+	Tasks      int           `json:"tasks" bson:"tasks"`     // 结果过期的任务数量
+	Results    int           `json:"results" bson:"results"` // 删除的结果数量
+	Error      string        `json:"error" bson:"error"`
+}
+
+// 节点日志清理情况（由节点清理后回报）
+type RetentionNodeReport struct {
+	NodeId   bson.ObjectId `json:"node_id" bson:"node_id"`
+	NodeName string        `json:"node_name" bson:"node_name"`
+	Done     bool          `json:"done" bson:"done"`           // 节点是否已回报
+	LogFiles int           `json:"log_files" bson:"log_files"` // 删除的日志文件数量
+	LogBytes int64         `json:"log_bytes" bson:"log_bytes"` // 删除的日志文件大小
+	Error    string        `json:"error" bson:"error"`
+}
+
+// 数据保留清理报告
+type RetentionReport struct {
+	Id     bson.ObjectId `json:"_id" bson:"_id"`
+	DryRun bool          `json:"dry_run" bson:"dry_run"` // 试运行，只统计不删除
+
+	// 爬虫结果
+	Spiders []RetentionSpiderReport `json:"spiders" bson:"spiders"`
+
+	// 任务记录
+	TaskDays    int `json:"task_days" bson:"task_days"`       // 任务记录保留天数
+	TaskRecords int `json:"task_records" bson:"task_records"` // 删除的任务记录数量
+	TaskResults int `json:"task_results" bson:"task_results"` // 随任务记录删除的结果数量
+
+	// 日志文件
+	LogDays int                   `json:"log_days" bson:"log_days"` // 日志文件保留天数
+	Nodes   []RetentionNodeReport `json:"nodes" bson:"nodes"`
+
+	Error string `json:"error" bson:"error"`
+
+	CreateTs time.Time `json:"create_ts" bson:"create_ts"`
+	FinishTs time.Time `json:"finish_ts" bson:"finish_ts"`
+}
+
+func (r *RetentionReport) Add() error {
+	s, c := database.GetCol("retention_reports")
+	defer s.Close()
+
+	if r.Id == "" {
+		r.Id = bson.NewObjectId()
+	}
+	r.CreateTs = time.Now()
+
+	if err := c.Insert(r); err != nil {
+		debug.PrintStack()
+		return err
+	}
+	return nil
+}
+
+func (r *RetentionReport) Save() error {
+	s, c := database.GetCol("retention_reports")
+	defer s.Close()
+
+	// 节点回报由UpdateRetentionNodeReport单独更新，避免覆盖
+	if err := c.UpdateId(r.Id, bson.M{"$set": bson.M{
+		"spiders":      r.Spiders,
+		"task_records": r.TaskRecords,
+		"task_results": r.TaskResults,
+		"error":        r.Error,
+		"finish_ts":    r.FinishTs,
+	}}); err != nil {
+		debug.PrintStack()
+		return err
+	}
+	return nil
+}
+
+func GetRetentionReportList(filter interface{}, skip int, limit int) ([]RetentionReport, error) {
+	s, c := database.GetCol("retention_reports")
+	defer s.Close()
+
+	reports := []RetentionReport{}
+	if err := c.Find(filter).Skip(skip).Limit(limit).Sort("-create_ts").All(&reports); err != nil {
+		debug.PrintStack()
+		return reports, err
+	}
+	return reports, nil
+}
+
+func GetRetentionReportListTotal(filter interface{}) (int, error) {
+	s, c := database.GetCol("retention_reports")
+	defer s.Close()
+
+	return c.Find(filter).Count()
+}
+
+func GetRetentionReport(id bson.ObjectId) (RetentionReport, error) {
+	s, c := database.GetCol("retention_reports")
+	defer s.Close()
+
+	var result RetentionReport
+	if err := c.FindId(id).One(&result); err != nil {
+		if err != mgo.ErrNotFound {
+			debug.PrintStack()
+		}
+		return result, err
+	}
+	return result, nil
+}
+
+// 记录节点的日志清理情况（存在多个主节点时会重复收到回报，使用$set保证幂等）
+func UpdateRetentionNodeReport(id bson.ObjectId, node RetentionNodeReport) error {
+	s, c := database.GetCol("retention_reports")
+	defer s.Close()
+
+	return c.Update(bson.M{"_id": id, "nodes.node_id": node.NodeId}, bson.M{"$set": bson.M{
+		"nodes.$.done":      true,
+		"nodes.$.log_files": node.LogFiles,
+		"nodes.$.log_bytes": node.LogBytes,
+		"nodes.$.error":     node.Error,
+	}})
+}
@@ -19,9 +19,18 @@ type Schedule struct {
 	Cron        string        `json:"cron" bson:"cron"`
 	EntryId     cron.EntryID  `json:"entry_id" bson:"entry_id"`
 
+	// 定时运行工作流（设置后忽略爬虫）
+	WorkflowId bson.ObjectId `json:"workflow_id,omitempty" bson:"workflow_id,omitempty"`
+
+	// 错过执行的补跑策略
+	MisfirePolicy  string    `json:"misfire_policy" bson:"misfire_policy"`
+	MisfireMaxRuns int       `json:"misfire_max_runs" bson:"misfire_max_runs"`
+	LastFireTs     time.Time `json:"last_fire_ts" bson:"last_fire_ts"`
+
 	// 前端展示
-	SpiderName string `json:"spider_name" bson:"spider_name"`
-	NodeName   string `json:"node_name" bson:"node_name"`
+	SpiderName   string `json:"spider_name" bson:"spider_name"`
+	NodeName     string `json:"node_name" bson:"node_name"`
+	WorkflowName string `json:"workflow_name" bson:"workflow_name"`
 
 	CreateTs time.Time `json:"create_ts" bson:"create_ts"`
 	UpdateTs time.Time `json:"update_ts" bson:"update_ts"`
@@ -47,6 +56,17 @@ func GetScheduleList(filter interface{}) ([]Schedule, error) {
 	}
 
 	for i, schedule := range schedules {
+		// 获取工作流名称
+		if schedule.WorkflowId != "" {
+			workflow, err := GetWorkflow(schedule.WorkflowId)
+			if err != nil {
+				log.Errorf(err.Error())
+				continue
+			}
+			schedules[i].WorkflowName = workflow.Name
+			continue
+		}
+
 		// 获取节点名称
 		if schedule.NodeId == bson.ObjectIdHex(constants.ObjectIdNull) {
 			// 选择所有节点
@@ -92,12 +112,26 @@ func UpdateSchedule(id bson.ObjectId, item Schedule) error {
 		return err
 	}
 
+	// 保留创建时间与最后一次触发时间
+	item.CreateTs = result.CreateTs
+	item.LastFireTs = result.LastFireTs
+
 	if err := item.Save(); err != nil {
 		return err
 	}
 	return nil
 }
 
+func UpdateScheduleLastFireTs(id bson.ObjectId, ts time.Time) error {
+	s, c := database.GetCol("schedules")
+	defer s.Close()
+
+	if err := c.UpdateId(id, bson.M{"$set": bson.M{"last_fire_ts": ts}}); err != nil {
+		return err
+	}
+	return nil
+}
+
 func AddSchedule(item Schedule) error {
 	s, c := database.GetCol("schedules")
 	defer s.Close()
@@ -141,3 +175,14 @@ func GetScheduleCount() (int, error) {
 
 	return count, nil
 }
+
+func RemoveSchedulesBySpiderId(spiderId bson.ObjectId) error {
+	s, c := database.GetCol("schedules")
+	defer s.Close()
+
+	if _, err := c.RemoveAll(bson.M{"spider_id": spiderId}); err != nil {
+		debug.PrintStack()
+		return err
+	}
+	return nil
+}
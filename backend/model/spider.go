@@ -2,6 +2,7 @@ package model
 
 import (
 	"crawlab/database"
+	"crawlab/sinks"
 	"github.com/apex/log"
 	"github.com/globalsign/mgo"
 	"github.com/globalsign/mgo/bson"
@@ -24,10 +25,45 @@ type Spider struct {
 	Site        string        `json:"site"`                             // 爬虫网站
 	Envs        []Env         `json:"envs" bson:"envs"`                 // 环境变量
 
+	// 版本
+	VersionId bson.ObjectId `json:"version_id,omitempty" bson:"version_id,omitempty"` // 当前版本ID
+	Hash      string        `json:"hash" bson:"hash"`                                 // 当前版本内容哈希
+	CommitSha string        `json:"commit_sha" bson:"commit_sha"`                     // 当前版本Git提交SHA
+
+	// Git仓库
+	GitUrl      string    `json:"git_url" bson:"git_url"`             // 仓库地址
+	GitBranch   string    `json:"git_branch" bson:"git_branch"`       // 分支或标签（为空时使用默认分支）
+	GitUsername string    `json:"git_username" bson:"git_username"`   // 用户名
+	GitPassword string    `json:"git_password" bson:"git_password"`   // 密码或访问令牌
+	GitPullCron string    `json:"git_pull_cron" bson:"git_pull_cron"` // 自动拉取的Cron表达式（为空时不自动拉取）
+	GitCommit   string    `json:"git_commit" bson:"git_commit"`       // 工作目录当前提交SHA
+	GitPullTs   time.Time `json:"git_pull_ts" bson:"git_pull_ts"`     // 最后一次拉取时间
+	GitError    string    `json:"git_error" bson:"git_error"`         // 最后一次拉取错误
+
 	// 自定义爬虫
 	Src string `json:"src" bson:"src"` // 源码位置
 	Cmd string `json:"cmd" bson:"cmd"` // 执行命令
 
+	// 项目类型（自动检测）
+	Framework     string   `json:"framework" bson:"framework"`           // 项目类型：scrapy, node, python, go
+	ScrapySpiders []string `json:"scrapy_spiders" bson:"scrapy_spiders"` // Scrapy项目中的爬虫名称，可作为任务的运行目标
+
+	// Scrapy项目
+	ScrapyPipeline bool `json:"scrapy_pipeline" bson:"scrapy_pipeline"` // 是否注入Crawlab标准结果管道
+
+	// 结果储存（默认储存在MongoDB结果集Col中）
+	Sink sinks.Config `json:"sink" bson:"sink"`
+
+	// 结果去重（通过结果写入接口写入的结果）
+	DedupKeys []string `json:"dedup_keys" bson:"dedup_keys"` // 去重字段，如url，多个字段时按组合去重
+	DedupMode string   `json:"dedup_mode" bson:"dedup_mode"` // 重复结果处理方式：skip 丢弃, upsert 更新, keep 保留
+
+	// 数据质量规则
+	Quality QualityConfig `json:"quality" bson:"quality"`
+
+	// 结果保留策略
+	Retention RetentionPolicy `json:"retention" bson:"retention"`
+
 	// 前端展示
 	LastRunTs time.Time `json:"last_run_ts"` // 最后一次执行时间
 
@@ -73,6 +109,11 @@ func (spider *Spider) Add() error {
 	return nil
 }
 
+// 获取爬虫的结果储存
+func (spider *Spider) GetSink() (sinks.Sink, error) {
+	return sinks.New(spider.Sink, spider.Col)
+}
+
 func (spider *Spider) GetTasks() ([]Task, error) {
 	tasks, err := GetTaskList(bson.M{"spider_id": spider.Id}, 0, 10, "-create_ts")
 	if err != nil {
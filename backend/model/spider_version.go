@@ -0,0 +1,103 @@
+package model
+
+import (
+	"crawlab/database"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"runtime/debug"
+	"time"
+)
+
+type SpiderFile struct {
+	Path string `json:"path" bson:"path"` // 相对路径
+	Hash string `json:"hash" bson:"hash"` // 内容哈希
+	Size int64  `json:"size" bson:"size"` // 文件大小
+}
+
+type SpiderVersion struct {
+	Id        bson.ObjectId `json:"_id" bson:"_id"`
+	SpiderId  bson.ObjectId `json:"spider_id" bson:"spider_id"`   // 爬虫ID
+	FileId    bson.ObjectId `json:"file_id" bson:"file_id"`       // GridFS文件ID
+	Hash      string        `json:"hash" bson:"hash"`             // 内容哈希
+	Uploader  string        `json:"uploader" bson:"uploader"`     // 上传者
+	Changelog string        `json:"changelog" bson:"changelog"`   // 变更说明
+	CommitSha string        `json:"commit_sha" bson:"commit_sha"` // Git提交SHA
+	Files     []SpiderFile  `json:"files" bson:"files"`           // 文件清单
+
+	CreateTs time.Time `json:"create_ts" bson:"create_ts"`
+}
+
+func (v *SpiderVersion) Add() error {
+	s, c := database.GetCol("spider_versions")
+	defer s.Close()
+
+	v.Id = bson.NewObjectId()
+	v.CreateTs = time.Now()
+
+	if err := c.Insert(&v); err != nil {
+		debug.PrintStack()
+		return err
+	}
+	return nil
+}
+
+func GetSpiderVersionList(spiderId bson.ObjectId) ([]SpiderVersion, error) {
+	s, c := database.GetCol("spider_versions")
+	defer s.Close()
+
+	versions := []SpiderVersion{}
+	if err := c.Find(bson.M{"spider_id": spiderId}).Select(bson.M{"files": 0}).Sort("-create_ts").All(&versions); err != nil {
+		debug.PrintStack()
+		return versions, err
+	}
+	return versions, nil
+}
+
+func GetSpiderVersion(id bson.ObjectId) (SpiderVersion, error) {
+	s, c := database.GetCol("spider_versions")
+	defer s.Close()
+
+	var result SpiderVersion
+	if err := c.FindId(id).One(&result); err != nil {
+		if err != mgo.ErrNotFound {
+			debug.PrintStack()
+		}
+		return result, err
+	}
+	return result, nil
+}
+
+func GetSpiderVersionByHash(spiderId bson.ObjectId, hash string) (SpiderVersion, error) {
+	s, c := database.GetCol("spider_versions")
+	defer s.Close()
+
+	var result SpiderVersion
+	if err := c.Find(bson.M{"spider_id": spiderId, "hash": hash}).Sort("-create_ts").One(&result); err != nil {
+		if err != mgo.ErrNotFound {
+			debug.PrintStack()
+		}
+		return result, err
+	}
+	return result, nil
+}
+
+func RemoveSpiderVersion(id bson.ObjectId) error {
+	s, c := database.GetCol("spider_versions")
+	defer s.Close()
+
+	if err := c.RemoveId(id); err != nil {
+		return err
+	}
+	return nil
+}
+
+func RemoveSpiderVersionsBySpiderId(spiderId bson.ObjectId) error {
+	s, c := database.GetCol("spider_versions")
+	defer s.Close()
+
+	if _, err := c.RemoveAll(bson.M{"spider_id": spiderId}); err != nil {
+		debug.PrintStack()
+		return err
+	}
+	return nil
+}
@@ -1,11 +1,16 @@
 package model
 
+import "time"
+
 type SystemInfo struct {
 	ARCH        string       `json:"arch"`
 	OS          string       `json:"os"`
 	Hostname    string       `json:"host_name"`
 	NumCpu      int          `json:"num_cpu"`
 	Executables []Executable `json:"executables"`
+
+	// 爬虫依赖安装状态
+	Dependencies []Dependency `json:"dependencies"`
 }
 
 type Executable struct {
@@ -13,3 +18,14 @@ type Executable struct {
 	FileName    string `json:"file_name"`
 	DisplayName string `json:"display_name"`
 }
+
+type Dependency struct {
+	SpiderId   string    `json:"spider_id"`
+	SpiderName string    `json:"spider_name"`
+	Type       string    `json:"type"`       // 依赖类型：python, node, go
+	Hash       string    `json:"hash"`       // 依赖文件哈希
+	Status     string    `json:"status"`     // 安装状态
+	Error      string    `json:"error"`      // 错误信息
+	Log        string    `json:"log"`        // 安装日志（末尾部分）
+	InstallTs  time.Time `json:"install_ts"` // 安装时间
+}
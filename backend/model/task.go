@@ -24,6 +24,33 @@ type Task struct {
 	WaitDuration    float64       `json:"wait_duration" bson:"wait_duration"`
 	RuntimeDuration float64       `json:"runtime_duration" bson:"runtime_duration"`
 	TotalDuration   float64       `json:"total_duration" bson:"total_duration"`
+	Param           string        `json:"param" bson:"param"`
+	Envs            []Env         `json:"envs" bson:"envs"`
+	Target          string        `json:"target" bson:"target"`
+
+	// 执行时的爬虫版本
+	SpiderVersionId bson.ObjectId `json:"spider_version_id,omitempty" bson:"spider_version_id,omitempty"`
+	SpiderHash      string        `json:"spider_hash" bson:"spider_hash"`
+	CommitSha       string        `json:"commit_sha" bson:"commit_sha"`
+
+	// 结果写入统计（去重）
+	InsertedCount int `json:"inserted_count" bson:"inserted_count"` // 新增结果数
+	UpdatedCount  int `json:"updated_count" bson:"updated_count"`   // 更新结果数
+	SkippedCount  int `json:"skipped_count" bson:"skipped_count"`   // 丢弃的重复结果数
+
+	// 数据质量校验
+	QualityChecked    int                `json:"quality_checked" bson:"quality_checked"`       // 校验的结果数量
+	QualityViolations []QualityViolation `json:"quality_violations" bson:"quality_violations"` // 违反的规则
+
+	// 结果保留
+	ResultsExpired bool `json:"results_expired" bson:"results_expired"` // 结果已按保留策略删除
+
+	// 工作流
+	WorkflowRunId bson.ObjectId `json:"workflow_run_id,omitempty" bson:"workflow_run_id,omitempty"`
+
+	// 定时任务
+	ScheduleId bson.ObjectId `json:"schedule_id,omitempty" bson:"schedule_id,omitempty"`
+	MisfireTs  time.Time     `json:"misfire_ts" bson:"misfire_ts,omitempty"` // 补跑任务对应的错过的触发时间
 
 	// 前端数据
 	SpiderName string `json:"spider_name"`
@@ -82,25 +109,12 @@ func (t *Task) GetResults(pageNum int, pageSize int) (results []interface{}, tot
 		return
 	}
 
-	if spider.Col == "" {
-		return
-	}
-
-	s, c := database.GetCol(spider.Col)
-	defer s.Close()
-
-	query := bson.M{
-		"task_id": t.Id,
-	}
-	if err = c.Find(query).Skip((pageNum - 1) * pageSize).Limit(pageSize).Sort("-create_ts").All(&results); err != nil {
-		return
-	}
-
-	if total, err = c.Find(query).Count(); err != nil {
+	// 通过结果储存获取结果
+	sink, err := spider.GetSink()
+	if err != nil {
 		return
 	}
-
-	return
+	return sink.Preview(t.Id, pageNum, pageSize)
 }
 
 func GetTaskList(filter interface{}, skip int, limit int, sortKey string) ([]Task, error) {
@@ -207,7 +221,7 @@ func GetDailyTaskStats(query bson.M) ([]TaskDailyItem, error) {
 	defer s.Close()
 
 	// 起始日期
-	startDate := time.Now().Add(- 30 * 24 * time.Hour)
+	startDate := time.Now().Add(-30 * 24 * time.Hour)
 	endDate := time.Now()
 
 	// query
@@ -234,9 +248,9 @@ func GetDailyTaskStats(query bson.M) ([]TaskDailyItem, error) {
 			"success_count": bson.M{
 				"$cond": []interface{}{
 					bson.M{
-						"$eq": []string{
+						"$in": []interface{}{
 							"$status",
-							constants.StatusFinished,
+							[]string{constants.StatusFinished, constants.StatusFinishedWithWarnings},
 						},
 					},
 					1,
@@ -308,22 +322,112 @@ func UpdateTaskResultCount(id string) (err error) {
 		return err
 	}
 
-	// 获取结果数量
-	s, c := database.GetCol(spider.Col)
-	defer s.Close()
-	resultCount, err := c.Find(bson.M{"task_id": task.Id}).Count()
+	// 通过结果储存获取结果数量
+	sink, err := spider.GetSink()
+	if err != nil {
+		log.Errorf(err.Error())
+		debug.PrintStack()
+		return err
+	}
+	resultCount, err := sink.Count(task.Id)
 	if err != nil {
 		log.Errorf(err.Error())
 		debug.PrintStack()
 		return err
 	}
 
-	// 保存结果数量
-	task.ResultCount = resultCount
-	if err := task.Save(); err != nil {
+	// 保存结果数量（只更新该字段，避免覆盖执行中任务的其他字段）
+	s, c := database.GetCol("tasks")
+	defer s.Close()
+	if err := c.UpdateId(task.Id, bson.M{"$set": bson.M{"result_count": resultCount}}); err != nil {
 		log.Errorf(err.Error())
 		debug.PrintStack()
 		return err
 	}
 	return nil
 }
+
+// 更新任务状态（只更新状态、错误信息及时间字段，避免覆盖任务执行期间写入的结果数量等字段）
+func UpdateTaskStatus(t Task) error {
+	s, c := database.GetCol("tasks")
+	defer s.Close()
+	if err := c.UpdateId(t.Id, bson.M{"$set": bson.M{
+		"status":    t.Status,
+		"error":     t.Error,
+		"finish_ts": t.FinishTs,
+		"update_ts": time.Now(),
+	}}); err != nil {
+		debug.PrintStack()
+		return err
+	}
+	return nil
+}
+
+// 增加任务结果数量及新增、更新、丢弃的结果数（结果写入接口调用）
+func IncTaskResultCounts(id string, inserted int, updated int, skipped int) error {
+	s, c := database.GetCol("tasks")
+	defer s.Close()
+	if err := c.UpdateId(id, bson.M{"$inc": bson.M{
+		"result_count":   inserted + updated,
+		"inserted_count": inserted,
+		"updated_count":  updated,
+		"skipped_count":  skipped,
+	}}); err != nil {
+		debug.PrintStack()
+		return err
+	}
+	return nil
+}
+
+func RemoveTasksBySpiderId(spiderId bson.ObjectId) error {
+	s, c := database.GetCol("tasks")
+	defer s.Close()
+
+	if _, err := c.RemoveAll(bson.M{"spider_id": spiderId}); err != nil {
+		debug.PrintStack()
+		return err
+	}
+	return nil
+}
+
+// 获取任务列表（只返回清理所需的字段，不查询爬虫及节点名称）
+func GetTaskBriefList(filter interface{}, sortKey string) ([]Task, error) {
+	s, c := database.GetCol("tasks")
+	defer s.Close()
+
+	var tasks []Task
+	if err := c.Find(filter).Select(bson.M{
+		"spider_id":       1,
+		"node_id":         1,
+		"status":          1,
+		"create_ts":       1,
+		"results_expired": 1,
+	}).Sort(sortKey).All(&tasks); err != nil {
+		debug.PrintStack()
+		return tasks, err
+	}
+	return tasks, nil
+}
+
+// 标记任务结果已按保留策略删除
+func SetTasksResultsExpired(ids []string) error {
+	s, c := database.GetCol("tasks")
+	defer s.Close()
+
+	if _, err := c.UpdateAll(bson.M{"_id": bson.M{"$in": ids}}, bson.M{"$set": bson.M{"results_expired": true}}); err != nil {
+		debug.PrintStack()
+		return err
+	}
+	return nil
+}
+
+func RemoveTasks(ids []string) error {
+	s, c := database.GetCol("tasks")
+	defer s.Close()
+
+	if _, err := c.RemoveAll(bson.M{"_id": bson.M{"$in": ids}}); err != nil {
+		debug.PrintStack()
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package model
+
+import (
+	"crawlab/database"
+	"github.com/apex/log"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"runtime/debug"
+	"time"
+)
+
+type Trigger struct {
+	Id        bson.ObjectId `json:"_id" bson:"_id"`
+	Name      string        `json:"name" bson:"name"`           // 触发器名称
+	SpiderId  bson.ObjectId `json:"spider_id" bson:"spider_id"` // 爬虫ID（令牌仅能触发该爬虫）
+	NodeId    bson.ObjectId `json:"node_id" bson:"node_id"`     // 节点ID
+	Param     string        `json:"param" bson:"param"`         // 默认执行参数
+	TokenHash string        `json:"-" bson:"token_hash"`        // 令牌哈希（令牌明文仅在创建时返回）
+	Revoked   bool          `json:"revoked" bson:"revoked"`     // 是否已吊销
+	Count     int           `json:"count" bson:"count"`         // 触发次数
+	LastTs    time.Time     `json:"last_ts" bson:"last_ts"`     // 最后一次触发时间
+	RevokeTs  time.Time     `json:"revoke_ts" bson:"revoke_ts"` // 吊销时间
+
+	// 前端展示
+	SpiderName string `json:"spider_name" bson:"spider_name"`
+
+	CreateTs time.Time `json:"create_ts" bson:"create_ts"`
+	UpdateTs time.Time `json:"update_ts" bson:"update_ts"`
+}
+
+func (t *Trigger) Add() error {
+	s, c := database.GetCol("triggers")
+	defer s.Close()
+
+	t.Id = bson.NewObjectId()
+	t.CreateTs = time.Now()
+	t.UpdateTs = time.Now()
+
+	if err := c.Insert(&t); err != nil {
+		debug.PrintStack()
+		return err
+	}
+	return nil
+}
+
+func GetTriggerList(filter interface{}) ([]Trigger, error) {
+	s, c := database.GetCol("triggers")
+	defer s.Close()
+
+	triggers := []Trigger{}
+	if err := c.Find(filter).Sort("-create_ts").All(&triggers); err != nil {
+		debug.PrintStack()
+		return triggers, err
+	}
+
+	for i, trigger := range triggers {
+		// 获取爬虫名称
+		spider, err := GetSpider(trigger.SpiderId)
+		if err != nil {
+			log.Errorf(err.Error())
+			continue
+		}
+		triggers[i].SpiderName = spider.DisplayName
+	}
+
+	return triggers, nil
+}
+
+func GetTriggerByTokenHash(tokenHash string) (Trigger, error) {
+	s, c := database.GetCol("triggers")
+	defer s.Close()
+
+	var result Trigger
+	if err := c.Find(bson.M{"token_hash": tokenHash, "revoked": false}).One(&result); err != nil {
+		if err != mgo.ErrNotFound {
+			debug.PrintStack()
+		}
+		return result, err
+	}
+	return result, nil
+}
+
+func RevokeTrigger(id bson.ObjectId) error {
+	s, c := database.GetCol("triggers")
+	defer s.Close()
+
+	update := bson.M{
+		"$set": bson.M{
+			"revoked":   true,
+			"revoke_ts": time.Now(),
+			"update_ts": time.Now(),
+		},
+	}
+	if err := c.UpdateId(id, update); err != nil {
+		return err
+	}
+	return nil
+}
+
+func UpdateTriggerLastTs(id bson.ObjectId) error {
+	s, c := database.GetCol("triggers")
+	defer s.Close()
+
+	update := bson.M{
+		"$set": bson.M{"last_ts": time.Now()},
+		"$inc": bson.M{"count": 1},
+	}
+	if err := c.UpdateId(id, update); err != nil {
+		return err
+	}
+	return nil
+}
+
+func RemoveTriggersBySpiderId(spiderId bson.ObjectId) error {
+	s, c := database.GetCol("triggers")
+	defer s.Close()
+
+	if _, err := c.RemoveAll(bson.M{"spider_id": spiderId}); err != nil {
+		debug.PrintStack()
+		return err
+	}
+	return nil
+}
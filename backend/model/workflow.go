@@ -0,0 +1,217 @@
+package model
+
+import (
+	"crawlab/database"
+	"github.com/apex/log"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"runtime/debug"
+	"time"
+)
+
+type WorkflowDependency struct {
+	Step      string `json:"step" bson:"step"`           // 上游步骤名称
+	Condition string `json:"condition" bson:"condition"` // 触发条件: on_success, on_failure, always
+}
+
+type WorkflowStep struct {
+	Name         string               `json:"name" bson:"name"`                 // 步骤名称（工作流内唯一）
+	SpiderId     bson.ObjectId        `json:"spider_id" bson:"spider_id"`       // 爬虫ID
+	NodeId       bson.ObjectId        `json:"node_id" bson:"node_id"`           // 节点ID
+	Param        string               `json:"param" bson:"param"`               // 执行参数，可引用$CRAWLAB_UPSTREAM_TASK_ID、$CRAWLAB_UPSTREAM_TASK_IDS（执行前展开）
+	Dependencies []WorkflowDependency `json:"dependencies" bson:"dependencies"` // 上游依赖
+}
+
+type Workflow struct {
+	Id          bson.ObjectId  `json:"_id" bson:"_id"`
+	Name        string         `json:"name" bson:"name"`
+	Description string         `json:"description" bson:"description"`
+	Steps       []WorkflowStep `json:"steps" bson:"steps"`
+
+	CreateTs time.Time `json:"create_ts" bson:"create_ts"`
+	UpdateTs time.Time `json:"update_ts" bson:"update_ts"`
+}
+
+type WorkflowRunStep struct {
+	WorkflowStep `bson:",inline"`
+
+	TaskId string `json:"task_id" bson:"task_id"` // 任务ID
+	Status string `json:"status" bson:"status"`   // 步骤状态
+}
+
+type WorkflowRun struct {
+	Id         bson.ObjectId     `json:"_id" bson:"_id"`
+	WorkflowId bson.ObjectId     `json:"workflow_id" bson:"workflow_id"`
+	Status     string            `json:"status" bson:"status"`
+	Trigger    string            `json:"trigger" bson:"trigger"`
+	Steps      []WorkflowRunStep `json:"steps" bson:"steps"`
+	StartTs    time.Time         `json:"start_ts" bson:"start_ts"`
+	FinishTs   time.Time         `json:"finish_ts" bson:"finish_ts"`
+
+	// 前端展示
+	WorkflowName string `json:"workflow_name" bson:"workflow_name"`
+
+	CreateTs time.Time `json:"create_ts" bson:"create_ts"`
+	UpdateTs time.Time `json:"update_ts" bson:"update_ts"`
+}
+
+func (wf *Workflow) Save() error {
+	s, c := database.GetCol("workflows")
+	defer s.Close()
+
+	wf.UpdateTs = time.Now()
+
+	if err := c.UpdateId(wf.Id, wf); err != nil {
+		debug.PrintStack()
+		return err
+	}
+	return nil
+}
+
+func (wf *Workflow) Add() error {
+	s, c := database.GetCol("workflows")
+	defer s.Close()
+
+	wf.Id = bson.NewObjectId()
+	wf.CreateTs = time.Now()
+	wf.UpdateTs = time.Now()
+
+	if err := c.Insert(&wf); err != nil {
+		debug.PrintStack()
+		return err
+	}
+	return nil
+}
+
+func GetWorkflowList(filter interface{}) ([]Workflow, error) {
+	s, c := database.GetCol("workflows")
+	defer s.Close()
+
+	workflows := []Workflow{}
+	if err := c.Find(filter).Sort("-create_ts").All(&workflows); err != nil {
+		debug.PrintStack()
+		return workflows, err
+	}
+	return workflows, nil
+}
+
+func GetWorkflow(id bson.ObjectId) (Workflow, error) {
+	s, c := database.GetCol("workflows")
+	defer s.Close()
+
+	var result Workflow
+	if err := c.FindId(id).One(&result); err != nil {
+		if err != mgo.ErrNotFound {
+			debug.PrintStack()
+		}
+		return result, err
+	}
+	return result, nil
+}
+
+func UpdateWorkflow(id bson.ObjectId, item Workflow) error {
+	s, c := database.GetCol("workflows")
+	defer s.Close()
+
+	var result Workflow
+	if err := c.FindId(id).One(&result); err != nil {
+		debug.PrintStack()
+		return err
+	}
+
+	item.CreateTs = result.CreateTs
+	if err := item.Save(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func RemoveWorkflow(id bson.ObjectId) error {
+	s, c := database.GetCol("workflows")
+	defer s.Close()
+
+	var result Workflow
+	if err := c.FindId(id).One(&result); err != nil {
+		return err
+	}
+
+	if err := c.RemoveId(id); err != nil {
+		return err
+	}
+
+	// 删除运行记录
+	s2, c2 := database.GetCol("workflow_runs")
+	defer s2.Close()
+	if _, err := c2.RemoveAll(bson.M{"workflow_id": id}); err != nil {
+		log.Errorf(err.Error())
+		debug.PrintStack()
+		return err
+	}
+
+	return nil
+}
+
+func (run *WorkflowRun) Save() error {
+	s, c := database.GetCol("workflow_runs")
+	defer s.Close()
+
+	run.UpdateTs = time.Now()
+
+	if err := c.UpdateId(run.Id, run); err != nil {
+		debug.PrintStack()
+		return err
+	}
+	return nil
+}
+
+func (run *WorkflowRun) Add() error {
+	s, c := database.GetCol("workflow_runs")
+	defer s.Close()
+
+	run.CreateTs = time.Now()
+	run.UpdateTs = time.Now()
+
+	if err := c.Insert(&run); err != nil {
+		debug.PrintStack()
+		return err
+	}
+	return nil
+}
+
+func GetWorkflowRunList(filter interface{}, skip int, limit int, sortKey string) ([]WorkflowRun, error) {
+	s, c := database.GetCol("workflow_runs")
+	defer s.Close()
+
+	runs := []WorkflowRun{}
+	if err := c.Find(filter).Skip(skip).Limit(limit).Sort(sortKey).All(&runs); err != nil {
+		debug.PrintStack()
+		return runs, err
+	}
+	return runs, nil
+}
+
+func GetWorkflowRunListTotal(filter interface{}) (int, error) {
+	s, c := database.GetCol("workflow_runs")
+	defer s.Close()
+
+	var result int
+	result, err := c.Find(filter).Count()
+	if err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+func GetWorkflowRun(id bson.ObjectId) (WorkflowRun, error) {
+	s, c := database.GetCol("workflow_runs")
+	defer s.Close()
+
+	var result WorkflowRun
+	if err := c.FindId(id).One(&result); err != nil {
+		if err != mgo.ErrNotFound {
+			debug.PrintStack()
+		}
+		return result, err
+	}
+	return result, nil
+}
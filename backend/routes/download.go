@@ -0,0 +1,44 @@
+package routes
+
+import (
+	"crawlab/services"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+type DownloadTokenRequestData struct {
+	Path string `json:"path" binding:"required"`
+}
+
+// 生成下载令牌，前端下载结果时将令牌作为查询参数token附加到下载地址
+func PutDownloadToken(c *gin.Context) {
+	var data DownloadTokenRequestData
+	if err := c.ShouldBindJSON(&data); err != nil {
+		HandleError(http.StatusBadRequest, c, err)
+		return
+	}
+
+	user, ok := GetCurrentUser(c)
+	if !ok {
+		HandleError(http.StatusUnauthorized, c, errors.New("unauthorized"))
+		return
+	}
+
+	if !services.IsDownloadPath(data.Path) {
+		HandleErrorF(http.StatusBadRequest, c, "invalid download path")
+		return
+	}
+
+	token, err := services.MakeDownloadToken(user, data.Path)
+	if err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "ok",
+		Message: "success",
+		Data:    token,
+	})
+}
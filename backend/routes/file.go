@@ -1,16 +1,25 @@
 package routes
 
 import (
+	"crawlab/utils"
 	"github.com/gin-gonic/gin"
-	"io/ioutil"
+	"github.com/spf13/viper"
 	"net/http"
 )
 
 func GetFile(c *gin.Context) {
 	path := c.Query("path")
-	fileBytes, err := ioutil.ReadFile(path)
+
+	// 仅允许读取爬虫目录内的文件
+	fs, err := utils.NewSandboxFs(viper.GetString("spider.path"))
 	if err != nil {
 		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+	fileBytes, err := fs.ReadFile(path)
+	if err != nil {
+		HandleFsError(c, err)
+		return
 	}
 	c.JSON(http.StatusOK, Response{
 		Status:  "ok",
@@ -0,0 +1,285 @@
+package routes
+
+import (
+	"crawlab/model"
+	"crawlab/services"
+	"github.com/gin-gonic/gin"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"net/http"
+)
+
+// 隐去通知渠道的密码、密钥及Webhook请求头的值（保留请求头名称）
+func maskNotificationChannel(ch *model.NotificationChannel) {
+	ch.SmtpPassword = ""
+	ch.Secret = ""
+	if ch.Headers != nil {
+		headers := map[string]string{}
+		for key := range ch.Headers {
+			headers[key] = ""
+		}
+		ch.Headers = headers
+	}
+}
+
+// 更新时未传（被隐去）的密码、密钥及请求头的值保留原值，发送地址变更时不保留，避免发送到新地址
+func restoreNotificationChannelSecrets(item *model.NotificationChannel, ch model.NotificationChannel) {
+	if item.SmtpPassword == "" && item.SmtpHost == ch.SmtpHost {
+		item.SmtpPassword = ch.SmtpPassword
+	}
+	if item.Secret == "" && item.Url == ch.Url {
+		item.Secret = ch.Secret
+	}
+	for key, value := range item.Headers {
+		if value == "" && item.Url == ch.Url {
+			item.Headers[key] = ch.Headers[key]
+		}
+	}
+}
+
+func GetNotificationChannelList(c *gin.Context) {
+	results, err := model.GetNotificationChannelList(nil)
+	if err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+	for i := range results {
+		maskNotificationChannel(&results[i])
+	}
+	c.JSON(http.StatusOK, Response{
+		Status:  "ok",
+		Message: "success",
+		Data:    results,
+	})
+}
+
+func PutNotificationChannel(c *gin.Context) {
+	var item model.NotificationChannel
+
+	// 绑定数据模型
+	if err := c.ShouldBindJSON(&item); err != nil {
+		HandleError(http.StatusBadRequest, c, err)
+		return
+	}
+
+	// 校验通知渠道
+	if err := services.ValidateNotificationChannel(item); err != nil {
+		HandleError(http.StatusBadRequest, c, err)
+		return
+	}
+
+	// 添加通知渠道
+	if err := item.Add(); err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+
+	maskNotificationChannel(&item)
+	c.JSON(http.StatusOK, Response{
+		Status:  "ok",
+		Message: "success",
+		Data:    item,
+	})
+}
+
+func PostNotificationChannel(c *gin.Context) {
+	id := c.Param("id")
+
+	if !bson.IsObjectIdHex(id) {
+		HandleErrorF(http.StatusBadRequest, c, "invalid id")
+		return
+	}
+
+	// 绑定数据模型
+	var item model.NotificationChannel
+	if err := c.ShouldBindJSON(&item); err != nil {
+		HandleError(http.StatusBadRequest, c, err)
+		return
+	}
+
+	// 未传的密码、密钥及请求头的值保留原值
+	ch, err := model.GetNotificationChannel(bson.ObjectIdHex(id))
+	if err == mgo.ErrNotFound {
+		HandleError(http.StatusNotFound, c, err)
+		return
+	} else if err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+	restoreNotificationChannelSecrets(&item, ch)
+
+	// 校验通知渠道
+	if err := services.ValidateNotificationChannel(item); err != nil {
+		HandleError(http.StatusBadRequest, c, err)
+		return
+	}
+
+	// 更新数据库
+	if err := model.UpdateNotificationChannel(bson.ObjectIdHex(id), item); err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "ok",
+		Message: "success",
+	})
+}
+
+func DeleteNotificationChannel(c *gin.Context) {
+	id := c.Param("id")
+
+	if !bson.IsObjectIdHex(id) {
+		HandleErrorF(http.StatusBadRequest, c, "invalid id")
+		return
+	}
+
+	// 删除通知渠道
+	if err := model.RemoveNotificationChannel(bson.ObjectIdHex(id)); err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "ok",
+		Message: "success",
+	})
+}
+
+// 向通知渠道发送测试通知，用于检查渠道配置
+func TestNotificationChannel(c *gin.Context) {
+	id := c.Param("id")
+
+	if !bson.IsObjectIdHex(id) {
+		HandleErrorF(http.StatusBadRequest, c, "invalid id")
+		return
+	}
+
+	ch, err := model.GetNotificationChannel(bson.ObjectIdHex(id))
+	if err == mgo.ErrNotFound {
+		HandleError(http.StatusNotFound, c, err)
+		return
+	} else if err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+
+	// 发送失败时返回渠道的错误信息
+	if err := services.TestNotificationChannel(ch); err != nil {
+		HandleError(http.StatusBadGateway, c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "ok",
+		Message: "success",
+	})
+}
+
+func GetNotificationRuleList(c *gin.Context) {
+	query := bson.M{}
+	if spiderId := c.Query("spider_id"); bson.IsObjectIdHex(spiderId) {
+		query["spider_id"] = bson.ObjectIdHex(spiderId)
+	}
+	if scheduleId := c.Query("schedule_id"); bson.IsObjectIdHex(scheduleId) {
+		query["schedule_id"] = bson.ObjectIdHex(scheduleId)
+	}
+
+	results, err := model.GetNotificationRuleList(query)
+	if err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+	c.JSON(http.StatusOK, Response{
+		Status:  "ok",
+		Message: "success",
+		Data:    results,
+	})
+}
+
+func PutNotificationRule(c *gin.Context) {
+	var item model.NotificationRule
+
+	// 绑定数据模型
+	if err := c.ShouldBindJSON(&item); err != nil {
+		HandleError(http.StatusBadRequest, c, err)
+		return
+	}
+
+	// 校验通知规则
+	if err := services.ValidateNotificationRule(item); err != nil {
+		HandleError(http.StatusBadRequest, c, err)
+		return
+	}
+
+	// 添加通知规则
+	if err := item.Add(); err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "ok",
+		Message: "success",
+		Data:    item,
+	})
+}
+
+func PostNotificationRule(c *gin.Context) {
+	id := c.Param("id")
+
+	if !bson.IsObjectIdHex(id) {
+		HandleErrorF(http.StatusBadRequest, c, "invalid id")
+		return
+	}
+
+	// 绑定数据模型
+	var item model.NotificationRule
+	if err := c.ShouldBindJSON(&item); err != nil {
+		HandleError(http.StatusBadRequest, c, err)
+		return
+	}
+
+	// 校验通知规则
+	if err := services.ValidateNotificationRule(item); err != nil {
+		HandleError(http.StatusBadRequest, c, err)
+		return
+	}
+
+	// 更新数据库
+	if err := model.UpdateNotificationRule(bson.ObjectIdHex(id), item); err == mgo.ErrNotFound {
+		HandleError(http.StatusNotFound, c, err)
+		return
+	} else if err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "ok",
+		Message: "success",
+	})
+}
+
+func DeleteNotificationRule(c *gin.Context) {
+	id := c.Param("id")
+
+	if !bson.IsObjectIdHex(id) {
+		HandleErrorF(http.StatusBadRequest, c, "invalid id")
+		return
+	}
+
+	// 删除通知规则
+	if err := model.RemoveNotificationRule(bson.ObjectIdHex(id)); err == mgo.ErrNotFound {
+		HandleError(http.StatusNotFound, c, err)
+		return
+	} else if err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "ok",
+		Message: "success",
+	})
+}
@@ -0,0 +1,101 @@
+package routes
+
+import (
+	"crawlab/model"
+	"crawlab/services"
+	"github.com/gin-gonic/gin"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"net/http"
+)
+
+type RetentionReportListRequestData struct {
+	PageNum  int   `form:"page_num"`
+	PageSize int   `form:"page_size"`
+	DryRun   *bool `form:"dry_run"`
+}
+
+func GetRetentionReportList(c *gin.Context) {
+	// 绑定数据
+	data := RetentionReportListRequestData{}
+	if err := c.ShouldBindQuery(&data); err != nil {
+		HandleError(http.StatusBadRequest, c, err)
+		return
+	}
+	if data.PageNum == 0 {
+		data.PageNum = 1
+	}
+	if data.PageSize == 0 {
+		data.PageSize = 10
+	}
+
+	query := bson.M{}
+	if data.DryRun != nil {
+		query["dry_run"] = *data.DryRun
+	}
+
+	// 获取清理报告
+	reports, err := model.GetRetentionReportList(query, (data.PageNum-1)*data.PageSize, data.PageSize)
+	if err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+
+	// 获取清理报告总数
+	total, err := model.GetRetentionReportListTotal(query)
+	if err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, ListResponse{
+		Status:  "ok",
+		Message: "success",
+		Total:   total,
+		Data:    reports,
+	})
+}
+
+func GetRetentionReport(c *gin.Context) {
+	id := c.Param("id")
+
+	if !bson.IsObjectIdHex(id) {
+		HandleErrorF(http.StatusBadRequest, c, "invalid id")
+		return
+	}
+
+	result, err := model.GetRetentionReport(bson.ObjectIdHex(id))
+	if err == mgo.ErrNotFound {
+		HandleError(http.StatusNotFound, c, err)
+		return
+	} else if err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+	c.JSON(http.StatusOK, Response{
+		Status:  "ok",
+		Message: "success",
+		Data:    result,
+	})
+}
+
+// 执行数据保留策略，dry_run=1时只统计将被删除的结果、任务记录及日志文件
+// 各节点的日志清理情况在节点回报后写入报告，可通过报告详情查看
+func RunRetention(c *gin.Context) {
+	dryRun := c.Query("dry_run") == "1" || c.Query("dry_run") == "true"
+
+	report, err := services.RunRetention(dryRun)
+	if err == services.ErrRetentionRunning {
+		HandleError(http.StatusConflict, c, err)
+		return
+	} else if err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "ok",
+		Message: "success",
+		Data:    report,
+	})
+}
@@ -46,6 +46,12 @@ func PostSchedule(c *gin.Context) {
 		HandleError(http.StatusBadRequest, c, err)
 		return
 	}
+
+	// 校验定时任务
+	if err := services.ValidateSchedule(newItem); err != nil {
+		HandleError(http.StatusBadRequest, c, err)
+		return
+	}
 	newItem.Id = bson.ObjectIdHex(id)
 
 	// 如果node_id为空，则置为空ObjectId
@@ -53,6 +59,11 @@ func PostSchedule(c *gin.Context) {
 		newItem.NodeId = bson.ObjectIdHex(constants.ObjectIdNull)
 	}
 
+	// 如果spider_id为空（工作流定时任务），则置为空ObjectId
+	if newItem.SpiderId == "" {
+		newItem.SpiderId = bson.ObjectIdHex(constants.ObjectIdNull)
+	}
+
 	// 更新数据库
 	if err := model.UpdateSchedule(bson.ObjectIdHex(id), newItem); err != nil {
 		HandleError(http.StatusInternalServerError, c, err)
@@ -80,11 +91,22 @@ func PutSchedule(c *gin.Context) {
 		return
 	}
 
+	// 校验定时任务
+	if err := services.ValidateSchedule(item); err != nil {
+		HandleError(http.StatusBadRequest, c, err)
+		return
+	}
+
 	// 如果node_id为空，则置为空ObjectId
 	if item.NodeId == "" {
 		item.NodeId = bson.ObjectIdHex(constants.ObjectIdNull)
 	}
 
+	// 如果spider_id为空（工作流定时任务），则置为空ObjectId
+	if item.SpiderId == "" {
+		item.SpiderId = bson.ObjectIdHex(constants.ObjectIdNull)
+	}
+
 	// 更新数据库
 	if err := model.AddSchedule(item); err != nil {
 		HandleError(http.StatusInternalServerError, c, err)
@@ -112,6 +134,12 @@ func DeleteSchedule(c *gin.Context) {
 		return
 	}
 
+	// 删除定时任务的通知规则
+	if err := model.RemoveNotificationRulesByScheduleId(bson.ObjectIdHex(id)); err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+
 	// 更新定时任务
 	if err := services.Sched.Update(); err != nil {
 		HandleError(http.StatusInternalServerError, c, err)
@@ -0,0 +1,92 @@
+package routes
+
+import (
+	"crawlab/constants"
+	"crawlab/model"
+	"crawlab/services"
+	"github.com/gin-gonic/gin"
+	"github.com/globalsign/mgo/bson"
+	"net/http"
+)
+
+type ScrapySettingsReqBody struct {
+	Config   map[string]map[string]string `json:"config"`   // scrapy.cfg，值为空时删除
+	Settings []services.ScrapySetting     `json:"settings"` // settings.py，值为空时删除
+}
+
+// 获取Scrapy爬虫
+func getScrapySpider(c *gin.Context) (spider model.Spider, ok bool) {
+	id := c.Param("id")
+
+	if !bson.IsObjectIdHex(id) {
+		HandleErrorF(http.StatusBadRequest, c, "invalid id")
+		return spider, false
+	}
+
+	spider, err := model.GetSpider(bson.ObjectIdHex(id))
+	if err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return spider, false
+	}
+	if spider.Framework != constants.FrameworkScrapy {
+		HandleErrorF(http.StatusBadRequest, c, "not a scrapy project")
+		return spider, false
+	}
+
+	return spider, true
+}
+
+func GetScrapyProject(c *gin.Context) {
+	spider, ok := getScrapySpider(c)
+	if !ok {
+		return
+	}
+
+	project, err := services.GetScrapyProject(spider)
+	if err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "ok",
+		Message: "success",
+		Data:    project,
+	})
+}
+
+func PostScrapySettings(c *gin.Context) {
+	spider, ok := getScrapySpider(c)
+	if !ok {
+		return
+	}
+
+	var reqBody ScrapySettingsReqBody
+	if err := c.ShouldBindJSON(&reqBody); err != nil {
+		HandleError(http.StatusBadRequest, c, err)
+		return
+	}
+
+	// 更新scrapy.cfg
+	if len(reqBody.Config) > 0 {
+		if err := services.UpdateScrapyConfig(spider.Src, reqBody.Config); err != nil {
+			HandleError(http.StatusInternalServerError, c, err)
+			return
+		}
+	}
+
+	// 更新settings.py
+	if len(reqBody.Settings) > 0 {
+		if err := services.UpdateScrapySettings(spider.Src, reqBody.Settings); err != nil {
+			HandleError(http.StatusBadRequest, c, err)
+			return
+		}
+	}
+
+	republishSpider(c, spider, "update scrapy settings")
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "ok",
+		Message: "success",
+	})
+}
@@ -5,6 +5,7 @@ import (
 	"crawlab/database"
 	"crawlab/model"
 	"crawlab/services"
+	"crawlab/sinks"
 	"crawlab/utils"
 	"github.com/apex/log"
 	"github.com/gin-gonic/gin"
@@ -13,7 +14,6 @@ import (
 	"github.com/pkg/errors"
 	uuid "github.com/satori/go.uuid"
 	"github.com/spf13/viper"
-	"io/ioutil"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -23,12 +23,43 @@ import (
 	"time"
 )
 
+// 隐去Git仓库密码、结果储存的连接字符串及请求头的值
+func maskSpiderSecrets(spider *model.Spider) {
+	spider.GitPassword = ""
+	spider.Sink.Dsn = ""
+	if spider.Sink.Headers != nil {
+		headers := map[string]string{}
+		for key := range spider.Sink.Headers {
+			headers[key] = ""
+		}
+		spider.Sink.Headers = headers
+	}
+}
+
+// 更新时未传（被隐去）的密码、连接字符串及请求头的值保留原值，地址变更时不保留，避免发送到新地址
+func restoreSpiderSecrets(item *model.Spider, spider model.Spider) {
+	if item.GitPassword == "" && item.GitUrl == spider.GitUrl {
+		item.GitPassword = spider.GitPassword
+	}
+	if item.Sink.Dsn == "" && item.Sink.Type == spider.Sink.Type && item.Sink.Driver == spider.Sink.Driver {
+		item.Sink.Dsn = spider.Sink.Dsn
+	}
+	for key, value := range item.Sink.Headers {
+		if value == "" && item.Sink.Url == spider.Sink.Url {
+			item.Sink.Headers[key] = spider.Sink.Headers[key]
+		}
+	}
+}
+
 func GetSpiderList(c *gin.Context) {
 	results, err := model.GetSpiderList(nil, 0, 0)
 	if err != nil {
 		HandleError(http.StatusInternalServerError, c, err)
 		return
 	}
+	for i := range results {
+		maskSpiderSecrets(&results[i])
+	}
 	c.JSON(http.StatusOK, Response{
 		Status:  "ok",
 		Message: "success",
@@ -48,6 +79,7 @@ func GetSpider(c *gin.Context) {
 		HandleError(http.StatusInternalServerError, c, err)
 		return
 	}
+	maskSpiderSecrets(&result)
 	c.JSON(http.StatusOK, Response{
 		Status:  "ok",
 		Message: "success",
@@ -68,6 +100,48 @@ func PostSpider(c *gin.Context) {
 		return
 	}
 
+	// 未传的密码等保留原值
+	spider, err := model.GetSpider(bson.ObjectIdHex(id))
+	if err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+	restoreSpiderSecrets(&item, spider)
+
+	// 结果储存
+	if err := sinks.Validate(item.Sink); err != nil {
+		HandleError(http.StatusBadRequest, c, err)
+		return
+	}
+
+	// 数据质量规则
+	if err := services.ValidateQualityConfig(item.Quality); err != nil {
+		HandleError(http.StatusBadRequest, c, err)
+		return
+	}
+
+	// 结果保留策略
+	if err := services.ValidateRetentionPolicy(item.Retention); err != nil {
+		HandleError(http.StatusBadRequest, c, err)
+		return
+	}
+
+	// 结果去重
+	switch item.DedupMode {
+	case "", constants.DedupModeSkip, constants.DedupModeUpsert, constants.DedupModeKeep:
+	default:
+		HandleErrorF(http.StatusBadRequest, c, "invalid dedup_mode: "+item.DedupMode)
+		return
+	}
+
+	// Git爬虫
+	if item.GitUrl != "" {
+		if err := services.ValidateGitSpider(item); err != nil {
+			HandleError(http.StatusBadRequest, c, err)
+			return
+		}
+	}
+
 	if err := model.UpdateSpider(bson.ObjectIdHex(id), item); err != nil {
 		HandleError(http.StatusInternalServerError, c, err)
 		return
@@ -91,6 +165,10 @@ func PublishAllSpiders(c *gin.Context) {
 	})
 }
 
+type SpiderPublishReqBody struct {
+	Changelog string `json:"changelog"`
+}
+
 func PublishSpider(c *gin.Context) {
 	id := c.Param("id")
 
@@ -98,13 +176,45 @@ func PublishSpider(c *gin.Context) {
 		HandleErrorF(http.StatusBadRequest, c, "invalid id")
 	}
 
+	// 变更说明（可选）
+	var reqBody SpiderPublishReqBody
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&reqBody); err != nil {
+			HandleError(http.StatusBadRequest, c, err)
+			return
+		}
+	}
+
 	spider, err := model.GetSpider(bson.ObjectIdHex(id))
 	if err != nil {
 		HandleError(http.StatusInternalServerError, c, err)
 		return
 	}
 
-	if err := services.PublishSpider(spider); err != nil {
+	// 上传者
+	uploader := GetCurrentUploader(c)
+
+	if err := services.PublishSpiderVersion(spider, uploader, reqBody.Changelog); err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "ok",
+		Message: "success",
+	})
+}
+
+func GetSpiderVersions(c *gin.Context) {
+	id := c.Param("id")
+
+	if !bson.IsObjectIdHex(id) {
+		HandleErrorF(http.StatusBadRequest, c, "invalid id")
+		return
+	}
+
+	versions, err := model.GetSpiderVersionList(bson.ObjectIdHex(id))
+	if err != nil {
 		HandleError(http.StatusInternalServerError, c, err)
 		return
 	}
@@ -112,9 +222,69 @@ func PublishSpider(c *gin.Context) {
 	c.JSON(http.StatusOK, Response{
 		Status:  "ok",
 		Message: "success",
+		Data:    versions,
 	})
 }
 
+func GetSpiderDeployments(c *gin.Context) {
+	id := c.Param("id")
+
+	if !bson.IsObjectIdHex(id) {
+		HandleErrorF(http.StatusBadRequest, c, "invalid id")
+		return
+	}
+
+	// 获取爬虫
+	spider, err := model.GetSpider(bson.ObjectIdHex(id))
+	if err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+
+	// 各个节点上的部署状态
+	deployments, err := model.GetSpiderDeploymentList(spider)
+	if err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "ok",
+		Message: "success",
+		Data:    deployments,
+	})
+}
+
+func RollbackSpider(c *gin.Context) {
+	id := c.Param("id")
+	vid := c.Param("vid")
+
+	if !bson.IsObjectIdHex(id) || !bson.IsObjectIdHex(vid) {
+		HandleErrorF(http.StatusBadRequest, c, "invalid id")
+		return
+	}
+
+	// 获取爬虫
+	spider, err := model.GetSpider(bson.ObjectIdHex(id))
+	if err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+
+	// 回滚到指定版本并发布到所有节点
+	if err := services.RollbackSpider(spider, bson.ObjectIdHex(vid)); err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "ok",
+		Message: "success",
+	})
+}
+
+// 上传爬虫：支持zip、tar.gz压缩文件和单个脚本文件（.py, .js）
+// 可选表单字段：name（爬虫名称，默认取自文件名）、cmd（执行命令）、col（结果集）
 func PutSpider(c *gin.Context) {
 	// 从body中获取文件
 	file, err := c.FormFile("file")
@@ -124,73 +294,145 @@ func PutSpider(c *gin.Context) {
 		return
 	}
 
-	// 如果不为zip文件，返回错误
-	if !strings.HasSuffix(file.Filename, ".zip") {
+	// 如果不为支持的文件类型，返回错误
+	if services.GetSpiderArchiveExt(file.Filename) == "" && services.GetSpiderScriptCmd(file.Filename) == "" {
 		debug.PrintStack()
-		HandleError(http.StatusBadRequest, c, errors.New("Not a valid zip file"))
+		HandleError(http.StatusBadRequest, c, errors.New("Not a valid zip, tar.gz or script file"))
+		return
+	}
+
+	// 文件大小超出限制
+	if maxSize := utils.GetUploadMaxSize(); maxSize > 0 && file.Size > maxSize {
+		HandleError(http.StatusBadRequest, c, utils.ErrFileTooLarge)
+		return
+	}
+
+	// 爬虫名称
+	name := c.PostForm("name")
+	if name == "" {
+		name = services.GetUploadSpiderName(file.Filename)
+	}
+
+	// 目标目录（不允许位于爬虫目录以外，不允许为隐藏目录）
+	spidersFs, err := utils.NewSandboxFs(viper.GetString("spider.path"))
+	if err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+	dstPath, err := spidersFs.Resolve(filepath.Base(name))
+	if err != nil || dstPath == spidersFs.Root || strings.HasPrefix(filepath.Base(name), ".") {
+		HandleErrorF(http.StatusBadRequest, c, "invalid spider name")
 		return
 	}
 
 	// 保存到本地临时文件
 	randomId := uuid.NewV4()
-	tmpFilePath := filepath.Join(viper.GetString("other.tmppath"), randomId.String()+".zip")
+	tmpFilePath := filepath.Join(viper.GetString("other.tmppath"), randomId.String()+filepath.Ext(file.Filename))
 	if err := c.SaveUploadedFile(file, tmpFilePath); err != nil {
 		debug.PrintStack()
 		HandleError(http.StatusInternalServerError, c, err)
 		return
 	}
+	defer os.Remove(tmpFilePath)
 
-	// 读取临时文件
-	tmpFile, err := os.OpenFile(tmpFilePath, os.O_RDONLY, 0777)
-	if err != nil {
+	// 解压到爬虫目录
+	if err := services.ExtractSpiderUpload(tmpFilePath, file.Filename, dstPath); err != nil {
 		debug.PrintStack()
+		HandleFsError(c, err)
+		return
+	}
+
+	// 更新爬虫
+	services.UpdateSpiders()
+
+	// 获取爬虫（爬虫名称即目录名；dstPath已解析符号链接，与数据库中的src不一定相同）
+	spiders, err := model.GetSpiderList(bson.M{"name": filepath.Base(dstPath)}, 0, 1)
+	if err != nil || len(spiders) == 0 {
+		HandleErrorF(http.StatusInternalServerError, c, "spider not found after upload")
+		return
+	}
+	spider := spiders[0]
+
+	// 执行命令（未指定时使用自动检测的命令）、结果集
+	if cmd := c.PostForm("cmd"); cmd != "" {
+		spider.Cmd = cmd
+	}
+	if col := c.PostForm("col"); col != "" {
+		spider.Col = col
+	}
+	if err := spider.Save(); err != nil {
 		HandleError(http.StatusInternalServerError, c, err)
 		return
 	}
-	if err = tmpFile.Close(); err != nil {
-		debug.PrintStack()
+
+	// 发布到工作节点
+	if err := services.PublishSpiderVersion(spider, GetCurrentUploader(c), "upload "+filepath.Base(file.Filename)); err != nil {
 		HandleError(http.StatusInternalServerError, c, err)
 		return
 	}
 
-	// 目标目录
-	dstPath := filepath.Join(
-		viper.GetString("spider.path"),
-		strings.Replace(file.Filename, ".zip", "", 1),
-	)
+	maskSpiderSecrets(&spider)
+	c.JSON(http.StatusOK, Response{
+		Status:  "ok",
+		Message: "success",
+		Data:    spider,
+	})
+}
 
-	// 如果目标目录已存在，删除目标目录
-	if utils.Exists(dstPath) {
-		if err := os.RemoveAll(dstPath); err != nil {
-			debug.PrintStack()
-			HandleError(http.StatusInternalServerError, c, err)
-		}
+// 从Git仓库创建爬虫
+func PutGitSpider(c *gin.Context) {
+	var item model.Spider
+	if err := c.ShouldBindJSON(&item); err != nil {
+		HandleError(http.StatusBadRequest, c, err)
+		return
 	}
 
-	// 将临时文件解压到爬虫目录
-	if err := utils.DeCompress(tmpFile, dstPath); err != nil {
-		debug.PrintStack()
-		HandleError(http.StatusInternalServerError, c, err)
+	// 校验
+	if item.Name == "" || strings.ContainsAny(item.Name, `/\`) || strings.HasPrefix(item.Name, ".") {
+		HandleErrorF(http.StatusBadRequest, c, "invalid name")
+		return
+	}
+	if err := services.ValidateGitSpider(item); err != nil {
+		HandleError(http.StatusBadRequest, c, err)
 		return
 	}
 
-	// 删除临时文件
-	if err = os.Remove(tmpFilePath); err != nil {
-		debug.PrintStack()
+	// 源码目录
+	item.Src = filepath.Join(viper.GetString("spider.path"), item.Name)
+	if utils.Exists(item.Src) {
+		HandleErrorF(http.StatusBadRequest, c, "spider already exists")
+		return
+	}
+
+	// 存入数据库
+	item.Type = constants.Customized
+	item.FileId = bson.ObjectIdHex(constants.ObjectIdNull)
+	if item.DisplayName == "" {
+		item.DisplayName = item.Name
+	}
+	if err := item.Add(); err != nil {
 		HandleError(http.StatusInternalServerError, c, err)
 		return
 	}
 
-	// 更新爬虫
-	services.UpdateSpiders()
+	// 上传者
+	uploader := GetCurrentUploader(c)
+
+	// 拉取仓库并发布
+	if err := services.PullSpider(item, uploader); err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
 
+	maskSpiderSecrets(&item)
 	c.JSON(http.StatusOK, Response{
 		Status:  "ok",
 		Message: "success",
+		Data:    item,
 	})
 }
 
-func DeleteSpider(c *gin.Context) {
+func PullSpider(c *gin.Context) {
 	id := c.Param("id")
 
 	if !bson.IsObjectIdHex(id) {
@@ -198,21 +440,56 @@ func DeleteSpider(c *gin.Context) {
 		return
 	}
 
-	// 获取该爬虫
 	spider, err := model.GetSpider(bson.ObjectIdHex(id))
 	if err != nil {
 		HandleError(http.StatusInternalServerError, c, err)
 		return
 	}
+	if spider.GitUrl == "" {
+		HandleErrorF(http.StatusBadRequest, c, "spider is not backed by a git repository")
+		return
+	}
+
+	// 上传者
+	uploader := GetCurrentUploader(c)
+
+	// 拉取仓库并发布
+	if err := services.PullSpider(spider, uploader); err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "ok",
+		Message: "success",
+	})
+}
+
+func DeleteSpider(c *gin.Context) {
+	id := c.Param("id")
+
+	if !bson.IsObjectIdHex(id) {
+		HandleErrorF(http.StatusBadRequest, c, "invalid id")
+		return
+	}
 
-	// 删除爬虫文件目录
-	if err := os.RemoveAll(spider.Src); err != nil {
+	// 获取该爬虫
+	spider, err := model.GetSpider(bson.ObjectIdHex(id))
+	if err != nil {
 		HandleError(http.StatusInternalServerError, c, err)
 		return
 	}
 
-	// 从数据库中删除该爬虫
-	if err := model.RemoveSpider(bson.ObjectIdHex(id)); err != nil {
+	// 级联删除爬虫（默认删除历史任务及其结果，保留结果集）
+	opts := services.SpiderRemoveOptions{
+		KeepTasks: c.Query("keep_tasks") == "1" || c.Query("keep_tasks") == "true",
+		DropCol:   c.Query("drop_col") == "1" || c.Query("drop_col") == "true",
+	}
+	if err := services.RemoveSpider(spider, opts); err != nil {
+		if errors.Cause(err) == services.ErrSpiderInWorkflow {
+			HandleError(http.StatusConflict, c, err)
+			return
+		}
 		HandleError(http.StatusInternalServerError, c, err)
 		return
 	}
@@ -249,6 +526,11 @@ func GetSpiderDir(c *gin.Context) {
 	// 爬虫ID
 	id := c.Param("id")
 
+	if !bson.IsObjectIdHex(id) {
+		HandleErrorF(http.StatusBadRequest, c, "invalid id")
+		return
+	}
+
 	// 目录相对路径
 	path := c.Query("path")
 
@@ -259,13 +541,20 @@ func GetSpiderDir(c *gin.Context) {
 		return
 	}
 
-	// 获取目录下文件列表
-	f, err := ioutil.ReadDir(filepath.Join(spider.Src, path))
+	// 爬虫文件系统
+	fs, err := services.GetSpiderFs(spider)
 	if err != nil {
 		HandleError(http.StatusInternalServerError, c, err)
 		return
 	}
 
+	// 获取目录下文件列表
+	f, err := fs.ReadDir(path)
+	if err != nil {
+		HandleFsError(c, err)
+		return
+	}
+
 	// 遍历文件列表
 	var fileList []model.File
 	for _, file := range f {
@@ -289,6 +578,11 @@ func GetSpiderFile(c *gin.Context) {
 	// 爬虫ID
 	id := c.Param("id")
 
+	if !bson.IsObjectIdHex(id) {
+		HandleErrorF(http.StatusBadRequest, c, "invalid id")
+		return
+	}
+
 	// 文件相对路径
 	path := c.Query("path")
 
@@ -299,10 +593,18 @@ func GetSpiderFile(c *gin.Context) {
 		return
 	}
 
-	// 读取文件
-	fileBytes, err := ioutil.ReadFile(filepath.Join(spider.Src, path))
+	// 爬虫文件系统
+	fs, err := services.GetSpiderFs(spider)
 	if err != nil {
 		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+
+	// 读取文件
+	fileBytes, err := fs.ReadFile(path)
+	if err != nil {
+		HandleFsError(c, err)
+		return
 	}
 
 	// 返回结果
@@ -322,6 +624,11 @@ func PostSpiderFile(c *gin.Context) {
 	// 爬虫ID
 	id := c.Param("id")
 
+	if !bson.IsObjectIdHex(id) {
+		HandleErrorF(http.StatusBadRequest, c, "invalid id")
+		return
+	}
+
 	// 文件相对路径
 	var reqBody SpiderFileReqBody
 	if err := c.ShouldBindJSON(&reqBody); err != nil {
@@ -336,12 +643,21 @@ func PostSpiderFile(c *gin.Context) {
 		return
 	}
 
-	// 写文件
-	if err := ioutil.WriteFile(filepath.Join(spider.Src, reqBody.Path), []byte(reqBody.Content), os.ModePerm); err != nil {
+	// 爬虫文件系统
+	fs, err := services.GetSpiderFs(spider)
+	if err != nil {
 		HandleError(http.StatusInternalServerError, c, err)
 		return
 	}
 
+	// 写文件
+	if err := fs.WriteFile(reqBody.Path, []byte(reqBody.Content)); err != nil {
+		HandleFsError(c, err)
+		return
+	}
+
+	republishSpider(c, spider, "update file "+reqBody.Path)
+
 	// 返回结果
 	c.JSON(http.StatusOK, Response{
 		Status:  "ok",
@@ -399,9 +715,9 @@ func GetSpiderStats(c *gin.Context) {
 			"success_count": bson.M{
 				"$cond": []interface{}{
 					bson.M{
-						"$eq": []string{
+						"$in": []interface{}{
 							"$status",
-							constants.StatusFinished,
+							[]string{constants.StatusFinished, constants.StatusFinishedWithWarnings},
 						},
 					},
 					1,
@@ -468,3 +784,160 @@ func GetSpiderStats(c *gin.Context) {
 		},
 	})
 }
+
+// 获取日期范围内（按任务创建时间，格式为2006-01-02，包含结束日期当天）的任务
+func getSpiderTasksByDate(c *gin.Context, spider model.Spider) (tasks []model.Task, ok bool) {
+	var startTs, endTs time.Time
+	var err error
+	if str := c.Query("start_date"); str != "" {
+		if startTs, err = time.ParseInLocation("2006-01-02", str, time.Local); err != nil {
+			HandleError(http.StatusBadRequest, c, err)
+			return nil, false
+		}
+	}
+	if str := c.Query("end_date"); str != "" {
+		if endTs, err = time.ParseInLocation("2006-01-02", str, time.Local); err != nil {
+			HandleError(http.StatusBadRequest, c, err)
+			return nil, false
+		}
+		endTs = endTs.AddDate(0, 0, 1)
+	}
+
+	tasks, err = services.GetSpiderTasksByDate(spider.Id, startTs, endTs)
+	if err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return nil, false
+	}
+	return tasks, true
+}
+
+// 获取日期范围内的任务ID
+func getSpiderTaskIdsByDate(c *gin.Context, spider model.Spider) (taskIds []string, ok bool) {
+	tasks, ok := getSpiderTasksByDate(c, spider)
+	if !ok {
+		return nil, false
+	}
+	for _, t := range tasks {
+		taskIds = append(taskIds, t.Id)
+	}
+	return taskIds, true
+}
+
+func GetSpiderResults(c *gin.Context) {
+	id := c.Param("id")
+
+	if !bson.IsObjectIdHex(id) {
+		HandleErrorF(http.StatusBadRequest, c, "invalid id")
+		return
+	}
+
+	// 绑定数据
+	q, ok := bindResultQuery(c)
+	if !ok {
+		return
+	}
+
+	// 获取爬虫
+	spider, err := model.GetSpider(bson.ObjectIdHex(id))
+	if err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+
+	// 获取范围内的任务
+	taskIds, ok := getSpiderTaskIdsByDate(c, spider)
+	if !ok {
+		return
+	}
+
+	// 跨任务查询结果
+	q.TaskIds = taskIds
+	queryResults(c, spider, q)
+}
+
+func DownloadSpiderResults(c *gin.Context) {
+	id := c.Param("id")
+
+	if !bson.IsObjectIdHex(id) {
+		HandleErrorF(http.StatusBadRequest, c, "invalid id")
+		return
+	}
+
+	// 获取爬虫
+	spider, err := model.GetSpider(bson.ObjectIdHex(id))
+	if err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+
+	// 获取范围内的任务
+	taskIds, ok := getSpiderTaskIdsByDate(c, spider)
+	if !ok {
+		return
+	}
+
+	exportResults(c, spider, taskIds, spider.Name)
+}
+
+type SpiderResultSchemaRequestData struct {
+	Tasks  int `form:"tasks"`  // 最近的任务数量，默认10
+	Sample int `form:"sample"` // 每个任务的样本数量，默认1000
+}
+
+func GetSpiderResultSchema(c *gin.Context) {
+	id := c.Param("id")
+
+	if !bson.IsObjectIdHex(id) {
+		HandleErrorF(http.StatusBadRequest, c, "invalid id")
+		return
+	}
+
+	// 绑定数据
+	data := SpiderResultSchemaRequestData{}
+	if err := c.ShouldBindQuery(&data); err != nil {
+		HandleError(http.StatusBadRequest, c, err)
+		return
+	}
+	if data.Tasks <= 0 {
+		data.Tasks = 10
+	}
+	if data.Sample <= 0 {
+		data.Sample = 1000
+	}
+	if data.Sample > 10000 {
+		data.Sample = 10000
+	}
+
+	// 获取爬虫
+	spider, err := model.GetSpider(bson.ObjectIdHex(id))
+	if err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+
+	// 日期范围内最近的任务
+	tasks, ok := getSpiderTasksByDate(c, spider)
+	if !ok {
+		return
+	}
+	if len(tasks) > data.Tasks {
+		tasks = tasks[len(tasks)-data.Tasks:]
+	}
+
+	// 推断结果结构
+	schema, err := services.InferResultSchema(spider, tasks, data.Sample)
+	if err == sinks.ErrQueryNotSupported {
+		HandleError(http.StatusBadRequest, c, err)
+		return
+	}
+	if err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "ok",
+		Message: "success",
+		Data:    schema,
+	})
+}
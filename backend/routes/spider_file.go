@@ -0,0 +1,251 @@
+package routes
+
+import (
+	"crawlab/model"
+	"crawlab/services"
+	"crawlab/utils"
+	"github.com/apex/log"
+	"github.com/gin-gonic/gin"
+	"github.com/globalsign/mgo/bson"
+	uuid "github.com/satori/go.uuid"
+	"github.com/spf13/viper"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+)
+
+type SpiderFileCreateReqBody struct {
+	Path    string `json:"path" binding:"required"`
+	Content string `json:"content"`
+}
+
+type SpiderFileRenameReqBody struct {
+	Path    string `json:"path" binding:"required"`
+	NewPath string `json:"new_path" binding:"required"`
+}
+
+// 获取爬虫及其沙箱文件系统
+func getSpiderFs(c *gin.Context) (spider model.Spider, fs *utils.SandboxFs, ok bool) {
+	id := c.Param("id")
+
+	if !bson.IsObjectIdHex(id) {
+		HandleErrorF(http.StatusBadRequest, c, "invalid id")
+		return spider, nil, false
+	}
+
+	spider, err := model.GetSpider(bson.ObjectIdHex(id))
+	if err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return spider, nil, false
+	}
+
+	fs, err = services.GetSpiderFs(spider)
+	if err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return spider, nil, false
+	}
+
+	return spider, fs, true
+}
+
+// 爬虫文件变更后重新发布到工作节点（内容未变化时不会生成新版本）
+func republishSpider(c *gin.Context, spider model.Spider, changelog string) {
+	if err := services.PublishSpiderVersion(spider, GetCurrentUploader(c), changelog); err != nil {
+		log.Errorf(err.Error())
+		debug.PrintStack()
+	}
+}
+
+func CreateSpiderFile(c *gin.Context) {
+	spider, fs, ok := getSpiderFs(c)
+	if !ok {
+		return
+	}
+
+	var reqBody SpiderFileCreateReqBody
+	if err := c.ShouldBindJSON(&reqBody); err != nil {
+		HandleError(http.StatusBadRequest, c, err)
+		return
+	}
+
+	// 新建文件
+	if err := fs.CreateFile(reqBody.Path, []byte(reqBody.Content)); err != nil {
+		HandleFsError(c, err)
+		return
+	}
+
+	republishSpider(c, spider, "create file "+reqBody.Path)
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "ok",
+		Message: "success",
+	})
+}
+
+func CreateSpiderDir(c *gin.Context) {
+	_, fs, ok := getSpiderFs(c)
+	if !ok {
+		return
+	}
+
+	var reqBody SpiderFileCreateReqBody
+	if err := c.ShouldBindJSON(&reqBody); err != nil {
+		HandleError(http.StatusBadRequest, c, err)
+		return
+	}
+
+	// 新建目录（空目录不影响爬虫内容，无需重新发布）
+	if err := fs.MkdirAll(reqBody.Path); err != nil {
+		HandleFsError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "ok",
+		Message: "success",
+	})
+}
+
+func RenameSpiderFile(c *gin.Context) {
+	spider, fs, ok := getSpiderFs(c)
+	if !ok {
+		return
+	}
+
+	var reqBody SpiderFileRenameReqBody
+	if err := c.ShouldBindJSON(&reqBody); err != nil {
+		HandleError(http.StatusBadRequest, c, err)
+		return
+	}
+
+	// 重命名或移动
+	if err := fs.Rename(reqBody.Path, reqBody.NewPath); err != nil {
+		HandleFsError(c, err)
+		return
+	}
+
+	republishSpider(c, spider, "rename "+reqBody.Path+" to "+reqBody.NewPath)
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "ok",
+		Message: "success",
+	})
+}
+
+func DeleteSpiderFile(c *gin.Context) {
+	spider, fs, ok := getSpiderFs(c)
+	if !ok {
+		return
+	}
+
+	path := c.Query("path")
+
+	// 删除文件或目录
+	if err := fs.Remove(path); err != nil {
+		HandleFsError(c, err)
+		return
+	}
+
+	republishSpider(c, spider, "delete "+path)
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "ok",
+		Message: "success",
+	})
+}
+
+func UploadSpiderFile(c *gin.Context) {
+	spider, fs, ok := getSpiderFs(c)
+	if !ok {
+		return
+	}
+
+	// 从body中获取文件
+	file, err := c.FormFile("file")
+	if err != nil {
+		HandleError(http.StatusBadRequest, c, err)
+		return
+	}
+
+	// 文件大小超出限制
+	if maxSize := utils.GetUploadMaxSize(); maxSize > 0 && file.Size > maxSize {
+		HandleError(http.StatusBadRequest, c, utils.ErrFileTooLarge)
+		return
+	}
+
+	// 目标路径：上传到的目录 + 文件名
+	path := filepath.ToSlash(filepath.Join(c.PostForm("path"), filepath.Base(file.Filename)))
+	dstPath, err := fs.Resolve(path)
+	if err != nil {
+		HandleFsError(c, err)
+		return
+	}
+	if dstPath == fs.Root {
+		HandleFsError(c, utils.ErrPathEscape)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(dstPath), os.ModePerm); err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+
+	// 保存文件（覆盖同名文件）
+	if err := c.SaveUploadedFile(file, dstPath); err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+
+	republishSpider(c, spider, "upload "+path)
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "ok",
+		Message: "success",
+	})
+}
+
+func DownloadSpiderFile(c *gin.Context) {
+	spider, fs, ok := getSpiderFs(c)
+	if !ok {
+		return
+	}
+
+	path := c.Query("path")
+
+	fullPath, err := fs.Resolve(path)
+	if err != nil {
+		HandleFsError(c, err)
+		return
+	}
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		HandleFsError(c, err)
+		return
+	}
+
+	// 下载单个文件
+	if !info.IsDir() {
+		c.FileAttachment(fullPath, info.Name())
+		return
+	}
+
+	// 将目录打包为zip文件下载
+	d, err := os.Open(fullPath)
+	if err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+	defer d.Close()
+	tmpFilePath := filepath.Join(viper.GetString("other.tmppath"), uuid.NewV4().String()+".zip")
+	defer os.Remove(tmpFilePath)
+	if err := utils.Compress([]*os.File{d}, tmpFilePath); err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+
+	fileName := info.Name()
+	if fullPath == fs.Root {
+		fileName = spider.Name
+	}
+	c.FileAttachment(tmpFilePath, fileName+".zip")
+}
@@ -1,16 +1,18 @@
 package routes
 
 import (
-	"bytes"
 	"crawlab/constants"
 	"crawlab/model"
 	"crawlab/services"
-	"crawlab/utils"
-	"encoding/csv"
+	"crawlab/sinks"
+	"errors"
+	"github.com/apex/log"
 	"github.com/gin-gonic/gin"
 	"github.com/globalsign/mgo/bson"
 	uuid "github.com/satori/go.uuid"
+	"github.com/spf13/viper"
 	"net/http"
+	"runtime/debug"
 )
 
 type TaskListRequestData struct {
@@ -21,8 +23,11 @@ type TaskListRequestData struct {
 }
 
 type TaskResultsRequestData struct {
-	PageNum  int `form:"page_num"`
-	PageSize int `form:"page_size"`
+	PageNum  int      `form:"page_num"`
+	PageSize int      `form:"page_size"`
+	Filter   []string `form:"filter"` // 筛选条件，格式为 字段:运算符:值，可指定多个
+	Sort     string   `form:"sort"`   // 排序字段，以逗号分隔，以-开头表示倒序
+	Fields   string   `form:"fields"` // 返回的字段，以逗号分隔
 }
 
 func GetTaskList(c *gin.Context) {
@@ -103,6 +108,19 @@ func PutTask(c *gin.Context) {
 		t.NodeId = bson.ObjectIdHex(constants.ObjectIdNull)
 	}
 
+	// 校验运行目标
+	if t.Target != "" {
+		spider, err := model.GetSpider(t.SpiderId)
+		if err != nil {
+			HandleError(http.StatusInternalServerError, c, err)
+			return
+		}
+		if !services.IsValidSpiderTarget(spider, t.Target) {
+			HandleErrorF(http.StatusBadRequest, c, "invalid target: "+t.Target)
+			return
+		}
+	}
+
 	// 将任务存入数据库
 	if err := model.AddTask(t); err != nil {
 		HandleError(http.StatusInternalServerError, c, err)
@@ -151,13 +169,78 @@ func GetTaskLog(c *gin.Context) {
 	})
 }
 
+// 解析结果查询参数
+func bindResultQuery(c *gin.Context) (q sinks.Query, ok bool) {
+	data := TaskResultsRequestData{}
+	if err := c.ShouldBindQuery(&data); err != nil {
+		HandleError(http.StatusBadRequest, c, err)
+		return q, false
+	}
+	if data.PageNum <= 0 {
+		data.PageNum = 1
+	}
+	if data.PageSize <= 0 {
+		data.PageSize = 10
+	}
+	if data.PageSize > sinks.MaxPageSize {
+		data.PageSize = sinks.MaxPageSize
+	}
+	q.PageNum = data.PageNum
+	q.PageSize = data.PageSize
+
+	// 筛选条件
+	for _, str := range data.Filter {
+		f, err := sinks.ParseFilter(str)
+		if err != nil {
+			HandleError(http.StatusBadRequest, c, err)
+			return q, false
+		}
+		q.Filters = append(q.Filters, f)
+	}
+
+	// 排序及返回的字段
+	q.Sort = services.ParseFieldList(data.Sort)
+	if err := sinks.ValidateSort(q.Sort); err != nil {
+		HandleError(http.StatusBadRequest, c, err)
+		return q, false
+	}
+	q.Fields = services.ParseFieldList(data.Fields)
+	for _, field := range q.Fields {
+		if err := sinks.ValidateField(field); err != nil {
+			HandleError(http.StatusBadRequest, c, err)
+			return q, false
+		}
+	}
+
+	return q, true
+}
+
+// 查询结果并返回
+func queryResults(c *gin.Context, spider model.Spider, q sinks.Query) {
+	results, total, err := services.QueryResults(spider, q)
+	if err == sinks.ErrPreviewNotSupported || err == sinks.ErrQueryNotSupported {
+		HandleError(http.StatusBadRequest, c, err)
+		return
+	}
+	if err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, ListResponse{
+		Status:  "ok",
+		Message: "success",
+		Data:    results,
+		Total:   total,
+	})
+}
+
 func GetTaskResults(c *gin.Context) {
 	id := c.Param("id")
 
 	// 绑定数据
-	data := TaskResultsRequestData{}
-	if err := c.ShouldBindQuery(&data); err != nil {
-		HandleError(http.StatusBadRequest, c, err)
+	q, ok := bindResultQuery(c)
+	if !ok {
 		return
 	}
 
@@ -168,88 +251,175 @@ func GetTaskResults(c *gin.Context) {
 		return
 	}
 
+	// 获取爬虫
+	spider, err := task.GetSpider()
+	if err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+
 	// 获取结果
-	results, total, err := task.GetResults(data.PageNum, data.PageSize)
+	q.TaskIds = []string{task.Id}
+	queryResults(c, spider, q)
+}
+
+func PostTaskResults(c *gin.Context) {
+	id := c.Param("id")
+
+	// 获取任务
+	task, err := model.GetTask(id)
+	if err != nil {
+		HandleError(http.StatusUnauthorized, c, errors.New("token does not match task"))
+		return
+	}
+
+	// 校验任务令牌（须与任务当前的运行节点、开始时间一致且任务仍在运行）
+	if err := services.CheckTaskToken(c.GetHeader("Authorization"), task); err != nil {
+		HandleError(http.StatusUnauthorized, c, err)
+		return
+	}
+
+	// 解析结果（JSON数组、JSON对象或NDJSON）
+	maxSize := viper.GetInt64("task.resultsMaxSize")
+	if maxSize > 0 {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxSize)
+	}
+	items, err := services.ParseResultBatch(c.Request.Body)
 	if err != nil {
+		HandleError(http.StatusBadRequest, c, err)
+		return
+	}
+
+	// 写入结果
+	stats, err := services.IngestTaskResults(task, items)
+	if err != nil {
+		if err == services.ErrTaskNotRunning {
+			HandleError(http.StatusUnauthorized, c, err)
+			return
+		}
 		HandleError(http.StatusInternalServerError, c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, ListResponse{
+	c.JSON(http.StatusOK, Response{
 		Status:  "ok",
 		Message: "success",
-		Data:    results,
-		Total:   total,
+		Data:    stats,
 	})
 }
 
-func DownloadTaskResultsCsv(c *gin.Context) {
+func GetTaskResultsDiff(c *gin.Context) {
 	id := c.Param("id")
 
-	// 获取任务
+	// 获取任务及基准任务
 	task, err := model.GetTask(id)
 	if err != nil {
 		HandleError(http.StatusInternalServerError, c, err)
 		return
 	}
+	baseTask, err := model.GetTask(c.Query("base"))
+	if err != nil {
+		HandleErrorF(http.StatusBadRequest, c, "invalid base task")
+		return
+	}
+	if baseTask.SpiderId != task.SpiderId {
+		HandleErrorF(http.StatusBadRequest, c, "base task belongs to another spider")
+		return
+	}
 
-	// 获取结果
-	results, _, err := task.GetResults(1, constants.Infinite)
+	// 获取爬虫
+	spider, err := task.GetSpider()
 	if err != nil {
 		HandleError(http.StatusInternalServerError, c, err)
 		return
 	}
+	if err := services.ValidateDiffSpider(spider); err != nil {
+		HandleError(http.StatusBadRequest, c, err)
+		return
+	}
+	sink, err := spider.GetSink()
+	if err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+	if _, ok := sink.(sinks.Iterable); !ok {
+		HandleError(http.StatusBadRequest, c, sinks.ErrQueryNotSupported)
+		return
+	}
 
-	// 字段列表
-	var columns []string
-	if len(results) == 0 {
-		columns = []string{}
-	} else {
-		item := results[0].(bson.M)
-		for key := range item {
-			columns = append(columns, key)
-		}
+	// 格式：jsonl（默认）、csv、xlsx
+	format := c.DefaultQuery("format", services.ExportFormatJsonl)
+	contentType, ok := services.ExportContentTypes[format]
+	if !ok {
+		HandleErrorF(http.StatusBadRequest, c, "unsupported export format: "+format)
+		return
 	}
 
-	// 缓冲
-	bytesBuffer := &bytes.Buffer{}
+	// 逐条写入比较结果
+	if format != services.ExportFormatJsonl {
+		c.Writer.Header().Set("Content-Disposition", "attachment;filename=diff."+format)
+	}
+	c.Writer.Header().Set("Content-Type", contentType)
+	c.Status(http.StatusOK)
+	if err := services.ExportResultDiff(c.Writer, spider, baseTask.Id, task.Id, format); err != nil {
+		// 已开始写入响应，只能记录错误
+		log.Errorf(err.Error())
+		debug.PrintStack()
+	}
+}
 
-	// 写入UTF-8 BOM，避免使用Microsoft Excel打开乱码
-	bytesBuffer.Write([]byte("\xEF\xBB\xBF"))
+func DownloadTaskResults(c *gin.Context) {
+	id := c.Param("id")
 
-	writer := csv.NewWriter(bytesBuffer)
+	// 获取任务
+	task, err := model.GetTask(id)
+	if err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
 
-	// 写入表头
-	if err := writer.Write(columns); err != nil {
+	// 获取爬虫
+	spider, err := task.GetSpider()
+	if err != nil {
 		HandleError(http.StatusInternalServerError, c, err)
 		return
 	}
 
-	// 写入内容
-	for _, result := range results {
-		// 将result转换为[]string
-		item := result.(bson.M)
-		var values []string
-		for _, col := range columns {
-			value := utils.InterfaceToString(item[col])
-			values = append(values, value)
-		}
+	exportResults(c, spider, []string{task.Id}, "data")
+}
 
-		// 写入数据
-		if err := writer.Write(values); err != nil {
-			HandleError(http.StatusInternalServerError, c, err)
-			return
-		}
+// 流式导出结果，格式及字段通过format（csv, jsonl, xlsx，默认csv）、columns（逗号分隔）参数指定
+func exportResults(c *gin.Context, spider model.Spider, taskIds []string, fileName string) {
+	opts := services.ExportOptions{
+		Format:  c.DefaultQuery("format", services.ExportFormatCsv),
+		Columns: services.ParseFieldList(c.Query("columns")),
+	}
+	contentType, ok := services.ExportContentTypes[opts.Format]
+	if !ok {
+		HandleErrorF(http.StatusBadRequest, c, "unsupported export format: "+opts.Format)
+		return
 	}
 
-	// 此时才会将缓冲区数据写入
-	writer.Flush()
-
-	// 设置下载的文件名
-	c.Writer.Header().Set("Content-Disposition", "attachment;filename=data.csv")
+	// 只写的结果储存不支持导出
+	sink, err := spider.GetSink()
+	if err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+	if _, ok := sink.(sinks.Iterable); !ok {
+		HandleError(http.StatusBadRequest, c, sinks.ErrExportNotSupported)
+		return
+	}
 
-	// 设置文件类型以及输出数据
-	c.Data(http.StatusOK, "text/csv", bytesBuffer.Bytes())
+	// 设置下载的文件名及文件类型，之后逐条写入结果
+	c.Writer.Header().Set("Content-Disposition", "attachment;filename="+fileName+"."+opts.Format)
+	c.Writer.Header().Set("Content-Type", contentType)
+	c.Status(http.StatusOK)
+	if err := services.ExportResults(c.Writer, spider, taskIds, opts); err != nil {
+		// 已开始写入响应，只能记录错误
+		log.Errorf(err.Error())
+		debug.PrintStack()
+	}
 }
 
 func CancelTask(c *gin.Context) {
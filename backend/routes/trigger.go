@@ -0,0 +1,112 @@
+package routes
+
+import (
+	"crawlab/model"
+	"crawlab/services"
+	"github.com/gin-gonic/gin"
+	"github.com/globalsign/mgo/bson"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"net/http"
+)
+
+func GetTriggerList(c *gin.Context) {
+	query := bson.M{}
+	if spiderId := c.Query("spider_id"); bson.IsObjectIdHex(spiderId) {
+		query["spider_id"] = bson.ObjectIdHex(spiderId)
+	}
+
+	results, err := model.GetTriggerList(query)
+	if err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+	c.JSON(http.StatusOK, Response{
+		Status:  "ok",
+		Message: "success",
+		Data:    results,
+	})
+}
+
+func PutTrigger(c *gin.Context) {
+	var item model.Trigger
+
+	// 绑定数据模型
+	if err := c.ShouldBindJSON(&item); err != nil {
+		HandleError(http.StatusBadRequest, c, err)
+		return
+	}
+	if item.SpiderId == "" {
+		HandleErrorF(http.StatusBadRequest, c, "spider_id is required")
+		return
+	}
+
+	// 创建触发器
+	trigger, token, err := services.AddTrigger(item)
+	if err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+
+	// 令牌明文仅在此处返回一次
+	c.JSON(http.StatusOK, Response{
+		Status:  "ok",
+		Message: "success",
+		Data: gin.H{
+			"trigger": trigger,
+			"token":   token,
+		},
+	})
+}
+
+func DeleteTrigger(c *gin.Context) {
+	id := c.Param("id")
+
+	if !bson.IsObjectIdHex(id) {
+		HandleErrorF(http.StatusBadRequest, c, "invalid id")
+		return
+	}
+
+	// 吊销触发器
+	if err := model.RevokeTrigger(bson.ObjectIdHex(id)); err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "ok",
+		Message: "success",
+	})
+}
+
+func FireTrigger(c *gin.Context) {
+	token := c.Param("token")
+
+	// 读取请求体
+	payload, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil {
+		HandleError(http.StatusBadRequest, c, err)
+		return
+	}
+
+	// 触发任务
+	task, err := services.FireTrigger(token, payload)
+	if err == services.ErrInvalidTriggerToken {
+		HandleError(http.StatusUnauthorized, c, err)
+		return
+	} else if errors.Cause(err) == services.ErrInvalidTriggerPayload {
+		HandleError(http.StatusBadRequest, c, err)
+		return
+	} else if err != nil {
+		HandleError(http.StatusInternalServerError, c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "ok",
+		Message: "success",
+		Data: gin.H{
+			"task_id": task.Id,
+		},
+	})
+}
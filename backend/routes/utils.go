@@ -1,7 +1,12 @@
 package routes
 
 import (
+	"crawlab/constants"
+	"crawlab/model"
+	"crawlab/utils"
 	"github.com/gin-gonic/gin"
+	"net/http"
+	"os"
 	"runtime/debug"
 )
 
@@ -22,3 +27,35 @@ func HandleErrorF(statusCode int, c *gin.Context, err string) {
 		Error:   err,
 	})
 }
+
+// 沙箱文件系统错误（路径越界、超出限制、文件已存在）返回400，文件不存在返回404，其余返回500
+func HandleFsError(c *gin.Context, err error) {
+	switch {
+	case err == utils.ErrPathEscape, err == utils.ErrSymlinkEscape, err == utils.ErrFileTooLarge, err == utils.ErrTooManyFiles:
+		HandleError(http.StatusBadRequest, c, err)
+	case os.IsExist(err):
+		HandleError(http.StatusBadRequest, c, err)
+	case os.IsNotExist(err):
+		HandleError(http.StatusNotFound, c, err)
+	default:
+		HandleError(http.StatusInternalServerError, c, err)
+	}
+}
+
+// 获取当前用户（由AuthorizationMiddleware设置）
+func GetCurrentUser(c *gin.Context) (user model.User, ok bool) {
+	value, ok := c.Get("user")
+	if !ok {
+		return user, false
+	}
+	user, ok = value.(model.User)
+	return user, ok
+}
+
+// 当前用户名，作为爬虫版本的上传者（无用户时为系统）
+func GetCurrentUploader(c *gin.Context) string {
+	if user, ok := GetCurrentUser(c); ok {
+		return user.Username
+	}
+	return constants.UploaderSystem
+}
@@ -0,0 +1,354 @@
+package services
+
+import (
+	"crawlab/constants"
+	"crawlab/model"
+	"crawlab/utils"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/apex/log"
+	"github.com/globalsign/mgo/bson"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 储存爬虫依赖环境的目录（隐藏目录，不会被识别为爬虫）
+const DependencyEnvDir = ".envs"
+
+// 安装日志在系统信息中保留的最大长度
+const DependencyLogMaxSize = 4096
+
+// 安装锁（每个爬虫一个），避免同一爬虫的依赖被并发安装，不同爬虫之间互不阻塞
+var (
+	dependencyLocks     = map[bson.ObjectId]*sync.Mutex{}
+	dependencyLocksLock sync.Mutex
+)
+
+// 获取爬虫的安装锁
+func getDependencyLock(spiderId bson.ObjectId) *sync.Mutex {
+	dependencyLocksLock.Lock()
+	defer dependencyLocksLock.Unlock()
+
+	lock, ok := dependencyLocks[spiderId]
+	if !ok {
+		lock = &sync.Mutex{}
+		dependencyLocks[spiderId] = lock
+	}
+	return lock
+}
+
+// 依赖类型对应的依赖文件，第一个文件存在时才安装，其余文件（锁文件）参与哈希计算
+var dependencyFiles = []struct {
+	Type  string
+	Files []string
+}{
+	{constants.DependencyTypePython, []string{"requirements.txt"}},
+	{constants.DependencyTypeNode, []string{"package.json", "package-lock.json", "npm-shrinkwrap.json"}},
+	{constants.DependencyTypeGo, []string{"go.mod", "go.sum"}},
+}
+
+// 爬虫依赖环境目录
+func GetDependencyEnvPath(spider model.Spider) string {
+	return filepath.Join(viper.GetString("spider.path"), DependencyEnvDir, spider.Id.Hex())
+}
+
+// Python虚拟环境目录
+func GetPythonEnvPath(spider model.Spider) string {
+	return filepath.Join(GetDependencyEnvPath(spider), "venv")
+}
+
+// Python虚拟环境可执行文件目录
+func GetPythonEnvBinPath(spider model.Spider) string {
+	if runtime.GOOS == constants.Windows {
+		return filepath.Join(GetPythonEnvPath(spider), "Scripts")
+	}
+	return filepath.Join(GetPythonEnvPath(spider), "bin")
+}
+
+// NodeJS依赖目录
+func GetNodeEnvPath(spider model.Spider) string {
+	return filepath.Join(GetDependencyEnvPath(spider), "node")
+}
+
+// 计算依赖文件哈希，依赖文件不存在时返回空字符串
+func HashDependencyFiles(dir string, files []string) (string, error) {
+	if !utils.Exists(filepath.Join(dir, files[0])) {
+		return "", nil
+	}
+	h := sha256.New()
+	for _, name := range files {
+		path := filepath.Join(dir, name)
+		if !utils.Exists(path) {
+			continue
+		}
+		hash, err := utils.HashFile(path)
+		if err != nil {
+			return "", err
+		}
+		h.Write([]byte(name))
+		h.Write([]byte{0})
+		h.Write([]byte(hash))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// 读取依赖安装状态
+func GetDependencyStatus(spider model.Spider, depType string) (dep model.Dependency, err error) {
+	data, err := ioutil.ReadFile(filepath.Join(GetDependencyEnvPath(spider), depType+".json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return dep, nil
+		}
+		return dep, err
+	}
+	if err := json.Unmarshal(data, &dep); err != nil {
+		return dep, err
+	}
+	return dep, nil
+}
+
+// 保存依赖安装状态
+func SaveDependencyStatus(spider model.Spider, dep model.Dependency) error {
+	envPath := GetDependencyEnvPath(spider)
+	if err := os.MkdirAll(envPath, os.ModePerm); err != nil {
+		return err
+	}
+	data, err := json.Marshal(&dep)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(envPath, dep.Type+".json"), data, os.ModePerm)
+}
+
+// 执行安装命令，输出写入日志
+func runDependencyCmd(logFile io.Writer, dir string, name string, args ...string) error {
+	_, _ = io.WriteString(logFile, "$ "+name+" "+strings.Join(args, " ")+"\n")
+	cmd := exec.Command(name, args...)
+	cmd.Dir = dir
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	if err := cmd.Run(); err != nil {
+		return errors.Wrap(err, name+" "+strings.Join(args, " "))
+	}
+	return nil
+}
+
+// 安装Python依赖到爬虫独立的虚拟环境
+func installPythonDependencies(spider model.Spider, logFile io.Writer) error {
+	dir := GetLocalSpiderDir(spider)
+
+	// 创建虚拟环境
+	if !utils.Exists(GetPythonEnvBinPath(spider)) {
+		python := "python3"
+		if runtime.GOOS == constants.Windows {
+			python = "python"
+		}
+		if err := runDependencyCmd(logFile, dir, python, "-m", "venv", GetPythonEnvPath(spider)); err != nil {
+			return err
+		}
+	}
+
+	// 安装依赖
+	pip := filepath.Join(GetPythonEnvBinPath(spider), "pip")
+	return runDependencyCmd(logFile, dir, pip, "install", "-r", filepath.Join(dir, "requirements.txt"))
+}
+
+// 安装NodeJS依赖到爬虫独立的node_modules（位于依赖环境目录，同步爬虫时不会被删除）
+func installNodeDependencies(spider model.Spider, logFile io.Writer) error {
+	dir := GetLocalSpiderDir(spider)
+	nodePath := GetNodeEnvPath(spider)
+	if err := os.MkdirAll(nodePath, os.ModePerm); err != nil {
+		return err
+	}
+
+	// 复制依赖文件
+	hasLock := false
+	for _, name := range []string{"package.json", "package-lock.json", "npm-shrinkwrap.json"} {
+		src := filepath.Join(dir, name)
+		dst := filepath.Join(nodePath, name)
+		if !utils.Exists(src) {
+			_ = os.Remove(dst)
+			continue
+		}
+		data, err := ioutil.ReadFile(src)
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(dst, data, os.ModePerm); err != nil {
+			return err
+		}
+		if name != "package.json" {
+			hasLock = true
+		}
+	}
+
+	// 有锁文件时严格按锁文件安装
+	if hasLock {
+		return runDependencyCmd(logFile, nodePath, "npm", "ci")
+	}
+	return runDependencyCmd(logFile, nodePath, "npm", "install")
+}
+
+// 下载Go依赖（Go模块按版本缓存，无需独立环境）
+func installGoDependencies(spider model.Spider, logFile io.Writer) error {
+	return runDependencyCmd(logFile, GetLocalSpiderDir(spider), "go", "mod", "download")
+}
+
+// 各类型依赖的安装函数（测试时可替换）
+var dependencyInstallers = map[string]func(spider model.Spider, logFile io.Writer) error{
+	constants.DependencyTypePython: installPythonDependencies,
+	constants.DependencyTypeNode:   installNodeDependencies,
+	constants.DependencyTypeGo:     installGoDependencies,
+}
+
+// 安装单个类型的依赖，依赖文件哈希未变化且已安装时跳过
+func installDependency(spider model.Spider, depType string, hash string) error {
+	// 已安装
+	dep, err := GetDependencyStatus(spider, depType)
+	if err != nil {
+		return err
+	}
+	if dep.Hash == hash && dep.Status == constants.DependencyStatusInstalled {
+		return nil
+	}
+
+	log.Infof("install %s dependencies of spider %s", depType, spider.Name)
+
+	dep = model.Dependency{
+		SpiderId:   spider.Id.Hex(),
+		SpiderName: spider.Name,
+		Type:       depType,
+		Hash:       hash,
+		Status:     constants.DependencyStatusInstalling,
+	}
+	if err := SaveDependencyStatus(spider, dep); err != nil {
+		return err
+	}
+
+	// 安装日志
+	logPath := filepath.Join(GetDependencyEnvPath(spider), depType+".log")
+	logFile, err := os.Create(logPath)
+	if err != nil {
+		return err
+	}
+
+	err = dependencyInstallers[depType](spider, logFile)
+	_ = logFile.Close()
+
+	// 保存安装结果
+	dep.InstallTs = time.Now()
+	if err != nil {
+		dep.Status = constants.DependencyStatusError
+		dep.Error = err.Error()
+	} else {
+		dep.Status = constants.DependencyStatusInstalled
+	}
+	if err := SaveDependencyStatus(spider, dep); err != nil {
+		return err
+	}
+	return err
+}
+
+// 检测爬虫目录中的依赖文件并安装依赖
+func InstallSpiderDependencies(spider model.Spider) error {
+	lock := getDependencyLock(spider.Id)
+	lock.Lock()
+	defer lock.Unlock()
+
+	dir := GetLocalSpiderDir(spider)
+	if !utils.Exists(dir) {
+		return nil
+	}
+
+	var errs []string
+	for _, item := range dependencyFiles {
+		hash, err := HashDependencyFiles(dir, item.Files)
+		if err != nil {
+			return err
+		}
+		if hash == "" {
+			continue
+		}
+		if err := installDependency(spider, item.Type, hash); err != nil {
+			log.Errorf(errors.Wrap(err, "install dependencies of spider "+spider.Name).Error())
+			debug.PrintStack()
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// 爬虫依赖环境的环境变量，执行任务时使用
+func GetDependencyEnvs(spider model.Spider) (envs []string) {
+	paths := []string{}
+
+	// Python虚拟环境
+	if utils.Exists(GetPythonEnvBinPath(spider)) {
+		paths = append(paths, GetPythonEnvBinPath(spider))
+		envs = append(envs, "VIRTUAL_ENV="+GetPythonEnvPath(spider))
+	}
+
+	// NodeJS依赖
+	nodeModules := filepath.Join(GetNodeEnvPath(spider), "node_modules")
+	if utils.Exists(nodeModules) {
+		paths = append(paths, filepath.Join(nodeModules, ".bin"))
+		envs = append(envs, "NODE_PATH="+nodeModules)
+	}
+
+	paths = append(paths, os.Getenv("PATH"))
+	envs = append(envs, "PATH="+strings.Join(paths, string(os.PathListSeparator)))
+	return envs
+}
+
+// 读取本节点所有爬虫的依赖安装状态
+func GetLocalDependencies() (deps []model.Dependency, err error) {
+	envRoot := filepath.Join(viper.GetString("spider.path"), DependencyEnvDir)
+	if !utils.Exists(envRoot) {
+		return deps, nil
+	}
+	items, err := ioutil.ReadDir(envRoot)
+	if err != nil {
+		return deps, err
+	}
+	for _, item := range items {
+		if !item.IsDir() {
+			continue
+		}
+		for _, df := range dependencyFiles {
+			data, err := ioutil.ReadFile(filepath.Join(envRoot, item.Name(), df.Type+".json"))
+			if err != nil {
+				continue
+			}
+			var dep model.Dependency
+			if err := json.Unmarshal(data, &dep); err != nil {
+				continue
+			}
+
+			// 安装日志末尾部分
+			logData, err := ioutil.ReadFile(filepath.Join(envRoot, item.Name(), df.Type+".log"))
+			if err == nil {
+				if len(logData) > DependencyLogMaxSize {
+					logData = logData[len(logData)-DependencyLogMaxSize:]
+				}
+				dep.Log = string(logData)
+			}
+			deps = append(deps, dep)
+		}
+	}
+	return deps, nil
+}
@@ -0,0 +1,211 @@
+package services
+
+import (
+	"crawlab/constants"
+	"crawlab/model"
+	"errors"
+	"github.com/globalsign/mgo/bson"
+	"github.com/spf13/viper"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// 在临时目录中创建爬虫及其文件（测试用）
+func newTestDependencySpider(t *testing.T, files map[string]string) (model.Spider, func()) {
+	root, err := ioutil.TempDir("", "crawlab-dependency")
+	if err != nil {
+		t.Fatal(err)
+	}
+	viper.Set("spider.path", root)
+
+	spider := model.Spider{Id: bson.NewObjectId(), Name: "test"}
+	dir := GetLocalSpiderDir(spider)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return spider, func() {
+		viper.Set("spider.path", nil)
+		_ = os.RemoveAll(root)
+	}
+}
+
+func TestGetDependencyLock(t *testing.T) {
+	id1, id2 := bson.NewObjectId(), bson.NewObjectId()
+	if getDependencyLock(id1) != getDependencyLock(id1) {
+		t.Fatal("expected the same lock for the same spider")
+	}
+
+	// 一个爬虫安装依赖时，不阻塞其他爬虫
+	lock := getDependencyLock(id1)
+	lock.Lock()
+	defer lock.Unlock()
+	done := make(chan bool)
+	go func() {
+		l := getDependencyLock(id2)
+		l.Lock()
+		l.Unlock()
+		done <- true
+	}()
+	<-done
+}
+
+func TestHashDependencyFiles(t *testing.T) {
+	spider, cleanup := newTestDependencySpider(t, map[string]string{
+		"requirements.txt": "requests==2.22.0\n",
+		"package.json":     `{"dependencies": {"axios": "^0.19.0"}}`,
+	})
+	defer cleanup()
+	dir := GetLocalSpiderDir(spider)
+	pyFiles := []string{"requirements.txt"}
+	nodeFiles := []string{"package.json", "package-lock.json", "npm-shrinkwrap.json"}
+
+	// 依赖文件不存在
+	if hash, err := HashDependencyFiles(dir, []string{"go.mod", "go.sum"}); err != nil || hash != "" {
+		t.Fatalf("HashDependencyFiles(go.mod) = %s, %v", hash, err)
+	}
+
+	pyHash, err := HashDependencyFiles(dir, pyFiles)
+	if err != nil || pyHash == "" {
+		t.Fatalf("HashDependencyFiles(requirements.txt) = %s, %v", pyHash, err)
+	}
+	nodeHash, err := HashDependencyFiles(dir, nodeFiles)
+	if err != nil || nodeHash == "" {
+		t.Fatalf("HashDependencyFiles(package.json) = %s, %v", nodeHash, err)
+	}
+
+	// 内容不变时哈希不变
+	if hash, _ := HashDependencyFiles(dir, pyFiles); hash != pyHash {
+		t.Errorf("hash changed without changes: %s != %s", hash, pyHash)
+	}
+
+	// 修改依赖文件
+	if err := ioutil.WriteFile(filepath.Join(dir, "requirements.txt"), []byte("requests==2.23.0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if hash, _ := HashDependencyFiles(dir, pyFiles); hash == pyHash {
+		t.Errorf("hash not changed after requirements.txt changed")
+	}
+
+	// 锁文件参与哈希计算
+	if err := ioutil.WriteFile(filepath.Join(dir, "package-lock.json"), []byte(`{"lockfileVersion": 1}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	lockHash, _ := HashDependencyFiles(dir, nodeFiles)
+	if lockHash == nodeHash {
+		t.Errorf("hash not changed after package-lock.json added")
+	}
+
+	// 只有锁文件时不安装
+	if err := os.Remove(filepath.Join(dir, "package.json")); err != nil {
+		t.Fatal(err)
+	}
+	if hash, _ := HashDependencyFiles(dir, nodeFiles); hash != "" {
+		t.Errorf("hash without package.json = %s", hash)
+	}
+}
+
+func TestInstallDependencySkipInstalled(t *testing.T) {
+	spider, cleanup := newTestDependencySpider(t, map[string]string{"requirements.txt": "requests\n"})
+	defer cleanup()
+
+	// 替换安装函数，记录安装次数
+	installs := 0
+	var installErr error
+	oldInstallers := dependencyInstallers
+	dependencyInstallers = map[string]func(spider model.Spider, logFile io.Writer) error{
+		constants.DependencyTypePython: func(spider model.Spider, logFile io.Writer) error {
+			installs++
+			_, _ = io.WriteString(logFile, "installing\n")
+			return installErr
+		},
+	}
+	defer func() { dependencyInstallers = oldInstallers }()
+
+	// 未安装
+	if dep, err := GetDependencyStatus(spider, constants.DependencyTypePython); err != nil || dep.Status != "" {
+		t.Fatalf("GetDependencyStatus = %+v, %v", dep, err)
+	}
+
+	// 安装失败，记录错误，下次重新安装
+	installErr = errors.New("pip failed")
+	if err := InstallSpiderDependencies(spider); err == nil || !strings.Contains(err.Error(), "pip failed") {
+		t.Fatalf("InstallSpiderDependencies = %v", err)
+	}
+	dep, err := GetDependencyStatus(spider, constants.DependencyTypePython)
+	if err != nil || dep.Status != constants.DependencyStatusError || dep.Error != "pip failed" {
+		t.Fatalf("GetDependencyStatus = %+v, %v", dep, err)
+	}
+
+	installErr = nil
+	if err := InstallSpiderDependencies(spider); err != nil {
+		t.Fatal(err)
+	}
+	dep, err = GetDependencyStatus(spider, constants.DependencyTypePython)
+	if err != nil || dep.Status != constants.DependencyStatusInstalled || dep.SpiderId != spider.Id.Hex() || dep.Hash == "" {
+		t.Fatalf("GetDependencyStatus = %+v, %v", dep, err)
+	}
+	if installs != 2 {
+		t.Fatalf("installs = %d, want 2", installs)
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(GetDependencyEnvPath(spider), "python.log")); err != nil || string(data) != "installing\n" {
+		t.Errorf("python.log = %s, %v", data, err)
+	}
+
+	// 已安装且依赖文件未变化时跳过
+	if err := InstallSpiderDependencies(spider); err != nil {
+		t.Fatal(err)
+	}
+	if installs != 2 {
+		t.Fatalf("installs = %d, want 2 (skip installed)", installs)
+	}
+
+	// 依赖文件变化时重新安装
+	if err := ioutil.WriteFile(filepath.Join(GetLocalSpiderDir(spider), "requirements.txt"), []byte("scrapy\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := InstallSpiderDependencies(spider); err != nil {
+		t.Fatal(err)
+	}
+	if installs != 3 {
+		t.Fatalf("installs = %d, want 3", installs)
+	}
+}
+
+func TestGetDependencyEnvs(t *testing.T) {
+	spider, cleanup := newTestDependencySpider(t, nil)
+	defer cleanup()
+	path := os.Getenv("PATH")
+
+	// 没有依赖环境时只有PATH
+	envs := GetDependencyEnvs(spider)
+	if len(envs) != 1 || envs[0] != "PATH="+path {
+		t.Fatalf("GetDependencyEnvs = %v", envs)
+	}
+
+	// Python虚拟环境及NodeJS依赖
+	binPath := GetPythonEnvBinPath(spider)
+	nodeModules := filepath.Join(GetNodeEnvPath(spider), "node_modules")
+	for _, dir := range []string{binPath, nodeModules} {
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	envs = GetDependencyEnvs(spider)
+	want := []string{
+		"VIRTUAL_ENV=" + GetPythonEnvPath(spider),
+		"NODE_PATH=" + nodeModules,
+		"PATH=" + strings.Join([]string{binPath, filepath.Join(nodeModules, ".bin"), path}, string(os.PathListSeparator)),
+	}
+	if strings.Join(envs, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("GetDependencyEnvs = %v, want %v", envs, want)
+	}
+}
@@ -0,0 +1,272 @@
+package services
+
+import (
+	"bufio"
+	"crawlab/constants"
+	"crawlab/model"
+	"crawlab/sinks"
+	"crawlab/utils"
+	"crypto/sha1"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/globalsign/mgo/bson"
+	"io"
+	"sort"
+)
+
+const (
+	DiffAdded   = "added"
+	DiffRemoved = "removed"
+	DiffChanged = "changed"
+)
+
+var (
+	ErrNoKeyFields   = errors.New("spider has no key fields (dedup_keys)")
+	ErrDiffDedupMode = errors.New("result diff requires dedup_mode keep, repeated results are not stored under each task with skip or upsert")
+)
+
+// 字段变化
+type DiffChange struct {
+	Field string      `json:"field"`
+	Old   interface{} `json:"old"`
+	New   interface{} `json:"new"`
+}
+
+// 一条结果的变化
+type DiffEntry struct {
+	Type    string       `json:"type"`              // added, removed, changed
+	Key     bson.M       `json:"key"`               // 键字段的值
+	Item    bson.M       `json:"item,omitempty"`    // 新增或删除的结果
+	Changes []DiffChange `json:"changes,omitempty"` // 变化的字段
+}
+
+// 结果内容（去掉Crawlab写入的字段后展开）
+func getDiffContent(item bson.M) map[string]interface{} {
+	flat := utils.FlattenMap(item)
+	for field := range flat {
+		if resultInternalFields[field] {
+			delete(flat, field)
+		}
+	}
+	return flat
+}
+
+// 结果内容的哈希
+func getDiffHash(content map[string]interface{}) string {
+	data, _ := json.Marshal(content)
+	return fmt.Sprintf("%x", sha1.Sum(data))
+}
+
+// 遍历任务的结果，跳过没有键字段的结果，键重复时只取第一条
+func iterDiffItems(iterable sinks.Iterable, taskId string, keyFields []string, fn func(key string, item bson.M)) error {
+	iter, err := iterable.Iter([]string{taskId})
+	if err != nil {
+		return err
+	}
+	keys := map[string]bool{}
+	for {
+		var item bson.M
+		if !iter.Next(&item) {
+			break
+		}
+		key, ok := GetDedupKey(item, keyFields)
+		if !ok || keys[key] {
+			continue
+		}
+		keys[key] = true
+		fn(key, item)
+	}
+	return iter.Close()
+}
+
+// 键字段的值
+func getDiffKey(item bson.M, keyFields []string) bson.M {
+	key := bson.M{}
+	for _, field := range keyFields {
+		key[field] = item[field]
+	}
+	return key
+}
+
+// 校验爬虫是否支持比较任务结果
+// 去重方式为skip时重复的结果不会保存到新任务下，为upsert时结果的task_id会被更新为最新的任务，
+// 任务下的结果都不完整，只有keep（只作为键，不去重）时可以比较
+func ValidateDiffSpider(spider model.Spider) error {
+	if len(spider.DedupKeys) == 0 {
+		return ErrNoKeyFields
+	}
+	if spider.DedupMode != constants.DedupModeKeep {
+		return ErrDiffDedupMode
+	}
+	return nil
+}
+
+// 比较两个任务的结果，按爬虫的键字段（dedup_keys）匹配结果，依次输出新增、删除及变化的结果
+// 为支持大量结果，内存中只保存每条结果的键及内容哈希，以及变化结果在基准任务中的内容：
+// 1. 遍历基准任务，记录键及内容哈希
+// 2. 遍历当前任务，输出新增的结果，记录变化的键
+// 3. 遍历基准任务，输出删除的结果，保存变化结果的原内容
+// 4. 遍历当前任务，输出变化的字段
+func DiffTaskResults(spider model.Spider, baseTaskId string, taskId string, emit func(entry DiffEntry) error) error {
+	if err := ValidateDiffSpider(spider); err != nil {
+		return err
+	}
+	keyFields := spider.DedupKeys
+
+	sink, err := spider.GetSink()
+	if err != nil {
+		return err
+	}
+	iterable, ok := sink.(sinks.Iterable)
+	if !ok {
+		return sinks.ErrQueryNotSupported
+	}
+
+	return diffTaskResults(iterable, keyFields, baseTaskId, taskId, emit)
+}
+
+func diffTaskResults(iterable sinks.Iterable, keyFields []string, baseTaskId string, taskId string, emit func(entry DiffEntry) error) error {
+	// 基准任务的键及内容哈希
+	baseHashes := map[string]string{}
+	if err := iterDiffItems(iterable, baseTaskId, keyFields, func(key string, item bson.M) {
+		baseHashes[key] = getDiffHash(getDiffContent(item))
+	}); err != nil {
+		return err
+	}
+
+	// 新增的结果
+	var emitErr error
+	seen := map[string]bool{}
+	changed := map[string]bool{}
+	if err := iterDiffItems(iterable, taskId, keyFields, func(key string, item bson.M) {
+		if emitErr != nil {
+			return
+		}
+		seen[key] = true
+		hash, ok := baseHashes[key]
+		if !ok {
+			emitErr = emit(DiffEntry{Type: DiffAdded, Key: getDiffKey(item, keyFields), Item: item})
+		} else if hash != getDiffHash(getDiffContent(item)) {
+			changed[key] = true
+		}
+	}); err != nil {
+		return err
+	}
+	if emitErr != nil {
+		return emitErr
+	}
+
+	// 删除的结果，保存变化结果的原内容
+	baseContents := map[string]map[string]interface{}{}
+	if err := iterDiffItems(iterable, baseTaskId, keyFields, func(key string, item bson.M) {
+		if emitErr != nil {
+			return
+		}
+		if !seen[key] {
+			emitErr = emit(DiffEntry{Type: DiffRemoved, Key: getDiffKey(item, keyFields), Item: item})
+		} else if changed[key] {
+			baseContents[key] = getDiffContent(item)
+		}
+	}); err != nil {
+		return err
+	}
+	if emitErr != nil {
+		return emitErr
+	}
+
+	// 变化的字段
+	if len(changed) == 0 {
+		return nil
+	}
+	if err := iterDiffItems(iterable, taskId, keyFields, func(key string, item bson.M) {
+		if emitErr != nil || !changed[key] {
+			return
+		}
+		oldContent := baseContents[key]
+		newContent := getDiffContent(item)
+
+		var fields []string
+		for field := range oldContent {
+			fields = append(fields, field)
+		}
+		for field := range newContent {
+			if _, ok := oldContent[field]; !ok {
+				fields = append(fields, field)
+			}
+		}
+		sort.Strings(fields)
+
+		var changes []DiffChange
+		for _, field := range fields {
+			oldValue, newValue := oldContent[field], newContent[field]
+			if getDiffHash(map[string]interface{}{"v": oldValue}) != getDiffHash(map[string]interface{}{"v": newValue}) {
+				changes = append(changes, DiffChange{Field: field, Old: oldValue, New: newValue})
+			}
+		}
+		delete(changed, key)
+		emitErr = emit(DiffEntry{Type: DiffChanged, Key: getDiffKey(item, keyFields), Changes: changes})
+	}); err != nil {
+		return err
+	}
+	return emitErr
+}
+
+// 将结果比较以JSON Lines、CSV或Excel格式流式写入w
+// CSV及Excel中每个变化的字段一行：type, 键字段..., field, old, new
+func ExportResultDiff(w io.Writer, spider model.Spider, baseTaskId string, taskId string, format string) error {
+	if _, ok := ExportContentTypes[format]; !ok {
+		return errors.New("unsupported export format: " + format)
+	}
+
+	bw := bufio.NewWriter(w)
+
+	// JSON Lines
+	if format == ExportFormatJsonl {
+		enc := json.NewEncoder(bw)
+		if err := DiffTaskResults(spider, baseTaskId, taskId, func(entry DiffEntry) error {
+			return enc.Encode(entry)
+		}); err != nil {
+			return err
+		}
+		return bw.Flush()
+	}
+
+	// CSV、Excel
+	rw, closeFunc, err := newRowWriter(bw, format)
+	if err != nil {
+		return err
+	}
+
+	// 表头
+	header := []string{"type"}
+	header = append(header, spider.DedupKeys...)
+	header = append(header, "field", "old", "new")
+	if err := rw.Write(header); err != nil {
+		return err
+	}
+
+	if err := DiffTaskResults(spider, baseTaskId, taskId, func(entry DiffEntry) error {
+		row := []string{entry.Type}
+		for _, field := range spider.DedupKeys {
+			row = append(row, utils.InterfaceToString(entry.Key[field]))
+		}
+		if entry.Type != DiffChanged {
+			return rw.Write(append(row, "", "", ""))
+		}
+		for _, change := range entry.Changes {
+			values := append(append([]string{}, row...), change.Field, utils.InterfaceToString(change.Old), utils.InterfaceToString(change.New))
+			if err := rw.Write(values); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if err := closeFunc(); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,134 @@
+package services
+
+import (
+	"crawlab/constants"
+	"crawlab/model"
+	"crawlab/sinks"
+	"errors"
+	"github.com/globalsign/mgo/bson"
+	"reflect"
+	"testing"
+)
+
+// 内存中的结果储存（测试用）
+type fakeIterable struct {
+	items map[string][]bson.M
+	iters int
+}
+
+type fakeIterator struct {
+	items []bson.M
+}
+
+func (f *fakeIterable) Iter(taskIds []string) (sinks.Iterator, error) {
+	f.iters++
+	var items []bson.M
+	for _, taskId := range taskIds {
+		items = append(items, f.items[taskId]...)
+	}
+	return &fakeIterator{items: items}, nil
+}
+
+func (i *fakeIterator) Next(item *bson.M) bool {
+	if len(i.items) == 0 {
+		return false
+	}
+	*item = bson.M{}
+	for k, v := range i.items[0] {
+		(*item)[k] = v
+	}
+	i.items = i.items[1:]
+	return true
+}
+
+func (i *fakeIterator) Close() error {
+	return nil
+}
+
+func TestValidateDiffSpider(t *testing.T) {
+	tests := []struct {
+		spider model.Spider
+		want   error
+	}{
+		{model.Spider{DedupMode: constants.DedupModeKeep}, ErrNoKeyFields},
+		{model.Spider{DedupKeys: []string{"url"}}, ErrDiffDedupMode},
+		{model.Spider{DedupKeys: []string{"url"}, DedupMode: constants.DedupModeSkip}, ErrDiffDedupMode},
+		{model.Spider{DedupKeys: []string{"url"}, DedupMode: constants.DedupModeUpsert}, ErrDiffDedupMode},
+		{model.Spider{DedupKeys: []string{"url"}, DedupMode: constants.DedupModeKeep}, nil},
+	}
+	for _, tt := range tests {
+		if err := ValidateDiffSpider(tt.spider); err != tt.want {
+			t.Errorf("ValidateDiffSpider(%v, %s) = %v, want %v", tt.spider.DedupKeys, tt.spider.DedupMode, err, tt.want)
+		}
+	}
+}
+
+func TestDiffTaskResults(t *testing.T) {
+	iterable := &fakeIterable{items: map[string][]bson.M{
+		"base": {
+			{"url": "a", "title": "A", "task_id": "base"},
+			{"url": "b", "title": "B", "price": 1, "task_id": "base"},
+			{"url": "c", "title": "C", "task_id": "base"},
+			{"url": "d", "meta": bson.M{"tag": "x"}, "task_id": "base"},
+			// 重复的键，只取第一条
+			{"url": "a", "title": "A2", "task_id": "base"},
+			// 没有键字段
+			{"title": "no key", "task_id": "base"},
+			{"url": nil, "title": "nil key", "task_id": "base"},
+		},
+		"new": {
+			{"url": "a", "title": "A", "task_id": "new", "ingest_ts": 1},
+			{"url": "b", "title": "B2", "task_id": "new"},
+			{"url": "d", "meta": bson.M{"tag": "y"}, "task_id": "new"},
+			{"url": "e", "title": "E", "task_id": "new"},
+			{"url": "e", "title": "E2", "task_id": "new"},
+			{"url": "b", "title": "B3", "task_id": "new"},
+			{"title": "no key", "task_id": "new"},
+		},
+	}}
+
+	var entries []DiffEntry
+	err := diffTaskResults(iterable, []string{"url"}, "base", "new", func(entry DiffEntry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []DiffEntry{
+		{Type: DiffAdded, Key: bson.M{"url": "e"}, Item: bson.M{"url": "e", "title": "E", "task_id": "new"}},
+		{Type: DiffRemoved, Key: bson.M{"url": "c"}, Item: bson.M{"url": "c", "title": "C", "task_id": "base"}},
+		{Type: DiffChanged, Key: bson.M{"url": "b"}, Changes: []DiffChange{
+			{Field: "price", Old: 1, New: nil},
+			{Field: "title", Old: "B", New: "B2"},
+		}},
+		{Type: DiffChanged, Key: bson.M{"url": "d"}, Changes: []DiffChange{
+			{Field: "meta.tag", Old: "x", New: "y"},
+		}},
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Fatalf("diffTaskResults =\n%v\nwant\n%v", entries, want)
+	}
+
+	// 没有变化时不再遍历当前任务
+	iterable.iters = 0
+	entries = nil
+	if err := diffTaskResults(iterable, []string{"url"}, "base", "base", func(entry DiffEntry) error {
+		entries = append(entries, entry)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 || iterable.iters != 3 {
+		t.Fatalf("diffTaskResults same task = %v, iters %d", entries, iterable.iters)
+	}
+
+	// 输出出错时停止
+	errEmit := errors.New("emit")
+	if err := diffTaskResults(iterable, []string{"url"}, "base", "new", func(entry DiffEntry) error {
+		return errEmit
+	}); err != errEmit {
+		t.Fatalf("diffTaskResults = %v, want %v", err, errEmit)
+	}
+}
@@ -0,0 +1,90 @@
+package services
+
+import (
+	"crawlab/model"
+	"errors"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/globalsign/mgo/bson"
+	"github.com/spf13/viper"
+	"regexp"
+	"time"
+)
+
+// 下载令牌有效期（秒）
+const downloadTokenExpire = 300
+
+// 可使用下载令牌的下载地址
+var downloadPathRegexes = []*regexp.Regexp{
+	regexp.MustCompile(`^/tasks/[^/]+/results/download$`),
+	regexp.MustCompile(`^/spiders/[^/]+/results/download$`),
+	regexp.MustCompile(`^/spiders/[^/]+/files/download$`),
+}
+
+// 是否为可使用下载令牌的下载地址
+func IsDownloadPath(path string) bool {
+	for _, re := range downloadPathRegexes {
+		if re.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// 生成下载令牌，浏览器直接下载时无法携带Authorization请求头，通过查询参数token传递
+// 令牌短期有效，且只对指定的下载地址有效
+func MakeDownloadToken(user model.User, path string) (string, error) {
+	if !IsDownloadPath(path) {
+		return "", errors.New("invalid download path")
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user_id":       user.Id.Hex(),
+		"download_path": path,
+		"nbf":           time.Now().Unix(),
+		"exp":           time.Now().Unix() + downloadTokenExpire,
+	})
+	return token.SignedString([]byte(viper.GetString("server.secret")))
+}
+
+// 校验下载令牌，返回令牌所属用户
+func CheckDownloadToken(tokenStr string, path string) (user model.User, err error) {
+	userId, err := parseDownloadToken(tokenStr, path)
+	if err != nil {
+		return user, err
+	}
+
+	user, err = model.GetUser(bson.ObjectIdHex(userId))
+	if err != nil {
+		return user, errors.New("cannot get user")
+	}
+	return user, nil
+}
+
+// 解析下载令牌，返回用户ID
+func parseDownloadToken(tokenStr string, path string) (string, error) {
+	token, err := jwt.Parse(tokenStr, SecretFunc())
+	if err != nil {
+		return "", err
+	}
+	if !token.Valid {
+		return "", errors.New("token is invalid")
+	}
+
+	claim, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return "", errors.New("cannot convert claim to mapclaim")
+	}
+
+	// 有效期由jwt校验，这里要求令牌必须带有有效期
+	if _, ok := claim["exp"]; !ok {
+		return "", errors.New("token has no expiry")
+	}
+	if p, ok := claim["download_path"].(string); !ok || p != path {
+		return "", errors.New("token does not match download path")
+	}
+	userId, ok := claim["user_id"].(string)
+	if !ok || !bson.IsObjectIdHex(userId) {
+		return "", errors.New("token is not a download token")
+	}
+	return userId, nil
+}
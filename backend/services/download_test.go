@@ -0,0 +1,64 @@
+package services
+
+import (
+	"crawlab/model"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/globalsign/mgo/bson"
+	"github.com/spf13/viper"
+	"testing"
+	"time"
+)
+
+func TestDownloadToken(t *testing.T) {
+	viper.Set("server.secret", "test-secret")
+	defer viper.Set("server.secret", nil)
+
+	user := model.User{Id: bson.NewObjectId()}
+	path := "/tasks/abc/results/download"
+
+	for _, p := range []string{"/spiders/abc/files", "/spiders/abc/file", "/spiders/abc/files/download/x", "/tasks/abc/log"} {
+		if _, err := MakeDownloadToken(user, p); err == nil {
+			t.Fatalf("expected error for non-download path %s", p)
+		}
+	}
+
+	token, err := MakeDownloadToken(user, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userId, err := parseDownloadToken(token, path); err != nil || userId != user.Id.Hex() {
+		t.Fatalf("parseDownloadToken = %s, %v", userId, err)
+	}
+	if _, err := parseDownloadToken(token, "/spiders/abc/results/download"); err == nil {
+		t.Errorf("expected error for other path")
+	}
+
+	// 爬虫文件下载
+	filePath := "/spiders/abc/files/download"
+	fileToken, err := MakeDownloadToken(user, filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userId, err := parseDownloadToken(fileToken, filePath); err != nil || userId != user.Id.Hex() {
+		t.Fatalf("parseDownloadToken = %s, %v", userId, err)
+	}
+	if _, err := parseDownloadToken(fileToken, "/spiders/def/files/download"); err == nil {
+		t.Errorf("expected error for other spider")
+	}
+
+	// 用户令牌、过期令牌不能作为下载令牌使用
+	expired, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user_id":       user.Id.Hex(),
+		"download_path": path,
+		"exp":           time.Now().Add(-time.Minute).Unix(),
+	}).SignedString([]byte("test-secret"))
+	userToken, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"id":       user.Id.Hex(),
+		"username": "admin",
+	}).SignedString([]byte("test-secret"))
+	for _, tok := range []string{expired, userToken, token + "x"} {
+		if _, err := parseDownloadToken(tok, path); err == nil {
+			t.Errorf("expected error for token %s", tok)
+		}
+	}
+}
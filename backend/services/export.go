@@ -0,0 +1,225 @@
+package services
+
+import (
+	"bufio"
+	"crawlab/constants"
+	"crawlab/model"
+	"crawlab/sinks"
+	"crawlab/utils"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"github.com/globalsign/mgo/bson"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	ExportFormatCsv   = "csv"
+	ExportFormatJsonl = "jsonl"
+	ExportFormatXlsx  = "xlsx"
+)
+
+// 导出文件类型
+var ExportContentTypes = map[string]string{
+	ExportFormatCsv:   "text/csv",
+	ExportFormatJsonl: "application/x-ndjson",
+	ExportFormatXlsx:  "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+}
+
+// 导出选项
+type ExportOptions struct {
+	Format  string   // 导出格式：csv, jsonl, xlsx
+	Columns []string // 导出的字段（嵌套字段以点号连接），为空时扫描全部结果得到字段列表
+}
+
+// 表格中的一行
+type rowWriter interface {
+	Write(values []string) error
+}
+
+// 表格写入器，CSV写入UTF-8 BOM，避免使用Microsoft Excel打开乱码
+func newRowWriter(bw *bufio.Writer, format string) (rowWriter, func() error, error) {
+	if format == ExportFormatCsv {
+		if _, err := bw.WriteString("\xEF\xBB\xBF"); err != nil {
+			return nil, nil, err
+		}
+		cw := csv.NewWriter(bw)
+		return cw, func() error {
+			cw.Flush()
+			return cw.Error()
+		}, nil
+	}
+
+	xw, err := utils.NewXlsxWriter(bw)
+	if err != nil {
+		return nil, nil, err
+	}
+	return xw, xw.Close, nil
+}
+
+// 获取爬虫在指定时间范围内（按任务创建时间）的任务，时间为零值时不限制
+func GetSpiderTasksByDate(spiderId bson.ObjectId, startTs time.Time, endTs time.Time) ([]model.Task, error) {
+	query := bson.M{"spider_id": spiderId}
+	createTs := bson.M{}
+	if !startTs.IsZero() {
+		createTs["$gte"] = startTs
+	}
+	if !endTs.IsZero() {
+		createTs["$lt"] = endTs
+	}
+	if len(createTs) > 0 {
+		query["create_ts"] = createTs
+	}
+
+	return model.GetTaskList(query, 0, constants.Infinite, "create_ts")
+}
+
+// 获取爬虫在指定时间范围内（按任务创建时间）的任务ID，时间为零值时不限制
+func GetSpiderTaskIds(spiderId bson.ObjectId, startTs time.Time, endTs time.Time) ([]string, error) {
+	tasks, err := GetSpiderTasksByDate(spiderId, startTs, endTs)
+	if err != nil {
+		return nil, err
+	}
+	var taskIds []string
+	for _, t := range tasks {
+		taskIds = append(taskIds, t.Id)
+	}
+	return taskIds, nil
+}
+
+// 扫描结果，得到展开后的字段列表（_id在最前，其余按字母顺序）
+func scanExportColumns(iterable sinks.Iterable, taskIds []string) ([]string, error) {
+	iter, err := iterable.Iter(taskIds)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := map[string]bool{}
+	var item bson.M
+	for iter.Next(&item) {
+		for key := range utils.FlattenMap(item) {
+			keys[key] = true
+		}
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+
+	var columns []string
+	for key := range keys {
+		if key != "_id" {
+			columns = append(columns, key)
+		}
+	}
+	sort.Strings(columns)
+	if keys["_id"] {
+		columns = append([]string{"_id"}, columns...)
+	}
+	return columns, nil
+}
+
+// 流式导出爬虫任务的结果，逐条读取结果并写入w，不会将全部结果载入内存
+func ExportResults(w io.Writer, spider model.Spider, taskIds []string, opts ExportOptions) error {
+	if _, ok := ExportContentTypes[opts.Format]; !ok {
+		return errors.New("unsupported export format: " + opts.Format)
+	}
+
+	sink, err := spider.GetSink()
+	if err != nil {
+		return err
+	}
+	iterable, ok := sink.(sinks.Iterable)
+	if !ok {
+		return sinks.ErrExportNotSupported
+	}
+
+	return exportResults(w, iterable, taskIds, opts)
+}
+
+func exportResults(w io.Writer, iterable sinks.Iterable, taskIds []string, opts ExportOptions) (err error) {
+	// 字段列表（JSON Lines未指定字段时按原样导出，无需扫描）
+	columns := opts.Columns
+	if len(columns) == 0 && opts.Format != ExportFormatJsonl {
+		if columns, err = scanExportColumns(iterable, taskIds); err != nil {
+			return err
+		}
+	}
+
+	iter, err := iterable.Iter(taskIds)
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+
+	bw := bufio.NewWriter(w)
+
+	// JSON Lines
+	if opts.Format == ExportFormatJsonl {
+		enc := json.NewEncoder(bw)
+		enc.SetEscapeHTML(false)
+		var item bson.M
+		for iter.Next(&item) {
+			var value interface{} = item
+			if len(columns) > 0 {
+				flat := utils.FlattenMap(item)
+				row := bson.M{}
+				for _, col := range columns {
+					row[col] = flat[col]
+				}
+				value = row
+			}
+			if err := enc.Encode(value); err != nil {
+				return err
+			}
+		}
+		if err := iter.Close(); err != nil {
+			return err
+		}
+		return bw.Flush()
+	}
+
+	// CSV、Excel
+	rw, closeFunc, err := newRowWriter(bw, opts.Format)
+	if err != nil {
+		return err
+	}
+
+	// 写入表头
+	if err := rw.Write(columns); err != nil {
+		return err
+	}
+
+	// 写入内容
+	var item bson.M
+	values := make([]string, len(columns))
+	for iter.Next(&item) {
+		flat := utils.FlattenMap(item)
+		for i, col := range columns {
+			values[i] = utils.InterfaceToString(flat[col])
+		}
+		if err := rw.Write(values); err != nil {
+			return err
+		}
+	}
+	if err := iter.Close(); err != nil {
+		return err
+	}
+
+	if err := closeFunc(); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// 解析以逗号分隔的字段列表
+func ParseFieldList(str string) (columns []string) {
+	for _, col := range strings.Split(str, ",") {
+		if col = strings.TrimSpace(col); col != "" {
+			columns = append(columns, col)
+		}
+	}
+	return columns
+}
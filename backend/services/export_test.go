@@ -0,0 +1,133 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"github.com/globalsign/mgo/bson"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newExportIterable() *fakeIterable {
+	id := bson.ObjectIdHex("5d7f1d2c8c8b4a0001000001")
+	return &fakeIterable{items: map[string][]bson.M{
+		"t1": {
+			{"_id": id, "title": "a,b \"c\"", "price": 1.5, "meta": bson.M{"tag": "x", "n": 2}},
+			{"title": "中文", "tags": []interface{}{"a", "b"}, "ok": true},
+		},
+		"t2": {
+			{"title": "<b>&</b>", "url": "http://x"},
+		},
+	}}
+}
+
+func TestExportResultsCsv(t *testing.T) {
+	var buf bytes.Buffer
+	if err := exportResults(&buf, newExportIterable(), []string{"t1", "t2"}, ExportOptions{Format: ExportFormatCsv}); err != nil {
+		t.Fatal(err)
+	}
+
+	data := buf.Bytes()
+	if !bytes.HasPrefix(data, []byte("\xEF\xBB\xBF")) {
+		t.Fatal("csv should start with utf-8 bom")
+	}
+	records, err := csv.NewReader(bytes.NewReader(data[3:])).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// _id在最前，其余字段按字母顺序
+	want := [][]string{
+		{"_id", "meta.n", "meta.tag", "ok", "price", "tags", "title", "url"},
+		{"5d7f1d2c8c8b4a0001000001", "2", "x", "", "1.5", "", "a,b \"c\"", ""},
+		{"", "", "", "true", "", `["a","b"]`, "中文", ""},
+		{"", "", "", "", "", "", "<b>&</b>", "http://x"},
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Fatalf("csv =\n%q\nwant\n%q", records, want)
+	}
+
+	// 指定字段时按指定顺序导出
+	buf.Reset()
+	if err := exportResults(&buf, newExportIterable(), []string{"t2"}, ExportOptions{Format: ExportFormatCsv, Columns: []string{"url", "missing", "title"}}); err != nil {
+		t.Fatal(err)
+	}
+	records, err = csv.NewReader(bytes.NewReader(buf.Bytes()[3:])).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = [][]string{{"url", "missing", "title"}, {"http://x", "", "<b>&</b>"}}
+	if !reflect.DeepEqual(records, want) {
+		t.Fatalf("csv =\n%q\nwant\n%q", records, want)
+	}
+}
+
+func TestExportResultsJsonl(t *testing.T) {
+	var buf bytes.Buffer
+	opts := ExportOptions{Format: ExportFormatJsonl, Columns: []string{"title", "meta.tag"}}
+	if err := exportResults(&buf, newExportIterable(), []string{"t1"}, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("jsonl lines = %d, want 2", len(lines))
+	}
+	want := []map[string]interface{}{
+		{"title": `a,b "c"`, "meta.tag": "x"},
+		{"title": "中文", "meta.tag": nil},
+	}
+	for i, line := range lines {
+		var row map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &row); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(row, want[i]) {
+			t.Errorf("jsonl line %d = %v, want %v", i, row, want[i])
+		}
+	}
+
+	// 未指定字段时按原样导出
+	buf.Reset()
+	if err := exportResults(&buf, newExportIterable(), []string{"t2"}, ExportOptions{Format: ExportFormatJsonl}); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(buf.String()); got != `{"title":"<b>&</b>","url":"http://x"}` {
+		t.Errorf("jsonl = %s", got)
+	}
+}
+
+func TestExportResultsXlsx(t *testing.T) {
+	var buf bytes.Buffer
+	if err := exportResults(&buf, newExportIterable(), []string{"t2"}, ExportOptions{Format: ExportFormatXlsx}); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range zr.File {
+		if f.Name != "xl/worksheets/sheet1.xml" {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(data), "&lt;b&gt;&amp;&lt;/b&gt;") {
+			t.Fatalf("sheet cells are not escaped: %s", data)
+		}
+		return
+	}
+	t.Fatal("sheet1.xml not found")
+}
@@ -0,0 +1,253 @@
+package services
+
+import (
+	"bytes"
+	"crawlab/constants"
+	"crawlab/model"
+	"github.com/apex/log"
+	"github.com/globalsign/mgo/bson"
+	"github.com/pkg/errors"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// git命令允许使用的传输协议
+const gitAllowProtocol = "https:ssh:git"
+
+// 允许的仓库地址协议（不允许本地路径、file://及ext::等可执行命令的传输协议）
+var gitUrlSchemes = map[string]bool{
+	"https": true,
+	"ssh":   true,
+	"git":   true,
+}
+
+// scp风格的仓库地址，如git@github.com:crawlab-team/crawlab.git
+var gitScpUrlRegex = regexp.MustCompile(`^[A-Za-z0-9._-]+@[A-Za-z0-9][A-Za-z0-9.-]*:[^:]`)
+
+// 校验仓库地址：只允许https、ssh、git协议及scp风格的地址
+func validateGitUrl(gitUrl string) error {
+	if gitScpUrlRegex.MatchString(gitUrl) {
+		return nil
+	}
+	u, err := url.Parse(gitUrl)
+	if err != nil || !gitUrlSchemes[u.Scheme] || u.Host == "" {
+		return errors.New("invalid git_url: only https, ssh, git and scp-style urls are allowed")
+	}
+	return nil
+}
+
+// 通过凭据助手传递用户名和密码（从环境变量读取），不写入仓库地址，避免出现在命令行参数及本地仓库配置中
+// 第一个空的credential.helper清除全局配置的凭据助手
+const gitCredentialHelper = `!f() { test "$1" = get && echo "username=${CRAWLAB_GIT_USERNAME}" && echo "password=${CRAWLAB_GIT_PASSWORD}"; }; f`
+
+// 执行git命令所需的认证配置及环境变量
+func getGitAuth(spider model.Spider) (args []string, env []string) {
+	if spider.GitUsername == "" && spider.GitPassword == "" {
+		return nil, nil
+	}
+	args = []string{"-c", "credential.helper=", "-c", "credential.helper=" + gitCredentialHelper}
+	env = []string{"CRAWLAB_GIT_USERNAME=" + spider.GitUsername, "CRAWLAB_GIT_PASSWORD=" + spider.GitPassword}
+	return args, env
+}
+
+// 提交SHA
+var gitShaRegex = regexp.MustCompile(`^[0-9a-fA-F]{4,64}$`)
+
+// 校验传给git命令的仓库地址及分支，避免以-开头被当作命令选项（如--upload-pack）
+func validateGitArg(name string, value string) error {
+	if strings.HasPrefix(value, "-") {
+		return errors.New("invalid " + name + ": must not start with '-'")
+	}
+	if strings.ContainsAny(value, " \t\r\n\x00") {
+		return errors.New("invalid " + name + ": must not contain whitespace")
+	}
+	return nil
+}
+
+// 在目录中执行git命令，返回标准输出
+func RunGitCmd(spider model.Spider, dir string, args ...string) (string, error) {
+	authArgs, authEnv := getGitAuth(spider)
+	cmd := exec.Command("git", append(authArgs, args...)...)
+	cmd.Dir = dir
+	// 禁止交互式输入认证信息，避免命令挂起；只允许指定的传输协议（包括重定向及子模块）
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0", "GIT_ALLOW_PROTOCOL="+gitAllowProtocol)
+	cmd.Env = append(cmd.Env, authEnv...)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		// 错误信息中隐去密码
+		msg := strings.TrimSpace(stderr.String())
+		if spider.GitPassword != "" {
+			msg = strings.Replace(msg, spider.GitPassword, "******", -1)
+		}
+		return "", errors.New("git " + args[0] + ": " + msg)
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// 拉取Git仓库到爬虫源码目录，返回当前提交SHA
+// 1. 源码目录不是Git仓库时初始化仓库
+// 2. 拉取指定分支或标签
+// 3. 将工作目录重置为拉取的提交
+func PullGitRepo(spider model.Spider) (string, error) {
+	if spider.GitUrl == "" {
+		return "", errors.New("spider is not backed by a git repository")
+	}
+	if err := ValidateGitSpider(spider); err != nil {
+		return "", err
+	}
+
+	// 初始化仓库
+	if !IsGitRepo(spider.Src) {
+		if err := os.MkdirAll(spider.Src, os.ModePerm); err != nil {
+			return "", err
+		}
+		if _, err := RunGitCmd(spider, spider.Src, "init"); err != nil {
+			return "", err
+		}
+	}
+
+	// 拉取分支或标签（为空时拉取默认分支）
+	ref := spider.GitBranch
+	if ref == "" {
+		ref = "HEAD"
+	}
+	if _, err := RunGitCmd(spider, spider.Src, "fetch", "--depth", "1", "--force", "--", spider.GitUrl, ref); err != nil {
+		return "", err
+	}
+
+	// 重置工作目录
+	if _, err := RunGitCmd(spider, spider.Src, "reset", "--hard", "FETCH_HEAD"); err != nil {
+		return "", err
+	}
+	if _, err := RunGitCmd(spider, spider.Src, "clean", "-fd"); err != nil {
+		return "", err
+	}
+
+	return GetGitCommit(spider)
+}
+
+// 将工作目录切换到指定提交（回滚时使用）
+func CheckoutGitCommit(spider model.Spider, sha string) error {
+	if !gitShaRegex.MatchString(sha) {
+		return errors.New("invalid commit sha: " + sha)
+	}
+	if err := ValidateGitSpider(spider); err != nil {
+		return err
+	}
+
+	// 浅克隆时本地可能没有该提交，先拉取
+	if _, err := RunGitCmd(spider, spider.Src, "cat-file", "-e", sha+"^{commit}"); err != nil {
+		if _, err := RunGitCmd(spider, spider.Src, "fetch", "--depth", "1", "--", spider.GitUrl, sha); err != nil {
+			return err
+		}
+	}
+	if _, err := RunGitCmd(spider, spider.Src, "reset", "--hard", sha); err != nil {
+		return err
+	}
+	if _, err := RunGitCmd(spider, spider.Src, "clean", "-fd"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// 工作目录当前提交SHA
+func GetGitCommit(spider model.Spider) (string, error) {
+	return RunGitCmd(spider, spider.Src, "rev-parse", "HEAD")
+}
+
+func IsGitRepo(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, ".git"))
+	return err == nil
+}
+
+// 拉取Git爬虫并发布
+func PullSpider(spider model.Spider, uploader string) error {
+	commit, err := PullGitRepo(spider)
+
+	// 记录拉取结果
+	spider.GitPullTs = time.Now()
+	if err != nil {
+		spider.GitError = err.Error()
+		if err := spider.Save(); err != nil {
+			log.Errorf(err.Error())
+		}
+		return err
+	}
+	spider.GitCommit = commit
+	spider.GitError = ""
+	if err := spider.Save(); err != nil {
+		return err
+	}
+
+	// 内容未变化时跳过
+	files, err := GetDirManifest(spider.Src)
+	if err != nil {
+		return err
+	}
+	if spider.VersionId != "" && HashManifest(files) == spider.Hash {
+		return nil
+	}
+
+	// 发布到工作节点
+	return PublishSpiderVersion(spider, uploader, "git pull "+commit)
+}
+
+// 按各个Git爬虫的Cron表达式自动拉取
+func PullGitSpiders() {
+	spiders, err := model.GetSpiderList(bson.M{"git_url": bson.M{"$ne": ""}, "git_pull_cron": bson.M{"$ne": ""}}, 0, constants.Infinite)
+	if err != nil {
+		log.Errorf(err.Error())
+		return
+	}
+
+	now := time.Now()
+	for _, spider := range spiders {
+		sched, err := scheduleParser.Parse(spider.GitPullCron)
+		if err != nil {
+			log.Errorf(errors.Wrap(err, "invalid git pull cron of spider "+spider.Name).Error())
+			continue
+		}
+
+		// 自上次拉取后尚未到拉取时间
+		if !spider.GitPullTs.IsZero() && sched.Next(spider.GitPullTs).After(now) {
+			continue
+		}
+
+		if err := PullSpider(spider, constants.UploaderSystem); err != nil {
+			log.Errorf(errors.Wrap(err, "pull spider "+spider.Name).Error())
+			debug.PrintStack()
+			continue
+		}
+	}
+}
+
+// 校验Git爬虫配置
+func ValidateGitSpider(spider model.Spider) error {
+	if spider.GitUrl == "" {
+		return errors.New("git_url is empty")
+	}
+	if err := validateGitArg("git_url", spider.GitUrl); err != nil {
+		return err
+	}
+	if err := validateGitUrl(spider.GitUrl); err != nil {
+		return err
+	}
+	if err := validateGitArg("git_branch", spider.GitBranch); err != nil {
+		return err
+	}
+	if spider.GitPullCron != "" {
+		if _, err := scheduleParser.Parse(spider.GitPullCron); err != nil {
+			return errors.Wrap(err, "invalid git_pull_cron")
+		}
+	}
+	return nil
+}
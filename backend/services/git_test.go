@@ -0,0 +1,273 @@
+package services
+
+import (
+	"crawlab/model"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/cgi"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// 在目录中执行git命令（测试用）
+func runTestGit(t *testing.T, dir string, args ...string) string {
+	args = append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %s", strings.Join(args, " "), out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// 创建本地裸仓库，并从工作仓库推送一次提交到master分支，返回裸仓库路径及工作仓库路径
+func newTestGitRepo(t *testing.T, root string) (string, string) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+
+	bare := filepath.Join(root, "repo.git")
+	work := filepath.Join(root, "work")
+	runTestGit(t, root, "init", "--bare", bare)
+	runTestGit(t, bare, "config", "uploadpack.allowAnySHA1InWant", "true")
+	runTestGit(t, root, "init", work)
+	commitTestFile(t, work, bare, "main.py", "print(1)")
+	return bare, work
+}
+
+// 提交文件并推送到裸仓库，返回提交SHA
+func commitTestFile(t *testing.T, work string, bare string, name string, content string) string {
+	if err := ioutil.WriteFile(filepath.Join(work, name), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	runTestGit(t, work, "add", "-A")
+	runTestGit(t, work, "commit", "-m", "update "+name)
+	runTestGit(t, work, "push", bare, "HEAD:refs/heads/master")
+	return runTestGit(t, work, "rev-parse", "HEAD")
+}
+
+// 通过HTTPS（git http-backend）提供裸仓库，username不为空时需要Basic认证，返回仓库地址及关闭函数
+func newTestGitServer(t *testing.T, root string, bare string, username string, password string) (string, func()) {
+	out, err := exec.Command("git", "--exec-path").Output()
+	if err != nil {
+		t.Skip("git exec path not found")
+	}
+	backend := filepath.Join(strings.TrimSpace(string(out)), "git-http-backend")
+	if _, err := os.Stat(backend); err != nil {
+		t.Skip("git-http-backend not installed")
+	}
+
+	handler := &cgi.Handler{
+		Path: backend,
+		Env:  []string{"GIT_PROJECT_ROOT=" + filepath.Dir(bare), "GIT_HTTP_EXPORT_ALL=1"},
+	}
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if username != "" {
+			if u, p, ok := r.BasicAuth(); !ok || u != username || p != password {
+				w.Header().Set("WWW-Authenticate", `Basic realm="git"`)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		}
+		handler.ServeHTTP(w, r)
+	}))
+
+	// 信任测试服务器的证书
+	caPath := filepath.Join(root, "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := ioutil.WriteFile(caPath, ca, 0644); err != nil {
+		t.Fatal(err)
+	}
+	oldCa, hasCa := os.LookupEnv("GIT_SSL_CAINFO")
+	_ = os.Setenv("GIT_SSL_CAINFO", caPath)
+
+	return server.URL + "/" + filepath.Base(bare), func() {
+		server.Close()
+		if hasCa {
+			_ = os.Setenv("GIT_SSL_CAINFO", oldCa)
+		} else {
+			_ = os.Unsetenv("GIT_SSL_CAINFO")
+		}
+	}
+}
+
+func TestPullGitRepo(t *testing.T) {
+	root, err := ioutil.TempDir("", "crawlab-git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	bare, work := newTestGitRepo(t, root)
+	first := runTestGit(t, work, "rev-parse", "HEAD")
+	gitUrl, closeServer := newTestGitServer(t, root, bare, "", "")
+	defer closeServer()
+
+	spider := model.Spider{
+		GitUrl:    gitUrl,
+		GitBranch: "master",
+		Src:       filepath.Join(root, "src"),
+	}
+
+	// 首次拉取
+	sha, err := PullGitRepo(spider)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sha != first {
+		t.Fatalf("PullGitRepo = %s, want %s", sha, first)
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(spider.Src, "main.py")); err != nil || string(data) != "print(1)" {
+		t.Fatalf("main.py = %s, %v", data, err)
+	}
+
+	// 拉取新提交
+	second := commitTestFile(t, work, bare, "main.py", "print(2)")
+	if sha, err := PullGitRepo(spider); err != nil || sha != second {
+		t.Fatalf("PullGitRepo = %s, %v, want %s", sha, err, second)
+	}
+
+	// 回滚到之前的提交（浅克隆中没有该提交，需要拉取）
+	if err := CheckoutGitCommit(spider, first); err != nil {
+		t.Fatal(err)
+	}
+	if sha, err := GetGitCommit(spider); err != nil || sha != first {
+		t.Fatalf("GetGitCommit = %s, %v, want %s", sha, err, first)
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(spider.Src, "main.py")); string(data) != "print(1)" {
+		t.Fatalf("main.py = %s", data)
+	}
+}
+
+func TestPullGitRepoRejectsOptions(t *testing.T) {
+	root, err := ioutil.TempDir("", "crawlab-git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	bare, _ := newTestGitRepo(t, root)
+	gitUrl, closeServer := newTestGitServer(t, root, bare, "", "")
+	defer closeServer()
+	marker := filepath.Join(root, "pwned")
+	src := filepath.Join(root, "src")
+
+	spiders := []model.Spider{
+		{GitUrl: "--upload-pack=touch " + marker, GitBranch: "master", Src: src},
+		{GitUrl: "--upload-pack=touch", GitBranch: "master", Src: src},
+		{GitUrl: gitUrl, GitBranch: "--upload-pack=touch", Src: src},
+		{GitUrl: "ext::sh -c touch% " + marker, Src: src},
+		{GitUrl: "ext::true", Src: src},
+		// 本地仓库不允许
+		{GitUrl: bare, GitBranch: "master", Src: src},
+		{GitUrl: "file://" + bare, GitBranch: "master", Src: src},
+	}
+	for _, spider := range spiders {
+		if err := ValidateGitSpider(spider); err == nil {
+			t.Errorf("ValidateGitSpider(%s, %s) expected error", spider.GitUrl, spider.GitBranch)
+		}
+		if _, err := PullGitRepo(spider); err == nil {
+			t.Errorf("PullGitRepo(%s, %s) expected error", spider.GitUrl, spider.GitBranch)
+		}
+	}
+
+	spider := model.Spider{GitUrl: gitUrl, GitBranch: "master", Src: src}
+	if err := ValidateGitSpider(spider); err != nil {
+		t.Fatal(err)
+	}
+	for _, sha := range []string{"--upload-pack=touch", "HEAD", "abc def"} {
+		if err := CheckoutGitCommit(spider, sha); err == nil {
+			t.Errorf("CheckoutGitCommit(%s) expected error", sha)
+		}
+	}
+
+	if _, err := os.Stat(marker); err == nil {
+		t.Fatal("git option was executed")
+	}
+}
+
+func TestValidateGitUrl(t *testing.T) {
+	for _, gitUrl := range []string{
+		"https://github.com/crawlab-team/crawlab.git",
+		"https://user@github.com/crawlab-team/crawlab.git",
+		"ssh://git@github.com/crawlab-team/crawlab.git",
+		"ssh://git@github.com:2222/crawlab-team/crawlab.git",
+		"git://github.com/crawlab-team/crawlab.git",
+		"git@github.com:crawlab-team/crawlab.git",
+		"git@github.com:/srv/crawlab.git",
+	} {
+		if err := validateGitUrl(gitUrl); err != nil {
+			t.Errorf("validateGitUrl(%s) unexpected error: %s", gitUrl, err)
+		}
+	}
+	for _, gitUrl := range []string{
+		"/tmp/repo.git",
+		"./repo",
+		"../repo",
+		"repo",
+		"C:\\repo",
+		"file:///tmp/repo.git",
+		"http://github.com/crawlab-team/crawlab.git",
+		"ext::sh -c touch% /tmp/pwned",
+		"fd::17",
+		"https:///crawlab.git",
+		"github.com:crawlab-team/crawlab.git",
+		"git@-oProxyCommand=x:repo",
+		"git@host::x",
+	} {
+		if err := validateGitUrl(gitUrl); err == nil {
+			t.Errorf("validateGitUrl(%s) expected error", gitUrl)
+		}
+	}
+}
+
+func TestPullGitRepoWithPassword(t *testing.T) {
+	root, err := ioutil.TempDir("", "crawlab-git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	bare, work := newTestGitRepo(t, root)
+	first := runTestGit(t, work, "rev-parse", "HEAD")
+	gitUrl, closeServer := newTestGitServer(t, root, bare, "crawlab", "s3cret")
+	defer closeServer()
+
+	// 密码错误
+	spider := model.Spider{
+		GitUrl:      gitUrl,
+		GitBranch:   "master",
+		GitUsername: "crawlab",
+		GitPassword: "wrong",
+		Src:         filepath.Join(root, "src"),
+	}
+	if _, err := PullGitRepo(spider); err == nil {
+		t.Fatal("expected error for wrong password")
+	}
+
+	// 通过凭据助手认证，密码不写入本地仓库配置
+	spider.GitPassword = "s3cret"
+	sha, err := PullGitRepo(spider)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sha != first {
+		t.Fatalf("PullGitRepo = %s, want %s", sha, first)
+	}
+	config, err := ioutil.ReadFile(filepath.Join(spider.Src, ".git", "config"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(config), "s3cret") {
+		t.Fatal("password is written to the repository config")
+	}
+	if args, _ := getGitAuth(spider); strings.Contains(strings.Join(args, " "), "s3cret") {
+		t.Fatal("password is passed as a command line argument")
+	}
+}
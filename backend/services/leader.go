@@ -0,0 +1,174 @@
+package services
+
+import (
+	"crawlab/database"
+	"crawlab/lib/cron"
+	"github.com/apex/log"
+	uuid "github.com/satori/go.uuid"
+	"github.com/spf13/viper"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// Redis中的Leader租约key
+const LeaderKey = "nodes:leader"
+
+// 默认租约时长（毫秒）
+const DefaultLeaderLease = 15000
+
+var Lead *Leader
+
+// 租约存储（Redis）
+type LeaseStore interface {
+	// 租约不存在时获取租约
+	SetNxPx(key string, value string, ms int) (bool, error)
+	// 租约持有者为value时续约
+	PExpireIfEqual(key string, value string, ms int) (bool, error)
+	// 租约持有者为value时释放租约
+	DelIfEqual(key string, value string) (bool, error)
+}
+
+// 当选、失去Leader身份时启动、停止调度器，当选后补跑错过的定时任务（测试时可替换）
+var startScheduler = func() error { return Sched.Start() }
+var stopScheduler = func() { Sched.Stop() }
+var replayMisfires = HandleMisfires
+
+// 主节点选举
+// 多个主节点同时运行时，只有持有Redis租约的主节点（Leader）执行定时任务以及
+// 节点状态、爬虫更新、爬虫发布等定时作业，所有主节点均提供HTTP API服务
+type Leader struct {
+	Id    string
+	Lease int
+
+	store    LeaseStore
+	cron     *cron.Cron
+	isLeader bool
+	term     int // 任期，每次当选加1
+	mu       sync.Mutex
+	electMu  sync.Mutex
+}
+
+// 当前主节点是否为Leader
+func IsLeader() bool {
+	if Lead == nil {
+		return false
+	}
+	Lead.mu.Lock()
+	defer Lead.mu.Unlock()
+	return Lead.isLeader
+}
+
+// 包装仅由Leader执行的定时作业
+func LeaderJob(job func()) func() {
+	return func() {
+		if !IsLeader() {
+			return
+		}
+		job()
+	}
+}
+
+// 是否仍为指定任期的Leader，失去Leader身份后再次当选时任期不同
+func (l *Leader) isLeaderOf(term int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.isLeader && l.term == term
+}
+
+// 获取或续约租约
+// electMu保证选举串行执行；mu只保护isLeader及任期，启动调度器时不持有mu，避免阻塞IsLeader
+// 补跑错过的定时任务耗时较长，在选举之外异步执行，每次补跑前检查是否仍为本任期的Leader
+func (l *Leader) Elect() {
+	l.electMu.Lock()
+	defer l.electMu.Unlock()
+
+	l.mu.Lock()
+	wasLeader := l.isLeader
+	l.mu.Unlock()
+
+	var ok bool
+	var err error
+	if wasLeader {
+		// 续约
+		ok, err = l.store.PExpireIfEqual(LeaderKey, l.Id, l.Lease)
+	} else {
+		// 尝试获取租约
+		ok, err = l.store.SetNxPx(LeaderKey, l.Id, l.Lease)
+	}
+	if err != nil {
+		log.Errorf("leader election error: " + err.Error())
+		debug.PrintStack()
+
+		// 无法确认租约是否仍然有效，为避免重复调度，放弃Leader身份
+		ok = false
+	}
+
+	// 更新Leader身份
+	l.mu.Lock()
+	l.isLeader = ok
+	if ok && !wasLeader {
+		l.term++
+	}
+	term := l.term
+	l.mu.Unlock()
+
+	if ok && !wasLeader {
+		// 当选Leader
+		log.Infof("node %s is elected as leader", l.Id)
+		if err := startScheduler(); err != nil {
+			log.Errorf("start scheduler error: " + err.Error())
+			debug.PrintStack()
+			stopScheduler()
+			l.mu.Lock()
+			l.isLeader = false
+			l.mu.Unlock()
+			_, _ = l.store.DelIfEqual(LeaderKey, l.Id)
+			return
+		}
+
+		// 补跑错过的定时任务
+		go func() {
+			if err := replayMisfires(func() bool { return l.isLeaderOf(term) }); err != nil {
+				log.Errorf("handle misfires error: " + err.Error())
+				debug.PrintStack()
+			}
+		}()
+	} else if !ok && wasLeader {
+		// 失去Leader身份
+		log.Infof("node %s lost leadership", l.Id)
+		stopScheduler()
+	}
+}
+
+// 初始化主节点选举服务
+func InitLeaderService() error {
+	// 租约时长
+	lease := viper.GetInt("server.leaderLease")
+	if lease <= 0 {
+		lease = DefaultLeaderLease
+	}
+
+	// 每个主节点实例的唯一标识
+	mac, err := GetMac()
+	if err != nil {
+		return err
+	}
+
+	Lead = &Leader{
+		Id:    mac + ":" + uuid.NewV4().String(),
+		Lease: lease,
+		store: &database.RedisClient,
+		cron:  cron.New(cron.WithSeconds()),
+	}
+
+	// 首次选举
+	Lead.Elect()
+
+	// 每1/3个租约时长续约一次
+	interval := time.Duration(lease) * time.Millisecond / 3
+	Lead.cron.Schedule(cron.Every(interval), cron.FuncJob(Lead.Elect))
+	Lead.cron.Start()
+
+	return nil
+}
@@ -0,0 +1,184 @@
+package services
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 内存中的租约存储（测试用），err不为空时所有操作返回该错误
+type testLeaseStore struct {
+	mu     sync.Mutex
+	holder string
+	err    error
+}
+
+func (s *testLeaseStore) SetNxPx(key string, value string, ms int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return false, s.err
+	}
+	if s.holder != "" {
+		return false, nil
+	}
+	s.holder = value
+	return true, nil
+}
+
+func (s *testLeaseStore) PExpireIfEqual(key string, value string, ms int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return false, s.err
+	}
+	return s.holder == value, nil
+}
+
+func (s *testLeaseStore) DelIfEqual(key string, value string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return false, s.err
+	}
+	if s.holder != value {
+		return false, nil
+	}
+	s.holder = ""
+	return true, nil
+}
+
+func (s *testLeaseStore) setHolder(holder string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.holder = holder
+}
+
+// 替换调度器的启动、停止及补跑，记录调用次数，补跑时将Leader检查函数发送到replays
+type testScheduler struct {
+	starts   int
+	stops    int
+	startErr error
+	replays  chan func() bool
+}
+
+func fakeScheduler() (*testScheduler, func()) {
+	oldStart, oldStop, oldReplay := startScheduler, stopScheduler, replayMisfires
+	sched := &testScheduler{replays: make(chan func() bool, 10)}
+	startScheduler = func() error {
+		sched.starts++
+		return sched.startErr
+	}
+	stopScheduler = func() {
+		sched.stops++
+	}
+	replayMisfires = func(isLeader func() bool) error {
+		sched.replays <- isLeader
+		return nil
+	}
+	return sched, func() {
+		startScheduler, stopScheduler, replayMisfires = oldStart, oldStop, oldReplay
+	}
+}
+
+func (s *testScheduler) waitReplay(t *testing.T) func() bool {
+	select {
+	case isLeader := <-s.replays:
+		return isLeader
+	case <-time.After(time.Second):
+		t.Fatal("misfires are not replayed")
+		return nil
+	}
+}
+
+func TestLeaderElectAndRenew(t *testing.T) {
+	sched, restore := fakeScheduler()
+	defer restore()
+
+	store := &testLeaseStore{}
+	l := &Leader{Id: "a", Lease: 1000, store: store}
+
+	// 当选：启动调度器并在选举之外补跑
+	l.Elect()
+	if !l.isLeaderOf(1) || sched.starts != 1 {
+		t.Fatalf("isLeader = %v, starts = %d", l.isLeader, sched.starts)
+	}
+	isLeader := sched.waitReplay(t)
+	if !isLeader() {
+		t.Fatal("replay check = false, want true")
+	}
+
+	// 续约：不重复启动调度器及补跑
+	l.Elect()
+	l.Elect()
+	if !l.isLeaderOf(1) || sched.starts != 1 || sched.stops != 0 {
+		t.Fatalf("isLeader = %v, starts = %d, stops = %d", l.isLeader, sched.starts, sched.stops)
+	}
+	if len(sched.replays) != 0 {
+		t.Fatal("misfires replayed again on renew")
+	}
+
+	// 其他节点不能获取租约
+	other := &Leader{Id: "b", Lease: 1000, store: store}
+	other.Elect()
+	if other.isLeader || sched.starts != 1 {
+		t.Fatalf("other isLeader = %v, starts = %d", other.isLeader, sched.starts)
+	}
+}
+
+func TestLeaderLoseLeadership(t *testing.T) {
+	sched, restore := fakeScheduler()
+	defer restore()
+
+	store := &testLeaseStore{}
+	l := &Leader{Id: "a", Lease: 1000, store: store}
+	l.Elect()
+	firstTerm := sched.waitReplay(t)
+
+	// 租约被其他节点持有（如租约过期后被抢占）：续约失败，停止调度器，停止补跑
+	store.setHolder("b")
+	l.Elect()
+	if l.isLeader || sched.stops != 1 {
+		t.Fatalf("isLeader = %v, stops = %d", l.isLeader, sched.stops)
+	}
+	if firstTerm() {
+		t.Fatal("replay check = true after losing leadership")
+	}
+
+	// 租约释放后再次当选：新任期重新补跑，上一任期的补跑不再继续
+	store.setHolder("")
+	l.Elect()
+	if !l.isLeader || sched.starts != 2 {
+		t.Fatalf("isLeader = %v, starts = %d", l.isLeader, sched.starts)
+	}
+	secondTerm := sched.waitReplay(t)
+	if firstTerm() || !secondTerm() {
+		t.Fatalf("replay checks = %v, %v, want false, true", firstTerm(), secondTerm())
+	}
+
+	// Redis出错时无法确认租约，放弃Leader身份
+	store.err = errors.New("connection refused")
+	l.Elect()
+	if l.isLeader || sched.stops != 2 || secondTerm() {
+		t.Fatalf("isLeader = %v, stops = %d", l.isLeader, sched.stops)
+	}
+}
+
+func TestLeaderStartSchedulerError(t *testing.T) {
+	sched, restore := fakeScheduler()
+	defer restore()
+	sched.startErr = errors.New("start error")
+
+	store := &testLeaseStore{}
+	l := &Leader{Id: "a", Lease: 1000, store: store}
+	l.Elect()
+
+	// 启动失败时释放租约，不补跑
+	if l.isLeader || store.holder != "" || sched.stops != 1 {
+		t.Fatalf("isLeader = %v, holder = %s, stops = %d", l.isLeader, store.holder, sched.stops)
+	}
+	if len(sched.replays) != 0 {
+		t.Fatal("misfires replayed after start error")
+	}
+}
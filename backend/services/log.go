@@ -40,6 +40,10 @@ func GetRemoteLog(task model.Task) (logStr string, err error) {
 		return "", err
 	}
 
+	// 生成频道，等待获取log（须在发布消息前生成，其他主节点据此忽略该响应）
+	ch := TaskLogChanMap.ChanBlocked(task.Id)
+	defer TaskLogChanMap.Remove(task.Id)
+
 	// 发布获取日志消息
 	channel := "nodes:" + task.NodeId.Hex()
 	if err := database.Publish(channel, string(msgBytes)); err != nil {
@@ -47,9 +51,6 @@ func GetRemoteLog(task model.Task) (logStr string, err error) {
 		return "", err
 	}
 
-	// 生成频道，等待获取log
-	ch := TaskLogChanMap.ChanBlocked(task.Id)
-
 	// 此处阻塞，等待结果
 	logStr = <-ch
 
@@ -40,6 +40,13 @@ type NodeMessage struct {
 	// 系统信息
 	SysInfo model.SystemInfo `json:"sys_info"`
 
+	// 日志清理
+	ReportId string `json:"report_id"` // 清理报告ID
+	LogDays  int    `json:"log_days"`  // 日志文件保留天数
+	DryRun   bool   `json:"dry_run"`   // 只统计不删除
+	LogFiles int    `json:"log_files"` // 删除的日志文件数量
+	LogBytes int64  `json:"log_bytes"` // 删除的日志文件大小
+
 	// 错误相关
 	Error string `json:"error"`
 }
@@ -316,16 +323,33 @@ func MasterNodeCallback(channel string, msgStr string) {
 	if msg.Type == constants.MsgTypeGetLog {
 		// 获取日志
 		fmt.Println(msg)
+
+		// 该请求不是由本主节点发出，忽略
+		if !TaskLogChanMap.HasChanKey(msg.TaskId) {
+			return
+		}
+
 		time.Sleep(10 * time.Millisecond)
 		ch := TaskLogChanMap.ChanBlocked(msg.TaskId)
 		ch <- msg.Log
 	} else if msg.Type == constants.MsgTypeGetSystemInfo {
 		// 获取系统信息
 		fmt.Println(msg)
+
+		// 该请求不是由本主节点发出，忽略
+		if !SystemInfoChanMap.HasChanKey(msg.NodeId) {
+			return
+		}
+
 		time.Sleep(10 * time.Millisecond)
 		ch := SystemInfoChanMap.ChanBlocked(msg.NodeId)
 		sysInfoBytes, _ := json.Marshal(&msg.SysInfo)
 		ch <- string(sysInfoBytes)
+	} else if msg.Type == constants.MsgTypeCleanLogs {
+		// 记录节点的日志清理情况
+		if err := SaveCleanLogsReport(msg); err != nil {
+			log.Errorf(err.Error())
+		}
 	}
 }
 
@@ -398,6 +422,30 @@ func WorkerNodeCallback(channel string, msgStr string) {
 			log.Errorf(err.Error())
 			return
 		}
+	} else if msg.Type == constants.MsgTypeCleanLogs {
+		// 清理本节点的日志文件，并将清理情况回报给主节点
+		msgSd := NodeMessage{
+			Type:     constants.MsgTypeCleanLogs,
+			NodeId:   msg.NodeId,
+			ReportId: msg.ReportId,
+		}
+		files, size, err := CleanLocalLogs(msg.LogDays, msg.DryRun)
+		if err != nil {
+			log.Errorf(err.Error())
+			msgSd.Error = err.Error()
+		}
+		msgSd.LogFiles = files
+		msgSd.LogBytes = size
+		msgSdBytes, err := json.Marshal(&msgSd)
+		if err != nil {
+			log.Errorf(err.Error())
+			debug.PrintStack()
+			return
+		}
+		if err := database.Publish("nodes:master", string(msgSdBytes)); err != nil {
+			log.Errorf(err.Error())
+			return
+		}
 	}
 }
 
@@ -431,16 +479,16 @@ func InitNodeService() error {
 		// 如果为主节点，订阅主节点通信频道
 		channel := "nodes:master"
 		sub.Subscribe(channel, MasterNodeCallback)
-	} else {
-		// 若为工作节点，订阅单独指定通信频道
-		channel := "nodes:" + node.Id.Hex()
-		sub.Subscribe(channel, WorkerNodeCallback)
 	}
 
-	// 如果为主节点，每30秒刷新所有节点信息
+	// 订阅单独指定通信频道（存在多个主节点时，主节点之间也通过该频道获取日志、取消任务）
+	channel := "nodes:" + node.Id.Hex()
+	sub.Subscribe(channel, WorkerNodeCallback)
+
+	// 如果为主节点，每30秒刷新所有节点信息（仅由Leader执行）
 	if IsMaster() {
 		spec := "*/10 * * * * *"
-		if _, err := c.AddFunc(spec, UpdateNodeStatus); err != nil {
+		if _, err := c.AddFunc(spec, LeaderJob(UpdateNodeStatus)); err != nil {
 			debug.PrintStack()
 			return err
 		}
@@ -0,0 +1,521 @@
+package services
+
+import (
+	"bytes"
+	"crawlab/constants"
+	"crawlab/model"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/apex/log"
+	"github.com/globalsign/mgo/bson"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// 默认标题模板
+const defaultNotificationTitle = `[Crawlab] {{.Spider.DisplayName}} task {{.Event}}`
+
+// 默认内容模板
+const defaultNotificationTemplate = `Spider: {{.Spider.DisplayName}}
+Task: {{.Task.Id}}
+Status: {{.Task.Status}}
+Node: {{.Task.NodeName}}
+{{- if .Task.Target}}
+Target: {{.Task.Target}}
+{{- end}}
+{{- if .Task.Param}}
+Param: {{.Task.Param}}
+{{- end}}
+{{- if .Schedule.Name}}
+Schedule: {{.Schedule.Name}}
+{{- end}}
+Start: {{datetime .Task.StartTs}}
+{{- if not .Task.FinishTs.IsZero}}
+Finish: {{datetime .Task.FinishTs}}
+Duration: {{printf "%.1f" .Task.Duration}}s
+{{- end}}
+Results: {{.Task.ResultCount}}
+{{- if .Task.Error}}
+Error: {{.Task.Error}}
+{{- end}}`
+
+var notificationEvents = map[string]bool{
+	constants.NotifyEventStarted:     true,
+	constants.NotifyEventFinished:    true,
+	constants.NotifyEventError:       true,
+	constants.NotifyEventCancelled:   true,
+	constants.NotifyEventZeroResults: true,
+}
+
+// 模板函数
+var notificationFuncs = template.FuncMap{
+	// 格式化时间，零值为空字符串
+	"datetime": func(ts time.Time) string {
+		if ts.IsZero() {
+			return ""
+		}
+		return ts.Format("2006-01-02 15:04:05")
+	},
+}
+
+var notificationClient = &http.Client{Timeout: 10 * time.Second}
+
+// 模板中可用的任务字段
+type NotificationTask struct {
+	Id          string    `json:"_id"`
+	Status      string    `json:"status"`
+	Error       string    `json:"error"`
+	Param       string    `json:"param"`
+	Target      string    `json:"target"`
+	NodeName    string    `json:"node_name"`
+	ResultCount int       `json:"result_count"`
+	CreateTs    time.Time `json:"create_ts"`
+	StartTs     time.Time `json:"start_ts"`
+	FinishTs    time.Time `json:"finish_ts"`
+	Duration    float64   `json:"duration"` // 运行时长（秒）
+}
+
+// 模板中可用的爬虫字段
+type NotificationSpider struct {
+	Id          string `json:"_id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+}
+
+// 模板中可用的定时任务字段
+type NotificationSchedule struct {
+	Id   string `json:"_id"`
+	Name string `json:"name"`
+}
+
+// 通知模板数据（只包含展示所需的字段，避免模板读取密码等敏感信息）
+type NotificationData struct {
+	Event    string
+	Task     NotificationTask
+	Spider   NotificationSpider
+	Schedule NotificationSchedule
+}
+
+// 校验通知渠道
+func ValidateNotificationChannel(ch model.NotificationChannel) error {
+	switch ch.Type {
+	case constants.ChannelTypeEmail:
+		if ch.SmtpHost == "" || ch.SmtpPort <= 0 {
+			return errors.New("email channel requires smtp_host and smtp_port")
+		}
+		if ch.SmtpFrom == "" && ch.SmtpUsername == "" {
+			return errors.New("email channel requires smtp_from or smtp_username")
+		}
+		if len(ch.To) == 0 {
+			return errors.New("email channel requires recipients")
+		}
+		for _, addr := range append([]string{getEmailFrom(ch)}, ch.To...) {
+			if _, err := mail.ParseAddress(addr); err != nil {
+				return errors.New("invalid email address: " + addr)
+			}
+		}
+		return nil
+	case constants.ChannelTypeWebhook, constants.ChannelTypeDingTalk, constants.ChannelTypeWeCom, constants.ChannelTypeSlack:
+		u, err := url.Parse(ch.Url)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.New("invalid webhook url: " + ch.Url)
+		}
+		return nil
+	default:
+		return errors.New("invalid channel type: " + ch.Type)
+	}
+}
+
+// 校验通知规则
+func ValidateNotificationRule(rule model.NotificationRule) error {
+	if rule.SpiderId == "" && rule.ScheduleId == "" {
+		return errors.New("spider_id or schedule_id is required")
+	}
+	if len(rule.Events) == 0 {
+		return errors.New("events are required")
+	}
+	for _, event := range rule.Events {
+		if !notificationEvents[event] {
+			return errors.New("invalid event: " + event)
+		}
+	}
+	if len(rule.ChannelIds) == 0 {
+		return errors.New("channel_ids are required")
+	}
+	for _, id := range rule.ChannelIds {
+		if _, err := model.GetNotificationChannel(id); err != nil {
+			return errors.New("channel not found: " + id.Hex())
+		}
+	}
+	if _, _, err := parseNotificationTemplates(rule); err != nil {
+		return err
+	}
+	return nil
+}
+
+// 解析标题及内容模板，为空时使用默认模板
+func parseNotificationTemplates(rule model.NotificationRule) (title *template.Template, content *template.Template, err error) {
+	titleStr := rule.Title
+	if titleStr == "" {
+		titleStr = defaultNotificationTitle
+	}
+	contentStr := rule.Template
+	if contentStr == "" {
+		contentStr = defaultNotificationTemplate
+	}
+	if title, err = template.New("title").Funcs(notificationFuncs).Parse(titleStr); err != nil {
+		return nil, nil, err
+	}
+	if content, err = template.New("content").Funcs(notificationFuncs).Parse(contentStr); err != nil {
+		return nil, nil, err
+	}
+	return title, content, nil
+}
+
+// 按通知规则的模板生成标题及内容
+func RenderNotification(rule model.NotificationRule, data NotificationData) (title string, content string, err error) {
+	titleTpl, contentTpl, err := parseNotificationTemplates(rule)
+	if err != nil {
+		return "", "", err
+	}
+	var buf bytes.Buffer
+	if err := titleTpl.Execute(&buf, data); err != nil {
+		return "", "", err
+	}
+	// 标题用作邮件主题，不能换行
+	title = strings.Join(strings.Fields(buf.String()), " ")
+
+	buf.Reset()
+	if err := contentTpl.Execute(&buf, data); err != nil {
+		return "", "", err
+	}
+	return title, buf.String(), nil
+}
+
+// 生成任务的通知模板数据
+func GetNotificationData(t model.Task, spider model.Spider, event string) NotificationData {
+	data := NotificationData{
+		Event: event,
+		Task: NotificationTask{
+			Id:          t.Id,
+			Status:      t.Status,
+			Error:       t.Error,
+			Param:       t.Param,
+			Target:      t.Target,
+			ResultCount: t.ResultCount,
+			CreateTs:    t.CreateTs,
+			StartTs:     t.StartTs,
+			FinishTs:    t.FinishTs,
+		},
+		Spider: NotificationSpider{
+			Id:          spider.Id.Hex(),
+			Name:        spider.Name,
+			DisplayName: spider.DisplayName,
+		},
+	}
+	if data.Spider.DisplayName == "" {
+		data.Spider.DisplayName = spider.Name
+	}
+	if !t.StartTs.IsZero() && !t.FinishTs.IsZero() {
+		data.Task.Duration = t.FinishTs.Sub(t.StartTs).Seconds()
+	}
+
+	// 节点名称
+	if t.NodeId != "" {
+		if node, err := model.GetNode(t.NodeId); err == nil {
+			data.Task.NodeName = node.Name
+		}
+	}
+
+	// 定时任务名称
+	if t.ScheduleId != "" {
+		data.Schedule.Id = t.ScheduleId.Hex()
+		if schedule, err := model.GetSchedule(t.ScheduleId); err == nil {
+			data.Schedule.Name = schedule.Name
+		}
+	}
+
+	return data
+}
+
+// 发送任务事件通知（异步执行，发送失败时记录日志）
+func NotifyTaskEvent(t model.Task, event string) {
+	go func() {
+		if err := notifyTaskEvent(t, event); err != nil {
+			log.Errorf("notify task %s %s error: %s", t.Id, event, err.Error())
+		}
+	}()
+}
+
+func notifyTaskEvent(t model.Task, event string) error {
+	rules, err := model.GetTaskNotificationRules(t, event)
+	if err != nil {
+		return err
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+
+	spider, err := model.GetSpider(t.SpiderId)
+	if err != nil {
+		return err
+	}
+	data := GetNotificationData(t, spider, event)
+
+	for _, rule := range rules {
+		title, content, err := RenderNotification(rule, data)
+		if err != nil {
+			log.Errorf("render notification rule %s error: %s", rule.Name, err.Error())
+			continue
+		}
+		for _, id := range rule.ChannelIds {
+			ch, err := model.GetNotificationChannel(id)
+			if err != nil {
+				log.Errorf("get notification channel %s error: %s", id.Hex(), err.Error())
+				continue
+			}
+			if err := SendNotification(ch, title, content, data); err != nil {
+				log.Errorf("send notification to channel %s error: %s", ch.Name, err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+// 通过渠道发送通知
+func SendNotification(ch model.NotificationChannel, title string, content string, data NotificationData) error {
+	switch ch.Type {
+	case constants.ChannelTypeEmail:
+		return sendNotificationEmail(ch, title, content)
+	case constants.ChannelTypeWebhook:
+		return postNotification(ch.Url, ch.Headers, map[string]interface{}{
+			"event":    data.Event,
+			"title":    title,
+			"content":  content,
+			"task":     data.Task,
+			"spider":   data.Spider,
+			"schedule": data.Schedule,
+		}, false)
+	case constants.ChannelTypeDingTalk:
+		u, err := signDingTalkUrl(ch.Url, ch.Secret, time.Now())
+		if err != nil {
+			return err
+		}
+		return postNotification(u, nil, map[string]interface{}{
+			"msgtype": "text",
+			"text":    map[string]string{"content": title + "\n" + content},
+		}, true)
+	case constants.ChannelTypeWeCom:
+		return postNotification(ch.Url, nil, map[string]interface{}{
+			"msgtype": "text",
+			"text":    map[string]string{"content": title + "\n" + content},
+		}, true)
+	case constants.ChannelTypeSlack:
+		return postNotification(ch.Url, nil, map[string]interface{}{
+			"text": "*" + title + "*\n" + content,
+		}, false)
+	default:
+		return errors.New("invalid channel type: " + ch.Type)
+	}
+}
+
+// 发送测试通知
+func TestNotificationChannel(ch model.NotificationChannel) error {
+	now := time.Now()
+	data := NotificationData{
+		Event: "test",
+		Task: NotificationTask{
+			Id:       "test",
+			Status:   constants.StatusFinished,
+			NodeName: "test",
+			CreateTs: now,
+			StartTs:  now,
+			FinishTs: now,
+		},
+		Spider: NotificationSpider{
+			Id:          bson.NewObjectId().Hex(),
+			Name:        "test",
+			DisplayName: "test",
+		},
+	}
+	title, content, err := RenderNotification(model.NotificationRule{}, data)
+	if err != nil {
+		return err
+	}
+	return SendNotification(ch, title, content, data)
+}
+
+// 钉钉机器人加签：在地址上附加timestamp及sign参数
+func signDingTalkUrl(rawUrl string, secret string, now time.Time) (string, error) {
+	if secret == "" {
+		return rawUrl, nil
+	}
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return "", err
+	}
+	ts := strconv.FormatInt(now.UnixNano()/int64(time.Millisecond), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "\n" + secret))
+	q := u.Query()
+	q.Set("timestamp", ts)
+	q.Set("sign", base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// 发送JSON请求，非2xx响应视为失败
+// 钉钉、企业微信机器人出错时仍返回200，需检查响应中的errcode
+func postNotification(url string, headers map[string]string, body interface{}, checkErrCode bool) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	res, err := notificationClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	resBody, _ := ioutil.ReadAll(io.LimitReader(res.Body, 4096))
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return errors.New("POST " + req.URL.Host + ": " + strconv.Itoa(res.StatusCode) + " " + string(resBody))
+	}
+
+	if checkErrCode {
+		var result struct {
+			ErrCode int    `json:"errcode"`
+			ErrMsg  string `json:"errmsg"`
+		}
+		if err := json.Unmarshal(resBody, &result); err != nil {
+			return err
+		}
+		if result.ErrCode != 0 {
+			return errors.New(strconv.Itoa(result.ErrCode) + " " + result.ErrMsg)
+		}
+	}
+	return nil
+}
+
+// 生成邮件内容
+func buildNotificationEmail(from string, to []string, title string, content string, now time.Time) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("From: " + from + "\r\n")
+	buf.WriteString("To: " + strings.Join(to, ", ") + "\r\n")
+	buf.WriteString("Subject: " + mime.BEncoding.Encode("UTF-8", title) + "\r\n")
+	buf.WriteString("Date: " + now.Format(time.RFC1123Z) + "\r\n")
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: base64\r\n")
+	buf.WriteString("\r\n")
+
+	// 正文按76字符换行
+	encoded := base64.StdEncoding.EncodeToString([]byte(content))
+	for len(encoded) > 76 {
+		buf.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	buf.WriteString(encoded + "\r\n")
+	return buf.Bytes()
+}
+
+// 通过SMTP发送邮件，465端口使用TLS连接，其余端口在服务器支持时使用STARTTLS
+func sendNotificationEmail(ch model.NotificationChannel, title string, content string) error {
+	from := getEmailFrom(ch)
+	msg := buildNotificationEmail(from, ch.To, title, content, time.Now())
+
+	addr := net.JoinHostPort(ch.SmtpHost, strconv.Itoa(ch.SmtpPort))
+	var conn net.Conn
+	var err error
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	if ch.SmtpPort == 465 {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: ch.SmtpHost})
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	_ = conn.SetDeadline(time.Now().Add(30 * time.Second))
+
+	c, err := smtp.NewClient(conn, ch.SmtpHost)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ch.SmtpPort != 465 {
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			if err := c.StartTLS(&tls.Config{ServerName: ch.SmtpHost}); err != nil {
+				return err
+			}
+		}
+	}
+	if ch.SmtpUsername != "" {
+		if err := c.Auth(smtp.PlainAuth("", ch.SmtpUsername, ch.SmtpPassword, ch.SmtpHost)); err != nil {
+			return err
+		}
+	}
+
+	// 发件人及收件人地址（可能为 "名称 <地址>" 形式）
+	fromAddr, err := mail.ParseAddress(from)
+	if err != nil {
+		return err
+	}
+	if err := c.Mail(fromAddr.Address); err != nil {
+		return err
+	}
+	for _, to := range ch.To {
+		toAddr, err := mail.ParseAddress(to)
+		if err != nil {
+			return err
+		}
+		if err := c.Rcpt(toAddr.Address); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// 发件人，为空时使用SMTP用户名
+func getEmailFrom(ch model.NotificationChannel) string {
+	if ch.SmtpFrom != "" {
+		return ch.SmtpFrom
+	}
+	return ch.SmtpUsername
+}
@@ -0,0 +1,378 @@
+package services
+
+import (
+	"bufio"
+	"crawlab/constants"
+	"crawlab/model"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 测试用通知数据
+func newTestNotificationData() NotificationData {
+	start := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+	return NotificationData{
+		Event: constants.NotifyEventFinished,
+		Task: NotificationTask{
+			Id:          "task-1",
+			Status:      constants.StatusFinished,
+			NodeName:    "master",
+			ResultCount: 42,
+			StartTs:     start,
+			FinishTs:    start.Add(90 * time.Second),
+			Duration:    90,
+		},
+		Spider: NotificationSpider{
+			Id:          "spider-1",
+			Name:        "news",
+			DisplayName: "新闻",
+		},
+	}
+}
+
+func TestRenderNotification(t *testing.T) {
+	data := newTestNotificationData()
+
+	title, content, err := RenderNotification(model.NotificationRule{}, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if title != "[Crawlab] 新闻 task finished" {
+		t.Errorf("title = %q", title)
+	}
+	for _, s := range []string{"Task: task-1", "Node: master", "Finish: 2024-01-01 08:01:30", "Duration: 90.0s", "Results: 42"} {
+		if !strings.Contains(content, s) {
+			t.Errorf("content does not contain %q:\n%s", s, content)
+		}
+	}
+	if strings.Contains(content, "Error:") || strings.Contains(content, "Schedule:") {
+		t.Errorf("content contains empty sections:\n%s", content)
+	}
+
+	// 自定义模板，标题中的换行被去掉
+	title, content, err = RenderNotification(model.NotificationRule{
+		Title:    "{{.Spider.Name}}\n{{.Event}}",
+		Template: "{{.Task.ResultCount}} results",
+	}, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if title != "news finished" || content != "42 results" {
+		t.Errorf("title = %q, content = %q", title, content)
+	}
+
+	if _, _, err := RenderNotification(model.NotificationRule{Template: "{{.Task.Password}}"}, data); err == nil {
+		t.Error("expected error for unknown field")
+	}
+}
+
+func TestValidateNotificationChannel(t *testing.T) {
+	valid := []model.NotificationChannel{
+		{Type: constants.ChannelTypeEmail, SmtpHost: "smtp.example.com", SmtpPort: 587, SmtpUsername: "bot@example.com", To: []string{"a@example.com"}},
+		{Type: constants.ChannelTypeWebhook, Url: "https://example.com/hook"},
+		{Type: constants.ChannelTypeSlack, Url: "https://hooks.slack.com/services/x"},
+	}
+	for _, ch := range valid {
+		if err := ValidateNotificationChannel(ch); err != nil {
+			t.Errorf("ValidateNotificationChannel(%s) error: %s", ch.Type, err)
+		}
+	}
+
+	invalid := []model.NotificationChannel{
+		{Type: constants.ChannelTypeEmail, SmtpHost: "smtp.example.com", SmtpPort: 587, SmtpUsername: "bot@example.com"},
+		{Type: constants.ChannelTypeEmail, SmtpHost: "smtp.example.com", SmtpPort: 587, SmtpUsername: "bot", To: []string{"a@example.com"}},
+		{Type: constants.ChannelTypeWebhook, Url: "file:///etc/passwd"},
+		{Type: constants.ChannelTypeDingTalk, Url: ""},
+		{Type: "sms"},
+	}
+	for _, ch := range invalid {
+		if err := ValidateNotificationChannel(ch); err == nil {
+			t.Errorf("ValidateNotificationChannel(%s, %s) expected error", ch.Type, ch.Url)
+		}
+	}
+}
+
+// 记录收到的通知请求
+type testNotificationRequest struct {
+	Path   string
+	Query  map[string][]string
+	Header http.Header
+	Body   map[string]interface{}
+}
+
+func newTestNotificationServer(t *testing.T, response string) (*httptest.Server, *[]testNotificationRequest) {
+	var requests []testNotificationRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		body := map[string]interface{}{}
+		if err := json.Unmarshal(data, &body); err != nil {
+			t.Errorf("invalid json body: %s", data)
+		}
+		requests = append(requests, testNotificationRequest{
+			Path:   r.URL.Path,
+			Query:  r.URL.Query(),
+			Header: r.Header,
+			Body:   body,
+		})
+		_, _ = w.Write([]byte(response))
+	}))
+	return srv, &requests
+}
+
+func TestSendNotificationWebhook(t *testing.T) {
+	srv, requests := newTestNotificationServer(t, "ok")
+	defer srv.Close()
+
+	data := newTestNotificationData()
+	ch := model.NotificationChannel{
+		Type:    constants.ChannelTypeWebhook,
+		Url:     srv.URL + "/hook",
+		Headers: map[string]string{"X-Token": "abc"},
+	}
+	if err := SendNotification(ch, "title", "content", data); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(*requests) != 1 {
+		t.Fatalf("requests = %d, want 1", len(*requests))
+	}
+	req := (*requests)[0]
+	if req.Path != "/hook" || req.Header.Get("X-Token") != "abc" || req.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("request = %s %v", req.Path, req.Header)
+	}
+	if req.Body["event"] != constants.NotifyEventFinished || req.Body["title"] != "title" || req.Body["content"] != "content" {
+		t.Fatalf("body = %v", req.Body)
+	}
+	task := req.Body["task"].(map[string]interface{})
+	spider := req.Body["spider"].(map[string]interface{})
+	if task["_id"] != "task-1" || task["result_count"] != float64(42) || spider["name"] != "news" {
+		t.Fatalf("body = %v", req.Body)
+	}
+}
+
+func TestSendNotificationDingTalk(t *testing.T) {
+	srv, requests := newTestNotificationServer(t, `{"errcode":0,"errmsg":"ok"}`)
+	defer srv.Close()
+
+	data := newTestNotificationData()
+	ch := model.NotificationChannel{
+		Type:   constants.ChannelTypeDingTalk,
+		Url:    srv.URL + "/robot/send?access_token=tok",
+		Secret: "SEC123",
+	}
+	if err := SendNotification(ch, "title", "content", data); err != nil {
+		t.Fatal(err)
+	}
+
+	req := (*requests)[0]
+	if req.Query["access_token"][0] != "tok" {
+		t.Fatalf("query = %v", req.Query)
+	}
+	ts := req.Query["timestamp"][0]
+	mac := hmac.New(sha256.New, []byte("SEC123"))
+	mac.Write([]byte(ts + "\nSEC123"))
+	if req.Query["sign"][0] != base64.StdEncoding.EncodeToString(mac.Sum(nil)) {
+		t.Fatalf("sign = %s", req.Query["sign"][0])
+	}
+	if req.Body["msgtype"] != "text" || req.Body["text"].(map[string]interface{})["content"] != "title\ncontent" {
+		t.Fatalf("body = %v", req.Body)
+	}
+}
+
+func TestSendNotificationErrCode(t *testing.T) {
+	srv, _ := newTestNotificationServer(t, `{"errcode":310000,"errmsg":"sign not match"}`)
+	defer srv.Close()
+
+	data := newTestNotificationData()
+	for _, typ := range []string{constants.ChannelTypeDingTalk, constants.ChannelTypeWeCom} {
+		ch := model.NotificationChannel{Type: typ, Url: srv.URL}
+		if err := SendNotification(ch, "title", "content", data); err == nil || !strings.Contains(err.Error(), "sign not match") {
+			t.Errorf("SendNotification(%s) error = %v", typ, err)
+		}
+	}
+}
+
+func TestSendNotificationSlack(t *testing.T) {
+	srv, requests := newTestNotificationServer(t, "ok")
+	defer srv.Close()
+
+	ch := model.NotificationChannel{Type: constants.ChannelTypeSlack, Url: srv.URL}
+	if err := SendNotification(ch, "title", "content", newTestNotificationData()); err != nil {
+		t.Fatal(err)
+	}
+	if (*requests)[0].Body["text"] != "*title*\ncontent" {
+		t.Fatalf("body = %v", (*requests)[0].Body)
+	}
+}
+
+// 本地SMTP服务（测试用），记录认证信息、发件人、收件人及邮件内容
+type testSmtpServer struct {
+	ln   net.Listener
+	auth string
+	from string
+	to   []string
+	data string
+	done chan error
+}
+
+func newTestSmtpServer(t *testing.T) *testSmtpServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testSmtpServer{ln: ln, done: make(chan error, 1)}
+	go func() {
+		s.done <- s.serve()
+	}()
+	return s
+}
+
+func (s *testSmtpServer) port() int {
+	return s.ln.Addr().(*net.TCPAddr).Port
+}
+
+func (s *testSmtpServer) serve() error {
+	conn, err := s.ln.Accept()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	r := bufio.NewReader(conn)
+	reply := func(line string) {
+		_, _ = conn.Write([]byte(line + "\r\n"))
+	}
+	reply("220 localhost ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch cmd {
+		case "EHLO", "HELO":
+			reply("250-localhost")
+			reply("250 AUTH PLAIN")
+		case "AUTH":
+			s.auth = line
+			reply("235 2.7.0 Authentication successful")
+		case "MAIL":
+			s.from = line
+			reply("250 OK")
+		case "RCPT":
+			s.to = append(s.to, line)
+			reply("250 OK")
+		case "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var buf strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return err
+				}
+				if l == ".\r\n" {
+					break
+				}
+				buf.WriteString(l)
+			}
+			s.data = buf.String()
+			reply("250 OK")
+		case "QUIT":
+			reply("221 Bye")
+			return nil
+		default:
+			reply("502 Command not implemented")
+		}
+	}
+}
+
+func TestSendNotificationEmail(t *testing.T) {
+	s := newTestSmtpServer(t)
+	defer s.ln.Close()
+
+	ch := model.NotificationChannel{
+		Type:         constants.ChannelTypeEmail,
+		SmtpHost:     "127.0.0.1",
+		SmtpPort:     s.port(),
+		SmtpUsername: "bot@example.com",
+		SmtpPassword: "secret",
+		SmtpFrom:     "Crawlab <noreply@example.com>",
+		To:           []string{"a@example.com", "Ops <ops@example.com>"},
+	}
+	if err := SendNotification(ch, "[Crawlab] 新闻 task finished", "Results: 42", newTestNotificationData()); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-s.done; err != nil {
+		t.Fatal(err)
+	}
+
+	// 认证
+	auth := strings.Fields(s.auth)
+	if len(auth) != 3 || auth[1] != "PLAIN" {
+		t.Fatalf("auth = %q", s.auth)
+	}
+	if cred, _ := base64.StdEncoding.DecodeString(auth[2]); string(cred) != "\x00bot@example.com\x00secret" {
+		t.Fatalf("auth credentials = %q", cred)
+	}
+
+	// 发件人、收件人
+	if s.from != "MAIL FROM:<noreply@example.com>" && !strings.HasPrefix(s.from, "MAIL FROM:<noreply@example.com> ") {
+		t.Fatalf("from = %q", s.from)
+	}
+	if len(s.to) != 2 || s.to[0] != "RCPT TO:<a@example.com>" || s.to[1] != "RCPT TO:<ops@example.com>" {
+		t.Fatalf("to = %q", s.to)
+	}
+
+	// 邮件内容
+	msg, err := mail.ReadMessage(strings.NewReader(s.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil || subject != "[Crawlab] 新闻 task finished" {
+		t.Fatalf("subject = %q, %v", subject, err)
+	}
+	if msg.Header.Get("To") != "a@example.com, Ops <ops@example.com>" {
+		t.Fatalf("to header = %q", msg.Header.Get("To"))
+	}
+	body, _ := ioutil.ReadAll(msg.Body)
+	decoded, err := base64.StdEncoding.DecodeString(strings.Replace(string(body), "\r\n", "", -1))
+	if err != nil || string(decoded) != "Results: 42" {
+		t.Fatalf("body = %q, %v", decoded, err)
+	}
+}
+
+func TestSendNotificationEmailRefused(t *testing.T) {
+	// 连接失败时返回错误
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	_ = ln.Close()
+
+	ch := model.NotificationChannel{
+		Type:         constants.ChannelTypeEmail,
+		SmtpHost:     "127.0.0.1",
+		SmtpPort:     port,
+		SmtpUsername: "bot@example.com",
+		To:           []string{"a@example.com"},
+	}
+	if err := SendNotification(ch, "title", "content", newTestNotificationData()); err == nil {
+		t.Fatal("expected error for refused connection on port " + strconv.Itoa(port))
+	}
+}
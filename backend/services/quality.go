@@ -0,0 +1,247 @@
+package services
+
+import (
+	"crawlab/constants"
+	"crawlab/model"
+	"crawlab/sinks"
+	"crawlab/utils"
+	"errors"
+	"fmt"
+	"github.com/globalsign/mgo/bson"
+	"net/url"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+const (
+	qualityDefaultSampleSize = 10000 // 默认最多校验的结果数量
+	qualityMaxExamples       = 5     // 每条规则记录的违反规则的结果ID数量
+)
+
+var emailRegex = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+
+// 校验数据质量配置
+func ValidateQualityConfig(cfg model.QualityConfig) error {
+	if cfg.MinResults < 0 {
+		return errors.New("min_results must not be negative")
+	}
+	if cfg.MaxViolationRate < 0 || cfg.MaxViolationRate > 1 {
+		return errors.New("max_violation_rate must be between 0 and 1")
+	}
+	for _, rule := range cfg.Rules {
+		if err := sinks.ValidateField(rule.Field); err != nil {
+			return err
+		}
+		switch rule.Type {
+		case constants.QualityRuleRequired:
+		case constants.QualityRuleRegex:
+			if _, err := regexp.Compile(rule.Pattern); err != nil {
+				return err
+			}
+		case constants.QualityRuleFormat:
+			switch rule.Format {
+			case constants.QualityFormatNumber, constants.QualityFormatUrl, constants.QualityFormatEmail, constants.QualityFormatDate:
+			default:
+				return errors.New("invalid format: " + rule.Format)
+			}
+		case constants.QualityRuleRange:
+			if rule.Min == nil && rule.Max == nil {
+				return errors.New("range rule requires min or max: " + rule.Field)
+			}
+		default:
+			return errors.New("invalid rule type: " + rule.Type)
+		}
+	}
+	return nil
+}
+
+// 规则说明
+func getQualityRuleMessage(rule model.QualityRule) string {
+	switch rule.Type {
+	case constants.QualityRuleRegex:
+		return rule.Field + " should match " + rule.Pattern
+	case constants.QualityRuleFormat:
+		return rule.Field + " should be " + rule.Format
+	case constants.QualityRuleRange:
+		msg := rule.Field + " should be in range ["
+		if rule.Min != nil {
+			msg += strconv.FormatFloat(*rule.Min, 'f', -1, 64)
+		}
+		msg += ", "
+		if rule.Max != nil {
+			msg += strconv.FormatFloat(*rule.Max, 'f', -1, 64)
+		}
+		return msg + "]"
+	default:
+		return rule.Field + " is required"
+	}
+}
+
+// 转换为数值
+func toNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// 是否符合格式
+func matchFormat(value interface{}, format string) bool {
+	switch format {
+	case constants.QualityFormatNumber:
+		_, ok := toNumber(value)
+		return ok
+	case constants.QualityFormatDate:
+		if _, ok := value.(time.Time); ok {
+			return true
+		}
+		str := utils.InterfaceToString(value)
+		for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"} {
+			if _, err := time.Parse(layout, str); err == nil {
+				return true
+			}
+		}
+		return false
+	case constants.QualityFormatUrl:
+		u, err := url.Parse(utils.InterfaceToString(value))
+		return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+	case constants.QualityFormatEmail:
+		return emailRegex.MatchString(utils.InterfaceToString(value))
+	}
+	return true
+}
+
+// 结果是否符合规则（非必填规则不校验空值）
+func checkQualityRule(rule model.QualityRule, re *regexp.Regexp, item map[string]interface{}) bool {
+	value := item[rule.Field]
+	if isEmptyValue(value) {
+		return rule.Type != constants.QualityRuleRequired
+	}
+
+	switch rule.Type {
+	case constants.QualityRuleRegex:
+		return re.MatchString(utils.InterfaceToString(value))
+	case constants.QualityRuleFormat:
+		return matchFormat(value, rule.Format)
+	case constants.QualityRuleRange:
+		f, ok := toNumber(value)
+		if !ok {
+			return false
+		}
+		return (rule.Min == nil || f >= *rule.Min) && (rule.Max == nil || f <= *rule.Max)
+	}
+	return true
+}
+
+// 任务结束时校验结果的数据质量
+// 返回违反的规则、校验的结果数量，以及是否应将任务标记为finished_with_warnings：
+// 结果数量少于min_results，或违反规则的结果比例超过max_violation_rate
+// max_violation_rate默认为0，即只要有一条结果违反规则，任务即标记为finished_with_warnings
+func CheckTaskQuality(t model.Task, spider model.Spider) (violations []model.QualityViolation, checked int, warn bool, err error) {
+	// 只写的结果储存无法读取结果，只校验结果数量
+	var iterable sinks.Iterable
+	if len(spider.Quality.Rules) > 0 {
+		sink, err := spider.GetSink()
+		if err != nil {
+			return []model.QualityViolation{}, checked, warn, err
+		}
+		iterable, _ = sink.(sinks.Iterable)
+	}
+	return checkTaskQuality(t, spider.Quality, iterable)
+}
+
+func checkTaskQuality(t model.Task, cfg model.QualityConfig, iterable sinks.Iterable) (violations []model.QualityViolation, checked int, warn bool, err error) {
+	violations = []model.QualityViolation{}
+
+	// 结果数量
+	if cfg.MinResults > 0 && t.ResultCount < cfg.MinResults {
+		violations = append(violations, model.QualityViolation{
+			Rule:     "min_results",
+			Message:  fmt.Sprintf("result count %d is less than %d", t.ResultCount, cfg.MinResults),
+			Count:    t.ResultCount,
+			Examples: []string{},
+		})
+		warn = true
+	}
+
+	if len(cfg.Rules) == 0 || iterable == nil {
+		return violations, checked, warn, nil
+	}
+
+	// 预编译正则表达式
+	regexes := make([]*regexp.Regexp, len(cfg.Rules))
+	ruleViolations := make([]model.QualityViolation, len(cfg.Rules))
+	for i, rule := range cfg.Rules {
+		if rule.Type == constants.QualityRuleRegex {
+			if regexes[i], err = regexp.Compile(rule.Pattern); err != nil {
+				return violations, checked, warn, err
+			}
+		}
+		ruleViolations[i] = model.QualityViolation{
+			Field:    rule.Field,
+			Rule:     rule.Type,
+			Message:  getQualityRuleMessage(rule),
+			Examples: []string{},
+		}
+	}
+
+	sampleSize := cfg.SampleSize
+	if sampleSize <= 0 {
+		sampleSize = qualityDefaultSampleSize
+	}
+
+	// 逐条校验结果
+	iter, err := iterable.Iter([]string{t.Id})
+	if err != nil {
+		return violations, checked, warn, err
+	}
+	violated := 0
+	var item bson.M
+	for checked < sampleSize && iter.Next(&item) {
+		checked++
+		flat := utils.FlattenMap(item)
+		ok := true
+		for i, rule := range cfg.Rules {
+			if checkQualityRule(rule, regexes[i], flat) {
+				continue
+			}
+			ok = false
+			ruleViolations[i].Count++
+			if len(ruleViolations[i].Examples) < qualityMaxExamples {
+				ruleViolations[i].Examples = append(ruleViolations[i].Examples, utils.InterfaceToString(item["_id"]))
+			}
+		}
+		if !ok {
+			violated++
+		}
+	}
+	if err := iter.Close(); err != nil {
+		return violations, checked, warn, err
+	}
+
+	for _, v := range ruleViolations {
+		if v.Count > 0 {
+			violations = append(violations, v)
+		}
+	}
+
+	// 违反规则的结果比例
+	if checked > 0 && float64(violated)/float64(checked) > cfg.MaxViolationRate {
+		warn = true
+	}
+
+	return violations, checked, warn, nil
+}
@@ -0,0 +1,141 @@
+package services
+
+import (
+	"crawlab/constants"
+	"crawlab/model"
+	"github.com/globalsign/mgo/bson"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func floatPtr(f float64) *float64 {
+	return &f
+}
+
+func TestValidateQualityConfig(t *testing.T) {
+	valid := []model.QualityConfig{
+		{},
+		{MinResults: 10, MaxViolationRate: 1},
+		{Rules: []model.QualityRule{
+			{Field: "title", Type: constants.QualityRuleRequired},
+			{Field: "code", Type: constants.QualityRuleRegex, Pattern: `^\d+$`},
+			{Field: "url", Type: constants.QualityRuleFormat, Format: constants.QualityFormatUrl},
+			{Field: "meta.price", Type: constants.QualityRuleRange, Min: floatPtr(0)},
+		}},
+	}
+	for _, cfg := range valid {
+		if err := ValidateQualityConfig(cfg); err != nil {
+			t.Errorf("ValidateQualityConfig(%+v) error: %s", cfg, err)
+		}
+	}
+
+	invalid := []model.QualityConfig{
+		{MinResults: -1},
+		{MaxViolationRate: -0.1},
+		{MaxViolationRate: 1.5},
+		{Rules: []model.QualityRule{{Field: "$where", Type: constants.QualityRuleRequired}}},
+		{Rules: []model.QualityRule{{Field: "", Type: constants.QualityRuleRequired}}},
+		{Rules: []model.QualityRule{{Field: "code", Type: constants.QualityRuleRegex, Pattern: "("}}},
+		{Rules: []model.QualityRule{{Field: "url", Type: constants.QualityRuleFormat, Format: "phone"}}},
+		{Rules: []model.QualityRule{{Field: "price", Type: constants.QualityRuleRange}}},
+		{Rules: []model.QualityRule{{Field: "price", Type: "unique"}}},
+	}
+	for _, cfg := range invalid {
+		if err := ValidateQualityConfig(cfg); err == nil {
+			t.Errorf("ValidateQualityConfig(%+v) expected error", cfg)
+		}
+	}
+}
+
+func TestCheckTaskQuality(t *testing.T) {
+	iterable := &fakeIterable{items: map[string][]bson.M{
+		"t1": {
+			{"_id": "1", "title": "a", "url": "https://a.com", "price": 10, "date": "2019-09-01", "email": "a@b.com", "meta": bson.M{"code": "123"}},
+			{"_id": "2", "title": "", "url": "ftp://a.com", "price": "20", "date": time.Now(), "meta": bson.M{"code": "12a"}},
+			{"_id": "3", "title": "c", "url": "a.com", "price": -1, "date": "01/09/2019", "email": "bad"},
+			{"_id": "4", "title": "d", "price": "abc"},
+		},
+	}}
+	cfg := model.QualityConfig{
+		Rules: []model.QualityRule{
+			{Field: "title", Type: constants.QualityRuleRequired},
+			{Field: "url", Type: constants.QualityRuleFormat, Format: constants.QualityFormatUrl},
+			{Field: "price", Type: constants.QualityRuleRange, Min: floatPtr(0), Max: floatPtr(100)},
+			{Field: "date", Type: constants.QualityRuleFormat, Format: constants.QualityFormatDate},
+			{Field: "email", Type: constants.QualityRuleFormat, Format: constants.QualityFormatEmail},
+			{Field: "meta.code", Type: constants.QualityRuleRegex, Pattern: `^\d+$`},
+		},
+	}
+	task := model.Task{Id: "t1", ResultCount: 4}
+
+	violations, checked, warn, err := checkTaskQuality(task, cfg, iterable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checked != 4 {
+		t.Errorf("checked = %d, want 4", checked)
+	}
+	// max_violation_rate默认为0，有结果违反规则即警告
+	if !warn {
+		t.Errorf("expected warning with default max_violation_rate")
+	}
+
+	// 空值只违反必填规则
+	want := map[string][]string{
+		"title":     {"2"},
+		"url":       {"2", "3"},
+		"price":     {"3", "4"},
+		"date":      {"3"},
+		"email":     {"3"},
+		"meta.code": {"2"},
+	}
+	got := map[string][]string{}
+	for _, v := range violations {
+		if v.Count != len(v.Examples) {
+			t.Errorf("%s count = %d, examples %v", v.Field, v.Count, v.Examples)
+		}
+		got[v.Field] = v.Examples
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("violations = %v, want %v", got, want)
+	}
+
+	// 违反规则的结果比例未超过max_violation_rate（4条中3条违反规则）
+	cfg.MaxViolationRate = 0.75
+	if _, _, warn, _ := checkTaskQuality(task, cfg, iterable); warn {
+		t.Errorf("unexpected warning with max_violation_rate 0.75")
+	}
+	cfg.MaxViolationRate = 0.7
+	if _, _, warn, _ := checkTaskQuality(task, cfg, iterable); !warn {
+		t.Errorf("expected warning with max_violation_rate 0.7")
+	}
+
+	// 最多校验sample_size条结果
+	cfg.SampleSize = 1
+	if violations, checked, warn, _ := checkTaskQuality(task, cfg, iterable); checked != 1 || len(violations) != 0 || warn {
+		t.Errorf("sample size 1 = %v, %d, %v", violations, checked, warn)
+	}
+}
+
+func TestCheckTaskQualityMinResults(t *testing.T) {
+	cfg := model.QualityConfig{MinResults: 5}
+
+	violations, checked, warn, err := checkTaskQuality(model.Task{Id: "t1", ResultCount: 3}, cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !warn || checked != 0 || len(violations) != 1 || violations[0].Rule != "min_results" || violations[0].Count != 3 {
+		t.Errorf("min results = %v, %d, %v", violations, checked, warn)
+	}
+
+	if violations, _, warn, _ := checkTaskQuality(model.Task{Id: "t1", ResultCount: 5}, cfg, nil); warn || len(violations) != 0 {
+		t.Errorf("min results reached = %v, %v", violations, warn)
+	}
+
+	// 只写的结果储存只校验结果数量
+	cfg.Rules = []model.QualityRule{{Field: "title", Type: constants.QualityRuleRequired}}
+	if violations, checked, warn, _ := checkTaskQuality(model.Task{Id: "t1", ResultCount: 5}, cfg, nil); warn || checked != 0 || len(violations) != 0 {
+		t.Errorf("write-only sink = %v, %d, %v", violations, checked, warn)
+	}
+}
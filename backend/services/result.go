@@ -0,0 +1,297 @@
+package services
+
+import (
+	"bufio"
+	"crawlab/constants"
+	"crawlab/model"
+	"crawlab/sinks"
+	"crypto/sha1"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/globalsign/mgo/bson"
+	"github.com/spf13/viper"
+	"io"
+	"strings"
+	"time"
+)
+
+var ErrTaskNotRunning = errors.New("task is not running")
+
+// 由Crawlab写入结果的字段（每个任务都不同），比较结果及推断结果结构时忽略
+var resultInternalFields = map[string]bool{
+	"_id":           true,
+	"task_id":       true,
+	"spider_id":     true,
+	"create_ts":     true,
+	"ingest_ts":     true,
+	"dedup_key":     true,
+	"first_task_id": true,
+	"last_task_id":  true,
+}
+
+// 生成任务令牌，爬虫通过该令牌调用结果写入接口
+// 令牌绑定任务的本次运行（任务ID、执行节点及开始时间），任务结束或重新运行后即失效
+// 有效期默认1小时，运行时间更长的任务需调大task.tokenExpire
+func MakeTaskToken(t model.Task) (string, error) {
+	expire := viper.GetInt64("task.tokenExpire")
+	if expire <= 0 {
+		expire = 3600
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"task_id":  t.Id,
+		"node_id":  t.NodeId.Hex(),
+		"start_ts": t.StartTs.Unix(),
+		"nbf":      time.Now().Unix(),
+		"exp":      time.Now().Unix() + expire,
+	})
+	return token.SignedString([]byte(viper.GetString("server.secret")))
+}
+
+// 校验任务令牌，令牌须与任务当前的运行一致且任务仍在运行
+func CheckTaskToken(tokenStr string, t model.Task) error {
+	tokenStr = strings.TrimPrefix(tokenStr, "Bearer ")
+
+	token, err := jwt.Parse(tokenStr, SecretFunc())
+	if err != nil {
+		return err
+	}
+	if !token.Valid {
+		return errors.New("token is invalid")
+	}
+
+	claim, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return errors.New("cannot convert claim to mapclaim")
+	}
+	if id, ok := claim["task_id"].(string); !ok || id != t.Id {
+		return errors.New("token does not match task")
+	}
+	if nodeId, ok := claim["node_id"].(string); !ok || nodeId != t.NodeId.Hex() {
+		return errors.New("token does not match task node")
+	}
+	if startTs, ok := claim["start_ts"].(float64); !ok || int64(startTs) != t.StartTs.Unix() {
+		return errors.New("token does not match task run")
+	}
+	if t.Status != constants.StatusRunning {
+		return ErrTaskNotRunning
+	}
+	return nil
+}
+
+// 结果写入接口地址（本节点HTTP服务）
+func GetTaskResultsUrl(t model.Task) string {
+	host := viper.GetString("server.host")
+	if host == "" || host == "0.0.0.0" {
+		host = "127.0.0.1"
+	}
+	return "http://" + host + ":" + viper.GetString("server.port") + "/tasks/" + t.Id + "/results"
+}
+
+// 任务的结果写入环境变量
+func GetTaskResultEnvs(t model.Task) ([]string, error) {
+	token, err := MakeTaskToken(t)
+	if err != nil {
+		return nil, err
+	}
+	return []string{
+		"CRAWLAB_TASK_TOKEN=" + token,
+		"CRAWLAB_RESULTS_URL=" + GetTaskResultsUrl(t),
+	}, nil
+}
+
+// 解析结果批次，支持JSON数组、单个JSON对象及NDJSON（每行一个JSON对象）
+func ParseResultBatch(r io.Reader) (items []bson.M, err error) {
+	br := bufio.NewReader(r)
+
+	// 跳过开头的空白，判断是否为JSON数组
+	for {
+		b, err := br.ReadByte()
+		if err == io.EOF {
+			return items, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !strings.ContainsRune(" \t\r\n", rune(b)) {
+			_ = br.UnreadByte()
+			if b == '[' {
+				err = json.NewDecoder(br).Decode(&items)
+				return items, err
+			}
+			break
+		}
+	}
+
+	// 连续的JSON对象
+	dec := json.NewDecoder(br)
+	for {
+		item := bson.M{}
+		if err := dec.Decode(&item); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// 结果写入统计
+type IngestStats struct {
+	Inserted int `json:"inserted"` // 新增
+	Updated  int `json:"updated"`  // 更新
+	Skipped  int `json:"skipped"`  // 丢弃的重复结果
+}
+
+// 结果的去重键（去重字段值的哈希），缺少去重字段时不去重
+func GetDedupKey(item bson.M, fields []string) (string, bool) {
+	var values []interface{}
+	for _, field := range fields {
+		value, ok := item[field]
+		if !ok || value == nil {
+			return "", false
+		}
+		values = append(values, value)
+	}
+	data, err := json.Marshal(values)
+	if err != nil {
+		return "", false
+	}
+	return fmt.Sprintf("%x", sha1.Sum(data)), true
+}
+
+// 写入任务结果
+// 1. 为每条结果加上task_id、spider_id及写入时间
+// 2. 爬虫配置了去重字段时，按去重键丢弃或更新重复的结果
+// 3. 通过爬虫配置的结果储存写入
+// 4. 增加任务的结果数量及新增、更新、丢弃的结果数
+func IngestTaskResults(t model.Task, items []bson.M) (stats IngestStats, err error) {
+	if t.Status != constants.StatusRunning {
+		return stats, ErrTaskNotRunning
+	}
+	if len(items) == 0 {
+		return stats, nil
+	}
+
+	spider, err := t.GetSpider()
+	if err != nil {
+		return stats, err
+	}
+	sink, err := spider.GetSink()
+	if err != nil {
+		return stats, err
+	}
+
+	ts := time.Now()
+	for _, item := range items {
+		item["task_id"] = t.Id
+		item["spider_id"] = t.SpiderId
+		item["ingest_ts"] = ts
+	}
+
+	// 不去重
+	if len(spider.DedupKeys) == 0 || spider.DedupMode == constants.DedupModeKeep {
+		if err := sink.Write(t.Id, items); err != nil {
+			return stats, err
+		}
+		stats.Inserted = len(items)
+		return stats, model.IncTaskResultCounts(t.Id, stats.Inserted, 0, 0)
+	}
+
+	// 计算去重键
+	var keys []string
+	keySet := map[string]bool{}
+	itemKeys := make([]string, len(items))
+	for i, item := range items {
+		if key, ok := GetDedupKey(item, spider.DedupKeys); ok {
+			if !keySet[key] {
+				keySet[key] = true
+				keys = append(keys, key)
+			}
+			itemKeys[i] = key
+		}
+	}
+
+	// 已存在的去重键
+	existing, err := model.GetResultKeys(spider.Id, keys)
+	if err != nil {
+		return stats, err
+	}
+
+	// 区分新增、重复的结果（同一批次内重复的结果也按重复处理）
+	var inserts, updates []bson.M
+	seen := map[string]bool{}
+	for i, item := range items {
+		key := itemKeys[i]
+		if key == "" {
+			inserts = append(inserts, item)
+			continue
+		}
+
+		item["dedup_key"] = key
+		item["last_task_id"] = t.Id
+		if rk, ok := existing[key]; ok {
+			item["first_task_id"] = rk.FirstTaskId
+		} else {
+			item["first_task_id"] = t.Id
+		}
+
+		if _, ok := existing[key]; !ok && !seen[key] {
+			seen[key] = true
+			inserts = append(inserts, item)
+		} else if spider.DedupMode == constants.DedupModeUpsert {
+			updates = append(updates, item)
+		} else {
+			stats.Skipped++
+		}
+	}
+
+	// 写入新增的结果
+	if len(inserts) > 0 {
+		if err := sink.Write(t.Id, inserts); err != nil {
+			return stats, err
+		}
+		stats.Inserted = len(inserts)
+	}
+
+	// 更新重复的结果，只写的储存直接再次发送
+	if len(updates) > 0 {
+		if upserter, ok := sink.(sinks.Upserter); ok {
+			err = upserter.Upsert(t.Id, updates)
+		} else {
+			err = sink.Write(t.Id, updates)
+		}
+		if err != nil {
+			return stats, err
+		}
+		stats.Updated = len(updates)
+	}
+
+	// 记录去重键首次及最近一次出现的任务
+	if len(keys) > 0 {
+		if err := model.UpsertResultKeys(spider.Id, keys, t.Id); err != nil {
+			return stats, err
+		}
+	}
+
+	return stats, model.IncTaskResultCounts(t.Id, stats.Inserted, stats.Updated, stats.Skipped)
+}
+
+// 查询结果
+// 不含筛选、排序、投影的单个任务查询通过预览完成，所有储存均支持；其他查询需要储存支持查询（MongoDB）
+func QueryResults(spider model.Spider, q sinks.Query) ([]interface{}, int, error) {
+	sink, err := spider.GetSink()
+	if err != nil {
+		return nil, 0, err
+	}
+	if queryable, ok := sink.(sinks.Queryable); ok {
+		return queryable.Query(q)
+	}
+	if q.IsPreview() && len(q.TaskIds) == 1 {
+		return sink.Preview(q.TaskIds[0], q.PageNum, q.PageSize)
+	}
+	return nil, 0, sinks.ErrQueryNotSupported
+}
@@ -0,0 +1,63 @@
+package services
+
+import (
+	"crawlab/constants"
+	"crawlab/model"
+	"github.com/globalsign/mgo/bson"
+	"github.com/spf13/viper"
+	"testing"
+	"time"
+)
+
+func TestTaskToken(t *testing.T) {
+	viper.Set("server.secret", "test-secret")
+	defer viper.Set("server.secret", nil)
+
+	task := model.Task{
+		Id:      "abc",
+		NodeId:  bson.NewObjectId(),
+		StartTs: time.Now(),
+		Status:  constants.StatusRunning,
+	}
+	token, err := MakeTaskToken(task)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckTaskToken("Bearer "+token, task); err != nil {
+		t.Fatal(err)
+	}
+
+	// 其他任务
+	other := task
+	other.Id = "def"
+	if err := CheckTaskToken(token, other); err == nil {
+		t.Errorf("expected error for other task")
+	}
+
+	// 其他节点
+	other = task
+	other.NodeId = bson.NewObjectId()
+	if err := CheckTaskToken(token, other); err == nil {
+		t.Errorf("expected error for other node")
+	}
+
+	// 任务重新运行
+	other = task
+	other.StartTs = task.StartTs.Add(time.Minute)
+	if err := CheckTaskToken(token, other); err == nil {
+		t.Errorf("expected error for other run")
+	}
+
+	// 任务已结束
+	other = task
+	other.Status = constants.StatusFinished
+	if err := CheckTaskToken(token, other); err != ErrTaskNotRunning {
+		t.Errorf("CheckTaskToken = %v, want %v", err, ErrTaskNotRunning)
+	}
+
+	// 其他密钥签发
+	viper.Set("server.secret", "other-secret")
+	if err := CheckTaskToken(token, task); err == nil {
+		t.Errorf("expected error for other secret")
+	}
+}
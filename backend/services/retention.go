@@ -0,0 +1,369 @@
+package services
+
+import (
+	"crawlab/constants"
+	"crawlab/database"
+	"crawlab/lib/cron"
+	"crawlab/model"
+	"crawlab/sinks"
+	"encoding/json"
+	"errors"
+	"github.com/apex/log"
+	"github.com/globalsign/mgo/bson"
+	"github.com/spf13/viper"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	retentionBatchSize   = 1000          // 每批删除的任务数量
+	defaultRetentionCron = "0 0 3 * * *" // 默认每天3点清理
+)
+
+var ErrRetentionRunning = errors.New("retention is already running")
+
+var (
+	retentionRunning bool
+	retentionLock    sync.Mutex
+)
+
+// 未结束的任务不清理
+var retentionTaskStatus = bson.M{"$nin": []string{constants.StatusPending, constants.StatusRunning}}
+
+// 按批处理任务ID
+func forEachTaskIdBatch(taskIds []string, fn func(batch []string) error) error {
+	for i := 0; i < len(taskIds); i += retentionBatchSize {
+		end := i + retentionBatchSize
+		if end > len(taskIds) {
+			end = len(taskIds)
+		}
+		if err := fn(taskIds[i:end]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 按保留策略得到结果过期的任务，tasks为已结束的任务（按创建时间倒序）
+func getExpiredTaskIds(tasks []model.Task, policy model.RetentionPolicy, now time.Time) (taskIds []string) {
+	cutoff := now.AddDate(0, 0, -policy.Days)
+	for i, t := range tasks {
+		if t.ResultsExpired {
+			continue
+		}
+		if (policy.Tasks > 0 && i >= policy.Tasks) || (policy.Days > 0 && t.CreateTs.Before(cutoff)) {
+			taskIds = append(taskIds, t.Id)
+		}
+	}
+	return taskIds
+}
+
+// 统计（试运行）或删除任务的结果，返回结果数量
+func removeTaskResults(spider model.Spider, taskIds []string, dryRun bool) (int, error) {
+	sink, err := spider.GetSink()
+	if err != nil {
+		return 0, err
+	}
+	remover, ok := sink.(sinks.Remover)
+	if !ok {
+		return 0, sinks.ErrRemoveNotSupported
+	}
+
+	if dryRun {
+		total := 0
+		for _, id := range taskIds {
+			n, err := sink.Count(id)
+			if err != nil {
+				return total, err
+			}
+			total += n
+		}
+		return total, nil
+	}
+
+	n, err := remover.Remove(taskIds)
+	if err != nil {
+		return n, err
+	}
+
+	// 结果被删除后，再次出现的结果视为新结果
+	if err := model.RemoveResultKeys(getResultKeysRemoveQuery(spider, taskIds)); err != nil {
+		return n, err
+	}
+	return n, nil
+}
+
+// 任务结果被删除时需要删除的去重键
+// skip：重复的结果被丢弃，结果保存在首次出现的任务下，按first_task_id删除
+// upsert：结果的task_id被更新为最近一次出现的任务，按last_task_id删除
+func getResultKeysRemoveQuery(spider model.Spider, taskIds []string) bson.M {
+	field := "first_task_id"
+	if spider.DedupMode == constants.DedupModeUpsert {
+		field = "last_task_id"
+	}
+	return bson.M{"spider_id": spider.Id, field: bson.M{"$in": taskIds}}
+}
+
+// 按爬虫的保留策略清理结果，任务记录保留并标记为结果已过期
+func cleanSpiderResults(spider model.Spider, dryRun bool, now time.Time) model.RetentionSpiderReport {
+	report := model.RetentionSpiderReport{
+		SpiderId:   spider.Id,
+		SpiderName: spider.DisplayName,
+	}
+
+	tasks, err := model.GetTaskBriefList(bson.M{"spider_id": spider.Id, "status": retentionTaskStatus}, "-create_ts")
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	taskIds := getExpiredTaskIds(tasks, spider.Retention, now)
+	report.Tasks = len(taskIds)
+
+	if err := forEachTaskIdBatch(taskIds, func(batch []string) error {
+		n, err := removeTaskResults(spider, batch, dryRun)
+		report.Results += n
+		if err != nil {
+			return err
+		}
+		if dryRun {
+			return nil
+		}
+		return model.SetTasksResultsExpired(batch)
+	}); err != nil {
+		report.Error = err.Error()
+	}
+	return report
+}
+
+// 删除早于days天前的任务记录及其结果，返回删除的任务数量及结果数量
+func cleanTaskRecords(days int, dryRun bool, now time.Time) (records int, results int, err error) {
+	tasks, err := model.GetTaskBriefList(bson.M{
+		"create_ts": bson.M{"$lt": now.AddDate(0, 0, -days)},
+		"status":    retentionTaskStatus,
+	}, "create_ts")
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// 按爬虫分组（结果已过期的任务无需再删除结果）
+	var spiderIds []bson.ObjectId
+	spiderTaskIds := map[bson.ObjectId][]string{}
+	for _, t := range tasks {
+		if t.ResultsExpired {
+			continue
+		}
+		if _, ok := spiderTaskIds[t.SpiderId]; !ok {
+			spiderIds = append(spiderIds, t.SpiderId)
+		}
+		spiderTaskIds[t.SpiderId] = append(spiderTaskIds[t.SpiderId], t.Id)
+	}
+
+	// 删除结果（爬虫已删除或储存不支持删除时跳过），删除失败的爬虫保留任务记录，下次清理时重试
+	failed := map[bson.ObjectId]bool{}
+	for _, spiderId := range spiderIds {
+		spider, err := model.GetSpider(spiderId)
+		if err != nil {
+			continue
+		}
+		if err := forEachTaskIdBatch(spiderTaskIds[spiderId], func(batch []string) error {
+			n, err := removeTaskResults(spider, batch, dryRun)
+			results += n
+			return err
+		}); err != nil && err != sinks.ErrRemoveNotSupported {
+			log.Errorf("remove results of spider %s error: %s", spider.Name, err.Error())
+			failed[spiderId] = true
+		}
+	}
+
+	// 删除任务记录
+	var taskIds []string
+	for _, t := range tasks {
+		if !failed[t.SpiderId] {
+			taskIds = append(taskIds, t.Id)
+		}
+	}
+	if dryRun {
+		return len(taskIds), results, nil
+	}
+	err = forEachTaskIdBatch(taskIds, func(batch []string) error {
+		if err := sinks.RemoveResultCounts(batch); err != nil {
+			return err
+		}
+		if err := model.RemoveTasks(batch); err != nil {
+			return err
+		}
+		records += len(batch)
+		return nil
+	})
+	return records, results, err
+}
+
+// 执行数据保留策略
+// 1. 按各个爬虫的保留策略删除过期任务的结果
+// 2. 按全局策略（retention.taskDays）删除过期的任务记录及其结果
+// 3. 按全局策略（retention.logDays）通知各个在线节点清理log.path下的日志文件，节点清理后回报
+// 试运行时只统计不删除，报告均保存在retention_reports中
+func RunRetention(dryRun bool) (report model.RetentionReport, err error) {
+	retentionLock.Lock()
+	if retentionRunning {
+		retentionLock.Unlock()
+		return report, ErrRetentionRunning
+	}
+	retentionRunning = true
+	retentionLock.Unlock()
+	defer func() {
+		retentionLock.Lock()
+		retentionRunning = false
+		retentionLock.Unlock()
+	}()
+
+	now := time.Now()
+	report = model.RetentionReport{
+		DryRun:   dryRun,
+		Spiders:  []model.RetentionSpiderReport{},
+		TaskDays: viper.GetInt("retention.taskDays"),
+		LogDays:  viper.GetInt("retention.logDays"),
+		Nodes:    []model.RetentionNodeReport{},
+	}
+
+	// 需要清理日志的节点（离线节点在下次清理时处理）
+	if report.LogDays > 0 {
+		nodes, err := model.GetNodeList(bson.M{"status": constants.StatusOnline})
+		if err != nil {
+			return report, err
+		}
+		for _, node := range nodes {
+			report.Nodes = append(report.Nodes, model.RetentionNodeReport{
+				NodeId:   node.Id,
+				NodeName: node.Name,
+			})
+		}
+	}
+
+	// 先保存报告，节点回报时更新
+	if err := report.Add(); err != nil {
+		return report, err
+	}
+
+	// 爬虫结果
+	spiders, err := model.GetSpiderList(bson.M{"$or": []bson.M{
+		{"retention.days": bson.M{"$gt": 0}},
+		{"retention.tasks": bson.M{"$gt": 0}},
+	}}, 0, constants.Infinite)
+	if err != nil {
+		report.Error = err.Error()
+	}
+	for _, spider := range spiders {
+		report.Spiders = append(report.Spiders, cleanSpiderResults(spider, dryRun, now))
+	}
+
+	// 任务记录
+	if report.TaskDays > 0 {
+		records, results, err := cleanTaskRecords(report.TaskDays, dryRun, now)
+		report.TaskRecords = records
+		report.TaskResults = results
+		if err != nil {
+			report.Error = err.Error()
+		}
+	}
+
+	// 日志文件
+	for _, node := range report.Nodes {
+		msg := NodeMessage{
+			Type:     constants.MsgTypeCleanLogs,
+			NodeId:   node.NodeId.Hex(),
+			ReportId: report.Id.Hex(),
+			LogDays:  report.LogDays,
+			DryRun:   dryRun,
+		}
+		msgBytes, _ := json.Marshal(&msg)
+		if err := database.Publish("nodes:"+node.NodeId.Hex(), string(msgBytes)); err != nil {
+			log.Errorf(err.Error())
+		}
+	}
+
+	report.FinishTs = time.Now()
+	if err := report.Save(); err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+// 清理本节点log.path下修改时间早于days天前的日志文件，返回文件数量及大小
+func CleanLocalLogs(days int, dryRun bool) (files int, size int64, err error) {
+	if days <= 0 {
+		return 0, 0, errors.New("invalid log retention days")
+	}
+	cutoff := time.Now().AddDate(0, 0, -days)
+
+	root := viper.GetString("log.path")
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+
+		// 只处理日志文件（不跟随符号链接）
+		if !info.Mode().IsRegular() || !strings.HasSuffix(info.Name(), ".log") || !info.ModTime().Before(cutoff) {
+			return nil
+		}
+		if !dryRun {
+			if err := os.Remove(path); err != nil {
+				log.Errorf(err.Error())
+				return nil
+			}
+		}
+		files++
+		size += info.Size()
+		return nil
+	})
+	return files, size, err
+}
+
+// 保存节点回报的日志清理情况（主节点）
+func SaveCleanLogsReport(msg NodeMessage) error {
+	if !bson.IsObjectIdHex(msg.ReportId) || !bson.IsObjectIdHex(msg.NodeId) {
+		return nil
+	}
+	return model.UpdateRetentionNodeReport(bson.ObjectIdHex(msg.ReportId), model.RetentionNodeReport{
+		NodeId:   bson.ObjectIdHex(msg.NodeId),
+		LogFiles: msg.LogFiles,
+		LogBytes: msg.LogBytes,
+		Error:    msg.Error,
+	})
+}
+
+// 校验爬虫结果保留策略
+func ValidateRetentionPolicy(policy model.RetentionPolicy) error {
+	if policy.Days < 0 || policy.Tasks < 0 {
+		return errors.New("retention days and tasks must not be negative")
+	}
+	return nil
+}
+
+// 初始化数据保留服务（主节点），按retention.cron定时清理（仅由Leader执行）
+func InitRetentionService() error {
+	c := cron.New(cron.WithSeconds())
+
+	spec := viper.GetString("retention.cron")
+	if spec == "" {
+		spec = defaultRetentionCron
+	}
+	if _, err := c.AddFunc(spec, LeaderJob(func() {
+		if _, err := RunRetention(false); err != nil {
+			log.Errorf("run retention error: " + err.Error())
+			debug.PrintStack()
+		}
+	})); err != nil {
+		return err
+	}
+
+	c.Start()
+	return nil
+}
@@ -0,0 +1,58 @@
+package services
+
+import (
+	"crawlab/constants"
+	"crawlab/model"
+	"github.com/globalsign/mgo/bson"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGetResultKeysRemoveQuery(t *testing.T) {
+	spiderId := bson.NewObjectId()
+	taskIds := []string{"t1", "t2"}
+
+	tests := []struct {
+		mode  string
+		field string
+	}{
+		// 重复的结果被丢弃，结果文档保存在首次出现的任务下
+		{"", "first_task_id"},
+		{constants.DedupModeSkip, "first_task_id"},
+		// 结果文档的task_id被更新为最近一次出现的任务
+		{constants.DedupModeUpsert, "last_task_id"},
+	}
+	for _, tt := range tests {
+		spider := model.Spider{Id: spiderId, DedupMode: tt.mode}
+		want := bson.M{"spider_id": spiderId, tt.field: bson.M{"$in": taskIds}}
+		if got := getResultKeysRemoveQuery(spider, taskIds); !reflect.DeepEqual(got, want) {
+			t.Errorf("getResultKeysRemoveQuery(%q) = %v, want %v", tt.mode, got, want)
+		}
+	}
+}
+
+func TestGetExpiredTaskIds(t *testing.T) {
+	now := time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC)
+	tasks := []model.Task{
+		{Id: "t5", CreateTs: now.AddDate(0, 0, -1)},
+		{Id: "t4", CreateTs: now.AddDate(0, 0, -2)},
+		{Id: "t3", CreateTs: now.AddDate(0, 0, -3)},
+		{Id: "t2", CreateTs: now.AddDate(0, 0, -8), ResultsExpired: true},
+		{Id: "t1", CreateTs: now.AddDate(0, 0, -9)},
+	}
+
+	tests := []struct {
+		policy model.RetentionPolicy
+		want   []string
+	}{
+		{model.RetentionPolicy{Tasks: 2}, []string{"t3", "t1"}},
+		{model.RetentionPolicy{Days: 7}, []string{"t1"}},
+		{model.RetentionPolicy{Days: 7, Tasks: 1}, []string{"t4", "t3", "t1"}},
+	}
+	for _, tt := range tests {
+		if got := getExpiredTaskIds(tasks, tt.policy, now); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("getExpiredTaskIds(%+v) = %v, want %v", tt.policy, got, tt.want)
+		}
+	}
+}
@@ -4,46 +4,200 @@ import (
 	"crawlab/constants"
 	"crawlab/lib/cron"
 	"crawlab/model"
+	"errors"
 	"github.com/apex/log"
 	uuid "github.com/satori/go.uuid"
 	"runtime/debug"
+	"strconv"
+	"time"
 )
 
 var Sched *Scheduler
 
+var ErrNotLeader = errors.New("not leader")
+
+// 与定时任务执行器一致的cron表达式解析器（包含秒）
+var scheduleParser = cron.NewParser(
+	cron.Second | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor,
+)
+
 type Scheduler struct {
-	cron *cron.Cron
+	cron    *cron.Cron
+	updater *cron.Cron
+}
+
+// 最多补跑次数的上限
+const MaxMisfireRuns = 100
+
+// 校验定时任务：cron表达式、补跑策略及最多补跑次数
+func ValidateSchedule(s model.Schedule) error {
+	if _, err := scheduleParser.Parse(s.Cron); err != nil {
+		return errors.New("invalid cron: " + err.Error())
+	}
+	switch s.MisfirePolicy {
+	case "", constants.MisfireIgnore, constants.MisfireRunOnce, constants.MisfireRunAll:
+	default:
+		return errors.New("invalid misfire_policy: " + s.MisfirePolicy)
+	}
+	if s.MisfireMaxRuns < 0 || s.MisfireMaxRuns > MaxMisfireRuns {
+		return errors.New("misfire_max_runs must be between 0 and " + strconv.Itoa(MaxMisfireRuns))
+	}
+	return nil
 }
 
 func AddTask(s model.Schedule) func() {
 	return func() {
-		nodeId := s.NodeId
+		RunSchedule(s, time.Time{})
+	}
+}
 
-		// 生成任务ID
-		id := uuid.NewV4()
+// 补跑定时任务（测试时可替换）
+var replaySchedule = RunSchedule
 
-		// 生成任务模型
-		t := model.Task{
-			Id:       id.String(),
-			SpiderId: s.SpiderId,
-			NodeId:   nodeId,
-			Status:   constants.StatusPending,
+// 运行定时任务，misfireTs为补跑时错过的触发时间（记录在任务中），正常触发时为空
+func RunSchedule(s model.Schedule, misfireTs time.Time) {
+	// 运行工作流
+	if s.WorkflowId != "" {
+		if _, err := RunWorkflowById(s.WorkflowId, constants.TriggerSchedule); err != nil {
+			log.Errorf(err.Error())
+			debug.PrintStack()
+			return
 		}
 
-		// 将任务存入数据库
-		if err := model.AddTask(t); err != nil {
+		// 记录最后一次触发时间
+		if err := model.UpdateScheduleLastFireTs(s.Id, time.Now()); err != nil {
 			log.Errorf(err.Error())
 			debug.PrintStack()
-			return
 		}
+		return
+	}
+
+	nodeId := s.NodeId
+
+	// 生成任务ID
+	id := uuid.NewV4()
+
+	// 生成任务模型
+	t := model.Task{
+		Id:         id.String(),
+		SpiderId:   s.SpiderId,
+		NodeId:     nodeId,
+		Status:     constants.StatusPending,
+		ScheduleId: s.Id,
+		MisfireTs:  misfireTs,
+	}
+
+	// 将任务存入数据库
+	if err := model.AddTask(t); err != nil {
+		log.Errorf(err.Error())
+		debug.PrintStack()
+		return
+	}
+
+	// 加入任务队列
+	if err := AssignTask(t); err != nil {
+		log.Errorf(err.Error())
+		debug.PrintStack()
+		return
+	}
+
+	// 记录最后一次触发时间
+	if err := model.UpdateScheduleLastFireTs(s.Id, time.Now()); err != nil {
+		log.Errorf(err.Error())
+		debug.PrintStack()
+		return
+	}
+}
+
+// 获取错过的触发时间（最近的max个，按时间先后排序）
+func GetMissedFireTimes(s model.Schedule, now time.Time, max int) ([]time.Time, error) {
+	var fireTimes []time.Time
+
+	// 解析cron表达式
+	sched, err := scheduleParser.Parse(s.Cron)
+	if err != nil {
+		return fireTimes, err
+	}
+
+	// 如果从未触发过，则从创建时间开始计算
+	lastTs := s.LastFireTs
+	if lastTs.IsZero() {
+		lastTs = s.CreateTs
+	}
+	if lastTs.IsZero() {
+		return fireTimes, nil
+	}
+
+	// 从数据库读取的时间为UTC，需转换为本地时区，与定时任务按本地时区触发保持一致
+	lastTs = lastTs.In(time.Local)
+
+	// 遍历上次触发时间至今应触发的时间，只保留最近的max个
+	for ts := sched.Next(lastTs); !ts.IsZero() && ts.Before(now); ts = sched.Next(ts) {
+		if len(fireTimes) >= max {
+			fireTimes = fireTimes[1:]
+		}
+		fireTimes = append(fireTimes, ts)
+	}
+
+	return fireTimes, nil
+}
+
+// 根据补跑策略处理单个定时任务，每次补跑前检查isLeader，不再是Leader时停止补跑
+func HandleMisfire(s model.Schedule, now time.Time, isLeader func() bool) error {
+	// 最多补跑次数
+	var max int
+	switch s.MisfirePolicy {
+	case constants.MisfireRunOnce:
+		max = 1
+	case constants.MisfireRunAll:
+		max = s.MisfireMaxRuns
+		if max <= 0 {
+			max = 1
+		}
+	default:
+		// 忽略错过的任务
+		return nil
+	}
+
+	// 获取错过的触发时间
+	fireTimes, err := GetMissedFireTimes(s, now, max)
+	if err != nil {
+		return err
+	}
+
+	// 补跑任务
+	for _, ts := range fireTimes {
+		if !isLeader() {
+			return ErrNotLeader
+		}
+		log.Infof("misfire schedule %s (%s), missed fire time: %s", s.Name, s.Id.Hex(), ts.String())
+		replaySchedule(s, ts)
+	}
+
+	return nil
+}
+
+// 补跑主节点停机期间错过的定时任务，不再是Leader时停止补跑
+func HandleMisfires(isLeader func() bool) error {
+	// 获取所有定时任务
+	sList, err := model.GetScheduleList(nil)
+	if err != nil {
+		return err
+	}
 
-		// 加入任务队列
-		if err := AssignTask(t); err != nil {
+	now := time.Now()
+	for _, s := range sList {
+		if err := HandleMisfire(s, now, isLeader); err != nil {
+			if err == ErrNotLeader {
+				return err
+			}
 			log.Errorf(err.Error())
 			debug.PrintStack()
-			return
+			continue
 		}
 	}
+
+	return nil
 }
 
 func UpdateSchedules() {
@@ -53,9 +207,8 @@ func UpdateSchedules() {
 	}
 }
 
+// 启动调度器，错过的定时任务由Leader当选后另行补跑
 func (s *Scheduler) Start() error {
-	exec := cron.New(cron.WithSeconds())
-
 	// 启动cron服务
 	s.cron.Start()
 
@@ -64,15 +217,31 @@ func (s *Scheduler) Start() error {
 		return err
 	}
 
-	// 每30秒更新一次任务列表
+	// 每30秒更新一次任务列表（其他主节点修改的定时任务通过此处生效）
+	s.updater = cron.New(cron.WithSeconds())
 	spec := "*/30 * * * * *"
-	if _, err := exec.AddFunc(spec, UpdateSchedules); err != nil {
+	if _, err := s.updater.AddFunc(spec, UpdateSchedules); err != nil {
 		return err
 	}
+	s.updater.Start()
 
 	return nil
 }
 
+func (s *Scheduler) Stop() {
+	// 停止更新任务列表
+	if s.updater != nil {
+		s.updater.Stop()
+		s.updater = nil
+	}
+
+	// 停止cron服务
+	s.cron.Stop()
+
+	// 删除所有定时任务
+	s.RemoveAll()
+}
+
 func (s *Scheduler) AddJob(job model.Schedule) error {
 	spec := job.Cron
 
@@ -123,11 +292,9 @@ func (s *Scheduler) Update() error {
 }
 
 func InitScheduler() error {
+	// 定时任务仅在当选Leader后启动，参见InitLeaderService
 	Sched = &Scheduler{
 		cron: cron.New(cron.WithSeconds()),
 	}
-	if err := Sched.Start(); err != nil {
-		return err
-	}
 	return nil
 }
@@ -0,0 +1,152 @@
+package services
+
+import (
+	"crawlab/constants"
+	"crawlab/model"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGetMissedFireTimesLocalTimezone(t *testing.T) {
+	local := time.Local
+	time.Local = time.FixedZone("UTC+8", 8*3600)
+	defer func() { time.Local = local }()
+
+	// 每天本地时间2点触发，上次触发时间从数据库读取（UTC）
+	s := model.Schedule{
+		Cron:       "0 0 2 * * *",
+		LastFireTs: time.Date(2024, 1, 1, 2, 0, 0, 0, time.Local).UTC(),
+	}
+	now := time.Date(2024, 1, 3, 12, 0, 0, 0, time.Local)
+
+	fireTimes, err := GetMissedFireTimes(s, now, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []time.Time{
+		time.Date(2024, 1, 2, 2, 0, 0, 0, time.Local),
+		time.Date(2024, 1, 3, 2, 0, 0, 0, time.Local),
+	}
+	if len(fireTimes) != len(want) {
+		t.Fatalf("GetMissedFireTimes = %v, want %v", fireTimes, want)
+	}
+	for i := range want {
+		if !fireTimes[i].Equal(want[i]) {
+			t.Fatalf("GetMissedFireTimes = %v, want %v", fireTimes, want)
+		}
+	}
+
+	// 最多返回最近的max个
+	if fireTimes, _ := GetMissedFireTimes(s, now, 1); len(fireTimes) != 1 || !fireTimes[0].Equal(want[1]) {
+		t.Fatalf("GetMissedFireTimes max 1 = %v", fireTimes)
+	}
+}
+
+// 生成当天指定时刻（本地时区）
+func testHour(hour int) time.Time {
+	return time.Date(2024, 1, 1, hour, 0, 0, 0, time.Local)
+}
+
+func TestGetMissedFireTimes(t *testing.T) {
+	hourly := "0 0 * * * *"
+	now := time.Date(2024, 1, 1, 5, 30, 0, 0, time.Local)
+
+	tests := []struct {
+		name string
+		s    model.Schedule
+		max  int
+		want []time.Time
+	}{
+		{"all", model.Schedule{Cron: hourly, LastFireTs: testHour(0)}, 10,
+			[]time.Time{testHour(1), testHour(2), testHour(3), testHour(4), testHour(5)}},
+		{"most recent", model.Schedule{Cron: hourly, LastFireTs: testHour(0)}, 2,
+			[]time.Time{testHour(4), testHour(5)}},
+		{"never fired", model.Schedule{Cron: hourly, CreateTs: testHour(3).Add(time.Minute)}, 10,
+			[]time.Time{testHour(4), testHour(5)}},
+		{"no missed", model.Schedule{Cron: hourly, LastFireTs: testHour(5)}, 10, nil},
+		{"no create time", model.Schedule{Cron: hourly}, 10, nil},
+	}
+	for _, tt := range tests {
+		got, err := GetMissedFireTimes(tt.s, now, tt.max)
+		if err != nil {
+			t.Fatalf("%s: %s", tt.name, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: GetMissedFireTimes = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	if _, err := GetMissedFireTimes(model.Schedule{Cron: "invalid", LastFireTs: testHour(0)}, now, 10); err == nil {
+		t.Error("expected error for invalid cron")
+	}
+}
+
+func TestHandleMisfire(t *testing.T) {
+	var replayed []time.Time
+	old := replaySchedule
+	replaySchedule = func(s model.Schedule, misfireTs time.Time) {
+		replayed = append(replayed, misfireTs)
+	}
+	defer func() { replaySchedule = old }()
+
+	now := time.Date(2024, 1, 1, 5, 30, 0, 0, time.Local)
+	isLeader := func() bool { return true }
+	schedule := func(policy string, maxRuns int) model.Schedule {
+		return model.Schedule{Cron: "0 0 * * * *", LastFireTs: testHour(0), MisfirePolicy: policy, MisfireMaxRuns: maxRuns}
+	}
+
+	tests := []struct {
+		name string
+		s    model.Schedule
+		want []time.Time
+	}{
+		{"default", schedule("", 0), nil},
+		{"ignore", schedule(constants.MisfireIgnore, 10), nil},
+		{"run once", schedule(constants.MisfireRunOnce, 10), []time.Time{testHour(5)}},
+		{"run all", schedule(constants.MisfireRunAll, 10), []time.Time{testHour(1), testHour(2), testHour(3), testHour(4), testHour(5)}},
+		{"run all limited", schedule(constants.MisfireRunAll, 2), []time.Time{testHour(4), testHour(5)}},
+		{"run all default max", schedule(constants.MisfireRunAll, 0), []time.Time{testHour(5)}},
+	}
+	for _, tt := range tests {
+		replayed = nil
+		if err := HandleMisfire(tt.s, now, isLeader); err != nil {
+			t.Fatalf("%s: %s", tt.name, err)
+		}
+		if !reflect.DeepEqual(replayed, tt.want) {
+			t.Errorf("%s: replayed = %v, want %v", tt.name, replayed, tt.want)
+		}
+	}
+
+	// 补跑过程中失去Leader身份时停止补跑
+	replayed = nil
+	leader := true
+	replaySchedule = func(s model.Schedule, misfireTs time.Time) {
+		replayed = append(replayed, misfireTs)
+		leader = false
+	}
+	err := HandleMisfire(schedule(constants.MisfireRunAll, 10), now, func() bool { return leader })
+	if err != ErrNotLeader || len(replayed) != 1 {
+		t.Fatalf("HandleMisfire = %v, replayed = %v", err, replayed)
+	}
+}
+
+func TestValidateSchedule(t *testing.T) {
+	tests := []struct {
+		s   model.Schedule
+		err bool
+	}{
+		{model.Schedule{Cron: "0 0 * * * *"}, false},
+		{model.Schedule{Cron: "0 0 * * * *", MisfirePolicy: constants.MisfireRunAll, MisfireMaxRuns: MaxMisfireRuns}, false},
+		{model.Schedule{Cron: "0 0 * * * *", MisfirePolicy: constants.MisfireRunOnce}, false},
+		{model.Schedule{Cron: "0 0 * * *"}, true},
+		{model.Schedule{Cron: "0 0 * * * *", MisfirePolicy: "run_twice"}, true},
+		{model.Schedule{Cron: "0 0 * * * *", MisfirePolicy: constants.MisfireRunAll, MisfireMaxRuns: -1}, true},
+		{model.Schedule{Cron: "0 0 * * * *", MisfirePolicy: constants.MisfireRunAll, MisfireMaxRuns: MaxMisfireRuns + 1}, true},
+	}
+	for _, tt := range tests {
+		if err := ValidateSchedule(tt.s); (err != nil) != tt.err {
+			t.Errorf("ValidateSchedule(%s, %s, %d) = %v", tt.s.Cron, tt.s.MisfirePolicy, tt.s.MisfireMaxRuns, err)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package services
+
+import (
+	"crawlab/model"
+	"crawlab/sinks"
+	"crawlab/utils"
+	"github.com/globalsign/mgo/bson"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+const (
+	schemaMaxDistinct = 1000 // 每个字段最多统计的不同值数量，超出后不再统计
+	schemaMaxExamples = 3    // 每个字段的示例值数量
+	schemaMaxExample  = 100  // 示例值最大长度
+)
+
+// 字段统计
+type FieldStats struct {
+	Field          string         `json:"field"`           // 字段名，嵌套字段以点号连接
+	Types          map[string]int `json:"types"`           // 各类型出现的次数
+	Count          int            `json:"count"`           // 非空值数量
+	FillRate       float64        `json:"fill_rate"`       // 填充率：非空值数量 / 样本数量
+	Distinct       int            `json:"distinct"`        // 不同值数量
+	DistinctCapped bool           `json:"distinct_capped"` // 不同值过多，distinct只是下限
+	Examples       []string       `json:"examples"`        // 示例值
+
+	distinct map[string]bool
+}
+
+// 结果结构
+type ResultSchema struct {
+	TaskId      string       `json:"task_id,omitempty"`
+	CreateTs    *time.Time   `json:"create_ts,omitempty"`
+	SampleCount int          `json:"sample_count"` // 样本数量
+	Fields      []FieldStats `json:"fields"`
+
+	fields map[string]*FieldStats
+}
+
+// 爬虫结果结构：全部样本的汇总及各个任务的结构
+type SpiderResultSchema struct {
+	ResultSchema
+	Tasks []ResultSchema `json:"tasks"`
+}
+
+func newFieldStats(field string) *FieldStats {
+	return &FieldStats{Field: field, Types: map[string]int{}, Examples: []string{}, distinct: map[string]bool{}}
+}
+
+func newResultSchema() *ResultSchema {
+	return &ResultSchema{fields: map[string]*FieldStats{}}
+}
+
+// 值的类型
+func getValueType(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "bool"
+	case int, int32, int64, float32, float64:
+		return "number"
+	case time.Time:
+		return "date"
+	case bson.ObjectId:
+		return "objectid"
+	case []interface{}:
+		return "array"
+	case bson.M, map[string]interface{}:
+		return "object"
+	default:
+		return "other"
+	}
+}
+
+// 是否为空值（null、空字符串、空数组、空文档）
+func isEmptyValue(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case string:
+		return v == ""
+	case []interface{}:
+		return len(v) == 0
+	case bson.M:
+		return len(v) == 0
+	case map[string]interface{}:
+		return len(v) == 0
+	}
+	return false
+}
+
+// 统计一条结果，忽略Crawlab写入的字段
+func (rs *ResultSchema) add(item bson.M) {
+	rs.SampleCount++
+	for field, value := range utils.FlattenMap(item) {
+		if resultInternalFields[field] {
+			continue
+		}
+		fs, ok := rs.fields[field]
+		if !ok {
+			fs = newFieldStats(field)
+			rs.fields[field] = fs
+		}
+		fs.Types[getValueType(value)]++
+		if isEmptyValue(value) {
+			continue
+		}
+		fs.Count++
+
+		// 不同值及示例值
+		str := utils.InterfaceToString(value)
+		if fs.distinct[str] {
+			continue
+		}
+		if len(fs.distinct) >= schemaMaxDistinct {
+			fs.DistinctCapped = true
+			continue
+		}
+		fs.distinct[str] = true
+		if len(fs.Examples) < schemaMaxExamples {
+			if r := []rune(str); len(r) > schemaMaxExample {
+				str = string(r[:schemaMaxExample]) + "..."
+			}
+			fs.Examples = append(fs.Examples, str)
+		}
+	}
+}
+
+// 计算填充率并按字段名排序
+func (rs *ResultSchema) finish() {
+	rs.Fields = []FieldStats{}
+	for _, fs := range rs.fields {
+		fs.Distinct = len(fs.distinct)
+		if rs.SampleCount > 0 {
+			fs.FillRate = float64(fs.Count) / float64(rs.SampleCount)
+		}
+		rs.Fields = append(rs.Fields, *fs)
+	}
+	sort.Slice(rs.Fields, func(i, j int) bool {
+		return rs.Fields[i].Field < rs.Fields[j].Field
+	})
+}
+
+// 从任务的结果中随机抽取最多sampleSize条结果（蓄水池抽样，只在内存中保存样本）
+func sampleTaskResults(iterable sinks.Iterable, taskId string, sampleSize int) ([]bson.M, error) {
+	iter, err := iterable.Iter([]string{taskId})
+	if err != nil {
+		return nil, err
+	}
+	var samples []bson.M
+	for n := 0; ; n++ {
+		var item bson.M
+		if !iter.Next(&item) {
+			break
+		}
+		if len(samples) < sampleSize {
+			samples = append(samples, item)
+		} else if i := rand.Intn(n + 1); i < sampleSize {
+			samples[i] = item
+		}
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	return samples, nil
+}
+
+// 推断爬虫结果的结构，每个任务随机抽取最多sampleSize条结果
+// 返回全部样本的汇总及各个任务的字段类型、填充率、不同值数量和示例值，便于比较各次运行
+func InferResultSchema(spider model.Spider, tasks []model.Task, sampleSize int) (schema SpiderResultSchema, err error) {
+	sink, err := spider.GetSink()
+	if err != nil {
+		return schema, err
+	}
+	iterable, ok := sink.(sinks.Iterable)
+	if !ok {
+		return schema, sinks.ErrQueryNotSupported
+	}
+
+	return inferResultSchema(iterable, tasks, sampleSize)
+}
+
+func inferResultSchema(iterable sinks.Iterable, tasks []model.Task, sampleSize int) (schema SpiderResultSchema, err error) {
+	total := newResultSchema()
+	var taskSchemas []*ResultSchema
+	for _, t := range tasks {
+		taskSchema := newResultSchema()
+		taskSchema.TaskId = t.Id
+		createTs := t.CreateTs
+		taskSchema.CreateTs = &createTs
+
+		samples, err := sampleTaskResults(iterable, t.Id, sampleSize)
+		if err != nil {
+			return schema, err
+		}
+		for _, item := range samples {
+			taskSchema.add(item)
+			total.add(item)
+		}
+		taskSchemas = append(taskSchemas, taskSchema)
+	}
+
+	// 任务中没有出现的字段填充率为0，便于发现字段缺失
+	schema.Tasks = []ResultSchema{}
+	for _, taskSchema := range taskSchemas {
+		for field := range total.fields {
+			if _, ok := taskSchema.fields[field]; !ok {
+				taskSchema.fields[field] = newFieldStats(field)
+			}
+		}
+		taskSchema.finish()
+		schema.Tasks = append(schema.Tasks, *taskSchema)
+	}
+
+	total.finish()
+	schema.ResultSchema = *total
+	return schema, nil
+}
@@ -0,0 +1,164 @@
+package services
+
+import (
+	"crawlab/model"
+	"fmt"
+	"github.com/globalsign/mgo/bson"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestInferResultSchema(t *testing.T) {
+	iterable := &fakeIterable{items: map[string][]bson.M{
+		"t1": {
+			{"_id": bson.NewObjectId(), "task_id": "t1", "dedup_key": "k1", "ingest_ts": 1, "title": "a", "price": 1, "meta": bson.M{"tag": "x"}},
+			{"_id": bson.NewObjectId(), "task_id": "t1", "title": "a", "price": 2.5, "meta": bson.M{}},
+			{"_id": bson.NewObjectId(), "task_id": "t1", "title": "b", "price": nil},
+			{"_id": bson.NewObjectId(), "task_id": "t1", "title": "", "url": strings.Repeat("u", 150)},
+		},
+		"t2": {
+			{"task_id": "t2", "title": "c", "first_task_id": "t1", "last_task_id": "t2"},
+		},
+	}}
+	tasks := []model.Task{{Id: "t1"}, {Id: "t2"}}
+
+	schema, err := inferResultSchema(iterable, tasks, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Crawlab写入的字段不统计
+	var fields []string
+	for _, fs := range schema.Fields {
+		fields = append(fields, fs.Field)
+	}
+	if want := []string{"meta.tag", "price", "title", "url"}; !reflect.DeepEqual(fields, want) {
+		t.Fatalf("fields = %v, want %v", fields, want)
+	}
+	if schema.SampleCount != 5 {
+		t.Errorf("sample count = %d, want 5", schema.SampleCount)
+	}
+
+	stats := map[string]FieldStats{}
+	for _, fs := range schema.Fields {
+		stats[fs.Field] = fs
+	}
+
+	title := stats["title"]
+	if title.Count != 4 || title.FillRate != 0.8 || title.Distinct != 3 {
+		t.Errorf("title = count %d, fill rate %v, distinct %d", title.Count, title.FillRate, title.Distinct)
+	}
+	if !reflect.DeepEqual(title.Examples, []string{"a", "b", "c"}) {
+		t.Errorf("title examples = %v", title.Examples)
+	}
+	if title.Types["string"] != 5 {
+		t.Errorf("title types = %v", title.Types)
+	}
+
+	price := stats["price"]
+	if price.Count != 2 || price.FillRate != 0.4 || !reflect.DeepEqual(price.Types, map[string]int{"number": 2, "null": 1}) {
+		t.Errorf("price = count %d, fill rate %v, types %v", price.Count, price.FillRate, price.Types)
+	}
+	if !reflect.DeepEqual(price.Examples, []string{"1", "2.5"}) {
+		t.Errorf("price examples = %v", price.Examples)
+	}
+
+	// 嵌套字段
+	if tag := stats["meta.tag"]; tag.Count != 1 || tag.FillRate != 0.2 || tag.Distinct != 1 {
+		t.Errorf("meta.tag = count %d, fill rate %v, distinct %d", tag.Count, tag.FillRate, tag.Distinct)
+	}
+
+	// 示例值截断
+	if url := stats["url"]; len(url.Examples) != 1 || url.Examples[0] != strings.Repeat("u", schemaMaxExample)+"..." {
+		t.Errorf("url examples = %v", url.Examples)
+	}
+
+	// 任务中没有出现的字段填充率为0
+	if len(schema.Tasks) != 2 {
+		t.Fatalf("tasks = %d, want 2", len(schema.Tasks))
+	}
+	for _, fs := range schema.Tasks[1].Fields {
+		switch fs.Field {
+		case "title":
+			if fs.FillRate != 1 {
+				t.Errorf("t2 title fill rate = %v, want 1", fs.FillRate)
+			}
+		default:
+			if fs.FillRate != 0 || fs.Count != 0 {
+				t.Errorf("t2 %s = count %d, fill rate %v", fs.Field, fs.Count, fs.FillRate)
+			}
+		}
+	}
+}
+
+func TestInferResultSchemaDistinctCapped(t *testing.T) {
+	var items []bson.M
+	for i := 0; i < schemaMaxDistinct+10; i++ {
+		items = append(items, bson.M{"n": i, "c": "same"})
+	}
+	iterable := &fakeIterable{items: map[string][]bson.M{"t1": items}}
+
+	schema, err := inferResultSchema(iterable, []model.Task{{Id: "t1"}}, len(items))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fs := range schema.Fields {
+		switch fs.Field {
+		case "n":
+			if fs.Distinct != schemaMaxDistinct || !fs.DistinctCapped || len(fs.Examples) != schemaMaxExamples {
+				t.Errorf("n = distinct %d, capped %v, examples %v", fs.Distinct, fs.DistinctCapped, fs.Examples)
+			}
+		case "c":
+			if fs.Distinct != 1 || fs.DistinctCapped || fs.FillRate != 1 {
+				t.Errorf("c = distinct %d, capped %v, fill rate %v", fs.Distinct, fs.DistinctCapped, fs.FillRate)
+			}
+		}
+	}
+}
+
+func TestSampleTaskResults(t *testing.T) {
+	var items []bson.M
+	for i := 0; i < 1000; i++ {
+		items = append(items, bson.M{"n": i})
+	}
+	iterable := &fakeIterable{items: map[string][]bson.M{"t1": items}}
+
+	// 多次抽样，样本应来自全部结果而不只是前面的结果
+	seen := map[int]bool{}
+	maxN := 0
+	for round := 0; round < 20; round++ {
+		samples, err := sampleTaskResults(iterable, "t1", 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(samples) != 10 {
+			t.Fatalf("samples = %d, want 10", len(samples))
+		}
+		for _, item := range samples {
+			n := item["n"].(int)
+			if seen[n] && round == 0 {
+				t.Fatalf("duplicate sample %d", n)
+			}
+			seen[n] = true
+			if n > maxN {
+				maxN = n
+			}
+		}
+	}
+	if maxN < 100 {
+		t.Fatalf("samples only come from the first results (max %d)", maxN)
+	}
+
+	// 结果数量不足时返回全部结果
+	samples, err := sampleTaskResults(iterable, "t1", 2000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != len(items) {
+		t.Fatalf("samples = %d, want %d", len(samples), len(items))
+	}
+	if fmt.Sprint(samples[999]["n"]) != "999" {
+		t.Fatalf("samples[999] = %v", samples[999])
+	}
+}
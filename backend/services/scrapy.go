@@ -0,0 +1,534 @@
+package services
+
+import (
+	"bufio"
+	"crawlab/model"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Scrapy项目中的一项设置，值为Python表达式原文
+type ScrapySetting struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type ScrapyItem struct {
+	Name   string   `json:"name"`
+	Fields []string `json:"fields"`
+}
+
+type ScrapyPipeline struct {
+	Name     string `json:"name"`     // 完整路径，如 jd.pipelines.JdPipeline
+	Priority int    `json:"priority"` // ITEM_PIPELINES中的优先级
+	Enabled  bool   `json:"enabled"`  // 是否在ITEM_PIPELINES中启用
+}
+
+// Scrapy项目信息
+type ScrapyProject struct {
+	Config    map[string]map[string]string `json:"config"`    // scrapy.cfg
+	Settings  []ScrapySetting              `json:"settings"`  // settings.py
+	Spiders   []string                     `json:"spiders"`   // 爬虫名称
+	Items     []ScrapyItem                 `json:"items"`     // items.py
+	Pipelines []ScrapyPipeline             `json:"pipelines"` // pipelines.py及ITEM_PIPELINES
+}
+
+// settings.py中一项设置所在的行
+type scrapySettingSpan struct {
+	ScrapySetting
+	Start int // 起始行
+	End   int // 结束行（包含）
+}
+
+var (
+	scrapySettingRegex  = regexp.MustCompile(`^([A-Z][A-Z0-9_]*)\s*=\s*(.*)$`)
+	scrapyItemRegex     = regexp.MustCompile(`^class\s+(\w+)\s*\(([^)]*)\)\s*:`)
+	scrapyFieldRegex    = regexp.MustCompile(`^\s+(\w+)\s*=\s*(scrapy\.)?Field\(`)
+	scrapyPipelineRegex = regexp.MustCompile(`['"]([\w.]+)['"]\s*:\s*(\d+)`)
+)
+
+// 读取scrapy.cfg
+func ParseScrapyConfig(dir string) (map[string]map[string]string, error) {
+	f, err := os.Open(filepath.Join(dir, "scrapy.cfg"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	config := map[string]map[string]string{}
+	section := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if isScrapyConfigBlank(line) {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			if config[section] == nil {
+				config[section] = map[string]string{}
+			}
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 || section == "" {
+			continue
+		}
+		config[section][strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return config, scanner.Err()
+}
+
+// 更新scrapy.cfg：修改已有的键，新增的键追加到所在节的末尾，值为空时删除该键
+func UpdateScrapyConfig(dir string, config map[string]map[string]string) error {
+	path := filepath.Join(dir, "scrapy.cfg")
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(string(data), "\n")
+
+	// 已处理的键
+	done := map[string]map[string]bool{}
+	for section := range config {
+		done[section] = map[string]bool{}
+	}
+
+	var result []string
+	section := ""
+	flush := func() {
+		// 追加该节中新增的键（位于该节末尾的空行及注释之前，这些注释通常属于下一节）
+		blanks := 0
+		for blanks < len(result) && isScrapyConfigBlank(result[len(result)-1-blanks]) {
+			blanks++
+		}
+		tail := append([]string{}, result[len(result)-blanks:]...)
+		result = result[:len(result)-blanks]
+		for _, key := range sortedKeys(config[section]) {
+			if !done[section][key] && config[section][key] != "" {
+				result = append(result, key+" = "+config[section][key])
+				done[section][key] = true
+			}
+		}
+		result = append(result, tail...)
+	}
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]") {
+			flush()
+			section = strings.TrimSpace(trimmed[1 : len(trimmed)-1])
+			result = append(result, line)
+			continue
+		}
+		parts := strings.SplitN(trimmed, "=", 2)
+		if section != "" && len(parts) == 2 && !strings.HasPrefix(trimmed, "#") && !strings.HasPrefix(trimmed, ";") {
+			key := strings.TrimSpace(parts[0])
+			if value, ok := config[section][key]; ok {
+				done[section][key] = true
+				if value == "" {
+					continue
+				}
+				result = append(result, key+" = "+value)
+				continue
+			}
+		}
+		result = append(result, line)
+	}
+	flush()
+
+	// 新增的节（保留文件末尾的换行）
+	trailing := len(result) > 0 && result[len(result)-1] == ""
+	if trailing {
+		result = result[:len(result)-1]
+	}
+	for _, s := range sortedKeys(config) {
+		var added []string
+		for _, key := range sortedKeys(config[s]) {
+			if !done[s][key] && config[s][key] != "" {
+				added = append(added, key+" = "+config[s][key])
+			}
+		}
+		if len(added) > 0 {
+			result = append(result, "", "["+s+"]")
+			result = append(result, added...)
+		}
+	}
+	if trailing {
+		result = append(result, "")
+	}
+
+	return ioutil.WriteFile(path, []byte(strings.Join(result, "\n")), os.ModePerm)
+}
+
+// scrapy.cfg中的空行或注释
+func isScrapyConfigBlank(line string) bool {
+	line = strings.TrimSpace(line)
+	return line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";")
+}
+
+func sortedKeys(m interface{}) (keys []string) {
+	switch v := m.(type) {
+	case map[string]string:
+		for key := range v {
+			keys = append(keys, key)
+		}
+	case map[string]map[string]string:
+		for key := range v {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// settings模块（scrapy.cfg中[settings] default的值，如 jd.settings）
+func GetScrapySettingsModule(dir string) string {
+	config, err := ParseScrapyConfig(dir)
+	if err != nil {
+		return ""
+	}
+	return config["settings"]["default"]
+}
+
+// 模块路径对应的文件路径
+func getPythonModulePath(dir string, module string) string {
+	return filepath.Join(dir, filepath.FromSlash(strings.Replace(module, ".", "/", -1))+".py")
+}
+
+// Python代码中括号的嵌套层数变化（忽略字符串和注释中的括号）
+func bracketDelta(line string) int {
+	delta := 0
+	var quote rune
+	escaped := false
+	for _, ch := range line {
+		if quote != 0 {
+			if escaped {
+				escaped = false
+			} else if ch == '\\' {
+				escaped = true
+			} else if ch == quote {
+				quote = 0
+			}
+			continue
+		}
+		switch ch {
+		case '\'', '"':
+			quote = ch
+		case '#':
+			return delta
+		case '(', '[', '{':
+			delta++
+		case ')', ']', '}':
+			delta--
+		}
+	}
+	return delta
+}
+
+// 去掉行末注释
+func stripComment(line string) string {
+	var quote rune
+	escaped := false
+	for i, ch := range line {
+		if quote != 0 {
+			if escaped {
+				escaped = false
+			} else if ch == '\\' {
+				escaped = true
+			} else if ch == quote {
+				quote = 0
+			}
+			continue
+		}
+		switch ch {
+		case '\'', '"':
+			quote = ch
+		case '#':
+			return strings.TrimRight(line[:i], " \t")
+		}
+	}
+	return line
+}
+
+// 解析settings.py中的顶层大写设置项（支持跨行的列表、字典）
+func parseScrapySettings(lines []string) (spans []scrapySettingSpan) {
+	for i := 0; i < len(lines); i++ {
+		m := scrapySettingRegex.FindStringSubmatch(lines[i])
+		if m == nil {
+			continue
+		}
+		start := i
+		valueLines := []string{stripComment(m[2])}
+		depth := bracketDelta(m[2])
+		for depth > 0 && i+1 < len(lines) {
+			i++
+			depth += bracketDelta(lines[i])
+			// 跳过只有注释的行
+			if strings.HasPrefix(strings.TrimSpace(lines[i]), "#") {
+				continue
+			}
+			valueLines = append(valueLines, stripComment(lines[i]))
+		}
+		spans = append(spans, scrapySettingSpan{
+			ScrapySetting: ScrapySetting{
+				Key:   m[1],
+				Value: strings.TrimSpace(strings.Join(valueLines, "\n")),
+			},
+			Start: start,
+			End:   i,
+		})
+	}
+	return spans
+}
+
+// settings.py路径
+func GetScrapySettingsPath(dir string) (string, error) {
+	module := GetScrapySettingsModule(dir)
+	if module == "" {
+		return "", errors.New("settings module not found in scrapy.cfg")
+	}
+	return getPythonModulePath(dir, module), nil
+}
+
+// 读取settings.py中的设置项
+func GetScrapySettings(dir string) ([]ScrapySetting, error) {
+	path, err := GetScrapySettingsPath(dir)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	settings := []ScrapySetting{}
+	for _, span := range parseScrapySettings(strings.Split(string(data), "\n")) {
+		settings = append(settings, span.ScrapySetting)
+	}
+	return settings, nil
+}
+
+// 更新settings.py：修改已有的设置项（保留其余代码和注释），新增的设置项追加到文件末尾，值为空时删除该设置项
+func UpdateScrapySettings(dir string, settings []ScrapySetting) error {
+	path, err := GetScrapySettingsPath(dir)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(string(data), "\n")
+
+	values := map[string]string{}
+	for _, s := range settings {
+		if scrapySettingRegex.FindStringSubmatch(s.Key+" = 0") == nil {
+			return errors.New("invalid setting key: " + s.Key)
+		}
+		values[s.Key] = s.Value
+	}
+
+	// 替换已有的设置项
+	spanMap := map[int]scrapySettingSpan{}
+	done := map[string]bool{}
+	for _, span := range parseScrapySettings(lines) {
+		if _, ok := values[span.Key]; ok {
+			spanMap[span.Start] = span
+		}
+	}
+	var result []string
+	for i := 0; i < len(lines); i++ {
+		span, ok := spanMap[i]
+		if !ok {
+			result = append(result, lines[i])
+			continue
+		}
+		if value := values[span.Key]; value != "" && !done[span.Key] {
+			result = append(result, span.Key+" = "+value)
+		}
+		done[span.Key] = true
+		i = span.End
+	}
+
+	// 追加新增的设置项（保留文件末尾的换行）
+	trailing := len(result) > 0 && result[len(result)-1] == ""
+	if trailing {
+		result = result[:len(result)-1]
+	}
+	for _, s := range settings {
+		if !done[s.Key] && s.Value != "" {
+			result = append(result, s.Key+" = "+s.Value)
+			done[s.Key] = true
+		}
+	}
+	if trailing {
+		result = append(result, "")
+	}
+
+	return ioutil.WriteFile(path, []byte(strings.Join(result, "\n")), os.ModePerm)
+}
+
+// 解析items.py中的Item类及其字段
+func GetScrapyItems(pkgDir string) []ScrapyItem {
+	items := []ScrapyItem{}
+	data, err := ioutil.ReadFile(filepath.Join(pkgDir, "items.py"))
+	if err != nil {
+		return items
+	}
+	var cur *ScrapyItem
+	for _, line := range strings.Split(string(data), "\n") {
+		if m := scrapyItemRegex.FindStringSubmatch(line); m != nil {
+			if strings.Contains(m[2], "Item") {
+				items = append(items, ScrapyItem{Name: m[1], Fields: []string{}})
+				cur = &items[len(items)-1]
+			} else {
+				cur = nil
+			}
+			continue
+		}
+		if cur == nil {
+			continue
+		}
+		if m := scrapyFieldRegex.FindStringSubmatch(line); m != nil {
+			cur.Fields = append(cur.Fields, m[1])
+		}
+	}
+	return items
+}
+
+// 解析pipelines.py中的管道类，以及ITEM_PIPELINES中启用的管道
+func GetScrapyPipelines(pkgDir string, settings []ScrapySetting) []ScrapyPipeline {
+	pipelines := []ScrapyPipeline{}
+	index := map[string]int{}
+
+	// pipelines.py中定义的类
+	pkg := filepath.Base(pkgDir)
+	data, err := ioutil.ReadFile(filepath.Join(pkgDir, "pipelines.py"))
+	if err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			if m := scrapyItemRegex.FindStringSubmatch(line); m != nil {
+				name := pkg + ".pipelines." + m[1]
+				index[name] = len(pipelines)
+				pipelines = append(pipelines, ScrapyPipeline{Name: name})
+			}
+		}
+	}
+
+	// ITEM_PIPELINES中启用的管道
+	for _, s := range settings {
+		if s.Key != "ITEM_PIPELINES" {
+			continue
+		}
+		for _, m := range scrapyPipelineRegex.FindAllStringSubmatch(s.Value, -1) {
+			priority, _ := strconv.Atoi(m[2])
+			if i, ok := index[m[1]]; ok {
+				pipelines[i].Priority = priority
+				pipelines[i].Enabled = true
+				continue
+			}
+			index[m[1]] = len(pipelines)
+			pipelines = append(pipelines, ScrapyPipeline{Name: m[1], Priority: priority, Enabled: true})
+		}
+	}
+	return pipelines
+}
+
+// 获取Scrapy项目信息
+func GetScrapyProject(spider model.Spider) (project ScrapyProject, err error) {
+	dir := spider.Src
+	if project.Config, err = ParseScrapyConfig(dir); err != nil {
+		return project, errors.Wrap(err, "not a scrapy project")
+	}
+	if project.Settings, err = GetScrapySettings(dir); err != nil {
+		return project, err
+	}
+	pkgDir := getScrapyPackageDir(dir)
+	project.Spiders = GetScrapySpiderNames(dir)
+	project.Items = GetScrapyItems(pkgDir)
+	project.Pipelines = GetScrapyPipelines(pkgDir, project.Settings)
+	return project, nil
+}
+
+// Crawlab标准结果管道：将结果按批POST到结果写入接口（CRAWLAB_RESULTS_URL），通过任务令牌校验
+// 结果经过结果写入接口，按爬虫配置写入结果储存并去重，爬虫进程不需要数据库的连接信息
+const crawlabPipelineCode = `# -*- coding: utf-8 -*-
+# Generated by Crawlab, do not edit.
+import json
+import os
+
+try:
+    from urllib.request import Request, urlopen
+except ImportError:
+    from urllib2 import Request, urlopen
+
+
+class CrawlabResultsPipeline(object):
+    batch_size = 100
+
+    def open_spider(self, spider):
+        self.url = os.environ.get('CRAWLAB_RESULTS_URL')
+        self.token = os.environ.get('CRAWLAB_TASK_TOKEN')
+        self.items = []
+
+    def close_spider(self, spider):
+        self.flush()
+
+    def process_item(self, item, spider):
+        self.items.append(dict(item))
+        if len(self.items) >= self.batch_size:
+            self.flush()
+        return item
+
+    def flush(self):
+        if not self.items or not self.url:
+            return
+        data = json.dumps(self.items, default=str).encode('utf-8')
+        self.items = []
+        req = Request(self.url, data=data, headers={
+            'Content-Type': 'application/json',
+            'Authorization': 'Bearer ' + (self.token or ''),
+        })
+        urlopen(req, timeout=60).close()
+`
+
+// 包装项目settings模块，在ITEM_PIPELINES中加入Crawlab标准结果管道
+const crawlabSettingsTemplate = `# -*- coding: utf-8 -*-
+# Generated by Crawlab, do not edit.
+from {{module}} import *
+
+ITEM_PIPELINES = dict(globals().get('ITEM_PIPELINES') or {})
+ITEM_PIPELINES['crawlab_pipeline.CrawlabResultsPipeline'] = 999
+`
+
+// 生成注入结果管道所需的Python模块，返回执行任务时需要的环境变量
+// 通过SCRAPY_SETTINGS_MODULE指向包装后的settings模块，不修改项目代码
+func GetScrapyPipelineEnvs(spider model.Spider) ([]string, error) {
+	dir := GetLocalSpiderDir(spider)
+	module := GetScrapySettingsModule(dir)
+	if module == "" {
+		return nil, errors.New("settings module not found in scrapy.cfg")
+	}
+
+	// 生成模块
+	pyPath := filepath.Join(GetDependencyEnvPath(spider), "scrapy")
+	if err := os.MkdirAll(pyPath, os.ModePerm); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(filepath.Join(pyPath, "crawlab_pipeline.py"), []byte(crawlabPipelineCode), os.ModePerm); err != nil {
+		return nil, err
+	}
+	settingsCode := strings.Replace(crawlabSettingsTemplate, "{{module}}", module, 1)
+	if err := ioutil.WriteFile(filepath.Join(pyPath, "crawlab_settings.py"), []byte(settingsCode), os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	return []string{
+		"SCRAPY_SETTINGS_MODULE=crawlab_settings",
+		"PYTHONPATH=" + strings.Join([]string{pyPath, dir}, string(os.PathListSeparator)),
+	}, nil
+}
@@ -0,0 +1,206 @@
+package services
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// 复制测试用的Scrapy项目到临时目录
+func newTestScrapyProject(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "crawlab-scrapy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"scrapy.cfg", filepath.Join("demo", "settings.py")} {
+		data, err := ioutil.ReadFile(filepath.Join("testdata", "scrapy", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir, func() {
+		_ = os.RemoveAll(dir)
+	}
+}
+
+func readTestFile(t *testing.T, path string) string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestParseScrapyConfig(t *testing.T) {
+	dir, cleanup := newTestScrapyProject(t)
+	defer cleanup()
+
+	config, err := ParseScrapyConfig(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]map[string]string{
+		"settings": {"default": "demo.settings"},
+		"deploy":   {"project": "demo"},
+	}
+	if !reflect.DeepEqual(config, want) {
+		t.Fatalf("ParseScrapyConfig = %v, want %v", config, want)
+	}
+	if module := GetScrapySettingsModule(dir); module != "demo.settings" {
+		t.Errorf("GetScrapySettingsModule = %s", module)
+	}
+	if path, err := GetScrapySettingsPath(dir); err != nil || path != filepath.Join(dir, "demo", "settings.py") {
+		t.Errorf("GetScrapySettingsPath = %s, %v", path, err)
+	}
+
+	if _, err := ParseScrapyConfig(filepath.Join(dir, "demo")); err == nil {
+		t.Errorf("expected error without scrapy.cfg")
+	}
+}
+
+func TestUpdateScrapyConfig(t *testing.T) {
+	dir, cleanup := newTestScrapyProject(t)
+	defer cleanup()
+
+	err := UpdateScrapyConfig(dir, map[string]map[string]string{
+		"settings": {"default": "demo.settings_prod", "extra": "1"},
+		"deploy":   {"project": ""},
+		"scrapyd":  {"url": "http://localhost:6800/"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 注释保留，新增的键在所在节末尾，新增的节在文件末尾
+	want := `# Automatically created by: scrapy startproject
+#
+# For more information about the [deploy] section see:
+# https://scrapyd.readthedocs.io/en/latest/deploy.html
+
+[settings]
+default = demo.settings_prod
+extra = 1
+
+; scrapyd deploy target
+[deploy]
+#url = http://localhost:6800/
+
+[scrapyd]
+url = http://localhost:6800/`
+	if got := readTestFile(t, filepath.Join(dir, "scrapy.cfg")); got != want+"\n" {
+		t.Fatalf("scrapy.cfg =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestGetScrapySettings(t *testing.T) {
+	dir, cleanup := newTestScrapyProject(t)
+	defer cleanup()
+
+	settings, err := GetScrapySettings(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 注释掉的及缩进的设置项不解析，行末注释去掉，跨行的字典保留原文
+	want := []ScrapySetting{
+		{Key: "BOT_NAME", Value: "'demo'"},
+		{Key: "SPIDER_MODULES", Value: "['demo.spiders']"},
+		{Key: "NEWSPIDER_MODULE", Value: "'demo.spiders'"},
+		{Key: "USER_AGENT", Value: "'demo (+http://www.example.com) # not a comment'"},
+		{Key: "ROBOTSTXT_OBEY", Value: "True"},
+		{Key: "DEFAULT_REQUEST_HEADERS", Value: `{
+    'Accept': 'text/html,application/xhtml+xml',
+    'X-Brackets': '({[',
+    "X-Quote": 'it\'s',
+}`},
+		{Key: "ITEM_PIPELINES", Value: `{
+    'demo.pipelines.DemoPipeline': 300,
+    'demo.pipelines.MongoPipeline': 400,
+}`},
+		{Key: "FEED_EXPORT_ENCODING", Value: `"utf-8"`},
+	}
+	if !reflect.DeepEqual(settings, want) {
+		t.Fatalf("GetScrapySettings =\n%q\nwant\n%q", settings, want)
+	}
+
+	pipelines := GetScrapyPipelines(filepath.Join(dir, "demo"), settings)
+	wantPipelines := []ScrapyPipeline{
+		{Name: "demo.pipelines.DemoPipeline", Priority: 300, Enabled: true},
+		{Name: "demo.pipelines.MongoPipeline", Priority: 400, Enabled: true},
+	}
+	if !reflect.DeepEqual(pipelines, wantPipelines) {
+		t.Errorf("GetScrapyPipelines = %v, want %v", pipelines, wantPipelines)
+	}
+}
+
+func TestUpdateScrapySettings(t *testing.T) {
+	dir, cleanup := newTestScrapyProject(t)
+	defer cleanup()
+	path := filepath.Join(dir, "demo", "settings.py")
+	original := readTestFile(t, path)
+
+	err := UpdateScrapySettings(dir, []ScrapySetting{
+		{Key: "USER_AGENT", Value: `'crawlab "bot" # v1'`},
+		{Key: "DEFAULT_REQUEST_HEADERS", Value: "{\n    'Accept': 'application/json',\n}"},
+		{Key: "ITEM_PIPELINES", Value: ""},
+		{Key: "CONCURRENT_REQUESTS", Value: "32"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := readTestFile(t, path)
+
+	// 修改的设置项替换整个跨行的值，其余代码和注释保留
+	want := strings.Replace(original, `USER_AGENT = 'demo (+http://www.example.com) # not a comment'`, `USER_AGENT = 'crawlab "bot" # v1'`, 1)
+	want = strings.Replace(want, `DEFAULT_REQUEST_HEADERS = {
+    'Accept': 'text/html,application/xhtml+xml',  # html only
+    # 'Accept-Language': 'en',
+    'X-Brackets': '({[',
+    "X-Quote": 'it\'s',
+}`, `DEFAULT_REQUEST_HEADERS = {
+    'Accept': 'application/json',
+}`, 1)
+	// 值为空时删除设置项
+	want = strings.Replace(want, `ITEM_PIPELINES = {
+    'demo.pipelines.DemoPipeline': 300,
+    'demo.pipelines.MongoPipeline': 400,
+}
+`, "", 1)
+	// 新增的设置项追加到文件末尾
+	want += "CONCURRENT_REQUESTS = 32\n"
+	if got != want {
+		t.Fatalf("settings.py =\n%s\nwant\n%s", got, want)
+	}
+
+	// 修改后重新解析
+	settings, err := GetScrapySettings(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := map[string]string{}
+	for _, s := range settings {
+		values[s.Key] = s.Value
+	}
+	if values["USER_AGENT"] != `'crawlab "bot" # v1'` || values["CONCURRENT_REQUESTS"] != "32" || values["ROBOTSTXT_OBEY"] != "True" {
+		t.Errorf("settings after update = %v", values)
+	}
+	if _, ok := values["ITEM_PIPELINES"]; ok {
+		t.Errorf("ITEM_PIPELINES should be removed")
+	}
+
+	// 设置项名称不合法
+	for _, key := range []string{"lower", "A-B", "X = 1\nimport os\nY"} {
+		if err := UpdateScrapySettings(dir, []ScrapySetting{{Key: key, Value: "1"}}); err == nil {
+			t.Errorf("UpdateScrapySettings(%q) expected error", key)
+		}
+	}
+}
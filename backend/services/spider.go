@@ -8,6 +8,7 @@ import (
 	"crawlab/utils"
 	"encoding/json"
 	"github.com/apex/log"
+	"github.com/globalsign/mgo"
 	"github.com/globalsign/mgo/bson"
 	"github.com/pkg/errors"
 	uuid "github.com/satori/go.uuid"
@@ -20,6 +21,9 @@ import (
 	"strings"
 )
 
+// 默认保留的爬虫版本数
+const DefaultMaxSpiderVersions = 10
+
 type SpiderFileData struct {
 	FileName string
 	File     []byte
@@ -28,6 +32,8 @@ type SpiderFileData struct {
 type SpiderUploadMessage struct {
 	FileId   string
 	FileName string
+	SpiderId string
+	Hash     string
 }
 
 // 从项目目录中获取爬虫列表
@@ -65,13 +71,20 @@ func GetSpidersFromDir() ([]model.Spider, error) {
 			continue
 		}
 
+		// 检测项目类型，推断执行命令
+		src := filepath.Join(srcPath, item.Name())
+		d := DetectSpider(src)
+
 		// 构造爬虫
 		spider := model.Spider{
-			Name:        item.Name(),
-			DisplayName: item.Name(),
-			Type:        constants.Customized,
-			Src:         filepath.Join(srcPath, item.Name()),
-			FileId:      bson.ObjectIdHex(constants.ObjectIdNull),
+			Name:          item.Name(),
+			DisplayName:   item.Name(),
+			Type:          constants.Customized,
+			Src:           src,
+			FileId:        bson.ObjectIdHex(constants.ObjectIdNull),
+			Cmd:           d.Cmd,
+			Framework:     d.Framework,
+			ScrapySpiders: d.ScrapySpiders,
 		}
 
 		// 将爬虫加入列表
@@ -101,7 +114,23 @@ func SaveSpiders(spiders []model.Spider) error {
 				return err
 			}
 		} else {
-			// 存在
+			// 存在，更新检测到的项目类型和Scrapy爬虫列表，执行命令为空时补充
+			update := bson.M{}
+			if spider_.Framework != spider.Framework {
+				update["framework"] = spider.Framework
+			}
+			if strings.Join(spider_.ScrapySpiders, ",") != strings.Join(spider.ScrapySpiders, ",") {
+				update["scrapy_spiders"] = spider.ScrapySpiders
+			}
+			if spider_.Cmd == "" && spider.Cmd != "" {
+				update["cmd"] = spider.Cmd
+			}
+			if len(update) > 0 {
+				if err := c.UpdateId(spider_.Id, bson.M{"$set": update}); err != nil {
+					debug.PrintStack()
+					return err
+				}
+			}
 		}
 	}
 
@@ -184,13 +213,6 @@ func UploadToGridFs(spider model.Spider, fileName string, filePath string) (fid
 	s, gf := database.GetGridFs("files")
 	defer s.Close()
 
-	// 如果存在FileId删除GridFS上的老文件
-	if !utils.IsObjectIdNull(spider.FileId) {
-		if err = gf.RemoveId(spider.FileId); err != nil {
-			debug.PrintStack()
-		}
-	}
-
 	// 创建一个新GridFS文件
 	f, err := gf.Create(fileName)
 	if err != nil {
@@ -242,34 +264,120 @@ func PublishAllSpidersJob() {
 	}
 }
 
-// 发布爬虫
-// 1. 将源文件夹打包为zip文件
-// 2. 上传zip文件到GridFS
-// 3. 发布消息给工作节点
+// 发布爬虫，内容哈希未变化时跳过
 func PublishSpider(spider model.Spider) (err error) {
-	// 将源文件夹打包为zip文件
-	filePath, err := ZipSpider(spider)
+	// 如果源文件夹不存在，抛错
+	if !utils.Exists(spider.Src) {
+		return errors.New("source path does not exist")
+	}
+
+	// 计算源文件夹内容哈希
+	files, err := GetDirManifest(spider.Src)
 	if err != nil {
 		return err
 	}
+	if spider.VersionId != "" && HashManifest(files) == spider.Hash {
+		return nil
+	}
+
+	return PublishSpiderVersion(spider, constants.UploaderSystem, "")
+}
 
-	// 上传zip文件到GridFS
-	fileName := filepath.Base(spider.Src) + ".zip"
-	fid, err := UploadToGridFs(spider, fileName, filePath)
+// 发布爬虫版本
+// 1. 计算源文件夹内容哈希，如果已存在相同哈希的版本，直接发布该版本
+// 2. 将源文件夹打包为zip文件，按内容哈希上传各个文件
+// 3. 上传zip文件到GridFS，并保存为新版本
+// 4. 发布消息给工作节点
+func PublishSpiderVersion(spider model.Spider, uploader string, changelog string) (err error) {
+	// 如果源文件夹不存在，抛错
+	if !utils.Exists(spider.Src) {
+		return errors.New("source path does not exist")
+	}
+
+	// 计算源文件夹内容哈希
+	files, err := GetDirManifest(spider.Src)
 	if err != nil {
 		return err
 	}
+	hash := HashManifest(files)
+
+	// 获取相同哈希的版本，不存在则生成新版本
+	version, err := model.GetSpiderVersionByHash(spider.Id, hash)
+	if err == mgo.ErrNotFound {
+		// 按内容哈希上传各个文件，供工作节点增量同步
+		if err := UploadSpiderFiles(spider.Src, files); err != nil {
+			return err
+		}
+
+		// 将源文件夹打包为zip文件
+		filePath, err := ZipSpider(spider)
+		if err != nil {
+			return err
+		}
+
+		// 上传zip文件到GridFS
+		fileName := filepath.Base(spider.Src) + ".zip"
+		fid, err := UploadToGridFs(spider, fileName, filePath)
+		if err != nil {
+			return err
+		}
+
+		// Git爬虫记录提交SHA
+		var commitSha string
+		if spider.GitUrl != "" && IsGitRepo(spider.Src) {
+			if commitSha, err = GetGitCommit(spider); err != nil {
+				return err
+			}
+		}
 
-	// 保存FileId
-	spider.FileId = fid
+		// 保存版本
+		version = model.SpiderVersion{
+			SpiderId:  spider.Id,
+			FileId:    fid,
+			Hash:      hash,
+			Uploader:  uploader,
+			Changelog: changelog,
+			CommitSha: commitSha,
+			Files:     files,
+		}
+		if err := version.Add(); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	// 保存当前版本
+	spider.FileId = version.FileId
+	spider.VersionId = version.Id
+	spider.Hash = version.Hash
+	spider.CommitSha = version.CommitSha
 	if err := spider.Save(); err != nil {
 		return err
 	}
 
+	// 主节点直接使用源码目录，上报部署状态
+	spiderSyncLock.Lock()
+	ReportDeployment(spider, SpiderManifest{VersionId: version.Id, Hash: version.Hash}, nil)
+	spiderSyncLock.Unlock()
+
+	// 清理旧版本
+	if err := PruneSpiderVersions(spider); err != nil {
+		log.Errorf(err.Error())
+		debug.PrintStack()
+	}
+
 	// 发布消息给工作节点
+	return PublishSpiderMessage(spider)
+}
+
+// 发布消息给工作节点，通知其下载爬虫当前版本
+func PublishSpiderMessage(spider model.Spider) (err error) {
 	msg := SpiderUploadMessage{
-		FileId:   fid.Hex(),
-		FileName: fileName,
+		FileId:   spider.FileId.Hex(),
+		FileName: filepath.Base(spider.Src) + ".zip",
+		SpiderId: spider.Id.Hex(),
+		Hash:     spider.Hash,
 	}
 	msgStr, err := json.Marshal(msg)
 	if err != nil {
@@ -285,25 +393,64 @@ func PublishSpider(spider model.Spider) (err error) {
 	return
 }
 
-// 上传爬虫回调
-func OnFileUpload(channel string, msgStr string) {
+// 清理旧版本，仅保留最近N个版本（当前版本始终保留）
+func PruneSpiderVersions(spider model.Spider) error {
+	maxVersions := viper.GetInt("spider.versions")
+	if maxVersions <= 0 {
+		maxVersions = DefaultMaxSpiderVersions
+	}
+
+	versions, err := model.GetSpiderVersionList(spider.Id)
+	if err != nil {
+		return err
+	}
+	if len(versions) <= maxVersions {
+		return nil
+	}
+
+	// 获取MongoDB GridFS连接
 	s, gf := database.GetGridFs("files")
 	defer s.Close()
 
-	// 反序列化消息
-	var msg SpiderUploadMessage
-	if err := json.Unmarshal([]byte(msgStr), &msg); err != nil {
-		log.Errorf(err.Error())
-		debug.PrintStack()
-		return
+	removed := false
+	for _, version := range versions[maxVersions:] {
+		if version.Id == spider.VersionId {
+			continue
+		}
+
+		// 删除GridFS上的文件
+		if err := gf.RemoveId(version.FileId); err != nil && err != mgo.ErrNotFound {
+			log.Errorf(err.Error())
+			debug.PrintStack()
+		}
+
+		// 删除版本
+		if err := model.RemoveSpiderVersion(version.Id); err != nil {
+			return err
+		}
+		removed = true
+	}
+
+	// 删除不再被引用的文件
+	if removed {
+		if err := PruneSpiderFiles(); err != nil {
+			return err
+		}
 	}
 
+	return nil
+}
+
+// 从GridFS下载文件到临时文件
+func DownloadFromGridFs(fileId bson.ObjectId) (tmpFilePath string, err error) {
+	s, gf := database.GetGridFs("files")
+	defer s.Close()
+
 	// 从GridFS获取该文件
-	f, err := gf.OpenId(bson.ObjectIdHex(msg.FileId))
+	f, err := gf.OpenId(fileId)
 	if err != nil {
-		log.Errorf(err.Error())
 		debug.PrintStack()
-		return
+		return "", err
 	}
 	defer f.Close()
 
@@ -311,35 +458,134 @@ func OnFileUpload(channel string, msgStr string) {
 	randomId := uuid.NewV4()
 
 	// 创建临时文件
-	tmpFilePath := filepath.Join(viper.GetString("other.tmppath"), randomId.String()+".zip")
+	tmpFilePath = filepath.Join(viper.GetString("other.tmppath"), randomId.String()+".zip")
 	tmpFile, err := os.OpenFile(tmpFilePath, os.O_CREATE|os.O_WRONLY, os.ModePerm)
 	if err != nil {
-		log.Errorf(err.Error())
 		debug.PrintStack()
-		return
+		return "", err
 	}
 	defer tmpFile.Close()
 
 	// 将该文件写入临时文件
 	if _, err := io.Copy(tmpFile, f); err != nil {
+		debug.PrintStack()
+		return tmpFilePath, err
+	}
+
+	return tmpFilePath, nil
+}
+
+// 回滚爬虫到指定版本
+// 1. 从GridFS下载该版本（Git爬虫切换到该版本的提交），覆盖主节点上的源文件夹（避免定时发布重新生成新版本）
+// 2. 保存当前版本
+// 3. 发布消息给工作节点
+// 解压爬虫版本文件并替换源文件夹（zip文件内包含源文件夹名称）
+// 先解压到源文件夹旁的隐藏临时目录，与源文件夹位于同一文件系统，以便重命名
+func extractSpiderVersion(filePath string, dstPath string) error {
+	tmpDir := filepath.Join(filepath.Dir(dstPath), ".rollback-"+uuid.NewV4().String())
+	if err := os.MkdirAll(tmpDir, os.ModePerm); err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err := utils.DeCompressByPath(filePath, tmpDir); err != nil {
+		return err
+	}
+
+	root := filepath.Join(tmpDir, filepath.Base(dstPath))
+	if !utils.Exists(root) {
+		return errors.New("spider version file does not contain " + filepath.Base(dstPath))
+	}
+
+	// 替换源文件夹
+	if err := os.RemoveAll(dstPath); err != nil {
+		debug.PrintStack()
+		return err
+	}
+	return os.Rename(root, dstPath)
+}
+
+func RollbackSpider(spider model.Spider, versionId bson.ObjectId) (err error) {
+	// 获取版本
+	version, err := model.GetSpiderVersion(versionId)
+	if err != nil {
+		return err
+	}
+	if version.SpiderId != spider.Id {
+		return errors.New("version does not belong to spider")
+	}
+
+	if spider.GitUrl != "" && version.CommitSha != "" && IsGitRepo(spider.Src) {
+		// Git爬虫切换到该版本的提交，并关闭自动拉取，避免回滚被覆盖
+		if err := CheckoutGitCommit(spider, version.CommitSha); err != nil {
+			return err
+		}
+		spider.GitCommit = version.CommitSha
+		spider.GitPullCron = ""
+	} else {
+		// 下载该版本
+		tmpFilePath, err := DownloadFromGridFs(version.FileId)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmpFilePath)
+
+		// 解压到临时目录并替换源文件夹，解压失败时不影响原目录
+		if err := extractSpiderVersion(tmpFilePath, spider.Src); err != nil {
+			return err
+		}
+	}
+
+	// 保存当前版本
+	spider.FileId = version.FileId
+	spider.VersionId = version.Id
+	spider.Hash = version.Hash
+	spider.CommitSha = version.CommitSha
+	if err := spider.Save(); err != nil {
+		return err
+	}
+
+	// 上报主节点部署状态
+	spiderSyncLock.Lock()
+	ReportDeployment(spider, SpiderManifest{VersionId: version.Id, Hash: version.Hash}, nil)
+	spiderSyncLock.Unlock()
+
+	// 发布消息给工作节点
+	return PublishSpiderMessage(spider)
+}
+
+// 上传爬虫回调
+func OnFileUpload(channel string, msgStr string) {
+	// 反序列化消息
+	var msg SpiderUploadMessage
+	if err := json.Unmarshal([]byte(msgStr), &msg); err != nil {
 		log.Errorf(err.Error())
 		debug.PrintStack()
 		return
 	}
 
+	// 按内容哈希增量同步该爬虫
+	if bson.IsObjectIdHex(msg.SpiderId) {
+		if err := SyncSpiderById(bson.ObjectIdHex(msg.SpiderId)); err != nil {
+			log.Errorf(err.Error())
+			debug.PrintStack()
+		}
+		return
+	}
+
+	// 从GridFS下载该文件到临时文件
+	tmpFilePath, err := DownloadFromGridFs(bson.ObjectIdHex(msg.FileId))
+	if err != nil {
+		log.Errorf(err.Error())
+		return
+	}
+
 	// 解压缩临时文件到目标文件夹
 	dstPath := filepath.Join(
 		viper.GetString("spider.path"),
 		//strings.Replace(msg.FileName, ".zip", "", -1),
 	)
-	if err := utils.DeCompress(tmpFile, dstPath); err != nil {
-		log.Errorf(err.Error())
-		debug.PrintStack()
-		return
-	}
-
-	// 关闭临时文件
-	if err := tmpFile.Close(); err != nil {
+	if err := utils.DeCompressByPath(tmpFilePath, dstPath); err != nil {
 		log.Errorf(err.Error())
 		debug.PrintStack()
 		return
@@ -361,13 +607,18 @@ func InitSpiderService() error {
 	if IsMaster() {
 		// 主节点
 
-		// 每5秒更新一次爬虫信息
-		if _, err := c.AddFunc("*/5 * * * * *", UpdateSpiders); err != nil {
+		// 每5秒更新一次爬虫信息（仅由Leader执行）
+		if _, err := c.AddFunc("*/5 * * * * *", LeaderJob(UpdateSpiders)); err != nil {
+			return err
+		}
+
+		// 每60秒同步爬虫给工作节点（仅由Leader执行）
+		if _, err := c.AddFunc("0 * * * * *", LeaderJob(PublishAllSpidersJob)); err != nil {
 			return err
 		}
 
-		// 每60秒同步爬虫给工作节点
-		if _, err := c.AddFunc("0 * * * * *", PublishAllSpidersJob); err != nil {
+		// 每分钟按Cron表达式自动拉取Git爬虫（仅由Leader执行）
+		if _, err := c.AddFunc("30 * * * * *", LeaderJob(PullGitSpiders)); err != nil {
 			return err
 		}
 	} else {
@@ -378,6 +629,17 @@ func InitSpiderService() error {
 		var sub database.Subscriber
 		sub.Connect()
 		sub.Subscribe(channel, OnFileUpload)
+
+		// 订阅爬虫删除
+		sub.Subscribe("spiders:remove", OnSpiderRemove)
+
+		// 每60秒检查一次爬虫版本，同步错过的更新
+		if _, err := c.AddFunc("30 * * * * *", SyncAllSpiders); err != nil {
+			return err
+		}
+
+		// 启动时同步所有爬虫
+		go SyncAllSpiders()
 	}
 
 	// 启动定时任务
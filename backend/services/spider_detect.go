@@ -0,0 +1,182 @@
+package services
+
+import (
+	"bufio"
+	"crawlab/constants"
+	"crawlab/model"
+	"crawlab/utils"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// 检测到的爬虫项目信息
+type SpiderDetection struct {
+	Framework     string   // 项目类型
+	Cmd           string   // 推断的执行命令
+	ScrapySpiders []string // Scrapy项目中的爬虫名称
+}
+
+var (
+	scrapyClassRegex = regexp.MustCompile(`^class\s+\w+\s*\(`)
+	scrapyNameRegex  = regexp.MustCompile(`^\s+name\s*=\s*['"]([^'"]+)['"]`)
+)
+
+// 检测爬虫项目类型并推断执行命令
+// 1. Scrapy：存在scrapy.cfg
+// 2. Node：存在package.json
+// 3. Go：存在go.mod
+// 4. Node：只有一个.js文件
+// 5. Python：存在.py文件
+func DetectSpider(dir string) (d SpiderDetection) {
+	// Scrapy
+	if utils.Exists(filepath.Join(dir, "scrapy.cfg")) {
+		d.Framework = constants.FrameworkScrapy
+		d.ScrapySpiders = GetScrapySpiderNames(dir)
+		if len(d.ScrapySpiders) > 0 {
+			d.Cmd = "scrapy crawl " + d.ScrapySpiders[0]
+		}
+		return d
+	}
+
+	// Node（package.json）
+	if utils.Exists(filepath.Join(dir, "package.json")) {
+		d.Framework = constants.FrameworkNode
+		d.Cmd = getNodePackageCmd(dir)
+		return d
+	}
+
+	// Go
+	if utils.Exists(filepath.Join(dir, "go.mod")) {
+		d.Framework = constants.FrameworkGo
+		d.Cmd = "go run ."
+		return d
+	}
+
+	// 按顶层文件后缀检测
+	items, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return d
+	}
+	var jsFiles, pyFiles []string
+	for _, item := range items {
+		if item.IsDir() {
+			continue
+		}
+		switch filepath.Ext(item.Name()) {
+		case ".js":
+			jsFiles = append(jsFiles, item.Name())
+		case ".py":
+			pyFiles = append(pyFiles, item.Name())
+		}
+	}
+
+	// Node（单个.js文件）
+	if len(jsFiles) == 1 {
+		d.Framework = constants.FrameworkNode
+		d.Cmd = "node " + jsFiles[0]
+		return d
+	}
+
+	// Python：优先main.py、与目录同名的文件，否则取第一个
+	if len(pyFiles) > 0 {
+		d.Framework = constants.FrameworkPython
+		main := pyFiles[0]
+		for _, name := range []string{"main.py", filepath.Base(dir) + ".py"} {
+			if utils.Exists(filepath.Join(dir, name)) {
+				main = name
+				break
+			}
+		}
+		d.Cmd = "python " + main
+		return d
+	}
+
+	return d
+}
+
+// package.json中有start脚本时使用npm start，否则执行main字段指定的文件
+func getNodePackageCmd(dir string) string {
+	var pkg struct {
+		Main    string            `json:"main"`
+		Scripts map[string]string `json:"scripts"`
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "package.json"))
+	if err == nil {
+		_ = json.Unmarshal(data, &pkg)
+	}
+	if pkg.Scripts["start"] != "" {
+		return "npm start"
+	}
+	if pkg.Main != "" {
+		return "node " + pkg.Main
+	}
+	return "node index.js"
+}
+
+// Scrapy项目的包目录（scrapy.cfg中[settings] default = <包名>.settings）
+func getScrapyPackageDir(dir string) string {
+	module := GetScrapySettingsModule(dir)
+	if module == "" {
+		return ""
+	}
+	return filepath.Join(dir, strings.Split(module, ".")[0])
+}
+
+// 获取Scrapy项目中的爬虫名称（解析spiders目录下各个爬虫类的name属性）
+func GetScrapySpiderNames(dir string) []string {
+	pkgDir := getScrapyPackageDir(dir)
+	if pkgDir == "" {
+		return nil
+	}
+
+	names := []string{}
+	_ = filepath.Walk(filepath.Join(pkgDir, "spiders"), func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || filepath.Ext(path) != ".py" {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return nil
+		}
+		defer f.Close()
+
+		// 类定义之后的第一个name属性
+		inClass := false
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if scrapyClassRegex.MatchString(line) {
+				inClass = true
+				continue
+			}
+			if !inClass {
+				continue
+			}
+			if m := scrapyNameRegex.FindStringSubmatch(line); m != nil {
+				names = append(names, m[1])
+				inClass = false
+			}
+		}
+		return nil
+	})
+	sort.Strings(names)
+	return names
+}
+
+// 运行目标是否为Scrapy项目中的爬虫
+func IsValidSpiderTarget(spider model.Spider, target string) bool {
+	if spider.Framework != constants.FrameworkScrapy {
+		return false
+	}
+	for _, name := range spider.ScrapySpiders {
+		if name == target {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,261 @@
+package services
+
+import (
+	"crawlab/constants"
+	"crawlab/database"
+	"crawlab/model"
+	"crawlab/sinks"
+	"crawlab/utils"
+	"encoding/json"
+	"github.com/apex/log"
+	"github.com/globalsign/mgo/bson"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+	"os"
+	"runtime/debug"
+	"strings"
+)
+
+var ErrSpiderInWorkflow = errors.New("spider is used by workflows")
+
+// 删除爬虫的选项
+type SpiderRemoveOptions struct {
+	KeepTasks bool // 保留历史任务及其结果
+	DropCol   bool // 删除整个结果集（结果集可能被多个爬虫共用，默认不删除）
+}
+
+// 删除爬虫消息，工作节点据此删除本地爬虫目录（此时爬虫已从数据库删除，因此需带上名称）
+type SpiderRemoveMessage struct {
+	SpiderId string
+	Name     string
+}
+
+// 引用该爬虫的工作流名称
+func getSpiderWorkflowNames(spiderId bson.ObjectId) ([]string, error) {
+	workflows, err := model.GetWorkflowList(bson.M{"steps.spider_id": spiderId})
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, wf := range workflows {
+		names = append(names, wf.Name)
+	}
+	return names, nil
+}
+
+// 级联删除爬虫（爬虫被工作流引用时不允许删除，需先从工作流中移除相应步骤，直接删除步骤会破坏下游步骤的依赖）
+// 1. 删除主节点上的爬虫目录（失败时中止，避免UpdateSpiders根据残留目录重新创建爬虫）
+// 2. 从数据库删除爬虫
+// 3. 删除定时任务、触发器、历史任务及其结果（可选）、结果集（可选）
+// 4. 删除各个版本及GridFS文件、部署状态
+// 5. 通知工作节点删除本地爬虫目录
+func RemoveSpider(spider model.Spider, opts SpiderRemoveOptions) error {
+	// 检查工作流引用
+	names, err := getSpiderWorkflowNames(spider.Id)
+	if err != nil {
+		return err
+	}
+	if len(names) > 0 {
+		return errors.Wrap(ErrSpiderInWorkflow, strings.Join(names, ", "))
+	}
+
+	// 删除爬虫目录及依赖环境
+	if err := os.RemoveAll(spider.Src); err != nil {
+		debug.PrintStack()
+		return err
+	}
+	if err := os.RemoveAll(GetDependencyEnvPath(spider)); err != nil {
+		log.Errorf(err.Error())
+	}
+
+	// 从数据库中删除该爬虫
+	if err := model.RemoveSpider(spider.Id); err != nil {
+		return err
+	}
+
+	// 以下为清理步骤，出错时记录日志并继续
+
+	// 删除通知规则（包括该爬虫定时任务的规则）
+	if err := model.RemoveNotificationRulesBySpiderId(spider.Id); err != nil {
+		log.Errorf(err.Error())
+	}
+	if schedules, err := model.GetScheduleList(bson.M{"spider_id": spider.Id}); err != nil {
+		log.Errorf(err.Error())
+	} else {
+		for _, sch := range schedules {
+			if err := model.RemoveNotificationRulesByScheduleId(sch.Id); err != nil {
+				log.Errorf(err.Error())
+			}
+		}
+	}
+
+	// 删除定时任务并重新加载
+	if err := model.RemoveSchedulesBySpiderId(spider.Id); err != nil {
+		log.Errorf(err.Error())
+	}
+	if Sched != nil {
+		if err := Sched.Update(); err != nil {
+			log.Errorf(err.Error())
+		}
+	}
+
+	// 删除触发器
+	if err := model.RemoveTriggersBySpiderId(spider.Id); err != nil {
+		log.Errorf(err.Error())
+	}
+
+	// 删除历史任务及其结果
+	if !opts.KeepTasks {
+		if err := removeSpiderTasks(spider); err != nil {
+			log.Errorf(err.Error())
+		}
+	}
+
+	// 删除结果去重键
+	if err := model.RemoveResultKeysBySpiderId(spider.Id); err != nil {
+		log.Errorf(err.Error())
+	}
+
+	// 删除结果集
+	if opts.DropCol && spider.Col != "" {
+		s, c := database.GetCol(spider.Col)
+		if err := c.DropCollection(); err != nil {
+			log.Errorf(err.Error())
+		}
+		s.Close()
+	}
+
+	// 删除各个版本及GridFS文件
+	if err := removeSpiderVersions(spider); err != nil {
+		log.Errorf(err.Error())
+	}
+
+	// 删除部署状态
+	if err := model.RemoveDeploymentsBySpiderId(spider.Id); err != nil {
+		log.Errorf(err.Error())
+	}
+
+	// 通知工作节点
+	msg := SpiderRemoveMessage{
+		SpiderId: spider.Id.Hex(),
+		Name:     spider.Name,
+	}
+	msgBytes, _ := json.Marshal(&msg)
+	if err := database.Publish("spiders:remove", string(msgBytes)); err != nil {
+		log.Errorf(err.Error())
+	}
+
+	return nil
+}
+
+// 删除爬虫的历史任务及任务的结果
+func removeSpiderTasks(spider model.Spider) error {
+	tasks, err := model.GetTaskList(bson.M{"spider_id": spider.Id}, 0, constants.Infinite, "-create_ts")
+	if err != nil {
+		return err
+	}
+
+	var taskIds []string
+	for _, t := range tasks {
+		taskIds = append(taskIds, t.Id)
+	}
+
+	// 通过结果储存删除结果（只写的储存无法删除，跳过），并删除结果数量记录
+	sink, err := spider.GetSink()
+	if err != nil {
+		return err
+	}
+	remover, ok := sink.(sinks.Remover)
+	if err := forEachTaskIdBatch(taskIds, func(batch []string) error {
+		if ok {
+			if _, err := remover.Remove(batch); err != nil {
+				return err
+			}
+		}
+		return sinks.RemoveResultCounts(batch)
+	}); err != nil {
+		return err
+	}
+
+	// 删除日志文件
+	for _, t := range tasks {
+		if t.LogPath != "" {
+			_ = os.Remove(t.LogPath)
+		}
+	}
+
+	return model.RemoveTasksBySpiderId(spider.Id)
+}
+
+// 删除爬虫的各个版本、zip文件及不再被引用的文件
+func removeSpiderVersions(spider model.Spider) error {
+	versions, err := model.GetSpiderVersionList(spider.Id)
+	if err != nil {
+		return err
+	}
+
+	// 删除zip文件
+	s, gf := database.GetGridFs("files")
+	fileIds := map[bson.ObjectId]bool{}
+	if spider.FileId != "" && spider.FileId.Hex() != constants.ObjectIdNull {
+		fileIds[spider.FileId] = true
+	}
+	for _, v := range versions {
+		fileIds[v.FileId] = true
+	}
+	for fid := range fileIds {
+		if err := gf.RemoveId(fid); err != nil {
+			log.Errorf(err.Error())
+		}
+	}
+	s.Close()
+
+	// 删除版本记录
+	if err := model.RemoveSpiderVersionsBySpiderId(spider.Id); err != nil {
+		return err
+	}
+
+	// 删除不再被任何版本引用的文件
+	return PruneSpiderFiles()
+}
+
+// 删除爬虫回调（工作节点）
+func OnSpiderRemove(channel string, msgStr string) {
+	var msg SpiderRemoveMessage
+	if err := json.Unmarshal([]byte(msgStr), &msg); err != nil {
+		log.Errorf(err.Error())
+		debug.PrintStack()
+		return
+	}
+	if !bson.IsObjectIdHex(msg.SpiderId) || msg.Name == "" {
+		return
+	}
+
+	spiderSyncLock.Lock()
+	defer spiderSyncLock.Unlock()
+
+	spider := model.Spider{
+		Id:   bson.ObjectIdHex(msg.SpiderId),
+		Name: msg.Name,
+	}
+
+	// 爬虫目录（沙箱内解析，防止名称越界）、文件清单、依赖环境
+	fs, err := utils.NewSandboxFs(viper.GetString("spider.path"))
+	if err != nil {
+		log.Errorf(err.Error())
+		return
+	}
+	dir, err := fs.ResolveEntry(msg.Name)
+	if err != nil {
+		log.Errorf(err.Error())
+		return
+	}
+	for _, path := range []string{dir, GetLocalManifestPath(spider), GetDependencyEnvPath(spider)} {
+		if err := os.RemoveAll(path); err != nil {
+			log.Errorf(err.Error())
+		}
+	}
+	delete(reportedDeployments, spider.Id)
+
+	log.Infof("removed spider %s", msg.Name)
+}
@@ -0,0 +1,485 @@
+package services
+
+import (
+	"crawlab/constants"
+	"crawlab/database"
+	"crawlab/model"
+	"crawlab/utils"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/apex/log"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/pkg/errors"
+	uuid "github.com/satori/go.uuid"
+	"github.com/spf13/viper"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// 按内容哈希储存爬虫文件的GridFS前缀
+const SpiderFileGridFsPrefix = "spider_files"
+
+// 工作节点上储存爬虫文件清单的目录（隐藏目录，不会被识别为爬虫）
+const SpiderManifestDir = ".manifests"
+
+// 工作节点上已安装爬虫的文件清单
+type SpiderManifest struct {
+	SpiderId  bson.ObjectId      `json:"spider_id"`
+	VersionId bson.ObjectId      `json:"version_id"`
+	Hash      string             `json:"hash"`
+	Files     []model.SpiderFile `json:"files"`
+}
+
+// 同步锁，避免消息回调与定时同步同时写入爬虫目录
+var spiderSyncLock sync.Mutex
+
+// 本进程已上报的爬虫部署哈希，避免重复上报
+var reportedDeployments = map[bson.ObjectId]string{}
+
+// 生成目录的文件清单（按相对路径排序）
+func GetDirManifest(dir string) (files []model.SpiderFile, err error) {
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// 忽略符号链接，避免将爬虫目录以外的文件同步到工作节点
+		if info.Mode()&os.ModeSymlink != 0 {
+			return nil
+		}
+
+		if info.IsDir() {
+			// 忽略Git仓库目录
+			if info.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		// 相对路径
+		relPath, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		// 文件内容哈希
+		hash, err := utils.HashFile(path)
+		if err != nil {
+			return err
+		}
+
+		files = append(files, model.SpiderFile{
+			Path: filepath.ToSlash(relPath),
+			Hash: hash,
+			Size: info.Size(),
+		})
+		return nil
+	})
+	if err != nil {
+		debug.PrintStack()
+		return files, err
+	}
+	return files, nil
+}
+
+// 计算文件清单的哈希，作为爬虫内容哈希
+func HashManifest(files []model.SpiderFile) string {
+	h := sha256.New()
+	for _, f := range files {
+		h.Write([]byte(f.Path))
+		h.Write([]byte{0})
+		h.Write([]byte(f.Hash))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// 按内容哈希上传文件到GridFS（已存在则跳过）
+func UploadSpiderFiles(dir string, files []model.SpiderFile) error {
+	s, gf := database.GetGridFs(SpiderFileGridFsPrefix)
+	defer s.Close()
+
+	for _, file := range files {
+		// 已存在相同内容的文件
+		count, err := gf.Find(bson.M{"filename": file.Hash}).Count()
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			continue
+		}
+
+		// 上传文件
+		if err := uploadSpiderFile(gf, filepath.Join(dir, filepath.FromSlash(file.Path)), file.Hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func uploadSpiderFile(gf *mgo.GridFS, filePath string, hash string) error {
+	src, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	f, err := gf.Create(hash)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, src); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// 删除不再被任何版本引用的文件
+func PruneSpiderFiles() error {
+	// 所有版本引用的文件哈希
+	s, c := database.GetCol("spider_versions")
+	defer s.Close()
+	var hashes []string
+	if err := c.Find(nil).Distinct("files.hash", &hashes); err != nil {
+		return err
+	}
+	hashSet := map[string]bool{}
+	for _, hash := range hashes {
+		hashSet[hash] = true
+	}
+
+	// 遍历GridFS文件
+	s2, gf := database.GetGridFs(SpiderFileGridFsPrefix)
+	defer s2.Close()
+	var f struct {
+		Id       bson.ObjectId `bson:"_id"`
+		Filename string        `bson:"filename"`
+	}
+	iter := gf.Find(nil).Select(bson.M{"_id": 1, "filename": 1}).Iter()
+	for iter.Next(&f) {
+		if hashSet[f.Filename] {
+			continue
+		}
+		if err := gf.RemoveId(f.Id); err != nil {
+			log.Errorf(err.Error())
+		}
+	}
+	return iter.Close()
+}
+
+// 工作节点上爬虫的安装目录
+func GetLocalSpiderDir(spider model.Spider) string {
+	return filepath.Join(viper.GetString("spider.path"), spider.Name)
+}
+
+// 爬虫源码目录的沙箱文件系统，文件相关接口均通过它访问爬虫文件
+func GetSpiderFs(spider model.Spider) (*utils.SandboxFs, error) {
+	if spider.Src == "" {
+		return nil, errors.New("spider has no source path")
+	}
+	return utils.NewSandboxFs(spider.Src)
+}
+
+// 工作节点上爬虫文件清单路径
+func GetLocalManifestPath(spider model.Spider) string {
+	return filepath.Join(viper.GetString("spider.path"), SpiderManifestDir, spider.Id.Hex()+".json")
+}
+
+// 读取工作节点上爬虫文件清单
+func GetLocalManifest(spider model.Spider) (manifest SpiderManifest, err error) {
+	data, err := ioutil.ReadFile(GetLocalManifestPath(spider))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return manifest, nil
+		}
+		return manifest, err
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return manifest, err
+	}
+	return manifest, nil
+}
+
+// 保存工作节点上爬虫文件清单
+func SaveLocalManifest(spider model.Spider, manifest SpiderManifest) error {
+	manifestPath := GetLocalManifestPath(spider)
+	if err := os.MkdirAll(filepath.Dir(manifestPath), os.ModePerm); err != nil {
+		return err
+	}
+	data, err := json.Marshal(&manifest)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(manifestPath, data, os.ModePerm)
+}
+
+// 从GridFS下载单个文件到目标路径
+func downloadSpiderFile(gf *mgo.GridFS, hash string, dstPath string) error {
+	f, err := gf.Open(hash)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dstPath), os.ModePerm); err != nil {
+		return err
+	}
+
+	// 先写入临时文件再重命名，避免执行中的任务读到不完整的文件
+	tmpPath := dstPath + "." + uuid.NewV4().String() + ".tmp"
+	tmpFile, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(tmpFile, f); err != nil {
+		_ = tmpFile.Close()
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, dstPath)
+}
+
+// 按文件增量同步爬虫：仅下载内容变化的文件，删除已不存在的文件
+func syncSpiderFiles(spider model.Spider, manifest SpiderManifest, version model.SpiderVersion) error {
+	dir := GetLocalSpiderDir(spider)
+
+	// 本地文件哈希
+	localFiles := map[string]string{}
+	for _, f := range manifest.Files {
+		localFiles[f.Path] = f.Hash
+	}
+
+	// 文件路径限制在爬虫目录内
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	fs, err := utils.NewSandboxFs(dir)
+	if err != nil {
+		return err
+	}
+
+	s, gf := database.GetGridFs(SpiderFileGridFsPrefix)
+	defer s.Close()
+
+	// 下载新增或变化的文件
+	newFiles := map[string]bool{}
+	for _, f := range version.Files {
+		newFiles[f.Path] = true
+		dstPath, err := fs.Resolve(f.Path)
+		if err != nil {
+			return err
+		}
+		if localFiles[f.Path] == f.Hash && utils.Exists(dstPath) {
+			continue
+		}
+		if err := downloadSpiderFile(gf, f.Hash, dstPath); err != nil {
+			return err
+		}
+	}
+
+	// 删除已不存在的文件
+	for path := range localFiles {
+		if newFiles[path] {
+			continue
+		}
+		dstPath, err := fs.Resolve(path)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(dstPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// 下载整个zip文件同步爬虫（无文件清单的旧版本）
+func syncSpiderArchive(spider model.Spider, version model.SpiderVersion) error {
+	// 从GridFS下载该文件到临时文件
+	tmpFilePath, err := DownloadFromGridFs(version.FileId)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFilePath)
+
+	// 解压缩临时文件到爬虫目录
+	if err := os.RemoveAll(GetLocalSpiderDir(spider)); err != nil {
+		return err
+	}
+	return utils.DeCompressByPath(tmpFilePath, viper.GetString("spider.path"))
+}
+
+// 上报本节点的爬虫部署状态
+func ReportDeployment(spider model.Spider, manifest SpiderManifest, syncErr error) {
+	node, err := GetCurrentNode()
+	if err != nil {
+		log.Errorf(err.Error())
+		return
+	}
+
+	update := bson.M{}
+	if syncErr != nil {
+		// 同步失败，保留已安装的版本信息
+		update["status"] = constants.DeployStatusError
+		update["error"] = syncErr.Error()
+		delete(reportedDeployments, spider.Id)
+	} else {
+		if reportedDeployments[spider.Id] == manifest.Hash {
+			return
+		}
+		update["status"] = constants.DeployStatusSuccess
+		update["error"] = ""
+		update["hash"] = manifest.Hash
+		update["version_id"] = manifest.VersionId
+		update["install_ts"] = time.Now()
+	}
+	if err := model.UpsertDeployment(spider.Id, node.Id, update); err != nil {
+		log.Errorf(err.Error())
+		return
+	}
+	if syncErr == nil {
+		reportedDeployments[spider.Id] = manifest.Hash
+	}
+}
+
+// 同步爬虫到本节点并上报部署状态、安装依赖，本地已安装版本与当前版本一致时跳过
+func SyncSpider(spider model.Spider) error {
+	spiderSyncLock.Lock()
+	defer spiderSyncLock.Unlock()
+
+	// 爬虫尚未发布
+	if spider.VersionId == "" {
+		return nil
+	}
+
+	manifest, err := syncSpider(spider)
+	ReportDeployment(spider, manifest, err)
+	if err != nil {
+		return err
+	}
+
+	// 安装依赖（依赖文件未变化时跳过），安装状态通过系统信息上报
+	if err := InstallSpiderDependencies(spider); err != nil {
+		log.Errorf(err.Error())
+	}
+	return nil
+}
+
+func syncSpider(spider model.Spider) (SpiderManifest, error) {
+	// 本地文件清单
+	manifest, err := GetLocalManifest(spider)
+	if err != nil {
+		return manifest, err
+	}
+	if manifest.Hash == spider.Hash && utils.Exists(GetLocalSpiderDir(spider)) {
+		return manifest, nil
+	}
+
+	// 获取当前版本
+	version, err := model.GetSpiderVersion(spider.VersionId)
+	if err != nil {
+		return manifest, err
+	}
+
+	log.Infof("sync spider %s: %s -> %s", spider.Name, manifest.Hash, version.Hash)
+
+	if len(version.Files) > 0 {
+		// 按文件增量同步，失败时回退到下载整个zip文件
+		if err := syncSpiderFiles(spider, manifest, version); err != nil {
+			log.Errorf("sync spider files error, fallback to archive: " + err.Error())
+			if err := syncSpiderArchive(spider, version); err != nil {
+				return manifest, err
+			}
+		}
+	} else {
+		if err := syncSpiderArchive(spider, version); err != nil {
+			return manifest, err
+		}
+	}
+
+	// 保存本地文件清单
+	files := version.Files
+	if len(files) == 0 {
+		if files, err = GetDirManifest(GetLocalSpiderDir(spider)); err != nil {
+			return manifest, err
+		}
+	}
+	manifest = SpiderManifest{
+		SpiderId:  spider.Id,
+		VersionId: version.Id,
+		Hash:      version.Hash,
+		Files:     files,
+	}
+	if err := SaveLocalManifest(spider, manifest); err != nil {
+		return manifest, err
+	}
+
+	return manifest, nil
+}
+
+// 确保本节点已安装爬虫的当前版本，不一致时先同步
+func EnsureSpiderDeployed(spider model.Spider) error {
+	// 主节点直接使用爬虫源码目录
+	if IsMaster() || spider.VersionId == "" {
+		return nil
+	}
+
+	manifest, err := GetLocalManifest(spider)
+	if err != nil {
+		return err
+	}
+	if manifest.Hash == spider.Hash {
+		return nil
+	}
+
+	// 等待同步完成后再次校验
+	if err := SyncSpider(spider); err != nil {
+		return errors.Wrap(err, "sync spider "+spider.Name)
+	}
+	manifest, err = GetLocalManifest(spider)
+	if err != nil {
+		return err
+	}
+	if manifest.Hash != spider.Hash {
+		return errors.New("spider version mismatch: installed " + manifest.Hash + ", expected " + spider.Hash)
+	}
+	return nil
+}
+
+func SyncSpiderById(id bson.ObjectId) error {
+	spider, err := model.GetSpider(id)
+	if err != nil {
+		return err
+	}
+	return SyncSpider(spider)
+}
+
+// 同步所有爬虫到本节点
+func SyncAllSpiders() {
+	spiders, err := model.GetSpiderList(nil, 0, constants.Infinite)
+	if err != nil {
+		log.Errorf(err.Error())
+		return
+	}
+
+	for _, spider := range spiders {
+		if err := SyncSpider(spider); err != nil {
+			log.Errorf(errors.Wrap(err, "sync spider "+spider.Name).Error())
+			debug.PrintStack()
+			continue
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package services
+
+import (
+	"crawlab/utils"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExtractSpiderVersion(t *testing.T) {
+	root, err := ioutil.TempDir("", "crawlab-rollback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	// 打包版本文件（与发布时相同，zip文件内包含源文件夹名称）
+	versionSrc := filepath.Join(root, "version", "spider")
+	if err := os.MkdirAll(versionSrc, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(versionSrc, "main.py"), []byte("print(1)"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d, err := os.Open(versionSrc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zipPath := filepath.Join(root, "version.zip")
+	if err := utils.Compress([]*os.File{d}, zipPath); err != nil {
+		t.Fatal(err)
+	}
+
+	// 当前源文件夹
+	src := filepath.Join(root, "spiders", "spider")
+	if err := os.MkdirAll(src, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "main.py"), []byte("print(2)"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// 解压失败时保留原目录
+	badPath := filepath.Join(root, "bad.zip")
+	if err := ioutil.WriteFile(badPath, []byte("not a zip"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := extractSpiderVersion(badPath, src); err == nil {
+		t.Fatal("expected error for invalid version file")
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(src, "main.py")); string(data) != "print(2)" {
+		t.Fatalf("main.py after failed rollback = %s", data)
+	}
+
+	// 版本文件中没有源文件夹时保留原目录
+	if err := extractSpiderVersion(zipPath, filepath.Join(root, "spiders", "other")); err == nil {
+		t.Fatal("expected error for missing source folder")
+	}
+
+	if err := extractSpiderVersion(zipPath, src); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(src, "main.py")); string(data) != "print(1)" {
+		t.Fatalf("main.py after rollback = %s", data)
+	}
+
+	// 不残留临时目录
+	items, err := ioutil.ReadDir(filepath.Dir(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 {
+		t.Fatalf("spiders dir has %d items, want 1", len(items))
+	}
+}
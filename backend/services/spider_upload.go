@@ -0,0 +1,121 @@
+package services
+
+import (
+	"crawlab/utils"
+	"github.com/pkg/errors"
+	uuid "github.com/satori/go.uuid"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// 支持上传的压缩文件后缀
+var spiderArchiveExts = []string{".zip", ".tar.gz", ".tgz"}
+
+// 支持直接上传的单个脚本文件后缀及其执行命令
+var spiderScriptCmds = map[string]string{
+	".py": "python",
+	".js": "node",
+}
+
+// 压缩文件后缀，不是压缩文件时返回空字符串
+func GetSpiderArchiveExt(fileName string) string {
+	for _, ext := range spiderArchiveExts {
+		if strings.HasSuffix(strings.ToLower(fileName), ext) {
+			return ext
+		}
+	}
+	return ""
+}
+
+// 单个脚本文件的执行命令，不是支持的脚本文件时返回空字符串
+func GetSpiderScriptCmd(fileName string) string {
+	return spiderScriptCmds[strings.ToLower(filepath.Ext(fileName))]
+}
+
+// 从上传文件名获取爬虫名称（去掉后缀）
+func GetUploadSpiderName(fileName string) string {
+	fileName = filepath.Base(fileName)
+	if ext := GetSpiderArchiveExt(fileName); ext != "" {
+		return fileName[:len(fileName)-len(ext)]
+	}
+	return strings.TrimSuffix(fileName, filepath.Ext(fileName))
+}
+
+// 压缩文件只包含一个顶层目录时，返回该目录，否则返回原目录
+func getArchiveRoot(dir string) (string, error) {
+	items, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return dir, err
+	}
+
+	var dirs []os.FileInfo
+	for _, item := range items {
+		// 忽略macOS生成的元数据
+		if item.Name() == "__MACOSX" || item.Name() == ".DS_Store" {
+			continue
+		}
+		if !item.IsDir() {
+			return dir, nil
+		}
+		dirs = append(dirs, item)
+	}
+	if len(dirs) != 1 {
+		return dir, nil
+	}
+	return filepath.Join(dir, dirs[0].Name()), nil
+}
+
+// 解压上传的爬虫文件到爬虫目录，已存在时覆盖
+// 1. 解压到爬虫根目录下的隐藏临时目录（单个脚本文件直接复制）
+// 2. 压缩文件只包含一个顶层目录时，以该目录作为爬虫目录
+// 3. 替换原爬虫目录
+func ExtractSpiderUpload(filePath string, fileName string, dstPath string) error {
+	// 临时目录，与爬虫目录位于同一文件系统，以便重命名
+	tmpDir := filepath.Join(filepath.Dir(dstPath), ".upload-"+uuid.NewV4().String())
+	if err := os.MkdirAll(tmpDir, os.ModePerm); err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	switch GetSpiderArchiveExt(fileName) {
+	case ".zip":
+		if err := utils.DeCompressByPath(filePath, tmpDir); err != nil {
+			return err
+		}
+	case ".tar.gz", ".tgz":
+		f, err := os.Open(filePath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if err := utils.DeCompressTarGz(f, tmpDir); err != nil {
+			return err
+		}
+	default:
+		if GetSpiderScriptCmd(fileName) == "" {
+			return errors.New("unsupported file type: " + fileName)
+		}
+		// 单个脚本文件包装为目录
+		data, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filepath.Join(tmpDir, filepath.Base(fileName)), data, os.ModePerm); err != nil {
+			return err
+		}
+	}
+
+	// 自动识别顶层目录
+	root, err := getArchiveRoot(tmpDir)
+	if err != nil {
+		return err
+	}
+
+	// 替换原爬虫目录
+	if err := os.RemoveAll(dstPath); err != nil {
+		return err
+	}
+	return os.Rename(root, dstPath)
+}
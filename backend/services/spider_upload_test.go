@@ -0,0 +1,242 @@
+package services
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestGetUploadSpiderName(t *testing.T) {
+	tests := []struct {
+		fileName string
+		ext      string
+		cmd      string
+		name     string
+	}{
+		{"baidu.zip", ".zip", "", "baidu"},
+		{"baidu.ZIP", ".zip", "", "baidu"},
+		{"baidu.tar.gz", ".tar.gz", "", "baidu"},
+		{"baidu.tgz", ".tgz", "", "baidu"},
+		{"dir/baidu.v2.tar.gz", ".tar.gz", "", "baidu.v2"},
+		{"main.py", "", "python", "main"},
+		{"index.JS", "", "node", "index"},
+		{"main.go", "", "", "main"},
+	}
+	for _, tt := range tests {
+		if got := GetSpiderArchiveExt(tt.fileName); got != tt.ext {
+			t.Errorf("GetSpiderArchiveExt(%s) = %q, want %q", tt.fileName, got, tt.ext)
+		}
+		if got := GetSpiderScriptCmd(tt.fileName); got != tt.cmd {
+			t.Errorf("GetSpiderScriptCmd(%s) = %q, want %q", tt.fileName, got, tt.cmd)
+		}
+		if got := GetUploadSpiderName(tt.fileName); got != tt.name {
+			t.Errorf("GetUploadSpiderName(%s) = %q, want %q", tt.fileName, got, tt.name)
+		}
+	}
+}
+
+// 写入测试用的zip文件，files为文件路径及内容
+func writeTestUploadZip(t *testing.T, path string, files map[string]string) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w := zip.NewWriter(f)
+	for name, body := range files {
+		fw, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fw.Write([]byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 写入测试用的tar.gz文件
+func writeTestUploadTarGz(t *testing.T, path string, files map[string]string) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+	for name, body := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(body)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 目录下所有文件的相对路径及内容
+func readTestDir(t *testing.T, dir string) map[string]string {
+	files := map[string]string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		files[filepath.ToSlash(rel)] = string(data)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestExtractSpiderUpload(t *testing.T) {
+	tests := []struct {
+		name     string
+		fileName string
+		write    func(t *testing.T, path string, files map[string]string)
+		files    map[string]string
+		want     map[string]string
+	}{
+		{
+			name:     "zip with top dir",
+			fileName: "baidu.zip",
+			write:    writeTestUploadZip,
+			files:    map[string]string{"baidu-master/main.py": "print(1)", "baidu-master/lib/a.py": "a"},
+			want:     map[string]string{"main.py": "print(1)", "lib/a.py": "a"},
+		},
+		{
+			name:     "zip with macOS metadata",
+			fileName: "baidu.zip",
+			write:    writeTestUploadZip,
+			files:    map[string]string{"baidu/main.py": "print(1)", "__MACOSX/baidu/._main.py": "x"},
+			want:     map[string]string{"main.py": "print(1)"},
+		},
+		{
+			name:     "zip without top dir",
+			fileName: "baidu.zip",
+			write:    writeTestUploadZip,
+			files:    map[string]string{"main.py": "print(1)", "lib/a.py": "a"},
+			want:     map[string]string{"main.py": "print(1)", "lib/a.py": "a"},
+		},
+		{
+			name:     "tar.gz with top dir",
+			fileName: "baidu.tar.gz",
+			write:    writeTestUploadTarGz,
+			files:    map[string]string{"baidu/main.py": "print(1)"},
+			want:     map[string]string{"main.py": "print(1)"},
+		},
+		{
+			name:     "script",
+			fileName: "main.py",
+			write: func(t *testing.T, path string, files map[string]string) {
+				if err := ioutil.WriteFile(path, []byte(files["main.py"]), 0644); err != nil {
+					t.Fatal(err)
+				}
+			},
+			files: map[string]string{"main.py": "print(1)"},
+			want:  map[string]string{"main.py": "print(1)"},
+		},
+	}
+
+	for _, tt := range tests {
+		func() {
+			root, err := ioutil.TempDir("", "crawlab-upload")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(root)
+
+			// 已存在的爬虫目录被替换
+			dstPath := filepath.Join(root, "spiders", "baidu")
+			if err := os.MkdirAll(dstPath, os.ModePerm); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(filepath.Join(dstPath, "old.py"), []byte("old"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			filePath := filepath.Join(root, "upload")
+			tt.write(t, filePath, tt.files)
+			if err := ExtractSpiderUpload(filePath, tt.fileName, dstPath); err != nil {
+				t.Fatalf("%s: %s", tt.name, err)
+			}
+			if got := readTestDir(t, dstPath); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%s: files = %v, want %v", tt.name, got, tt.want)
+			}
+
+			// 不残留临时目录
+			items, _ := ioutil.ReadDir(filepath.Dir(dstPath))
+			var names []string
+			for _, item := range items {
+				names = append(names, item.Name())
+			}
+			sort.Strings(names)
+			if !reflect.DeepEqual(names, []string{"baidu"}) {
+				t.Errorf("%s: spiders dir = %v", tt.name, names)
+			}
+		}()
+	}
+}
+
+func TestExtractSpiderUploadError(t *testing.T) {
+	root, err := ioutil.TempDir("", "crawlab-upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	dstPath := filepath.Join(root, "spiders", "baidu")
+	if err := os.MkdirAll(dstPath, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dstPath, "main.py"), []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// 非法的压缩文件、越界的路径、不支持的文件类型：保留原爬虫目录
+	badZip := filepath.Join(root, "bad.zip")
+	if err := ioutil.WriteFile(badZip, []byte("not a zip"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	slipZip := filepath.Join(root, "slip.zip")
+	writeTestUploadZip(t, slipZip, map[string]string{"../../evil.py": "evil"})
+	cases := []struct {
+		filePath string
+		fileName string
+	}{
+		{badZip, "baidu.zip"},
+		{slipZip, "baidu.zip"},
+		{badZip, "baidu.rar"},
+	}
+	for _, c := range cases {
+		if err := ExtractSpiderUpload(c.filePath, c.fileName, dstPath); err == nil {
+			t.Errorf("ExtractSpiderUpload(%s) expected error", c.fileName)
+		}
+		if got := readTestDir(t, dstPath); !reflect.DeepEqual(got, map[string]string{"main.py": "old"}) {
+			t.Fatalf("files after failed upload = %v", got)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "evil.py")); err == nil {
+		t.Fatal("evil.py written outside the spider dir")
+	}
+}
@@ -93,13 +93,19 @@ func GetLocalSystemInfo() (sysInfo model.SystemInfo, err error) {
 		debug.PrintStack()
 		return sysInfo, err
 	}
+	dependencies, err := GetLocalDependencies()
+	if err != nil {
+		debug.PrintStack()
+		return sysInfo, err
+	}
 
 	return model.SystemInfo{
-		ARCH:        runtime.GOARCH,
-		OS:          runtime.GOOS,
-		NumCpu:      runtime.GOMAXPROCS(0),
-		Hostname:    hostname,
-		Executables: executables,
+		ARCH:         runtime.GOARCH,
+		OS:           runtime.GOOS,
+		NumCpu:       runtime.GOMAXPROCS(0),
+		Hostname:     hostname,
+		Executables:  executables,
+		Dependencies: dependencies,
 	}, nil
 }
 
@@ -110,15 +116,16 @@ func GetRemoteSystemInfo(id string) (sysInfo model.SystemInfo, err error) {
 		NodeId: id,
 	}
 
+	// 通道（须在发布消息前生成，其他主节点据此忽略该响应）
+	ch := SystemInfoChanMap.ChanBlocked(id)
+	defer SystemInfoChanMap.Remove(id)
+
 	// 序列化
 	msgBytes, _ := json.Marshal(&msg)
 	if err := database.Publish("nodes:"+id, string(msgBytes)); err != nil {
 		return model.SystemInfo{}, err
 	}
 
-	// 通道
-	ch := SystemInfoChanMap.ChanBlocked(id)
-
 	// 等待响应，阻塞
 	sysInfoStr := <-ch
 
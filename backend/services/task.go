@@ -70,6 +70,8 @@ func (ex *Executor) Start() error {
 
 var TaskExecChanMap = utils.NewChanMap()
 
+var ErrTaskCancelled = errors.New("task is cancelled")
+
 // 派发任务
 func AssignTask(task model.Task) error {
 	// 生成任务信息
@@ -128,38 +130,76 @@ func ExecuteShellCmd(cmdStr string, cwd string, t model.Task, s model.Spider) (e
 	cmd.Env = append(cmd.Env, "CRAWLAB_TASK_ID="+t.Id)
 	cmd.Env = append(cmd.Env, "CRAWLAB_COLLECTION="+s.Col)
 
-	// 添加任务环境变量
+	// 添加结果写入接口的地址及任务令牌
+	resultEnvs, err := GetTaskResultEnvs(t)
+	if err != nil {
+		HandleTaskError(t, err)
+		return err
+	}
+	cmd.Env = append(cmd.Env, resultEnvs...)
+
+	// 添加爬虫依赖环境变量（Python虚拟环境、node_modules）
+	cmd.Env = append(cmd.Env, GetDependencyEnvs(s)...)
+
+	// 注入Crawlab标准结果管道
+	if s.Framework == constants.FrameworkScrapy && s.ScrapyPipeline {
+		envs, err := GetScrapyPipelineEnvs(s)
+		if err != nil {
+			HandleTaskError(t, err)
+			return err
+		}
+		cmd.Env = append(cmd.Env, envs...)
+	}
+
+	// 添加爬虫环境变量
 	for _, env := range s.Envs {
 		cmd.Env = append(cmd.Env, env.Name+"="+env.Value)
 	}
 
+	// 添加任务环境变量
+	for _, env := range t.Envs {
+		cmd.Env = append(cmd.Env, env.Name+"="+env.Value)
+	}
+
 	// 起一个goroutine来监控进程
 	ch := TaskExecChanMap.ChanBlocked(t.Id)
+	cancelled := make(chan bool, 1)
 	go func() {
 		// 传入信号，此处阻塞
 		signal := <-ch
 
-		if signal == constants.TaskCancel {
-			// 取消进程
-			if err := cmd.Process.Kill(); err != nil {
-				log.Errorf(err.Error())
-				debug.PrintStack()
-				return
-			}
-			t.Status = constants.StatusCancelled
+		// 正常结束的任务由ExecuteTask保存
+		if signal != constants.TaskCancel {
+			return
+		}
+
+		// 取消进程（先标记，避免进程退出后被当作执行出错）
+		cancelled <- true
+		if err := cmd.Process.Kill(); err != nil {
+			log.Errorf(err.Error())
+			debug.PrintStack()
+			return
 		}
 
-		// 保存任务
+		// 保存任务状态
+		t.Status = constants.StatusCancelled
 		t.FinishTs = time.Now()
-		if err := t.Save(); err != nil {
+		if err := model.UpdateTaskStatus(t); err != nil {
 			log.Infof(err.Error())
 			debug.PrintStack()
 			return
 		}
+		NotifyTaskEvent(t, constants.NotifyEventCancelled)
 	}()
 
 	// 开始执行
 	if err := cmd.Run(); err != nil {
+		// 任务已取消，状态由监控goroutine保存
+		select {
+		case <-cancelled:
+			return ErrTaskCancelled
+		default:
+		}
 		HandleTaskError(t, err)
 		return err
 	}
@@ -207,17 +247,6 @@ func GetWorkerPrefix(id int) string {
 	return "[Worker " + strconv.Itoa(id) + "] "
 }
 
-// 统计任务结果数
-func SaveTaskResultCount(id string) func() {
-	return func() {
-		if err := model.UpdateTaskResultCount(id); err != nil {
-			log.Errorf(err.Error())
-			debug.PrintStack()
-			return
-		}
-	}
-}
-
 // 执行任务
 func ExecuteTask(id int) {
 	if LockList[id] {
@@ -325,6 +354,33 @@ func ExecuteTask(id int) {
 	cmd := spider.Cmd
 	if t.Cmd != "" {
 		cmd = t.Cmd
+	} else if t.Target != "" && spider.Framework == constants.FrameworkScrapy {
+		// 运行Scrapy项目中指定的爬虫（加引号，避免爬虫名称被当作shell命令执行）
+		target := utils.ShellQuote(t.Target)
+		if runtime.GOOS == constants.Windows {
+			if target, err = utils.WindowsQuote(t.Target); err != nil {
+				log.Errorf(GetWorkerPrefix(id) + err.Error())
+				HandleTaskError(t, err)
+				return
+			}
+		}
+		cmd = "scrapy crawl " + target
+	}
+
+	// 执行参数（逐个加引号，避免参数被当作shell命令执行）
+	// 任务环境变量（如工作流的$CRAWLAB_UPSTREAM_TASK_ID）在加引号前展开
+	if t.Param != "" {
+		vars := map[string]string{}
+		for _, env := range t.Envs {
+			vars[env.Name] = env.Value
+		}
+		param, err := utils.QuoteArgs(t.Param, vars, runtime.GOOS == constants.Windows)
+		if err != nil {
+			log.Errorf(GetWorkerPrefix(id) + err.Error())
+			HandleTaskError(t, err)
+			return
+		}
+		cmd += " " + param
 	}
 
 	// 任务赋值
@@ -332,6 +388,23 @@ func ExecuteTask(id int) {
 	t.StartTs = time.Now()                               // 任务开始时间
 	t.Status = constants.StatusRunning                   // 任务状态
 	t.WaitDuration = t.StartTs.Sub(t.CreateTs).Seconds() // 等待时长
+	t.SpiderVersionId = spider.VersionId                 // 爬虫版本
+	t.SpiderHash = spider.Hash                           // 爬虫版本内容哈希
+	t.CommitSha = spider.CommitSha                       // 爬虫版本Git提交SHA
+
+	// 校验本节点已安装的爬虫版本，不一致时先同步
+	if err := EnsureSpiderDeployed(spider); err != nil {
+		log.Errorf(GetWorkerPrefix(id) + err.Error())
+		HandleTaskError(t, err)
+		return
+	}
+
+	// 安装爬虫依赖（已安装时跳过）
+	if err := InstallSpiderDependencies(spider); err != nil {
+		log.Errorf(GetWorkerPrefix(id) + err.Error())
+		HandleTaskError(t, err)
+		return
+	}
 
 	// 开始执行任务
 	log.Infof(GetWorkerPrefix(id) + "开始执行任务(ID:" + t.Id + ")")
@@ -342,31 +415,19 @@ func ExecuteTask(id int) {
 		HandleTaskError(t, err)
 		return
 	}
-
-	// 起一个cron执行器来统计任务结果数
-	if spider.Col != "" {
-		cronExec := cron.New(cron.WithSeconds())
-		_, err = cronExec.AddFunc("*/5 * * * * *", SaveTaskResultCount(t.Id))
-		if err != nil {
-			log.Errorf(GetWorkerPrefix(id) + err.Error())
-			return
-		}
-		cronExec.Start()
-		defer cronExec.Stop()
-	}
+	NotifyTaskEvent(t, constants.NotifyEventStarted)
 
 	// 执行Shell命令
-	if err := ExecuteShellCmd(cmd, cwd, t, spider); err != nil {
+	execErr := ExecuteShellCmd(cmd, cwd, t, spider)
+
+	// 更新任务结果数（通过结果写入接口写入的结果已实时计数，此处以结果储存中的数量为准进行校正）
+	if err := model.UpdateTaskResultCount(t.Id); err != nil {
 		log.Errorf(GetWorkerPrefix(id) + err.Error())
-		return
 	}
 
-	// 更新任务结果数
-	if spider.Col != "" {
-		if err := model.UpdateTaskResultCount(t.Id); err != nil {
-			log.Errorf(GetWorkerPrefix(id) + err.Error())
-			return
-		}
+	if execErr != nil {
+		log.Errorf(GetWorkerPrefix(id) + execErr.Error())
+		return
 	}
 
 	// 完成进程
@@ -380,12 +441,32 @@ func ExecuteTask(id int) {
 	t.RuntimeDuration = t.FinishTs.Sub(t.StartTs).Seconds() // 运行时长
 	t.TotalDuration = t.FinishTs.Sub(t.CreateTs).Seconds()  // 总时长
 
+	// 数据质量校验，未通过时任务状态为已完成（有警告）
+	if spider.Quality.IsEnabled() {
+		violations, checked, warn, err := CheckTaskQuality(t, spider)
+		if err != nil {
+			log.Errorf(GetWorkerPrefix(id) + err.Error())
+		} else {
+			t.QualityChecked = checked
+			t.QualityViolations = violations
+			if warn {
+				t.Status = constants.StatusFinishedWithWarnings
+			}
+		}
+	}
+
 	// 保存任务
 	if err := t.Save(); err != nil {
 		log.Errorf(GetWorkerPrefix(id) + err.Error())
 		return
 	}
 
+	// 发送通知
+	NotifyTaskEvent(t, constants.NotifyEventFinished)
+	if t.ResultCount == 0 {
+		NotifyTaskEvent(t, constants.NotifyEventZeroResults)
+	}
+
 	// 结束计时
 	toc := time.Now()
 
@@ -475,11 +556,12 @@ func HandleTaskError(t model.Task, err error) {
 	t.Status = constants.StatusError
 	t.Error = err.Error()
 	t.FinishTs = time.Now()
-	if err := t.Save(); err != nil {
+	if err := model.UpdateTaskStatus(t); err != nil {
 		log.Errorf(err.Error())
 		debug.PrintStack()
 		return
 	}
+	NotifyTaskEvent(t, constants.NotifyEventError)
 	debug.PrintStack()
 }
 
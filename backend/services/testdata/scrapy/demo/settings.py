@@ -0,0 +1,33 @@
+# -*- coding: utf-8 -*-
+
+# Scrapy settings for demo project
+#
+# USER_AGENT = 'commented out'
+
+BOT_NAME = 'demo'
+
+SPIDER_MODULES = ['demo.spiders']
+NEWSPIDER_MODULE = 'demo.spiders'
+
+# Crawl responsibly by identifying yourself (and your website) on the user-agent
+USER_AGENT = 'demo (+http://www.example.com) # not a comment'
+
+# Obey robots.txt rules
+ROBOTSTXT_OBEY = True  # respect robots.txt
+
+DEFAULT_REQUEST_HEADERS = {
+    'Accept': 'text/html,application/xhtml+xml',  # html only
+    # 'Accept-Language': 'en',
+    'X-Brackets': '({[',
+    "X-Quote": 'it\'s',
+}
+
+ITEM_PIPELINES = {
+    'demo.pipelines.DemoPipeline': 300,
+    'demo.pipelines.MongoPipeline': 400,
+}
+
+if BOT_NAME:
+    DOWNLOAD_DELAY = 1
+
+FEED_EXPORT_ENCODING = "utf-8"
@@ -0,0 +1,197 @@
+package services
+
+import (
+	"crawlab/constants"
+	"crawlab/model"
+	"crawlab/utils"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/apex/log"
+	"github.com/globalsign/mgo/bson"
+	"github.com/pkg/errors"
+	uuid "github.com/satori/go.uuid"
+	"regexp"
+	"runtime/debug"
+	"sort"
+	"strings"
+)
+
+var (
+	ErrInvalidTriggerToken   = errors.New("invalid token")
+	ErrInvalidTriggerPayload = errors.New("invalid trigger payload")
+)
+
+// 环境变量名称
+var envNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// 触发请求体中不允许设置的环境变量，避免调用方改变命令查找路径、注入动态库或覆盖Crawlab的变量
+var reservedEnvNames = []string{"PATH", "PYTHONPATH", "PYTHONHOME", "PYTHONSTARTUP", "NODE_OPTIONS", "NODE_PATH", "BASH_ENV", "ENV", "IFS", "SHELL", "HOME"}
+var reservedEnvPrefixes = []string{"LD_", "DYLD_", "CRAWLAB_"}
+
+// 触发请求体
+// param: 覆盖触发器的默认执行参数
+// envs: 额外的任务环境变量
+// 完整请求体通过环境变量CRAWLAB_TRIGGER_PAYLOAD传给爬虫
+type TriggerPayload struct {
+	Param *string           `json:"param"`
+	Envs  map[string]string `json:"envs"`
+}
+
+// 生成触发令牌
+func GenerateTriggerToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// 触发令牌哈希，数据库中仅保存哈希
+func HashTriggerToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// 创建触发器，返回令牌明文
+func AddTrigger(trigger model.Trigger) (model.Trigger, string, error) {
+	// 校验爬虫
+	if _, err := model.GetSpider(trigger.SpiderId); err != nil {
+		return trigger, "", err
+	}
+
+	// 如果node_id为空，则置为空ObjectId
+	if trigger.NodeId == "" {
+		trigger.NodeId = bson.ObjectIdHex(constants.ObjectIdNull)
+	}
+
+	// 生成令牌
+	token, err := GenerateTriggerToken()
+	if err != nil {
+		return trigger, "", err
+	}
+	trigger.TokenHash = HashTriggerToken(token)
+	trigger.Revoked = false
+
+	if err := trigger.Add(); err != nil {
+		return trigger, "", err
+	}
+
+	return trigger, token, nil
+}
+
+// 校验触发请求体中的环境变量名称
+func ValidateTriggerEnvName(name string) error {
+	if !envNameRegex.MatchString(name) {
+		return errors.Wrap(ErrInvalidTriggerPayload, "invalid env name "+name)
+	}
+	upper := strings.ToUpper(name)
+	for _, reserved := range reservedEnvNames {
+		if upper == reserved {
+			return errors.Wrap(ErrInvalidTriggerPayload, "reserved env name "+name)
+		}
+	}
+	for _, prefix := range reservedEnvPrefixes {
+		if strings.HasPrefix(upper, prefix) {
+			return errors.Wrap(ErrInvalidTriggerPayload, "reserved env name "+name)
+		}
+	}
+	return nil
+}
+
+// 校验触发任务的执行参数，调用方传入的参数及展开后来自调用方的变量值不能以-开头，避免注入命令行选项
+// fromPayload为true时参数由调用方传入，否则为触发器的默认执行参数
+func ValidateTriggerParam(param string, fromPayload bool, envs []model.Env) error {
+	vars := map[string]string{}
+	for _, env := range envs {
+		vars[env.Name] = env.Value
+	}
+	args, err := utils.ExpandArgs(param, vars)
+	if err != nil {
+		return errors.Wrap(ErrInvalidTriggerPayload, err.Error())
+	}
+	rawArgs, _ := utils.SplitArgs(param)
+
+	for i, arg := range args {
+		// 默认执行参数中的选项由触发器创建者指定（如--name=$NAME），允许使用
+		if !fromPayload && strings.HasPrefix(rawArgs[i], "-") {
+			continue
+		}
+		if strings.HasPrefix(arg, "-") {
+			return errors.Wrap(ErrInvalidTriggerPayload, "option is not allowed in param: "+arg)
+		}
+	}
+	return nil
+}
+
+// 通过令牌触发任务
+func FireTrigger(token string, payload []byte) (model.Task, error) {
+	// 获取触发器
+	trigger, err := model.GetTriggerByTokenHash(HashTriggerToken(token))
+	if err != nil {
+		return model.Task{}, ErrInvalidTriggerToken
+	}
+
+	// 解析请求体
+	var data TriggerPayload
+	if len(payload) > 0 {
+		if err := json.Unmarshal(payload, &data); err != nil {
+			return model.Task{}, errors.Wrap(ErrInvalidTriggerPayload, "payload must be a json object")
+		}
+	}
+
+	// 环境变量（按名称排序，保证顺序稳定）
+	envs := []model.Env{
+		{Name: "CRAWLAB_TRIGGER_ID", Value: trigger.Id.Hex()},
+		{Name: "CRAWLAB_TRIGGER_PAYLOAD", Value: string(payload)},
+	}
+	var names []string
+	for name := range data.Envs {
+		if err := ValidateTriggerEnvName(name); err != nil {
+			return model.Task{}, err
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		envs = append(envs, model.Env{Name: name, Value: data.Envs[name]})
+	}
+
+	// 执行参数（执行时按参数逐个加引号，不会被当作shell命令执行）
+	param := trigger.Param
+	if data.Param != nil {
+		param = *data.Param
+	}
+	if err := ValidateTriggerParam(param, data.Param != nil, envs); err != nil {
+		return model.Task{}, err
+	}
+
+	// 生成任务模型
+	t := model.Task{
+		Id:       uuid.NewV4().String(),
+		SpiderId: trigger.SpiderId,
+		NodeId:   trigger.NodeId,
+		Status:   constants.StatusPending,
+		Param:    param,
+		Envs:     envs,
+	}
+
+	// 将任务存入数据库
+	if err := model.AddTask(t); err != nil {
+		return t, err
+	}
+
+	// 加入任务队列
+	if err := AssignTask(t); err != nil {
+		return t, err
+	}
+
+	// 记录触发时间
+	if err := model.UpdateTriggerLastTs(trigger.Id); err != nil {
+		log.Errorf(err.Error())
+		debug.PrintStack()
+	}
+
+	return t, nil
+}
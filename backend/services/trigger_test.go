@@ -0,0 +1,55 @@
+package services
+
+import (
+	"crawlab/model"
+	"github.com/pkg/errors"
+	"testing"
+)
+
+func TestValidateTriggerEnvName(t *testing.T) {
+	for _, name := range []string{"FOO", "page_size", "_X1"} {
+		if err := ValidateTriggerEnvName(name); err != nil {
+			t.Errorf("ValidateTriggerEnvName(%q) error: %s", name, err)
+		}
+	}
+	for _, name := range []string{"PATH", "path", "LD_PRELOAD", "DYLD_INSERT_LIBRARIES", "PYTHONPATH", "CRAWLAB_TASK_ID", "crawlab_mongo_host", "1A", "A-B", ""} {
+		if err := ValidateTriggerEnvName(name); err == nil {
+			t.Errorf("ValidateTriggerEnvName(%q) expected error", name)
+		}
+	}
+}
+
+func TestValidateTriggerParam(t *testing.T) {
+	envs := []model.Env{
+		{Name: "CRAWLAB_TRIGGER_ID", Value: "abc"},
+		{Name: "PAGE", Value: "2"},
+		{Name: "EVIL", Value: "--output=/etc/passwd"},
+	}
+	tests := []struct {
+		param       string
+		fromPayload bool
+		ok          bool
+	}{
+		// 调用方传入的参数
+		{"key=value page=$PAGE", true, true},
+		{"'-a'", true, false},
+		{"key=value -o x", true, false},
+		{"--output=/tmp/x", true, false},
+		{"$EVIL", true, false},
+		{`"abc`, true, false},
+		// 触发器的默认执行参数
+		{"-a key=value --page=$PAGE", false, true},
+		{"-a page=$PAGE", false, true},
+		{"-a $EVIL", false, false},
+		{`-a "${EVIL}"`, false, false},
+		{"-a '$EVIL'", false, true},
+	}
+	for _, tt := range tests {
+		err := ValidateTriggerParam(tt.param, tt.fromPayload, envs)
+		if tt.ok && err != nil {
+			t.Errorf("ValidateTriggerParam(%q, %v) error: %s", tt.param, tt.fromPayload, err)
+		} else if !tt.ok && errors.Cause(err) != ErrInvalidTriggerPayload {
+			t.Errorf("ValidateTriggerParam(%q, %v) = %v, want %v", tt.param, tt.fromPayload, err, ErrInvalidTriggerPayload)
+		}
+	}
+}
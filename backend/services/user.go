@@ -70,8 +70,15 @@ func CheckToken(tokenStr string) (user model.User, err error) {
 		return
 	}
 
-	id := bson.ObjectIdHex(claim["id"].(string))
-	username := claim["username"].(string)
+	// 任务令牌等其他令牌不能作为用户令牌使用
+	idStr, ok := claim["id"].(string)
+	username, ok2 := claim["username"].(string)
+	if !ok || !ok2 || !bson.IsObjectIdHex(idStr) {
+		err = errors.New("token is not a user token")
+		return
+	}
+
+	id := bson.ObjectIdHex(idStr)
 	user, err = model.GetUser(id)
 	if err != nil {
 		err = errors.New("cannot get user")
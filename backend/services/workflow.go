@@ -0,0 +1,338 @@
+package services
+
+import (
+	"crawlab/constants"
+	"crawlab/lib/cron"
+	"crawlab/model"
+	"github.com/apex/log"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/pkg/errors"
+	uuid "github.com/satori/go.uuid"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// 校验工作流：步骤名称唯一、依赖的步骤存在、依赖关系无环
+func ValidateWorkflow(wf model.Workflow) error {
+	if len(wf.Steps) == 0 {
+		return errors.New("workflow has no steps")
+	}
+
+	// 步骤名称唯一
+	stepMap := map[string]model.WorkflowStep{}
+	for _, step := range wf.Steps {
+		if step.Name == "" {
+			return errors.New("step name is empty")
+		}
+		if _, ok := stepMap[step.Name]; ok {
+			return errors.New("duplicated step name: " + step.Name)
+		}
+		if step.SpiderId == "" {
+			return errors.New("spider_id of step " + step.Name + " is empty")
+		}
+		stepMap[step.Name] = step
+	}
+
+	// 依赖的步骤存在且条件合法
+	for _, step := range wf.Steps {
+		for _, dep := range step.Dependencies {
+			if _, ok := stepMap[dep.Step]; !ok {
+				return errors.New("step " + step.Name + " depends on unknown step: " + dep.Step)
+			}
+			switch dep.Condition {
+			case "", constants.ConditionOnSuccess, constants.ConditionOnFailure, constants.ConditionAlways:
+			default:
+				return errors.New("invalid condition: " + dep.Condition)
+			}
+		}
+	}
+
+	// 依赖关系无环（深度优先遍历）
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	states := map[string]int{}
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch states[name] {
+		case visiting:
+			return errors.New("workflow has a cycle at step: " + name)
+		case visited:
+			return nil
+		}
+		states[name] = visiting
+		for _, dep := range stepMap[name].Dependencies {
+			if err := visit(dep.Step); err != nil {
+				return err
+			}
+		}
+		states[name] = visited
+		return nil
+	}
+	for _, step := range wf.Steps {
+		if err := visit(step.Name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// 步骤是否已结束
+func IsStepFinished(status string) bool {
+	switch status {
+	case constants.StatusFinished, constants.StatusFinishedWithWarnings, constants.StatusError, constants.StatusCancelled, constants.StatusSkipped:
+		return true
+	}
+	return false
+}
+
+// 上游步骤状态是否满足触发条件
+func IsConditionMet(condition string, status string) bool {
+	switch condition {
+	case constants.ConditionAlways:
+		return true
+	case constants.ConditionOnFailure:
+		return status == constants.StatusError || status == constants.StatusCancelled
+	default:
+		return status == constants.StatusFinished || status == constants.StatusFinishedWithWarnings
+	}
+}
+
+// 为工作流步骤派发任务
+func AssignStepTask(run *model.WorkflowRun, step *model.WorkflowRunStep, upstreamTaskIds []string) error {
+	// 生成任务ID
+	id := uuid.NewV4()
+
+	// 节点
+	nodeId := step.NodeId
+	if nodeId == "" {
+		nodeId = bson.ObjectIdHex(constants.ObjectIdNull)
+	}
+
+	// 上游任务ID通过环境变量传递，执行参数中可引用
+	var upstreamTaskId string
+	if len(upstreamTaskIds) > 0 {
+		upstreamTaskId = upstreamTaskIds[0]
+	}
+
+	// 生成任务模型
+	t := model.Task{
+		Id:       id.String(),
+		SpiderId: step.SpiderId,
+		NodeId:   nodeId,
+		Status:   constants.StatusPending,
+		Param:    step.Param,
+		Envs: []model.Env{
+			{Name: "CRAWLAB_WORKFLOW_RUN_ID", Value: run.Id.Hex()},
+			{Name: "CRAWLAB_UPSTREAM_TASK_ID", Value: upstreamTaskId},
+			{Name: "CRAWLAB_UPSTREAM_TASK_IDS", Value: strings.Join(upstreamTaskIds, ",")},
+		},
+		WorkflowRunId: run.Id,
+	}
+
+	// 将任务存入数据库
+	if err := model.AddTask(t); err != nil {
+		return err
+	}
+
+	// 加入任务队列
+	if err := AssignTask(t); err != nil {
+		return err
+	}
+
+	step.TaskId = t.Id
+	step.Status = t.Status
+
+	return nil
+}
+
+// 获取步骤任务、派发步骤任务（测试时可替换）
+var getStepTask = model.GetTask
+var assignStepTask = AssignStepTask
+
+// 推进工作流运行：同步步骤状态，派发满足条件的步骤，返回是否有变化
+func AdvanceWorkflowRun(run *model.WorkflowRun) (changed bool, err error) {
+	// 同步已派发步骤的任务状态
+	for i := range run.Steps {
+		step := &run.Steps[i]
+		if step.TaskId == "" || IsStepFinished(step.Status) {
+			continue
+		}
+		task, err := getStepTask(step.TaskId)
+		if err != nil {
+			if err != mgo.ErrNotFound {
+				return changed, err
+			}
+			// 任务已被删除
+			task.Status = constants.StatusError
+		}
+		if task.Status != step.Status {
+			step.Status = task.Status
+			changed = true
+		}
+	}
+
+	// 步骤索引
+	stepMap := map[string]*model.WorkflowRunStep{}
+	for i := range run.Steps {
+		stepMap[run.Steps[i].Name] = &run.Steps[i]
+	}
+
+	// 循环处理，直到没有新派发或跳过的步骤（跳过的步骤可能使下游步骤就绪）
+	for {
+		progressed := false
+		for i := range run.Steps {
+			step := &run.Steps[i]
+			if step.Status != constants.StatusWaiting {
+				continue
+			}
+
+			// 上游步骤须全部结束
+			ready := true
+			shouldRun := true
+			var upstreamTaskIds []string
+			for _, dep := range step.Dependencies {
+				upstream := stepMap[dep.Step]
+				if !IsStepFinished(upstream.Status) {
+					ready = false
+					break
+				}
+				if !IsConditionMet(dep.Condition, upstream.Status) {
+					shouldRun = false
+				}
+				if upstream.TaskId != "" {
+					upstreamTaskIds = append(upstreamTaskIds, upstream.TaskId)
+				}
+			}
+			if !ready {
+				continue
+			}
+
+			if shouldRun {
+				// 派发任务
+				if err := assignStepTask(run, step, upstreamTaskIds); err != nil {
+					return changed, err
+				}
+			} else {
+				// 不满足条件，跳过
+				step.Status = constants.StatusSkipped
+			}
+			progressed = true
+			changed = true
+		}
+		if !progressed {
+			break
+		}
+	}
+
+	// 所有步骤结束时，工作流运行结束
+	finished := true
+	failed := false
+	for _, step := range run.Steps {
+		if !IsStepFinished(step.Status) {
+			finished = false
+			break
+		}
+		if step.Status == constants.StatusError || step.Status == constants.StatusCancelled {
+			failed = true
+		}
+	}
+	if finished {
+		if failed {
+			run.Status = constants.StatusError
+		} else {
+			run.Status = constants.StatusFinished
+		}
+		run.FinishTs = time.Now()
+		changed = true
+	}
+
+	return changed, nil
+}
+
+// 运行工作流
+func RunWorkflow(wf model.Workflow, trigger string) (model.WorkflowRun, error) {
+	// 生成运行记录，保存工作流步骤快照
+	run := model.WorkflowRun{
+		Id:           bson.NewObjectId(),
+		WorkflowId:   wf.Id,
+		WorkflowName: wf.Name,
+		Status:       constants.StatusRunning,
+		Trigger:      trigger,
+		StartTs:      time.Now(),
+	}
+	for _, step := range wf.Steps {
+		run.Steps = append(run.Steps, model.WorkflowRunStep{
+			WorkflowStep: step,
+			Status:       constants.StatusWaiting,
+		})
+	}
+
+	// 先保存运行记录，避免任务已派发而运行记录不存在
+	if err := run.Add(); err != nil {
+		return run, err
+	}
+
+	// 派发起始步骤，已派发的步骤即使出错也需要保存，之后由Leader定时推进
+	changed, err := AdvanceWorkflowRun(&run)
+	if changed {
+		if err := run.Save(); err != nil {
+			return run, err
+		}
+	}
+	if err != nil {
+		return run, err
+	}
+
+	return run, nil
+}
+
+func RunWorkflowById(id bson.ObjectId, trigger string) (model.WorkflowRun, error) {
+	wf, err := model.GetWorkflow(id)
+	if err != nil {
+		return model.WorkflowRun{}, err
+	}
+	return RunWorkflow(wf, trigger)
+}
+
+// 推进所有运行中的工作流
+func UpdateWorkflowRuns() {
+	runs, err := model.GetWorkflowRunList(bson.M{"status": constants.StatusRunning}, 0, constants.Infinite, "create_ts")
+	if err != nil {
+		log.Errorf(err.Error())
+		return
+	}
+
+	for _, run := range runs {
+		changed, err := AdvanceWorkflowRun(&run)
+		if err != nil {
+			log.Errorf(err.Error())
+			debug.PrintStack()
+		}
+		if !changed {
+			continue
+		}
+		if err := run.Save(); err != nil {
+			log.Errorf(err.Error())
+			continue
+		}
+	}
+}
+
+// 初始化工作流服务
+func InitWorkflowService() error {
+	c := cron.New(cron.WithSeconds())
+
+	// 每5秒推进一次运行中的工作流（仅由Leader执行）
+	if _, err := c.AddFunc("*/5 * * * * *", LeaderJob(UpdateWorkflowRuns)); err != nil {
+		return err
+	}
+
+	c.Start()
+	return nil
+}
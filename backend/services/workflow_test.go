@@ -0,0 +1,218 @@
+package services
+
+import (
+	"crawlab/constants"
+	"crawlab/model"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateWorkflow(t *testing.T) {
+	spiderId := bson.NewObjectId()
+	step := func(name string, deps ...model.WorkflowDependency) model.WorkflowStep {
+		return model.WorkflowStep{Name: name, SpiderId: spiderId, Dependencies: deps}
+	}
+	dep := func(name string, condition string) model.WorkflowDependency {
+		return model.WorkflowDependency{Step: name, Condition: condition}
+	}
+
+	tests := []struct {
+		name  string
+		steps []model.WorkflowStep
+		err   string
+	}{
+		{"valid", []model.WorkflowStep{
+			step("a"),
+			step("b", dep("a", constants.ConditionOnSuccess)),
+			step("c", dep("a", constants.ConditionOnFailure), dep("b", constants.ConditionAlways)),
+			step("d", dep("c", "")),
+		}, ""},
+		{"empty", nil, "no steps"},
+		{"empty name", []model.WorkflowStep{step("")}, "name is empty"},
+		{"duplicated", []model.WorkflowStep{step("a"), step("a")}, "duplicated"},
+		{"no spider", []model.WorkflowStep{{Name: "a"}}, "spider_id"},
+		{"unknown step", []model.WorkflowStep{step("a", dep("x", ""))}, "unknown step"},
+		{"bad condition", []model.WorkflowStep{step("a"), step("b", dep("a", "sometimes"))}, "invalid condition"},
+		{"self cycle", []model.WorkflowStep{step("a", dep("a", ""))}, "cycle"},
+		{"cycle", []model.WorkflowStep{
+			step("a", dep("c", "")),
+			step("b", dep("a", "")),
+			step("c", dep("b", "")),
+		}, "cycle"},
+	}
+	for _, tt := range tests {
+		err := ValidateWorkflow(model.Workflow{Steps: tt.steps})
+		if tt.err == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %s", tt.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: error = %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+// 替换任务的获取及派发，tasks为任务ID到状态的映射
+func fakeStepTasks(tasks map[string]string) (assigned *[]string, restore func()) {
+	oldGet, oldAssign := getStepTask, assignStepTask
+	assigned = &[]string{}
+	getStepTask = func(id string) (model.Task, error) {
+		status, ok := tasks[id]
+		if !ok {
+			return model.Task{}, mgo.ErrNotFound
+		}
+		return model.Task{Id: id, Status: status}, nil
+	}
+	assignStepTask = func(run *model.WorkflowRun, step *model.WorkflowRunStep, upstreamTaskIds []string) error {
+		step.TaskId = "task-" + step.Name
+		step.Status = constants.StatusPending
+		tasks[step.TaskId] = constants.StatusPending
+		*assigned = append(*assigned, step.Name+"("+strings.Join(upstreamTaskIds, ",")+")")
+		return nil
+	}
+	return assigned, func() {
+		getStepTask, assignStepTask = oldGet, oldAssign
+	}
+}
+
+func newTestWorkflowRun(steps ...model.WorkflowStep) *model.WorkflowRun {
+	run := &model.WorkflowRun{Status: constants.StatusRunning}
+	for _, step := range steps {
+		run.Steps = append(run.Steps, model.WorkflowRunStep{WorkflowStep: step, Status: constants.StatusWaiting})
+	}
+	return run
+}
+
+func getTestRunStatus(run *model.WorkflowRun) map[string]string {
+	res := map[string]string{}
+	for _, step := range run.Steps {
+		res[step.Name] = step.Status
+	}
+	return res
+}
+
+func TestAdvanceWorkflowRun(t *testing.T) {
+	tasks := map[string]string{}
+	assigned, restore := fakeStepTasks(tasks)
+	defer restore()
+
+	// a -> b (on_success), a -> c (on_failure), b,c -> d (always)
+	run := newTestWorkflowRun(
+		model.WorkflowStep{Name: "a"},
+		model.WorkflowStep{Name: "b", Dependencies: []model.WorkflowDependency{{Step: "a", Condition: constants.ConditionOnSuccess}}},
+		model.WorkflowStep{Name: "c", Dependencies: []model.WorkflowDependency{{Step: "a", Condition: constants.ConditionOnFailure}}},
+		model.WorkflowStep{Name: "d", Dependencies: []model.WorkflowDependency{
+			{Step: "b", Condition: constants.ConditionAlways},
+			{Step: "c", Condition: constants.ConditionAlways},
+		}},
+	)
+
+	// 只派发起始步骤
+	if changed, err := AdvanceWorkflowRun(run); err != nil || !changed {
+		t.Fatalf("AdvanceWorkflowRun = %v, %v", changed, err)
+	}
+	if !reflect.DeepEqual(*assigned, []string{"a()"}) {
+		t.Fatalf("assigned = %v", *assigned)
+	}
+
+	// 任务未结束时没有变化
+	if changed, err := AdvanceWorkflowRun(run); err != nil || changed {
+		t.Fatalf("AdvanceWorkflowRun = %v, %v, want no change", changed, err)
+	}
+
+	// a成功：派发b，跳过c
+	tasks["task-a"] = constants.StatusFinished
+	if _, err := AdvanceWorkflowRun(run); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"a": constants.StatusFinished,
+		"b": constants.StatusPending,
+		"c": constants.StatusSkipped,
+		"d": constants.StatusWaiting,
+	}
+	if got := getTestRunStatus(run); !reflect.DeepEqual(got, want) {
+		t.Fatalf("status = %v, want %v", got, want)
+	}
+
+	// b失败：d的条件为always，仍然派发，上游任务ID只包含已派发的步骤
+	tasks["task-b"] = constants.StatusError
+	if _, err := AdvanceWorkflowRun(run); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*assigned, []string{"a()", "b(task-a)", "d(task-b)"}) {
+		t.Fatalf("assigned = %v", *assigned)
+	}
+	if run.Status != constants.StatusRunning {
+		t.Fatalf("run status = %s", run.Status)
+	}
+
+	// 所有步骤结束，有失败的步骤时运行失败
+	tasks["task-d"] = constants.StatusFinished
+	if _, err := AdvanceWorkflowRun(run); err != nil {
+		t.Fatal(err)
+	}
+	if run.Status != constants.StatusError {
+		t.Fatalf("run status = %s, want %s", run.Status, constants.StatusError)
+	}
+}
+
+func TestAdvanceWorkflowRunOnFailure(t *testing.T) {
+	tasks := map[string]string{}
+	assigned, restore := fakeStepTasks(tasks)
+	defer restore()
+
+	// a -> b (on_failure) -> c (on_success)
+	run := newTestWorkflowRun(
+		model.WorkflowStep{Name: "a"},
+		model.WorkflowStep{Name: "b", Dependencies: []model.WorkflowDependency{{Step: "a", Condition: constants.ConditionOnFailure}}},
+		model.WorkflowStep{Name: "c", Dependencies: []model.WorkflowDependency{{Step: "b", Condition: constants.ConditionOnSuccess}}},
+	)
+	if _, err := AdvanceWorkflowRun(run); err != nil {
+		t.Fatal(err)
+	}
+
+	// a成功：b、c均被跳过，运行成功结束
+	tasks["task-a"] = constants.StatusFinished
+	if _, err := AdvanceWorkflowRun(run); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"a": constants.StatusFinished,
+		"b": constants.StatusSkipped,
+		"c": constants.StatusSkipped,
+	}
+	if got := getTestRunStatus(run); !reflect.DeepEqual(got, want) {
+		t.Fatalf("status = %v, want %v", got, want)
+	}
+	if run.Status != constants.StatusFinished {
+		t.Fatalf("run status = %s, want %s", run.Status, constants.StatusFinished)
+	}
+	if !reflect.DeepEqual(*assigned, []string{"a()"}) {
+		t.Fatalf("assigned = %v", *assigned)
+	}
+
+	// 任务被删除时视为失败，触发on_failure
+	tasks = map[string]string{}
+	assigned, restore2 := fakeStepTasks(tasks)
+	defer restore2()
+	run = newTestWorkflowRun(
+		model.WorkflowStep{Name: "a"},
+		model.WorkflowStep{Name: "b", Dependencies: []model.WorkflowDependency{{Step: "a", Condition: constants.ConditionOnFailure}}},
+	)
+	if _, err := AdvanceWorkflowRun(run); err != nil {
+		t.Fatal(err)
+	}
+	delete(tasks, "task-a")
+	if _, err := AdvanceWorkflowRun(run); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*assigned, []string{"a()", "b(task-a)"}) {
+		t.Fatalf("assigned = %v", *assigned)
+	}
+}
@@ -0,0 +1,146 @@
+package sinks
+
+import (
+	"crawlab/constants"
+	"encoding/json"
+	"github.com/globalsign/mgo/bson"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// 记录收到的请求，并替换结果数量记录（不依赖MongoDB）
+type testReceiver struct {
+	mu      sync.Mutex
+	paths   []string
+	types   []string
+	tokens  []string
+	bodies  []map[string]interface{}
+	counts  map[string]int
+	status  int
+	cleanup func()
+}
+
+func newTestReceiver(t *testing.T) (*testReceiver, *httptest.Server) {
+	r := &testReceiver{counts: map[string]int{}, status: http.StatusOK}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		data, _ := ioutil.ReadAll(req.Body)
+		body := map[string]interface{}{}
+		if err := json.Unmarshal(data, &body); err != nil {
+			t.Errorf("invalid json body: %s", data)
+		}
+		r.mu.Lock()
+		r.paths = append(r.paths, req.URL.Path)
+		r.types = append(r.types, req.Header.Get("Content-Type"))
+		r.tokens = append(r.tokens, req.Header.Get("Authorization"))
+		r.bodies = append(r.bodies, body)
+		status := r.status
+		r.mu.Unlock()
+		w.WriteHeader(status)
+	}))
+
+	old := addResultCount
+	addResultCount = func(taskId string, n int) error {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.counts[taskId] += n
+		return nil
+	}
+	r.cleanup = func() {
+		addResultCount = old
+		srv.Close()
+	}
+	return r, srv
+}
+
+func TestWebhookSinkWrite(t *testing.T) {
+	r, srv := newTestReceiver(t)
+	defer r.cleanup()
+
+	sink, err := New(Config{
+		Type:      constants.SinkTypeWebhook,
+		Url:       srv.URL + "/hook",
+		Headers:   map[string]string{"Authorization": "Bearer abc"},
+		BatchSize: 2,
+	}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sink.Write("t1", []bson.M{{"n": 1}, {"n": 2}, {"n": 3}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(r.bodies) != 2 {
+		t.Fatalf("requests = %d, want 2", len(r.bodies))
+	}
+	if r.paths[0] != "/hook" || r.types[0] != "application/json" || r.tokens[0] != "Bearer abc" {
+		t.Fatalf("request = %s %s %s", r.paths[0], r.types[0], r.tokens[0])
+	}
+	if r.bodies[0]["task_id"] != "t1" || len(r.bodies[0]["items"].([]interface{})) != 2 || len(r.bodies[1]["items"].([]interface{})) != 1 {
+		t.Fatalf("bodies = %v", r.bodies)
+	}
+	item := r.bodies[1]["items"].([]interface{})[0].(map[string]interface{})
+	if item["task_id"] != "t1" || item["n"] != float64(3) {
+		t.Fatalf("item = %v", item)
+	}
+	if r.counts["t1"] != 3 {
+		t.Fatalf("count = %d, want 3", r.counts["t1"])
+	}
+
+	if _, _, err := sink.Preview("t1", 1, 10); err != ErrPreviewNotSupported {
+		t.Fatalf("Preview error = %v", err)
+	}
+}
+
+func TestKafkaSinkWrite(t *testing.T) {
+	r, srv := newTestReceiver(t)
+	defer r.cleanup()
+
+	sink, err := New(Config{
+		Type:  constants.SinkTypeKafka,
+		Url:   srv.URL + "/",
+		Topic: "results",
+	}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sink.Write("t1", []bson.M{{"n": 1}, {"n": 2}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(r.bodies) != 1 || r.paths[0] != "/topics/results" || r.types[0] != "application/vnd.kafka.json.v2+json" {
+		t.Fatalf("requests = %v %v", r.paths, r.types)
+	}
+	records := r.bodies[0]["records"].([]interface{})
+	if len(records) != 2 {
+		t.Fatalf("records = %v", records)
+	}
+	record := records[0].(map[string]interface{})
+	if record["key"] != "t1" || record["value"].(map[string]interface{})["task_id"] != "t1" {
+		t.Fatalf("record = %v", record)
+	}
+	if r.counts["t1"] != 2 {
+		t.Fatalf("count = %d, want 2", r.counts["t1"])
+	}
+}
+
+func TestHttpSinkErrorStatus(t *testing.T) {
+	r, srv := newTestReceiver(t)
+	defer r.cleanup()
+	r.status = http.StatusInternalServerError
+
+	sink, err := New(Config{Type: constants.SinkTypeWebhook, Url: srv.URL}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Write("t1", []bson.M{{"n": 1}}); err == nil {
+		t.Fatal("expected error for non-2xx response")
+	}
+	if r.counts["t1"] != 0 {
+		t.Fatalf("count = %d, want 0", r.counts["t1"])
+	}
+}
@@ -0,0 +1,54 @@
+package sinks
+
+import (
+	"github.com/globalsign/mgo/bson"
+	"strings"
+)
+
+// Kafka结果储存，通过Kafka REST Proxy（v2 API）写入主题，消息的key为任务ID
+// 注意：不直连Kafka broker（不支持Kafka二进制协议、SASL等），需在Kafka集群前部署REST Proxy，
+// 消息以JSON格式（application/vnd.kafka.json.v2+json）写入，分区由REST Proxy按key分配
+type KafkaSink struct {
+	cfg Config
+}
+
+type kafkaRecord struct {
+	Key   string `json:"key"`
+	Value bson.M `json:"value"`
+}
+
+func (k *KafkaSink) Write(taskId string, items []bson.M) error {
+	items = withTaskId(taskId, items)
+	url := strings.TrimRight(k.cfg.Url, "/") + "/topics/" + k.cfg.Topic
+	size := batchSize(k.cfg)
+
+	for start := 0; start < len(items); start += size {
+		end := start + size
+		if end > len(items) {
+			end = len(items)
+		}
+
+		var records []kafkaRecord
+		for _, item := range items[start:end] {
+			records = append(records, kafkaRecord{Key: taskId, Value: item})
+		}
+		body := map[string]interface{}{"records": records}
+		if err := postJson(url, "application/vnd.kafka.json.v2+json", k.cfg.Headers, body); err != nil {
+			return err
+		}
+
+		// 已发送的结果计入结果数量
+		if err := addResultCount(taskId, end-start); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (k *KafkaSink) Count(taskId string) (int, error) {
+	return getResultCount(taskId)
+}
+
+func (k *KafkaSink) Preview(taskId string, pageNum int, pageSize int) ([]interface{}, int, error) {
+	return nil, 0, ErrPreviewNotSupported
+}
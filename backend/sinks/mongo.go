@@ -0,0 +1,289 @@
+package sinks
+
+import (
+	"crawlab/database"
+	"github.com/apex/log"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/pkg/errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MongoDB结果储存（默认），结果保存在爬虫的结果集中
+type MongoSink struct {
+	Col string
+}
+
+var (
+	// 已建立去重索引的结果集
+	mongoDedupIndexes     = map[string]bool{}
+	mongoDedupIndexesLock sync.Mutex
+)
+
+// 建立(spider_id, dedup_key)唯一索引（只包含带有去重键的结果），避免并发写入时同一爬虫出现重复的结果
+// 结果集中已有重复结果时建立失败，只记录错误，不影响写入
+func ensureDedupIndex(c *mgo.Collection) {
+	mongoDedupIndexesLock.Lock()
+	defer mongoDedupIndexesLock.Unlock()
+
+	if mongoDedupIndexes[c.Name] {
+		return
+	}
+	if err := c.EnsureIndex(mgo.Index{
+		Key:           []string{"spider_id", "dedup_key"},
+		Unique:        true,
+		Background:    true,
+		PartialFilter: bson.M{"dedup_key": bson.M{"$exists": true}},
+	}); err != nil {
+		log.Errorf("ensure dedup index of " + c.Name + " error: " + err.Error())
+	}
+	mongoDedupIndexes[c.Name] = true
+}
+
+func (m *MongoSink) Write(taskId string, items []bson.M) error {
+	if m.Col == "" {
+		return errors.New("spider has no result collection")
+	}
+	if len(items) == 0 {
+		return nil
+	}
+
+	s, c := database.GetCol(m.Col)
+	defer s.Close()
+
+	docs := make([]interface{}, len(items))
+	hasDedupKey := false
+	for i, item := range withTaskId(taskId, items) {
+		if _, ok := item["create_ts"]; !ok {
+			item["create_ts"] = time.Now()
+		}
+		if _, ok := item["dedup_key"]; ok {
+			hasDedupKey = true
+		}
+		docs[i] = item
+	}
+	if hasDedupKey {
+		ensureDedupIndex(c)
+	}
+	return c.Insert(docs...)
+}
+
+func (m *MongoSink) Upsert(taskId string, items []bson.M) error {
+	if m.Col == "" {
+		return errors.New("spider has no result collection")
+	}
+
+	s, c := database.GetCol(m.Col)
+	defer s.Close()
+
+	ensureDedupIndex(c)
+
+	for _, item := range withTaskId(taskId, items) {
+		// 首次出现时间保持不变
+		createTs, ok := item["create_ts"]
+		if !ok {
+			createTs = time.Now()
+		}
+		delete(item, "create_ts")
+
+		// 结果集可能被多个爬虫共用，按爬虫及去重键匹配
+		if _, err := c.Upsert(bson.M{"spider_id": item["spider_id"], "dedup_key": item["dedup_key"]}, bson.M{
+			"$set":         item,
+			"$setOnInsert": bson.M{"create_ts": createTs},
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MongoSink) Count(taskId string) (int, error) {
+	if m.Col == "" {
+		return 0, nil
+	}
+
+	s, c := database.GetCol(m.Col)
+	defer s.Close()
+
+	return c.Find(bson.M{"task_id": taskId}).Count()
+}
+
+func (m *MongoSink) Preview(taskId string, pageNum int, pageSize int) (results []interface{}, total int, err error) {
+	if m.Col == "" {
+		return
+	}
+
+	s, c := database.GetCol(m.Col)
+	defer s.Close()
+
+	query := bson.M{
+		"task_id": taskId,
+	}
+	if err = c.Find(query).Skip((pageNum - 1) * pageSize).Limit(pageSize).Sort("-create_ts").All(&results); err != nil {
+		return
+	}
+
+	if total, err = c.Find(query).Count(); err != nil {
+		return
+	}
+
+	return
+}
+
+// $in需要数组，没有任务时使用空数组
+func taskIdList(taskIds []string) []string {
+	if taskIds == nil {
+		return []string{}
+	}
+	return taskIds
+}
+
+type mongoIterator struct {
+	s      *mgo.Session
+	iter   *mgo.Iter
+	closed bool
+}
+
+func (i *mongoIterator) Next(item *bson.M) bool {
+	*item = bson.M{}
+	return i.iter.Next(item)
+}
+
+func (i *mongoIterator) Close() error {
+	if i.closed {
+		return nil
+	}
+	i.closed = true
+	defer i.s.Close()
+	return i.iter.Close()
+}
+
+func (m *MongoSink) Iter(taskIds []string) (Iterator, error) {
+	if m.Col == "" {
+		return nil, errors.New("spider has no result collection")
+	}
+
+	s, c := database.GetCol(m.Col)
+	iter := c.Find(bson.M{"task_id": bson.M{"$in": taskIdList(taskIds)}}).Iter()
+	return &mongoIterator{s: s, iter: iter}, nil
+}
+
+func (m *MongoSink) Remove(taskIds []string) (int, error) {
+	if m.Col == "" {
+		return 0, nil
+	}
+
+	s, c := database.GetCol(m.Col)
+	defer s.Close()
+
+	info, err := c.RemoveAll(bson.M{"task_id": bson.M{"$in": taskIdList(taskIds)}})
+	if err != nil {
+		return 0, err
+	}
+	return info.Removed, nil
+}
+
+// 查询条件中的值可能以字符串或数字储存，等值匹配时同时匹配两者
+func mongoEqValues(value string) []interface{} {
+	values := []interface{}{value}
+	if f, err := strconv.ParseFloat(value, 64); err == nil {
+		values = append(values, f)
+	}
+	if b, err := strconv.ParseBool(value); err == nil {
+		values = append(values, b)
+	}
+	if bson.IsObjectIdHex(value) {
+		values = append(values, bson.ObjectIdHex(value))
+	}
+	return values
+}
+
+// 范围查询的值：数字、日期或字符串
+func mongoRangeValue(value string) interface{} {
+	if f, err := strconv.ParseFloat(value, 64); err == nil {
+		return f
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t
+	}
+	return value
+}
+
+// 将查询转换为MongoDB查询条件（字段及运算符均已校验，不会注入其他运算符）
+func mongoSelector(q Query) bson.M {
+	selector := bson.M{}
+	for _, f := range q.Filters {
+		cond, ok := selector[f.Field].(bson.M)
+		if !ok {
+			cond = bson.M{}
+			selector[f.Field] = cond
+		}
+		switch f.Op {
+		case OpEq:
+			cond["$in"] = mongoEqValues(f.Value)
+		case OpNe:
+			cond["$nin"] = mongoEqValues(f.Value)
+		case OpIn:
+			var values []interface{}
+			for _, v := range strings.Split(f.Value, "|") {
+				values = append(values, mongoEqValues(v)...)
+			}
+			cond["$in"] = values
+		case OpRegex:
+			cond["$regex"] = bson.RegEx{Pattern: f.Value}
+		case OpGt, OpGte, OpLt, OpLte:
+			cond["$"+f.Op] = mongoRangeValue(f.Value)
+		}
+	}
+
+	// 限定任务范围（与筛选条件分开，筛选task_id时不会超出任务范围）
+	taskSelector := bson.M{"task_id": bson.M{"$in": taskIdList(q.TaskIds)}}
+	if len(selector) == 0 {
+		return taskSelector
+	}
+	return bson.M{"$and": []bson.M{taskSelector, selector}}
+}
+
+func (m *MongoSink) Query(q Query) (results []interface{}, total int, err error) {
+	if m.Col == "" {
+		return
+	}
+
+	s, c := database.GetCol(m.Col)
+	defer s.Close()
+
+	selector := mongoSelector(q)
+	query := c.Find(selector)
+
+	// 排序
+	sort := q.Sort
+	if len(sort) == 0 {
+		sort = []string{"-create_ts"}
+	}
+	query = query.Sort(sort...)
+
+	// 投影
+	if len(q.Fields) > 0 {
+		fields := bson.M{}
+		for _, f := range q.Fields {
+			fields[f] = 1
+		}
+		query = query.Select(fields)
+	}
+
+	if err = query.Skip((q.PageNum - 1) * q.PageSize).Limit(q.PageSize).All(&results); err != nil {
+		return
+	}
+
+	if total, err = c.Find(selector).Count(); err != nil {
+		return
+	}
+
+	return
+}
@@ -0,0 +1,103 @@
+package sinks
+
+import (
+	"github.com/pkg/errors"
+	"regexp"
+	"strings"
+)
+
+// 筛选条件运算符
+const (
+	OpEq    = "eq"    // 等于
+	OpNe    = "ne"    // 不等于
+	OpGt    = "gt"    // 大于
+	OpGte   = "gte"   // 大于等于
+	OpLt    = "lt"    // 小于
+	OpLte   = "lte"   // 小于等于
+	OpIn    = "in"    // 属于（值以|分隔）
+	OpRegex = "regex" // 正则匹配
+)
+
+var filterOps = map[string]bool{
+	OpEq: true, OpNe: true, OpGt: true, OpGte: true, OpLt: true, OpLte: true, OpIn: true, OpRegex: true,
+}
+
+// 字段名只允许字母、数字、下划线，嵌套字段以点号连接，不能以$开头，防止注入查询运算符
+var fieldNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z0-9_]+)*$`)
+
+// 正则表达式最大长度
+const maxRegexLength = 256
+
+// 每页最多返回的结果数量
+const MaxPageSize = 1000
+
+// 筛选条件
+type Filter struct {
+	Field string
+	Op    string
+	Value string
+}
+
+// 结果查询
+type Query struct {
+	TaskIds  []string // 任务ID
+	Filters  []Filter // 筛选条件（同时满足）
+	Sort     []string // 排序字段，以-开头表示倒序
+	Fields   []string // 返回的字段，为空时返回全部字段
+	PageNum  int
+	PageSize int
+}
+
+// 是否只是分页预览（不含筛选、排序、投影）
+func (q Query) IsPreview() bool {
+	return len(q.Filters) == 0 && len(q.Sort) == 0 && len(q.Fields) == 0
+}
+
+// 支持筛选、排序、投影查询的储存（MongoDB）
+type Queryable interface {
+	Query(q Query) ([]interface{}, int, error)
+}
+
+var ErrQueryNotSupported = errors.New("result query is not supported by this sink")
+
+// 校验字段名
+func ValidateField(field string) error {
+	if !fieldNameRegex.MatchString(field) {
+		return errors.New("invalid field name: " + field)
+	}
+	return nil
+}
+
+// 解析筛选条件，格式为 字段:运算符:值，如 sku:eq:100012043978、price:gte:100、title:regex:^Apple
+func ParseFilter(str string) (f Filter, err error) {
+	parts := strings.SplitN(str, ":", 3)
+	if len(parts) != 3 {
+		return f, errors.New("invalid filter, expected field:op:value: " + str)
+	}
+	f = Filter{Field: parts[0], Op: parts[1], Value: parts[2]}
+	if err := ValidateField(f.Field); err != nil {
+		return f, err
+	}
+	if !filterOps[f.Op] {
+		return f, errors.New("invalid filter operator: " + f.Op)
+	}
+	if f.Op == OpRegex {
+		if len(f.Value) > maxRegexLength {
+			return f, errors.New("regex is too long")
+		}
+		if _, err := regexp.Compile(f.Value); err != nil {
+			return f, err
+		}
+	}
+	return f, nil
+}
+
+// 校验排序字段
+func ValidateSort(sort []string) error {
+	for _, s := range sort {
+		if err := ValidateField(strings.TrimPrefix(s, "-")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
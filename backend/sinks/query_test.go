@@ -0,0 +1,108 @@
+package sinks
+
+import (
+	"github.com/globalsign/mgo/bson"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateField(t *testing.T) {
+	for _, field := range []string{"title", "_id", "price_2", "detail.price", "images.0.url"} {
+		if err := ValidateField(field); err != nil {
+			t.Errorf("ValidateField(%q) unexpected error: %s", field, err)
+		}
+	}
+	for _, field := range []string{"", "$where", "a.$gt", "$gt", "a..b", "a.", ".a", "a b", "a[0]", "0a", "title;", "a.b$"} {
+		if err := ValidateField(field); err == nil {
+			t.Errorf("ValidateField(%q) expected error", field)
+		}
+	}
+}
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Filter
+	}{
+		{"sku:eq:100012043978", Filter{Field: "sku", Op: OpEq, Value: "100012043978"}},
+		{"price:gte:100", Filter{Field: "price", Op: OpGte, Value: "100"}},
+		{"title:regex:^Apple", Filter{Field: "title", Op: OpRegex, Value: "^Apple"}},
+		{"url:eq:https://example.com/a", Filter{Field: "url", Op: OpEq, Value: "https://example.com/a"}},
+		{"detail.brand:in:a|b", Filter{Field: "detail.brand", Op: OpIn, Value: "a|b"}},
+		{"title:eq:", Filter{Field: "title", Op: OpEq, Value: ""}},
+	}
+	for _, tt := range tests {
+		got, err := ParseFilter(tt.in)
+		if err != nil {
+			t.Errorf("ParseFilter(%q) unexpected error: %s", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseFilter(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+
+	for _, in := range []string{
+		"title",                 // 缺少运算符和值
+		"title:eq",              // 缺少值
+		"$where:eq:sleep(1000)", // 运算符字段
+		"a.$gt:eq:1",            // 嵌套运算符字段
+		"title:where:1",         // 不支持的运算符
+		"title:$ne:1",           // MongoDB运算符
+		"title:regex:(",         // 非法正则
+		"title:regex:" + strings.Repeat("a", maxRegexLength+1), // 正则过长
+	} {
+		if _, err := ParseFilter(in); err == nil {
+			t.Errorf("ParseFilter(%q) expected error", in)
+		}
+	}
+}
+
+func TestValidateSort(t *testing.T) {
+	if err := ValidateSort([]string{"price", "-create_ts", "detail.price"}); err != nil {
+		t.Errorf("ValidateSort unexpected error: %s", err)
+	}
+	for _, sort := range [][]string{{"$natural"}, {"-$where"}, {"price", "a.$gt"}} {
+		if err := ValidateSort(sort); err == nil {
+			t.Errorf("ValidateSort(%q) expected error", sort)
+		}
+	}
+}
+
+func TestMongoSelector(t *testing.T) {
+	q := Query{
+		TaskIds: []string{"t1"},
+		Filters: []Filter{
+			{Field: "price", Op: OpGte, Value: "100"},
+			{Field: "price", Op: OpLt, Value: "200"},
+			{Field: "title", Op: OpRegex, Value: "^Apple"},
+			{Field: "brand", Op: OpIn, Value: "a|b"},
+			{Field: "sku", Op: OpNe, Value: "x"},
+			// 值中的运算符按字符串匹配
+			{Field: "name", Op: OpEq, Value: `{"$gt": ""}`},
+		},
+	}
+	want := bson.M{"$and": []bson.M{
+		{"task_id": bson.M{"$in": []string{"t1"}}},
+		{
+			"price": bson.M{"$gte": float64(100), "$lt": float64(200)},
+			"title": bson.M{"$regex": bson.RegEx{Pattern: "^Apple"}},
+			"brand": bson.M{"$in": []interface{}{"a", "b"}},
+			"sku":   bson.M{"$nin": []interface{}{"x"}},
+			"name":  bson.M{"$in": []interface{}{`{"$gt": ""}`}},
+		},
+	}}
+	if got := mongoSelector(q); !reflect.DeepEqual(got, want) {
+		t.Errorf("mongoSelector = %v, want %v", got, want)
+	}
+
+	// 没有筛选条件时只限定任务范围，筛选task_id不会超出任务范围
+	if got := mongoSelector(Query{TaskIds: []string{"t1"}}); !reflect.DeepEqual(got, bson.M{"task_id": bson.M{"$in": []string{"t1"}}}) {
+		t.Errorf("mongoSelector without filters = %v", got)
+	}
+	got := mongoSelector(Query{TaskIds: []string{"t1"}, Filters: []Filter{{Field: "task_id", Op: OpEq, Value: "t2"}}})
+	if and, ok := got["$and"].([]bson.M); !ok || len(and) != 2 || !reflect.DeepEqual(and[0], bson.M{"task_id": bson.M{"$in": []string{"t1"}}}) {
+		t.Errorf("mongoSelector with task_id filter = %v", got)
+	}
+}
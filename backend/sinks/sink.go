@@ -0,0 +1,179 @@
+package sinks
+
+import (
+	"crawlab/constants"
+	"crawlab/database"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/pkg/errors"
+	"regexp"
+	"time"
+)
+
+// 结果储存配置（保存在爬虫上，类型为空时使用MongoDB）
+type Config struct {
+	Type string `json:"type" bson:"type"` // 类型：mongo, sql, kafka, webhook
+
+	// SQL数据库
+	Driver string `json:"driver" bson:"driver"` // database/sql驱动名称，如mysql, postgres, sqlite3
+	Dsn    string `json:"dsn" bson:"dsn"`       // 连接字符串
+	Table  string `json:"table" bson:"table"`   // 表名
+
+	// Kafka、Webhook
+	// Kafka不直连broker，只能通过Confluent Kafka REST Proxy（v2 API）写入，Url需为REST Proxy地址
+	Url       string            `json:"url" bson:"url"`               // REST Proxy或Webhook地址
+	Topic     string            `json:"topic" bson:"topic"`           // Kafka主题
+	Headers   map[string]string `json:"headers" bson:"headers"`       // 附加请求头，如鉴权令牌
+	BatchSize int               `json:"batch_size" bson:"batch_size"` // 每批发送的结果数量
+}
+
+// 结果储存
+// 写入、计数、预览均通过该接口完成，任务的结果数量和结果预览与储存类型无关
+type Sink interface {
+	// 写入任务结果（每条结果都会带上task_id）
+	Write(taskId string, items []bson.M) error
+	// 任务结果数量
+	Count(taskId string) (int, error)
+	// 分页预览任务结果（按写入时间倒序）
+	Preview(taskId string, pageNum int, pageSize int) ([]interface{}, int, error)
+}
+
+// 支持按去重键更新结果的储存（MongoDB、SQL）
+// 只写的储存不支持更新，重复的结果会再次发送，由下游按去重键处理
+type Upserter interface {
+	// 按去重键更新结果，不存在则新建（结果中带有dedup_key字段）
+	Upsert(taskId string, items []bson.M) error
+}
+
+// 结果迭代器
+type Iterator interface {
+	// 读取下一条结果，没有更多结果或出错时返回false
+	Next(item *bson.M) bool
+	// 关闭迭代器，返回迭代过程中的错误
+	Close() error
+}
+
+// 支持逐条读取结果的储存（MongoDB、SQL），用于流式导出
+type Iterable interface {
+	// 遍历指定任务的结果
+	Iter(taskIds []string) (Iterator, error)
+}
+
+// 支持删除结果的储存（MongoDB、SQL），用于按保留策略清理结果
+type Remover interface {
+	// 删除指定任务的结果，返回删除的结果数量
+	Remove(taskIds []string) (int, error)
+}
+
+var (
+	// 只写的储存（Kafka、Webhook）无法读取结果
+	ErrPreviewNotSupported = errors.New("result preview is not supported by this sink")
+	ErrExportNotSupported  = errors.New("result export is not supported by this sink")
+	ErrRemoveNotSupported  = errors.New("result removal is not supported by this sink")
+
+	tableNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+const defaultBatchSize = 100
+
+// 校验结果储存配置
+func Validate(cfg Config) error {
+	switch cfg.Type {
+	case "", constants.SinkTypeMongo:
+		return nil
+	case constants.SinkTypeSql:
+		if cfg.Driver == "" || cfg.Dsn == "" {
+			return errors.New("sql sink requires driver and dsn")
+		}
+		if !tableNameRegex.MatchString(cfg.Table) {
+			return errors.New("invalid sql table name: " + cfg.Table)
+		}
+		return nil
+	case constants.SinkTypeKafka:
+		if cfg.Url == "" || cfg.Topic == "" {
+			return errors.New("kafka sink requires kafka rest proxy url and topic")
+		}
+		return nil
+	case constants.SinkTypeWebhook:
+		if cfg.Url == "" {
+			return errors.New("webhook sink requires url")
+		}
+		return nil
+	default:
+		return errors.New("unknown sink type: " + cfg.Type)
+	}
+}
+
+// 根据配置创建结果储存，col为爬虫的结果集（MongoDB储存使用）
+func New(cfg Config, col string) (Sink, error) {
+	if err := Validate(cfg); err != nil {
+		return nil, err
+	}
+	switch cfg.Type {
+	case constants.SinkTypeSql:
+		return newSqlSink(cfg)
+	case constants.SinkTypeKafka:
+		return &KafkaSink{cfg: cfg}, nil
+	case constants.SinkTypeWebhook:
+		return &WebhookSink{cfg: cfg}, nil
+	default:
+		return &MongoSink{Col: col}, nil
+	}
+}
+
+// 为结果加上task_id
+func withTaskId(taskId string, items []bson.M) []bson.M {
+	for _, item := range items {
+		item["task_id"] = taskId
+	}
+	return items
+}
+
+// 每批发送的结果数量
+func batchSize(cfg Config) int {
+	if cfg.BatchSize <= 0 {
+		return defaultBatchSize
+	}
+	return cfg.BatchSize
+}
+
+// 只写储存的结果数量记录在MongoDB中
+type resultCount struct {
+	TaskId   string    `bson:"_id"`
+	Count    int       `bson:"count"`
+	UpdateTs time.Time `bson:"update_ts"`
+}
+
+// 记录只写储存已发送的结果数量
+var addResultCount = incResultCount
+
+func incResultCount(taskId string, n int) error {
+	s, c := database.GetCol("result_counts")
+	defer s.Close()
+	_, err := c.UpsertId(taskId, bson.M{
+		"$inc": bson.M{"count": n},
+		"$set": bson.M{"update_ts": time.Now()},
+	})
+	return err
+}
+
+func getResultCount(taskId string) (int, error) {
+	s, c := database.GetCol("result_counts")
+	defer s.Close()
+	var rc resultCount
+	if err := c.FindId(taskId).One(&rc); err != nil {
+		if err == mgo.ErrNotFound {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return rc.Count, nil
+}
+
+// 删除任务的结果数量记录（任务记录删除时调用）
+func RemoveResultCounts(taskIds []string) error {
+	s, c := database.GetCol("result_counts")
+	defer s.Close()
+	_, err := c.RemoveAll(bson.M{"_id": bson.M{"$in": taskIdList(taskIds)}})
+	return err
+}
@@ -0,0 +1,308 @@
+package sinks
+
+import (
+	"database/sql"
+	"encoding/json"
+	"github.com/globalsign/mgo/bson"
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SQL数据库结果储存，支持的驱动：mysql、postgres、sqlite3
+// 结果以JSON形式保存在data列中，表不存在时自动创建：
+// task_id VARCHAR(64), spider_id VARCHAR(64), dedup_key VARCHAR(64), data TEXT, create_ts BIGINT（毫秒时间戳）
+// 并建立(spider_id, dedup_key)及task_id索引
+type SqlSink struct {
+	cfg Config
+	db  *sql.DB
+}
+
+var (
+	// 连接池按驱动和连接字符串复用
+	sqlDbs     = map[string]*sql.DB{}
+	sqlTables  = map[string]bool{}
+	sqlDbsLock sync.Mutex
+)
+
+func newSqlSink(cfg Config) (*SqlSink, error) {
+	sqlDbsLock.Lock()
+	defer sqlDbsLock.Unlock()
+
+	key := cfg.Driver + "|" + cfg.Dsn
+	db, ok := sqlDbs[key]
+	if !ok {
+		var err error
+		if db, err = sql.Open(cfg.Driver, cfg.Dsn); err != nil {
+			return nil, err
+		}
+		sqlDbs[key] = db
+	}
+
+	sink := &SqlSink{cfg: cfg, db: db}
+
+	// 建表
+	tableKey := key + "|" + cfg.Table
+	if !sqlTables[tableKey] {
+		if err := sink.createTable(); err != nil {
+			return nil, err
+		}
+		sqlTables[tableKey] = true
+	}
+
+	return sink, nil
+}
+
+// 建表及索引，旧版本创建的表没有spider_id列时补充该列
+func (s *SqlSink) createTable() error {
+	table := s.cfg.Table
+	if _, err := s.db.Exec("CREATE TABLE IF NOT EXISTS " + table +
+		" (task_id VARCHAR(64) NOT NULL, spider_id VARCHAR(64), dedup_key VARCHAR(64), data TEXT NOT NULL, create_ts BIGINT NOT NULL)"); err != nil {
+		return err
+	}
+
+	if rows, err := s.db.Query("SELECT spider_id FROM " + table + " WHERE 1 = 0"); err != nil {
+		if _, err := s.db.Exec("ALTER TABLE " + table + " ADD COLUMN spider_id VARCHAR(64)"); err != nil {
+			return err
+		}
+	} else {
+		_ = rows.Close()
+	}
+
+	indexes := []struct {
+		name    string
+		columns string
+	}{
+		{table + "_spider_id_dedup_key", "spider_id, dedup_key"},
+		{table + "_task_id", "task_id"},
+	}
+	for _, index := range indexes {
+		if err := s.createIndex(index.name, index.columns); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 创建索引，MySQL不支持CREATE INDEX IF NOT EXISTS，需先查询索引是否存在
+func (s *SqlSink) createIndex(name string, columns string) error {
+	if s.cfg.Driver == "mysql" {
+		var count int
+		if err := s.db.QueryRow("SELECT COUNT(*) FROM information_schema.statistics"+
+			" WHERE table_schema = DATABASE() AND table_name = ? AND index_name = ?", s.cfg.Table, name).Scan(&count); err != nil {
+			return err
+		}
+		if count > 0 {
+			return nil
+		}
+		_, err := s.db.Exec("CREATE INDEX " + name + " ON " + s.cfg.Table + " (" + columns + ")")
+		return err
+	}
+	_, err := s.db.Exec("CREATE INDEX IF NOT EXISTS " + name + " ON " + s.cfg.Table + " (" + columns + ")")
+	return err
+}
+
+// 占位符，PostgreSQL使用$1, $2...，其他数据库使用?
+func (s *SqlSink) placeholder(i int) string {
+	switch s.cfg.Driver {
+	case "postgres", "pgx":
+		return "$" + strconv.Itoa(i)
+	default:
+		return "?"
+	}
+}
+
+func (s *SqlSink) insertSql() string {
+	return "INSERT INTO " + s.cfg.Table + " (task_id, spider_id, dedup_key, data, create_ts) VALUES (" +
+		strings.Join([]string{s.placeholder(1), s.placeholder(2), s.placeholder(3), s.placeholder(4), s.placeholder(5)}, ", ") + ")"
+}
+
+// 结果所属的爬虫ID，没有时为NULL
+func spiderId(item bson.M) interface{} {
+	switch id := item["spider_id"].(type) {
+	case bson.ObjectId:
+		if id.Valid() {
+			return id.Hex()
+		}
+	case string:
+		if id != "" {
+			return id
+		}
+	}
+	return nil
+}
+
+// 结果的去重键，没有时为NULL
+func dedupKey(item bson.M) interface{} {
+	if key, ok := item["dedup_key"].(string); ok && key != "" {
+		return key
+	}
+	return nil
+}
+
+func (s *SqlSink) Write(taskId string, items []bson.M) error {
+	if len(items) == 0 {
+		return nil
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(s.insertSql())
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+
+	ts := time.Now().UnixNano() / int64(time.Millisecond)
+	for _, item := range withTaskId(taskId, items) {
+		data, err := json.Marshal(item)
+		if err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+		if _, err := stmt.Exec(taskId, spiderId(item), dedupKey(item), string(data), ts); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// 按爬虫ID及去重键更新，没有更新到记录时插入（不依赖各数据库的UPSERT语法）
+func (s *SqlSink) Upsert(taskId string, items []bson.M) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	ts := time.Now().UnixNano() / int64(time.Millisecond)
+	for _, item := range withTaskId(taskId, items) {
+		data, err := json.Marshal(item)
+		if err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+		res, err := tx.Exec("UPDATE "+s.cfg.Table+" SET task_id = "+s.placeholder(1)+", data = "+s.placeholder(2)+
+			" WHERE spider_id = "+s.placeholder(3)+" AND dedup_key = "+s.placeholder(4), taskId, string(data), spiderId(item), dedupKey(item))
+		if err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+		if n, err := res.RowsAffected(); err == nil && n > 0 {
+			continue
+		}
+		if _, err := tx.Exec(s.insertSql(), taskId, spiderId(item), dedupKey(item), string(data), ts); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *SqlSink) Count(taskId string) (count int, err error) {
+	err = s.db.QueryRow("SELECT COUNT(*) FROM "+s.cfg.Table+" WHERE task_id = "+s.placeholder(1), taskId).Scan(&count)
+	return
+}
+
+func (s *SqlSink) Preview(taskId string, pageNum int, pageSize int) (results []interface{}, total int, err error) {
+	rows, err := s.db.Query("SELECT data FROM "+s.cfg.Table+" WHERE task_id = "+s.placeholder(1)+
+		" ORDER BY create_ts DESC LIMIT "+strconv.Itoa(pageSize)+" OFFSET "+strconv.Itoa((pageNum-1)*pageSize), taskId)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var data string
+		if err = rows.Scan(&data); err != nil {
+			return
+		}
+		item := bson.M{}
+		if err = json.Unmarshal([]byte(data), &item); err != nil {
+			return
+		}
+		results = append(results, item)
+	}
+	if err = rows.Err(); err != nil {
+		return
+	}
+
+	if total, err = s.Count(taskId); err != nil {
+		return
+	}
+
+	return
+}
+
+type sqlIterator struct {
+	rows *sql.Rows
+	err  error
+}
+
+func (i *sqlIterator) Next(item *bson.M) bool {
+	if i.err != nil || !i.rows.Next() {
+		return false
+	}
+	var data string
+	if i.err = i.rows.Scan(&data); i.err != nil {
+		return false
+	}
+	*item = bson.M{}
+	if i.err = json.Unmarshal([]byte(data), item); i.err != nil {
+		return false
+	}
+	return true
+}
+
+func (i *sqlIterator) Close() error {
+	if err := i.rows.Close(); err != nil && i.err == nil {
+		i.err = err
+	}
+	if i.err == nil {
+		i.err = i.rows.Err()
+	}
+	return i.err
+}
+
+// 任务ID的IN条件及参数
+func (s *SqlSink) taskIdIn(taskIds []string) (string, []interface{}) {
+	if len(taskIds) == 0 {
+		taskIds = []string{""}
+	}
+	var placeholders []string
+	var args []interface{}
+	for i, id := range taskIds {
+		placeholders = append(placeholders, s.placeholder(i+1))
+		args = append(args, id)
+	}
+	return "task_id IN (" + strings.Join(placeholders, ", ") + ")", args
+}
+
+func (s *SqlSink) Iter(taskIds []string) (Iterator, error) {
+	cond, args := s.taskIdIn(taskIds)
+	rows, err := s.db.Query("SELECT data FROM "+s.cfg.Table+" WHERE "+cond+" ORDER BY create_ts", args...)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlIterator{rows: rows}, nil
+}
+
+func (s *SqlSink) Remove(taskIds []string) (int, error) {
+	cond, args := s.taskIdIn(taskIds)
+	res, err := s.db.Exec("DELETE FROM "+s.cfg.Table+" WHERE "+cond, args...)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
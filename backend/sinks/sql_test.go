@@ -0,0 +1,172 @@
+package sinks
+
+import (
+	"crawlab/constants"
+	"database/sql"
+	"github.com/globalsign/mgo/bson"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func newTestSqlSink(t *testing.T) (*SqlSink, func()) {
+	dir, err := ioutil.TempDir("", "crawlab-sink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink, err := New(Config{
+		Type:   constants.SinkTypeSql,
+		Driver: "sqlite3",
+		Dsn:    filepath.Join(dir, "results.db"),
+		Table:  "results",
+	}, "")
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return sink.(*SqlSink), func() {
+		_ = os.RemoveAll(dir)
+	}
+}
+
+func TestSqlSinkWriteAndRead(t *testing.T) {
+	sink, cleanup := newTestSqlSink(t)
+	defer cleanup()
+
+	if err := sink.Write("t1", []bson.M{{"title": "a"}, {"title": "b"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Write("t2", []bson.M{{"title": "c"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := sink.Count("t1"); err != nil || n != 2 {
+		t.Fatalf("Count(t1) = %d, %v", n, err)
+	}
+
+	results, total, err := sink.Preview("t1", 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 2 || len(results) != 1 || results[0].(bson.M)["task_id"] != "t1" {
+		t.Fatalf("Preview(t1) = %v, %d", results, total)
+	}
+
+	iter, err := sink.Iter([]string{"t1", "t2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var titles []string
+	var item bson.M
+	for iter.Next(&item) {
+		titles = append(titles, item["title"].(string))
+	}
+	if err := iter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(titles) != 3 {
+		t.Fatalf("Iter = %v", titles)
+	}
+
+	if n, err := sink.Remove([]string{"t1"}); err != nil || n != 2 {
+		t.Fatalf("Remove(t1) = %d, %v", n, err)
+	}
+	if n, _ := sink.Count("t1"); n != 0 {
+		t.Fatalf("Count(t1) after remove = %d", n)
+	}
+	if n, _ := sink.Count("t2"); n != 1 {
+		t.Fatalf("Count(t2) after remove = %d", n)
+	}
+}
+
+func TestSqlSinkUpsert(t *testing.T) {
+	sink, cleanup := newTestSqlSink(t)
+	defer cleanup()
+
+	spiderId := bson.NewObjectId()
+	otherSpiderId := bson.NewObjectId()
+	if err := sink.Upsert("t1", []bson.M{{"title": "a", "spider_id": spiderId, "dedup_key": "k1"}}); err != nil {
+		t.Fatal(err)
+	}
+	// 其他爬虫相同去重键的结果不受影响
+	if err := sink.Upsert("t0", []bson.M{{"title": "x", "spider_id": otherSpiderId, "dedup_key": "k1"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Upsert("t2", []bson.M{
+		{"title": "a2", "spider_id": spiderId, "dedup_key": "k1"},
+		{"title": "b", "spider_id": spiderId, "dedup_key": "k2"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, _ := sink.Count("t1"); n != 0 {
+		t.Fatalf("Count(t1) = %d, want 0", n)
+	}
+	results, total, err := sink.Preview("t2", 1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 2 {
+		t.Fatalf("Preview(t2) total = %d, want 2", total)
+	}
+	for _, r := range results {
+		if r.(bson.M)["dedup_key"] == "k1" && r.(bson.M)["title"] != "a2" {
+			t.Fatalf("upserted item = %v", r)
+		}
+	}
+	if n, _ := sink.Count("t0"); n != 1 {
+		t.Fatalf("Count(t0) = %d, want 1", n)
+	}
+}
+
+func TestSqlSinkMigrateTable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crawlab-sink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dsn := filepath.Join(dir, "results.db")
+
+	// 旧版本创建的表没有spider_id列
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE old_results (task_id VARCHAR(64) NOT NULL, dedup_key VARCHAR(64), data TEXT NOT NULL, create_ts BIGINT NOT NULL)"); err != nil {
+		t.Fatal(err)
+	}
+
+	sink, err := New(Config{Type: constants.SinkTypeSql, Driver: "sqlite3", Dsn: dsn, Table: "old_results"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.(Upserter).Upsert("t1", []bson.M{{"spider_id": bson.NewObjectId(), "dedup_key": "k1"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	var indexes []string
+	rows, err := db.Query("SELECT name FROM sqlite_master WHERE type = 'index' AND tbl_name = 'old_results' ORDER BY name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		indexes = append(indexes, name)
+	}
+	if !reflect.DeepEqual(indexes, []string{"old_results_spider_id_dedup_key", "old_results_task_id"}) {
+		t.Fatalf("indexes = %v", indexes)
+	}
+}
+
+func TestSqlSinkUnknownDriver(t *testing.T) {
+	if _, err := New(Config{Type: constants.SinkTypeSql, Driver: "unknown", Dsn: "x", Table: "results"}, ""); err == nil {
+		t.Fatal("expected error for unknown driver")
+	}
+}
@@ -0,0 +1,45 @@
+package sinks
+
+import (
+	"github.com/globalsign/mgo/bson"
+)
+
+// Webhook结果储存，按批次以JSON形式POST到指定地址：
+// {"task_id": "...", "items": [...]}
+type WebhookSink struct {
+	cfg Config
+}
+
+func (w *WebhookSink) Write(taskId string, items []bson.M) error {
+	items = withTaskId(taskId, items)
+	size := batchSize(w.cfg)
+
+	for start := 0; start < len(items); start += size {
+		end := start + size
+		if end > len(items) {
+			end = len(items)
+		}
+
+		body := map[string]interface{}{
+			"task_id": taskId,
+			"items":   items[start:end],
+		}
+		if err := postJson(w.cfg.Url, "application/json", w.cfg.Headers, body); err != nil {
+			return err
+		}
+
+		// 已发送的结果计入结果数量
+		if err := addResultCount(taskId, end-start); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *WebhookSink) Count(taskId string) (int, error) {
+	return getResultCount(taskId)
+}
+
+func (w *WebhookSink) Preview(taskId string, pageNum int, pageSize int) ([]interface{}, int, error) {
+	return nil, 0, ErrPreviewNotSupported
+}
@@ -1,7 +1,10 @@
 package utils
 
+import "sync"
+
 type ChanMap struct {
-	m map[string]chan string
+	m  map[string]chan string
+	mu sync.Mutex
 }
 
 func NewChanMap() *ChanMap {
@@ -9,6 +12,8 @@ func NewChanMap() *ChanMap {
 }
 
 func (cm *ChanMap) Chan(key string) chan string {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
 	if ch, ok := cm.m[key]; ok {
 		return ch
 	}
@@ -18,6 +23,8 @@ func (cm *ChanMap) Chan(key string) chan string {
 }
 
 func (cm *ChanMap) ChanBlocked(key string) chan string {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
 	if ch, ok := cm.m[key]; ok {
 		return ch
 	}
@@ -25,3 +32,16 @@ func (cm *ChanMap) ChanBlocked(key string) chan string {
 	cm.m[key] = ch
 	return ch
 }
+
+func (cm *ChanMap) HasChanKey(key string) bool {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	_, ok := cm.m[key]
+	return ok
+}
+
+func (cm *ChanMap) Remove(key string) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	delete(cm.m, key)
+}
@@ -1,7 +1,11 @@
 package utils
 
 import (
+	"archive/tar"
 	"archive/zip"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
 	"github.com/apex/log"
 	"io"
 	"os"
@@ -51,6 +55,7 @@ func DeCompressByPath(tarFile, dest string) error {
 /**
 @zipFile：压缩文件
 @dstPath：解压之后文件保存路径
+所有文件都被限制在保存路径内（防止zip-slip），并检查解压后的总大小和文件数
 */
 func DeCompress(srcFile *os.File, dstPath string) error {
 	// 如果保存路径不存在，创建一个
@@ -61,6 +66,13 @@ func DeCompress(srcFile *os.File, dstPath string) error {
 		}
 	}
 
+	// 沙箱文件系统
+	fs, err := NewSandboxFs(dstPath)
+	if err != nil {
+		debug.PrintStack()
+		return err
+	}
+
 	// 读取zip文件
 	zipFile, err := zip.OpenReader(srcFile.Name())
 	if err != nil {
@@ -70,14 +82,38 @@ func DeCompress(srcFile *os.File, dstPath string) error {
 	}
 	defer zipFile.Close()
 
+	// 检查文件数和解压后的总大小
+	maxSize := GetUploadMaxSize()
+	maxFiles := GetUploadMaxFiles()
+	if maxFiles > 0 && len(zipFile.File) > maxFiles {
+		return ErrTooManyFiles
+	}
+	var totalSize uint64
+	for _, innerFile := range zipFile.File {
+		totalSize += innerFile.UncompressedSize64
+	}
+	if maxSize > 0 && totalSize > uint64(maxSize) {
+		return ErrFileTooLarge
+	}
+
+	// 已写入的大小（文件头中的大小可能被伪造，写入时再次检查）
+	var writtenSize int64
+
 	// 遍历zip内所有文件和目录
 	for _, innerFile := range zipFile.File {
 		// 获取该文件数据
 		info := innerFile.FileInfo()
 
+		// 解析路径，不允许位于保存路径以外
+		filePath, err := fs.Resolve(innerFile.Name)
+		if err != nil {
+			log.Errorf("Unzip File Error : " + innerFile.Name + ": " + err.Error())
+			return err
+		}
+
 		// 如果是目录，则创建一个
 		if info.IsDir() {
-			err = os.MkdirAll(filepath.Join(dstPath, innerFile.Name), os.ModeDir|os.ModePerm)
+			err = os.MkdirAll(filePath, os.ModeDir|os.ModePerm)
 			if err != nil {
 				log.Errorf("Unzip File Error : " + err.Error())
 				debug.PrintStack()
@@ -86,15 +122,16 @@ func DeCompress(srcFile *os.File, dstPath string) error {
 			continue
 		}
 
+		// 忽略符号链接等非普通文件
+		if !info.Mode().IsRegular() {
+			continue
+		}
+
 		// 如果文件目录不存在，则创建一个
-		dirPath := filepath.Dir(innerFile.Name)
-		if !Exists(dirPath) {
-			err = os.MkdirAll(filepath.Join(dstPath, dirPath), os.ModeDir|os.ModePerm)
-			if err != nil {
-				log.Errorf("Unzip File Error : " + err.Error())
-				debug.PrintStack()
-				return err
-			}
+		if err := os.MkdirAll(filepath.Dir(filePath), os.ModeDir|os.ModePerm); err != nil {
+			log.Errorf("Unzip File Error : " + err.Error())
+			debug.PrintStack()
+			return err
 		}
 
 		// 打开该文件
@@ -106,31 +143,131 @@ func DeCompress(srcFile *os.File, dstPath string) error {
 		}
 
 		// 创建新文件
-		newFile, err := os.Create(filepath.Join(dstPath, innerFile.Name))
+		newFile, err := os.Create(filePath)
 		if err != nil {
+			_ = srcFile.Close()
 			log.Errorf("Unzip File Error : " + err.Error())
 			debug.PrintStack()
 			continue
 		}
-		defer newFile.Close()
 
 		// 拷贝该文件到新文件中
-		if _, err := io.Copy(newFile, srcFile); err != nil {
+		var reader io.Reader = srcFile
+		if maxSize > 0 {
+			reader = io.LimitReader(srcFile, maxSize-writtenSize+1)
+		}
+		n, err := io.Copy(newFile, reader)
+		_ = srcFile.Close()
+		_ = newFile.Close()
+		if err != nil {
 			debug.PrintStack()
 			return err
 		}
+		writtenSize += n
+		if maxSize > 0 && writtenSize > maxSize {
+			return ErrFileTooLarge
+		}
+	}
+	return nil
+}
 
-		// 关闭该文件
-		if err := srcFile.Close(); err != nil {
+/**
+@srcFile：tar.gz压缩文件
+@dstPath：解压之后文件保存路径
+与DeCompress相同，所有文件都被限制在保存路径内，并检查解压后的总大小和文件数；符号链接等非普通文件被忽略
+*/
+func DeCompressTarGz(srcFile *os.File, dstPath string) error {
+	// 如果保存路径不存在，创建一个
+	if !Exists(dstPath) {
+		if err := os.MkdirAll(dstPath, os.ModePerm); err != nil {
 			debug.PrintStack()
 			return err
 		}
+	}
 
-		// 关闭新文件
-		if err := newFile.Close(); err != nil {
-			debug.PrintStack()
+	// 沙箱文件系统
+	fs, err := NewSandboxFs(dstPath)
+	if err != nil {
+		debug.PrintStack()
+		return err
+	}
+
+	// 读取tar.gz文件
+	gr, err := gzip.NewReader(srcFile)
+	if err != nil {
+		log.Errorf("Untar File Error：" + err.Error())
+		debug.PrintStack()
+		return err
+	}
+	defer gr.Close()
+	tr := tar.NewReader(gr)
+
+	maxSize := GetUploadMaxSize()
+	maxFiles := GetUploadMaxFiles()
+	var writtenSize int64
+	fileCount := 0
+
+	// 遍历tar内所有文件和目录
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Errorf("Untar File Error：" + err.Error())
+			return err
+		}
+
+		// 文件数超出限制
+		fileCount++
+		if maxFiles > 0 && fileCount > maxFiles {
+			return ErrTooManyFiles
+		}
+
+		// 解析路径，不允许位于保存路径以外
+		filePath, err := fs.Resolve(header.Name)
+		if err != nil {
+			log.Errorf("Untar File Error : " + header.Name + ": " + err.Error())
 			return err
 		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			// 如果是目录，则创建一个
+			if err := os.MkdirAll(filePath, os.ModeDir|os.ModePerm); err != nil {
+				debug.PrintStack()
+				return err
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			// 如果文件目录不存在，则创建一个
+			if err := os.MkdirAll(filepath.Dir(filePath), os.ModeDir|os.ModePerm); err != nil {
+				debug.PrintStack()
+				return err
+			}
+
+			// 创建新文件
+			newFile, err := os.Create(filePath)
+			if err != nil {
+				debug.PrintStack()
+				return err
+			}
+
+			// 拷贝该文件到新文件中
+			var reader io.Reader = tr
+			if maxSize > 0 {
+				reader = io.LimitReader(tr, maxSize-writtenSize+1)
+			}
+			n, err := io.Copy(newFile, reader)
+			_ = newFile.Close()
+			if err != nil {
+				debug.PrintStack()
+				return err
+			}
+			writtenSize += n
+			if maxSize > 0 && writtenSize > maxSize {
+				return ErrFileTooLarge
+			}
+		}
 	}
 	return nil
 }
@@ -166,6 +303,14 @@ func _Compress(file *os.File, prefix string, zw *zip.Writer) error {
 			return err
 		}
 		for _, fi := range fileInfos {
+			// 忽略Git仓库目录
+			if fi.IsDir() && fi.Name() == ".git" {
+				continue
+			}
+			// 忽略符号链接，避免打包目录以外的文件
+			if fi.Mode()&os.ModeSymlink != 0 {
+				continue
+			}
 			f, err := os.Open(file.Name() + "/" + fi.Name())
 			if err != nil {
 				debug.PrintStack()
@@ -198,3 +343,18 @@ func _Compress(file *os.File, prefix string, zw *zip.Writer) error {
 	}
 	return nil
 }
+
+// 计算文件内容哈希
+func HashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
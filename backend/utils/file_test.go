@@ -0,0 +1,236 @@
+package utils
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// 压缩文件中的条目，Link不为空时为符号链接
+type testArchiveEntry struct {
+	Name string
+	Body string
+	Link string
+}
+
+func writeTestZip(t *testing.T, path string, entries []testArchiveEntry) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w := zip.NewWriter(f)
+	for _, entry := range entries {
+		header := &zip.FileHeader{Name: entry.Name, Method: zip.Deflate}
+		body := entry.Body
+		if entry.Link != "" {
+			header.SetMode(os.ModeSymlink | 0777)
+			body = entry.Link
+		} else {
+			header.SetMode(0644)
+		}
+		fw, err := w.CreateHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fw.Write([]byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeTestTarGz(t *testing.T, path string, entries []testArchiveEntry) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.Name, Mode: 0644, Size: int64(len(entry.Body)), Typeflag: tar.TypeReg}
+		if entry.Link != "" {
+			header.Typeflag = tar.TypeSymlink
+			header.Linkname = entry.Link
+			header.Size = 0
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if entry.Link == "" {
+			if _, err := tw.Write([]byte(entry.Body)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 按压缩格式解压
+type testDeCompressor struct {
+	name       string
+	write      func(t *testing.T, path string, entries []testArchiveEntry)
+	decompress func(path string, dstPath string) error
+}
+
+var testDeCompressors = []testDeCompressor{
+	{"zip", writeTestZip, DeCompressByPath},
+	{"tar.gz", writeTestTarGz, func(path string, dstPath string) error {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return DeCompressTarGz(f, dstPath)
+	}},
+}
+
+func TestDeCompress(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []testArchiveEntry
+		setup   func(root string, outside string) error // 解压前准备保存路径
+		err     bool
+		files   map[string]string // 保存路径内应存在的文件及内容
+		absent  []string          // 保存路径内不应存在的路径
+	}{
+		{
+			name:    "regular",
+			entries: []testArchiveEntry{{Name: "spider/main.py", Body: "print(1)"}, {Name: "spider/lib/util.py", Body: "x = 1"}},
+			files:   map[string]string{"spider/main.py": "print(1)", "spider/lib/util.py": "x = 1"},
+		},
+		{
+			name:    "dot dot",
+			entries: []testArchiveEntry{{Name: "../evil.py", Body: "evil"}},
+			err:     true,
+		},
+		{
+			name:    "nested dot dot",
+			entries: []testArchiveEntry{{Name: "spider/../../evil.py", Body: "evil"}},
+			err:     true,
+		},
+		{
+			name:    "absolute path",
+			entries: []testArchiveEntry{{Name: "/tmp/evil.py", Body: "evil"}},
+			files:   map[string]string{"tmp/evil.py": "evil"},
+		},
+		{
+			name: "symlink entry",
+			entries: []testArchiveEntry{
+				{Name: "spider/link", Link: "../../outside"},
+				{Name: "spider/main.py", Body: "print(1)"},
+			},
+			files:  map[string]string{"spider/main.py": "print(1)"},
+			absent: []string{"spider/link"},
+		},
+		{
+			name: "symlink entry then file through it",
+			entries: []testArchiveEntry{
+				{Name: "link", Link: "../outside"},
+				{Name: "link/evil.py", Body: "evil"},
+			},
+			files: map[string]string{"link/evil.py": "evil"},
+		},
+		{
+			name:    "existing symlinked parent",
+			entries: []testArchiveEntry{{Name: "link/evil.py", Body: "evil"}},
+			setup: func(root string, outside string) error {
+				return os.Symlink(outside, filepath.Join(root, "link"))
+			},
+			err: true,
+		},
+	}
+
+	for _, d := range testDeCompressors {
+		for _, tt := range tests {
+			func() {
+				root, outside, cleanup := newTestSandboxDirs(t)
+				defer cleanup()
+
+				if tt.setup != nil {
+					if err := tt.setup(root, outside); err != nil {
+						t.Skip("setup failed: " + err.Error())
+					}
+				}
+
+				archive := filepath.Join(filepath.Dir(root), "archive")
+				d.write(t, archive, tt.entries)
+				err := d.decompress(archive, root)
+				if tt.err && err == nil {
+					t.Errorf("%s %s: expected error", d.name, tt.name)
+				} else if !tt.err && err != nil {
+					t.Errorf("%s %s: unexpected error: %s", d.name, tt.name, err)
+				}
+
+				// 保存路径以外不能有任何文件
+				if items, _ := ioutil.ReadDir(outside); len(items) != 0 {
+					t.Errorf("%s %s: %d items written outside", d.name, tt.name, len(items))
+				}
+				if _, err := os.Lstat(filepath.Join(filepath.Dir(root), "evil.py")); err == nil {
+					t.Errorf("%s %s: evil.py written next to root", d.name, tt.name)
+				}
+
+				for name, body := range tt.files {
+					data, err := ioutil.ReadFile(filepath.Join(root, filepath.FromSlash(name)))
+					if err != nil || string(data) != body {
+						t.Errorf("%s %s: %s = %q, %v, want %q", d.name, tt.name, name, data, err, body)
+					}
+				}
+				for _, name := range tt.absent {
+					if _, err := os.Lstat(filepath.Join(root, filepath.FromSlash(name))); err == nil {
+						t.Errorf("%s %s: %s should not exist", d.name, tt.name, name)
+					}
+				}
+			}()
+		}
+	}
+}
+
+func TestDeCompressLimits(t *testing.T) {
+	defer viper.Set("spider.uploadMaxSize", 0)
+	defer viper.Set("spider.uploadMaxFiles", 0)
+
+	tests := []struct {
+		name     string
+		maxSize  int64
+		maxFiles int
+		entries  []testArchiveEntry
+		err      error
+	}{
+		{"within limits", 10, 2, []testArchiveEntry{{Name: "a.py", Body: "12345"}, {Name: "b.py", Body: "12345"}}, nil},
+		{"file too large", 10, 0, []testArchiveEntry{{Name: "a.py", Body: "12345678901"}}, ErrFileTooLarge},
+		{"total too large", 10, 0, []testArchiveEntry{{Name: "a.py", Body: "123456"}, {Name: "b.py", Body: "123456"}}, ErrFileTooLarge},
+		{"too many files", 0, 2, []testArchiveEntry{{Name: "a.py"}, {Name: "b.py"}, {Name: "c.py"}}, ErrTooManyFiles},
+	}
+
+	for _, d := range testDeCompressors {
+		for _, tt := range tests {
+			func() {
+				root, _, cleanup := newTestSandboxDirs(t)
+				defer cleanup()
+
+				viper.Set("spider.uploadMaxSize", tt.maxSize)
+				viper.Set("spider.uploadMaxFiles", tt.maxFiles)
+
+				archive := filepath.Join(filepath.Dir(root), "archive")
+				d.write(t, archive, tt.entries)
+				if err := d.decompress(archive, root); err != tt.err {
+					t.Errorf("%s %s: error = %v, want %v", d.name, tt.name, err, tt.err)
+				}
+			}()
+		}
+	}
+}
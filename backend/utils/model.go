@@ -2,6 +2,8 @@ package utils
 
 import (
 	"crawlab/constants"
+	"encoding/json"
+	"fmt"
 	"github.com/globalsign/mgo/bson"
 	"strconv"
 	"time"
@@ -11,16 +13,58 @@ func IsObjectIdNull(id bson.ObjectId) bool {
 	return id.Hex() == constants.ObjectIdNull
 }
 
+// 将结果中的值转换为字符串（用于导出），嵌套的文档及数组转换为JSON
 func InterfaceToString(value interface{}) string {
-	switch value.(type) {
+	switch v := value.(type) {
+	case nil:
+		return ""
 	case bson.ObjectId:
-		return value.(bson.ObjectId).Hex()
+		return v.Hex()
 	case string:
-		return value.(string)
+		return v
+	case bool:
+		return strconv.FormatBool(v)
 	case int:
-		return strconv.Itoa(value.(int))
+		return strconv.Itoa(v)
+	case int32:
+		return strconv.FormatInt(int64(v), 10)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
 	case time.Time:
-		return value.(time.Time).String()
+		return v.Format("2006-01-02 15:04:05")
+	case []byte:
+		return string(v)
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
+
+// 展开嵌套的文档，嵌套字段以点号连接，如{"a": {"b": 1}}展开为{"a.b": 1}
+func FlattenMap(item map[string]interface{}) map[string]interface{} {
+	result := map[string]interface{}{}
+	flattenMap("", item, result)
+	return result
+}
+
+func flattenMap(prefix string, item map[string]interface{}, result map[string]interface{}) {
+	for key, value := range item {
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		switch v := value.(type) {
+		case bson.M:
+			flattenMap(key, v, result)
+		case map[string]interface{}:
+			flattenMap(key, v, result)
+		default:
+			result[key] = value
+		}
 	}
-	return ""
 }
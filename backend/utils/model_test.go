@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"github.com/globalsign/mgo/bson"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestInterfaceToString(t *testing.T) {
+	tests := []struct {
+		in   interface{}
+		want string
+	}{
+		{nil, ""},
+		{bson.ObjectIdHex("5d7f1d2c8c8b4a0001000001"), "5d7f1d2c8c8b4a0001000001"},
+		{"abc", "abc"},
+		{true, "true"},
+		{42, "42"},
+		{int32(-1), "-1"},
+		{int64(1) << 40, "1099511627776"},
+		{float32(1.5), "1.5"},
+		{0.1, "0.1"},
+		{1e21, "1000000000000000000000"},
+		{time.Date(2019, 9, 1, 8, 30, 0, 0, time.UTC), "2019-09-01 08:30:00"},
+		{[]byte("raw"), "raw"},
+		{[]interface{}{"a", 1}, `["a",1]`},
+		{bson.M{"a": 1}, `{"a":1}`},
+	}
+	for _, tt := range tests {
+		if got := InterfaceToString(tt.in); got != tt.want {
+			t.Errorf("InterfaceToString(%#v) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestFlattenMap(t *testing.T) {
+	item := map[string]interface{}{
+		"a": 1,
+		"b": bson.M{"c": "x", "d": map[string]interface{}{"e": true}},
+		"f": []interface{}{bson.M{"g": 1}},
+		"h": bson.M{},
+	}
+	want := map[string]interface{}{
+		"a":     1,
+		"b.c":   "x",
+		"b.d.e": true,
+		"f":     []interface{}{bson.M{"g": 1}},
+	}
+	if got := FlattenMap(item); !reflect.DeepEqual(got, want) {
+		t.Errorf("FlattenMap = %v, want %v", got, want)
+	}
+}
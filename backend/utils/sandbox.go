@@ -0,0 +1,219 @@
+package utils
+
+import (
+	"errors"
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	ErrPathEscape    = errors.New("path escapes the root directory")
+	ErrFileTooLarge  = errors.New("file size exceeds the limit")
+	ErrTooManyFiles  = errors.New("file count exceeds the limit")
+	ErrSymlinkEscape = errors.New("symlink escapes the root directory")
+)
+
+// 上传文件解压后的总大小上限（字节，0表示不限制）
+func GetUploadMaxSize() int64 {
+	return viper.GetInt64("spider.uploadMaxSize")
+}
+
+// 上传文件解压后的文件数上限（0表示不限制）
+func GetUploadMaxFiles() int {
+	return viper.GetInt("spider.uploadMaxFiles")
+}
+
+// 沙箱文件系统：所有路径都被限制在根目录内，不允许通过 .. 或符号链接访问根目录以外的文件
+type SandboxFs struct {
+	Root string // 根目录（绝对路径，已解析符号链接）
+}
+
+func NewSandboxFs(root string) (*SandboxFs, error) {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	if realRoot, err := filepath.EvalSymlinks(absRoot); err == nil {
+		absRoot = realRoot
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	return &SandboxFs{Root: absRoot}, nil
+}
+
+// 是否位于根目录内
+func (fs *SandboxFs) contains(path string) bool {
+	rel, err := filepath.Rel(fs.Root, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// 将相对路径解析为根目录内的绝对路径
+// 1. 拼接后的路径须位于根目录内
+// 2. 路径中已存在的部分解析符号链接后，仍须位于根目录内
+func (fs *SandboxFs) Resolve(path string) (string, error) {
+	fullPath := filepath.Join(fs.Root, filepath.FromSlash(path))
+	if !fs.contains(fullPath) {
+		return "", ErrPathEscape
+	}
+
+	// 找到已存在的最深一级路径，解析其符号链接
+	existing := fullPath
+	for {
+		if _, err := os.Lstat(existing); err == nil {
+			break
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			break
+		}
+		existing = parent
+	}
+	realPath, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// 符号链接指向不存在的路径
+			return "", ErrSymlinkEscape
+		}
+		return "", err
+	}
+	if !fs.contains(realPath) {
+		return "", ErrSymlinkEscape
+	}
+
+	return fullPath, nil
+}
+
+// 根目录内的相对路径（使用 / 分隔）
+func (fs *SandboxFs) Rel(fullPath string) string {
+	rel, err := filepath.Rel(fs.Root, fullPath)
+	if err != nil {
+		return ""
+	}
+	return filepath.ToSlash(rel)
+}
+
+func (fs *SandboxFs) Stat(path string) (os.FileInfo, error) {
+	fullPath, err := fs.Resolve(path)
+	if err != nil {
+		return nil, err
+	}
+	return os.Stat(fullPath)
+}
+
+func (fs *SandboxFs) ReadDir(path string) ([]os.FileInfo, error) {
+	fullPath, err := fs.Resolve(path)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadDir(fullPath)
+}
+
+func (fs *SandboxFs) ReadFile(path string) ([]byte, error) {
+	fullPath, err := fs.Resolve(path)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(fullPath)
+}
+
+// 写文件，父目录不存在时自动创建
+func (fs *SandboxFs) WriteFile(path string, data []byte) error {
+	if maxSize := GetUploadMaxSize(); maxSize > 0 && int64(len(data)) > maxSize {
+		return ErrFileTooLarge
+	}
+	fullPath, err := fs.Resolve(path)
+	if err != nil {
+		return err
+	}
+	if fullPath == fs.Root {
+		return ErrPathEscape
+	}
+	if err := os.MkdirAll(filepath.Dir(fullPath), os.ModePerm); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fullPath, data, os.ModePerm)
+}
+
+func (fs *SandboxFs) MkdirAll(path string) error {
+	fullPath, err := fs.Resolve(path)
+	if err != nil {
+		return err
+	}
+	return os.MkdirAll(fullPath, os.ModePerm)
+}
+
+// 解析路径，但不跟随最后一级的符号链接（删除、重命名符号链接本身时使用）
+func (fs *SandboxFs) ResolveEntry(path string) (string, error) {
+	fullPath := filepath.Join(fs.Root, filepath.FromSlash(path))
+	if !fs.contains(fullPath) || fullPath == fs.Root {
+		return "", ErrPathEscape
+	}
+	parent, err := fs.Resolve(fs.Rel(filepath.Dir(fullPath)))
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(parent, filepath.Base(fullPath)), nil
+}
+
+// 新建文件，文件已存在时返回错误
+func (fs *SandboxFs) CreateFile(path string, data []byte) error {
+	if maxSize := GetUploadMaxSize(); maxSize > 0 && int64(len(data)) > maxSize {
+		return ErrFileTooLarge
+	}
+	fullPath, err := fs.ResolveEntry(path)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(fullPath), os.ModePerm); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(fullPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// 重命名或移动文件、目录，目标已存在时返回错误
+func (fs *SandboxFs) Rename(oldPath string, newPath string) error {
+	oldFullPath, err := fs.ResolveEntry(oldPath)
+	if err != nil {
+		return err
+	}
+	newFullPath, err := fs.ResolveEntry(newPath)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Lstat(oldFullPath); err != nil {
+		return err
+	}
+	if _, err := os.Lstat(newFullPath); err == nil {
+		return os.ErrExist
+	}
+	if err := os.MkdirAll(filepath.Dir(newFullPath), os.ModePerm); err != nil {
+		return err
+	}
+	return os.Rename(oldFullPath, newFullPath)
+}
+
+// 删除文件或目录（不允许删除根目录）
+func (fs *SandboxFs) Remove(path string) error {
+	fullPath, err := fs.ResolveEntry(path)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Lstat(fullPath); err != nil {
+		return err
+	}
+	return os.RemoveAll(fullPath)
+}
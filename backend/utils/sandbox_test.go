@@ -0,0 +1,116 @@
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// 创建测试用的根目录及根目录外的目录，返回根目录、外部目录
+func newTestSandboxDirs(t *testing.T) (string, string, func()) {
+	dir, err := ioutil.TempDir("", "crawlab-sandbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := filepath.Join(dir, "root")
+	outside := filepath.Join(dir, "outside")
+	for _, d := range []string{filepath.Join(root, "sub"), outside} {
+		if err := os.MkdirAll(d, os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root, outside, func() {
+		_ = os.RemoveAll(dir)
+	}
+}
+
+func TestSandboxFsResolve(t *testing.T) {
+	root, outside, cleanup := newTestSandboxDirs(t)
+	defer cleanup()
+
+	links := map[string]string{
+		"link_outside": outside,
+		"link_inside":  filepath.Join(root, "sub"),
+		"link_missing": filepath.Join(root, "missing"),
+		"link_parent":  "..",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+			t.Skip("symlinks not supported: " + err.Error())
+		}
+	}
+
+	fs, err := NewSandboxFs(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path string
+		want string
+		err  error
+	}{
+		{"", fs.Root, nil},
+		{"sub/main.py", filepath.Join(fs.Root, "sub", "main.py"), nil},
+		{"new/dir/main.py", filepath.Join(fs.Root, "new", "dir", "main.py"), nil},
+		{"sub/../main.py", filepath.Join(fs.Root, "main.py"), nil},
+		{"/etc/passwd", filepath.Join(fs.Root, "etc", "passwd"), nil},
+		{"link_inside/main.py", filepath.Join(fs.Root, "link_inside", "main.py"), nil},
+		{"..", "", ErrPathEscape},
+		{"../outside/main.py", "", ErrPathEscape},
+		{"sub/../../outside", "", ErrPathEscape},
+		{"link_outside", "", ErrSymlinkEscape},
+		{"link_outside/main.py", "", ErrSymlinkEscape},
+		{"link_outside/new/main.py", "", ErrSymlinkEscape},
+		{"link_parent/outside", "", ErrSymlinkEscape},
+		{"link_missing", "", ErrSymlinkEscape},
+	}
+	for _, tt := range tests {
+		got, err := fs.Resolve(tt.path)
+		if err != tt.err || got != tt.want {
+			t.Errorf("Resolve(%q) = %q, %v, want %q, %v", tt.path, got, err, tt.want, tt.err)
+		}
+	}
+
+	// 删除符号链接本身时不跟随最后一级的符号链接
+	if got, err := fs.ResolveEntry("link_outside"); err != nil || got != filepath.Join(fs.Root, "link_outside") {
+		t.Errorf("ResolveEntry(link_outside) = %q, %v", got, err)
+	}
+	if _, err := fs.ResolveEntry("link_outside/main.py"); err != ErrSymlinkEscape {
+		t.Errorf("ResolveEntry(link_outside/main.py) error = %v, want %v", err, ErrSymlinkEscape)
+	}
+	if _, err := fs.ResolveEntry(""); err != ErrPathEscape {
+		t.Errorf("ResolveEntry(root) error = %v, want %v", err, ErrPathEscape)
+	}
+
+	// 通过符号链接写入根目录以外
+	if err := fs.WriteFile("link_outside/main.py", []byte("x")); err != ErrSymlinkEscape {
+		t.Errorf("WriteFile(link_outside/main.py) error = %v, want %v", err, ErrSymlinkEscape)
+	}
+	if items, _ := ioutil.ReadDir(outside); len(items) != 0 {
+		t.Errorf("outside dir has %d items, want 0", len(items))
+	}
+}
+
+func TestNewSandboxFsSymlinkRoot(t *testing.T) {
+	root, outside, cleanup := newTestSandboxDirs(t)
+	defer cleanup()
+
+	// 根目录本身为符号链接时解析为真实路径
+	link := filepath.Join(outside, "root_link")
+	if err := os.Symlink(root, link); err != nil {
+		t.Skip("symlinks not supported: " + err.Error())
+	}
+	fs, err := NewSandboxFs(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	realRoot, _ := filepath.EvalSymlinks(root)
+	if fs.Root != realRoot {
+		t.Fatalf("Root = %s, want %s", fs.Root, realRoot)
+	}
+	if got, err := fs.Resolve("sub"); err != nil || got != filepath.Join(realRoot, "sub") {
+		t.Fatalf("Resolve(sub) = %q, %v", got, err)
+	}
+}
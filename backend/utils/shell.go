@@ -0,0 +1,147 @@
+package utils
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// 无需加引号的参数字符
+var shellSafeRegex = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// Windows cmd中有特殊含义的字符
+const windowsCmdMetaChars = "\"^&|<>%!()\r\n"
+
+// 环境变量名
+var shellVarRegex = regexp.MustCompile(`^(?:\{([A-Za-z_][A-Za-z0-9_]*)\}|([A-Za-z_][A-Za-z0-9_]*))`)
+
+// 按shell的规则拆分参数字符串，支持单引号、双引号及反斜杠转义，不做变量展开及命令替换
+func SplitArgs(s string) ([]string, error) {
+	return splitArgs(s, nil)
+}
+
+// 拆分参数字符串并展开vars中的变量，与QuoteArgs的拆分结果一致，用于在执行前校验参数
+func ExpandArgs(s string, vars map[string]string) ([]string, error) {
+	if vars == nil {
+		vars = map[string]string{}
+	}
+	return splitArgs(s, vars)
+}
+
+// 拆分参数字符串，单引号外的$NAME、${NAME}在vars中存在时替换为对应的值（替换结果不再拆分），其余按原样保留
+func splitArgs(s string, vars map[string]string) ([]string, error) {
+	var args []string
+	var buf strings.Builder
+	inArg := false
+	inSingle := false
+	inDouble := false
+	escaped := false
+
+	runes := []rune(s)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case escaped:
+			// 双引号内反斜杠只转义 " \ $ `
+			if inDouble && !strings.ContainsRune("\"\\$`", r) {
+				buf.WriteRune('\\')
+			}
+			buf.WriteRune(r)
+			escaped = false
+		case inSingle:
+			if r == '\'' {
+				inSingle = false
+			} else {
+				buf.WriteRune(r)
+			}
+		case r == '\\':
+			escaped = true
+			inArg = true
+		case r == '$' && vars != nil:
+			inArg = true
+			m := shellVarRegex.FindStringSubmatch(string(runes[i+1:]))
+			if m == nil {
+				buf.WriteRune(r)
+				break
+			}
+			name := m[1] + m[2]
+			if value, ok := vars[name]; ok {
+				buf.WriteString(value)
+			} else {
+				buf.WriteString("$" + m[0])
+			}
+			i += len([]rune(m[0]))
+		case inDouble:
+			if r == '"' {
+				inDouble = false
+			} else {
+				buf.WriteRune(r)
+			}
+		case r == '\'':
+			inSingle = true
+			inArg = true
+		case r == '"':
+			inDouble = true
+			inArg = true
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			if inArg {
+				args = append(args, buf.String())
+				buf.Reset()
+				inArg = false
+			}
+		default:
+			buf.WriteRune(r)
+			inArg = true
+		}
+	}
+
+	if escaped || inSingle || inDouble {
+		return nil, errors.New("unterminated quote or escape in arguments")
+	}
+	if inArg {
+		args = append(args, buf.String())
+	}
+	return args, nil
+}
+
+// 给参数加上单引号，使其在sh中按原样传递
+func ShellQuote(arg string) string {
+	if arg != "" && shellSafeRegex.MatchString(arg) {
+		return arg
+	}
+	return "'" + strings.Replace(arg, "'", `'"'"'`, -1) + "'"
+}
+
+// 给参数加上双引号，使其在Windows cmd中按原样传递，含有cmd特殊字符的参数无法安全传递，返回错误
+func WindowsQuote(arg string) (string, error) {
+	if strings.ContainsAny(arg, windowsCmdMetaChars) {
+		return "", errors.New("argument contains unsupported characters: " + arg)
+	}
+	if arg != "" && shellSafeRegex.MatchString(arg) {
+		return arg, nil
+	}
+	return "\"" + arg + "\"", nil
+}
+
+// 拆分参数字符串并逐个加引号，拼接为可安全追加到命令后的参数
+// vars中的变量在加引号前展开，其余变量加引号后不再由shell展开
+func QuoteArgs(s string, vars map[string]string, windows bool) (string, error) {
+	args, err := splitArgs(s, vars)
+	if err != nil {
+		return "", err
+	}
+
+	quoted := make([]string, 0, len(args))
+	for _, arg := range args {
+		if windows {
+			q, err := WindowsQuote(arg)
+			if err != nil {
+				return "", err
+			}
+			quoted = append(quoted, q)
+		} else {
+			quoted = append(quoted, ShellQuote(arg))
+		}
+	}
+	return strings.Join(quoted, " "), nil
+}
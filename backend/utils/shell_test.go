@@ -0,0 +1,91 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"", nil},
+		{"-a  key=value", []string{"-a", "key=value"}},
+		{`-a "x y" 'z w'`, []string{"-a", "x y", "z w"}},
+		{`a\ b "c\"d" "e\f"`, []string{"a b", `c"d`, `e\f`}},
+		{`"" x`, []string{"", "x"}},
+		{"; rm -rf /", []string{";", "rm", "-rf", "/"}},
+	}
+	for _, tt := range tests {
+		got, err := SplitArgs(tt.in)
+		if err != nil {
+			t.Fatalf("SplitArgs(%q) error: %s", tt.in, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("SplitArgs(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+
+	for _, in := range []string{`"abc`, `'abc`, `abc\`} {
+		if _, err := SplitArgs(in); err == nil {
+			t.Errorf("SplitArgs(%q) expected error", in)
+		}
+	}
+}
+
+func TestQuoteArgs(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"-a key=value", "-a key=value"},
+		{"; rm -rf / $(id) `id`", `';' rm -rf / '$(id)' '` + "`id`'"},
+		{`"it's"`, `'it'"'"'s'`},
+		{`"" x`, `'' x`},
+	}
+	for _, tt := range tests {
+		got, err := QuoteArgs(tt.in, nil, false)
+		if err != nil {
+			t.Fatalf("QuoteArgs(%q) error: %s", tt.in, err)
+		}
+		if got != tt.want {
+			t.Errorf("QuoteArgs(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+
+	if got, err := QuoteArgs(`-a "x y"`, nil, true); err != nil || got != `-a "x y"` {
+		t.Errorf("QuoteArgs windows = %s, %v", got, err)
+	}
+	if _, err := QuoteArgs("a & calc", nil, true); err == nil {
+		t.Errorf("QuoteArgs windows expected error for cmd meta characters")
+	}
+}
+
+func TestQuoteArgsExpandVars(t *testing.T) {
+	vars := map[string]string{
+		"CRAWLAB_UPSTREAM_TASK_ID":  "abc",
+		"CRAWLAB_UPSTREAM_TASK_IDS": "abc,def",
+		"EVIL":                      "x; rm -rf /",
+	}
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"-a task_id=$CRAWLAB_UPSTREAM_TASK_ID", "-a task_id=abc"},
+		{"--ids ${CRAWLAB_UPSTREAM_TASK_IDS}", "--ids abc,def"},
+		{`"$CRAWLAB_UPSTREAM_TASK_ID"_x`, "abc_x"},
+		{`'$CRAWLAB_UPSTREAM_TASK_ID' \$CRAWLAB_UPSTREAM_TASK_ID`, `'$CRAWLAB_UPSTREAM_TASK_ID' '$CRAWLAB_UPSTREAM_TASK_ID'`},
+		{"$HOME ${PATH} $ $1", `'$HOME' '${PATH}' '$' '$1'`},
+		{"-a $EVIL", `-a 'x; rm -rf /'`},
+	}
+	for _, tt := range tests {
+		got, err := QuoteArgs(tt.in, vars, false)
+		if err != nil {
+			t.Fatalf("QuoteArgs(%q) error: %s", tt.in, err)
+		}
+		if got != tt.want {
+			t.Errorf("QuoteArgs(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package utils
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// 读取工作表中的单元格
+func readXlsxCells(t *testing.T, data []byte) [][]string {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string][]byte{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name] = content
+	}
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels", "xl/worksheets/sheet1.xml"} {
+		if _, ok := files[name]; !ok {
+			t.Fatalf("%s not found", name)
+		}
+	}
+
+	var sheet struct {
+		Rows []struct {
+			R     string `xml:"r,attr"`
+			Cells []struct {
+				T string `xml:"is>t"`
+			} `xml:"c"`
+		} `xml:"sheetData>row"`
+	}
+	if err := xml.Unmarshal(files["xl/worksheets/sheet1.xml"], &sheet); err != nil {
+		t.Fatal(err)
+	}
+	var rows [][]string
+	for _, row := range sheet.Rows {
+		var cells []string
+		for _, cell := range row.Cells {
+			cells = append(cells, cell.T)
+		}
+		rows = append(rows, cells)
+	}
+	return rows
+}
+
+func TestXlsxWriter(t *testing.T) {
+	var buf bytes.Buffer
+	xw, err := NewXlsxWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := [][]string{
+		{"title", "html"},
+		{"a & b", `<a href="x">'y'</a>`},
+		{" space ", "line1\nline2\x00\x1b"},
+		{strings.Repeat("x", xlsxMaxCellLength+10), ""},
+	}
+	for _, row := range rows {
+		if err := xw.Write(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := xw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := [][]string{
+		{"title", "html"},
+		{"a & b", `<a href="x">'y'</a>`},
+		{" space ", "line1\nline2"},
+		{strings.Repeat("x", xlsxMaxCellLength), ""},
+	}
+	if got := readXlsxCells(t, buf.Bytes()); !reflect.DeepEqual(got, want) {
+		t.Fatalf("xlsx cells = %q, want %q", got, want)
+	}
+}
@@ -48,6 +48,18 @@ const actions = {
     const spiderId = rootState.spider.spiderForm._id
     const content = state.fileContent
     return request.post(`/spiders/${spiderId}/file`, { content, path })
+  },
+  downloadFile ({ rootState }, payload) {
+    // 浏览器直接下载时无法携带Authorization请求头，先获取短期有效的下载令牌
+    const { path } = payload
+    const spiderId = rootState.spider.spiderForm._id
+    const url = `/spiders/${spiderId}/files/download`
+    return request.put('/download-tokens', { path: url })
+      .then(response => {
+        window.location.href = request.baseUrl + url +
+          '?path=' + encodeURIComponent(path) +
+          '&token=' + encodeURIComponent(response.data.data)
+      })
   }
 }
 